@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/chenliu1993/simple-csi-driver/internal/agent"
+	"github.com/chenliu1993/simple-csi-driver/internal/localfs"
+	"github.com/chenliu1993/simple-csi-driver/internal/nfs"
+	"github.com/chenliu1993/simple-csi-driver/internal/nfsv3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"k8s.io/klog/v2"
+)
+
+const (
+	exportsUsage   = "exports <server>: print the export list of a nfs server"
+	devServerUsage = "dev-server --root <dir> [--listen <addr>]: serve a local directory over NFSv3 for development"
+	agentUsage     = "agent --exports </export=/local/path,...> --tls-cert <file> --tls-key <file> --tls-ca <file> [--listen <addr>] [--project-quotas]: run the storage agent on the nfs server host"
+)
+
+// command is an admin subcommand run instead of the driver
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+var commands = map[string]command{
+	"exports": {
+		usage: exportsUsage,
+		run:   runExports,
+	},
+	"dev-server": {
+		usage: devServerUsage,
+		run:   runDevServer,
+	},
+	"agent": {
+		usage: agentUsage,
+		run:   runAgent,
+	},
+}
+
+// runCommand runs the subcommand named by the first argument
+func runCommand(args []string) error {
+	cmd, ok := commands[args[0]]
+	if !ok {
+		var usages []string
+		for _, cmd := range commands {
+			usages = append(usages, "  "+cmd.usage)
+		}
+		sort.Strings(usages)
+		return fmt.Errorf("unknown command %q, available commands:\n%s", args[0], strings.Join(usages, "\n"))
+	}
+	return cmd.run(args[1:])
+}
+
+func runExports(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: " + exportsUsage)
+	}
+	return nfs.PrintExports(context.Background(), args[0], os.Stdout)
+}
+
+func runDevServer(args []string) error {
+	fs := flag.NewFlagSet("dev-server", flag.ContinueOnError)
+	root := fs.String("root", "", "directory to export")
+	listen := fs.String("listen", "127.0.0.1:2049", "address MOUNT and NFS are served on")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *root == "" {
+		return errors.New("usage: " + devServerUsage)
+	}
+
+	s, err := nfsv3.NewServer(*root)
+	if err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return err
+	}
+	stopCh := make(chan os.Signal, 1)
+	signal.Notify(stopCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-stopCh
+		s.Close()
+	}()
+
+	fmt.Printf("Serving %s on %s, run the driver with --fake-mount-root=%s and server %s\n",
+		s.Root(), l.Addr(), s.Root(), l.Addr())
+	if err := s.Serve(l); !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+	return nil
+}
+
+func runAgent(args []string) error {
+	fs := flag.NewFlagSet("agent", flag.ContinueOnError)
+	exportList := fs.String("exports", "", "comma separated /export=/local/path mappings of the exports the agent manages")
+	listen := fs.String("listen", ":9443", "address the agent is served on")
+	certFile := fs.String("tls-cert", "", "certificate of the agent")
+	keyFile := fs.String("tls-key", "", "key of the agent certificate")
+	caFile := fs.String("tls-ca", "", "ca the client certificates of controllers are verified with")
+	projectQuotas := fs.Bool("project-quotas", false, "limit volumes to their capacity with project quotas")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *exportList == "" || *certFile == "" || *keyFile == "" || *caFile == "" {
+		return errors.New("usage: " + agentUsage)
+	}
+
+	exports, err := agent.ParseExports(*exportList)
+	if err != nil {
+		return err
+	}
+	tlsConfig, err := agent.ServerTLSConfig(*certFile, *keyFile, *caFile)
+	if err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return err
+	}
+	s := grpc.NewServer(append(agent.ServerOptions(), grpc.Creds(credentials.NewTLS(tlsConfig)))...)
+	agent.RegisterStorageAgentServer(s, agent.NewServer(exports, localfs.Host, *projectQuotas))
+	stopCh := make(chan os.Signal, 1)
+	signal.Notify(stopCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-stopCh
+		s.GracefulStop()
+	}()
+
+	klog.V(2).InfoS("Storage agent is serving", "address", l.Addr().String(), "exports", exports)
+	return s.Serve(l)
+}
@@ -0,0 +1,217 @@
+package agent
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// writeCert writes a certificate signed by parent, or a self signed ca without parent, and
+// its key into dir
+func writeCert(t *testing.T, dir, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("CreateCertificate() error = %v", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey() error = %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("ParseCertificate() error = %v", err)
+	}
+	return cert, key
+}
+
+// newTLSConfigs returns the tls configs of an agent and of a controller sharing a ca
+func newTLSConfigs(t *testing.T) (*tls.Config, *tls.Config) {
+	t.Helper()
+	dir := t.TempDir()
+	ca, caKey := writeCert(t, dir, "ca", nil, nil)
+	writeCert(t, dir, "agent", ca, caKey)
+	writeCert(t, dir, "controller", ca, caKey)
+
+	serverConfig, err := ServerTLSConfig(filepath.Join(dir, "agent.crt"), filepath.Join(dir, "agent.key"), filepath.Join(dir, "ca.crt"))
+	if err != nil {
+		t.Fatalf("ServerTLSConfig() error = %v", err)
+	}
+	clientConfig, err := ClientTLSConfig(filepath.Join(dir, "controller.crt"), filepath.Join(dir, "controller.key"), filepath.Join(dir, "ca.crt"))
+	if err != nil {
+		t.Fatalf("ClientTLSConfig() error = %v", err)
+	}
+	return serverConfig, clientConfig
+}
+
+func TestAgent(t *testing.T) {
+	serverConfig, clientConfig := newTLSConfigs(t)
+	root := t.TempDir()
+	fake, err := StartFake(map[string]string{"/export": root}, serverConfig)
+	if err != nil {
+		t.Fatalf("StartFake() error = %v", err)
+	}
+	defer fake.Stop()
+	c, err := Dial(fake.Addr, clientConfig)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	resp, err := c.CreateDirectory(ctx, &DirectoryRequest{Basedir: "export", Subdir: "vol", Mode: 0750, CapacityBytes: 1 << 20})
+	if err != nil {
+		t.Fatalf("CreateDirectory() error = %v", err)
+	}
+	volumeDir := filepath.Join(root, "vol")
+	if resp.CapacityBytes != 1<<20 || fake.FS.Quota(volumeDir) != 1<<20 {
+		t.Errorf("CreateDirectory() capacity = %d, quota = %d", resp.CapacityBytes, fake.FS.Quota(volumeDir))
+	}
+	if err := c.SetQuota(ctx, &QuotaRequest{Basedir: "/export", Subdir: "vol", Bytes: 2 << 20}); err != nil || fake.FS.Quota(volumeDir) != 2<<20 {
+		t.Errorf("SetQuota() error = %v, quota = %d", err, fake.FS.Quota(volumeDir))
+	}
+	if err := os.WriteFile(filepath.Join(volumeDir, "data"), []byte("hello"), 0600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	if _, err := c.CreateSnapshot(ctx, &SnapshotRequest{Basedir: "/export", Subdir: "vol", Name: "snap", Source: "vol"}); err != nil {
+		t.Fatalf("CreateSnapshot() error = %v", err)
+	}
+	if _, err := c.CreateSnapshot(ctx, &SnapshotRequest{Basedir: "/export", Subdir: "other", Name: "snap", Source: "other"}); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("CreateSnapshot() of another volume error = %v, want code %v", err, codes.AlreadyExists)
+	}
+	if _, err := c.Clone(ctx, &CloneRequest{Basedir: "/export", Subdir: "restored", Snapshot: "snap", Mode: 0750}); err != nil {
+		t.Fatalf("Clone() error = %v", err)
+	}
+	if b, err := os.ReadFile(filepath.Join(root, "restored", "data")); err != nil || string(b) != "hello" {
+		t.Errorf("restored data = %q, %v", b, err)
+	}
+	if _, err := c.Clone(ctx, &CloneRequest{Basedir: "/export", Subdir: "clone", Volume: "vol", Mode: 0750}); err != nil {
+		t.Fatalf("Clone() of a volume error = %v", err)
+	}
+	if b, err := os.ReadFile(filepath.Join(root, "clone", "data")); err != nil || string(b) != "hello" {
+		t.Errorf("cloned data = %q, %v", b, err)
+	}
+	if _, err := c.Clone(ctx, &CloneRequest{Basedir: "/export", Subdir: "none", Mode: 0750}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Clone() without source error = %v, want code %v", err, codes.InvalidArgument)
+	}
+	list, err := c.ListDirectories(ctx, &ListRequest{Basedir: "/export"})
+	if err != nil || len(list.Subdirs) != 3 {
+		t.Errorf("ListDirectories() = %v, %v, want 3 volumes", list, err)
+	}
+	if err := c.DeleteSnapshot(ctx, &SnapshotRequest{Basedir: "/export", Name: "snap"}); err != nil {
+		t.Errorf("DeleteSnapshot() error = %v", err)
+	}
+
+	usage, err := c.Usage(ctx, &UsageRequest{Basedir: "/export"})
+	if err != nil || usage.TotalBytes <= 0 || usage.AvailableBytes <= 0 {
+		t.Errorf("Usage() = %v, %v", usage, err)
+	}
+	if _, err := c.Usage(ctx, &UsageRequest{Basedir: "/other"}); status.Code(err) != codes.NotFound {
+		t.Errorf("Usage() of an unknown export error = %v, want code %v", err, codes.NotFound)
+	}
+
+	if err := c.DeleteDirectory(ctx, &DirectoryRequest{Basedir: "/export", Subdir: "vol"}); err != nil {
+		t.Fatalf("DeleteDirectory() error = %v", err)
+	}
+	if _, err := os.Stat(volumeDir); !os.IsNotExist(err) {
+		t.Errorf("volume directory was not removed: %v", err)
+	}
+}
+
+func TestAgentRequiresClientCertificate(t *testing.T) {
+	serverConfig, clientConfig := newTLSConfigs(t)
+	fake, err := StartFake(map[string]string{"/export": t.TempDir()}, serverConfig)
+	if err != nil {
+		t.Fatalf("StartFake() error = %v", err)
+	}
+	defer fake.Stop()
+
+	// Trusts the agent but presents no certificate of its own
+	anonymous := clientConfig.Clone()
+	anonymous.Certificates = nil
+	c, err := Dial(fake.Addr, anonymous)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := c.Usage(ctx, &UsageRequest{Basedir: "/export"}); status.Code(err) != codes.Unavailable {
+		t.Errorf("Usage() without client certificate error = %v, want code %v", err, codes.Unavailable)
+	}
+}
+
+func TestParseExports(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    int
+		wantErr bool
+	}{
+		{
+			name:  "several exports",
+			value: "/export=/srv/export,/data/=/data",
+			want:  2,
+		},
+		{
+			name:    "relative export",
+			value:   "export=/srv/export",
+			wantErr: true,
+		},
+		{
+			name:    "missing local path",
+			value:   "/export",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseExports(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseExports() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != tt.want {
+				t.Errorf("ParseExports() = %v, want %d exports", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+// Package agent implements the storage agent, a grpc service running on the nfs server host
+// which manages volume directories, project quotas and snapshots for remote controllers.
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+const serviceName = "simplecsi.agent.v1.StorageAgent"
+
+// DirectoryRequest names a volume directory, Basedir is the exported directory as nfs
+// clients see it and Subdir the volume below it
+type DirectoryRequest struct {
+	Basedir string `json:"basedir"`
+	Subdir  string `json:"subdir"`
+	// Permission bits of a created directory
+	Mode uint32 `json:"mode,omitempty"`
+	// Limit of a created directory if the agent enforces project quotas
+	CapacityBytes int64 `json:"capacityBytes,omitempty"`
+}
+
+type DirectoryResponse struct {
+	// Limit the directory got, 0 if it has none
+	CapacityBytes int64 `json:"capacityBytes,omitempty"`
+}
+
+// QuotaRequest limits an existing volume directory, 0 bytes removes the limit
+type QuotaRequest struct {
+	Basedir string `json:"basedir"`
+	Subdir  string `json:"subdir"`
+	Bytes   int64  `json:"bytes"`
+}
+
+// SnapshotRequest names the snapshot Name of the volume directory Subdir, Source is
+// recorded to tell retries from another snapshot of the same name
+type SnapshotRequest struct {
+	Basedir string `json:"basedir"`
+	Subdir  string `json:"subdir,omitempty"`
+	Name    string `json:"name"`
+	Source  string `json:"source,omitempty"`
+}
+
+type SnapshotResponse struct {
+	CreationTime time.Time `json:"creationTime"`
+}
+
+// CloneRequest creates the volume directory Subdir from the snapshot, or as a copy of the
+// volume directory Volume
+type CloneRequest struct {
+	Basedir       string `json:"basedir"`
+	Subdir        string `json:"subdir"`
+	Snapshot      string `json:"snapshot,omitempty"`
+	Volume        string `json:"volume,omitempty"`
+	Mode          uint32 `json:"mode,omitempty"`
+	CapacityBytes int64  `json:"capacityBytes,omitempty"`
+}
+
+type UsageRequest struct {
+	Basedir string `json:"basedir"`
+}
+
+type UsageResponse struct {
+	TotalBytes     int64 `json:"totalBytes"`
+	AvailableBytes int64 `json:"availableBytes"`
+}
+
+type ListRequest struct {
+	Basedir string `json:"basedir"`
+}
+
+type ListResponse struct {
+	// Volume directories directly below the basedir
+	Subdirs []string `json:"subdirs"`
+}
+
+type Empty struct{}
+
+// StorageAgentServer is the service the agent implements
+type StorageAgentServer interface {
+	CreateDirectory(context.Context, *DirectoryRequest) (*DirectoryResponse, error)
+	DeleteDirectory(context.Context, *DirectoryRequest) (*Empty, error)
+	SetQuota(context.Context, *QuotaRequest) (*Empty, error)
+	CreateSnapshot(context.Context, *SnapshotRequest) (*SnapshotResponse, error)
+	DeleteSnapshot(context.Context, *SnapshotRequest) (*Empty, error)
+	Clone(context.Context, *CloneRequest) (*DirectoryResponse, error)
+	Usage(context.Context, *UsageRequest) (*UsageResponse, error)
+	ListDirectories(context.Context, *ListRequest) (*ListResponse, error)
+}
+
+// codec encodes the messages as json, they are plain structs rather than protobufs
+type codec struct{}
+
+func (codec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (codec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (codec) Name() string {
+	return "json"
+}
+
+// unaryMethod builds the description of a unary method calling fn
+func unaryMethod[Req, Resp any](name string, fn func(StorageAgentServer, context.Context, *Req) (*Resp, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			req := new(Req)
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			if interceptor == nil {
+				return fn(srv.(StorageAgentServer), ctx, req)
+			}
+			info := &grpc.UnaryServerInfo{
+				Server:     srv,
+				FullMethod: "/" + serviceName + "/" + name,
+			}
+			return interceptor(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				return fn(srv.(StorageAgentServer), ctx, req.(*Req))
+			})
+		},
+	}
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*StorageAgentServer)(nil),
+	Methods: []grpc.MethodDesc{
+		unaryMethod("CreateDirectory", StorageAgentServer.CreateDirectory),
+		unaryMethod("DeleteDirectory", StorageAgentServer.DeleteDirectory),
+		unaryMethod("SetQuota", StorageAgentServer.SetQuota),
+		unaryMethod("CreateSnapshot", StorageAgentServer.CreateSnapshot),
+		unaryMethod("DeleteSnapshot", StorageAgentServer.DeleteSnapshot),
+		unaryMethod("Clone", StorageAgentServer.Clone),
+		unaryMethod("Usage", StorageAgentServer.Usage),
+		unaryMethod("ListDirectories", StorageAgentServer.ListDirectories),
+	},
+	Streams: []grpc.StreamDesc{},
+}
+
+// RegisterStorageAgentServer registers the agent service on the grpc server, which has to
+// be created with ServerOptions
+func RegisterStorageAgentServer(s *grpc.Server, srv StorageAgentServer) {
+	s.RegisterService(&serviceDesc, srv)
+}
+
+// ServerOptions are the options a grpc server serving the agent needs
+func ServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{grpc.ForceServerCodec(codec{})}
+}
@@ -0,0 +1,77 @@
+package agent
+
+import (
+	"context"
+	"crypto/tls"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Client talks to a storage agent, errors of the agent are returned as grpc status errors
+type Client struct {
+	conn *grpc.ClientConn
+}
+
+// Dial connects to the agent at addr lazily, without tlsConfig the connection is not
+// encrypted which only the fake agent accepts
+func Dial(addr string, tlsConfig *tls.Config) (*Client, error) {
+	creds := insecure.NewCredentials()
+	if tlsConfig != nil {
+		creds = credentials.NewTLS(tlsConfig)
+	}
+	conn, err := grpc.Dial(addr,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(codec{})),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: conn}, nil
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Client) invoke(ctx context.Context, method string, req, resp interface{}) error {
+	return c.conn.Invoke(ctx, "/"+serviceName+"/"+method, req, resp)
+}
+
+func (c *Client) CreateDirectory(ctx context.Context, req *DirectoryRequest) (*DirectoryResponse, error) {
+	resp := &DirectoryResponse{}
+	return resp, c.invoke(ctx, "CreateDirectory", req, resp)
+}
+
+func (c *Client) DeleteDirectory(ctx context.Context, req *DirectoryRequest) error {
+	return c.invoke(ctx, "DeleteDirectory", req, &Empty{})
+}
+
+func (c *Client) SetQuota(ctx context.Context, req *QuotaRequest) error {
+	return c.invoke(ctx, "SetQuota", req, &Empty{})
+}
+
+func (c *Client) CreateSnapshot(ctx context.Context, req *SnapshotRequest) (*SnapshotResponse, error) {
+	resp := &SnapshotResponse{}
+	return resp, c.invoke(ctx, "CreateSnapshot", req, resp)
+}
+
+func (c *Client) DeleteSnapshot(ctx context.Context, req *SnapshotRequest) error {
+	return c.invoke(ctx, "DeleteSnapshot", req, &Empty{})
+}
+
+func (c *Client) Clone(ctx context.Context, req *CloneRequest) (*DirectoryResponse, error) {
+	resp := &DirectoryResponse{}
+	return resp, c.invoke(ctx, "Clone", req, resp)
+}
+
+func (c *Client) Usage(ctx context.Context, req *UsageRequest) (*UsageResponse, error) {
+	resp := &UsageResponse{}
+	return resp, c.invoke(ctx, "Usage", req, resp)
+}
+
+func (c *Client) ListDirectories(ctx context.Context, req *ListRequest) (*ListResponse, error) {
+	resp := &ListResponse{}
+	return resp, c.invoke(ctx, "ListDirectories", req, resp)
+}
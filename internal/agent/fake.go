@@ -0,0 +1,49 @@
+package agent
+
+import (
+	"crypto/tls"
+	"net"
+
+	"github.com/chenliu1993/simple-csi-driver/internal/localfs"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// Fake is an in-process agent for tests, it copies snapshots without reflinks and keeps
+// project quotas in memory so it runs on any filesystem without privileges
+type Fake struct {
+	// Address the agent listens on
+	Addr string
+	// Filesystem operations of the agent, holding the quotas set
+	FS *localfs.Fake
+
+	server *grpc.Server
+}
+
+// StartFake serves a fake agent for the exports on a local port, with tlsConfig it requires
+// tls like the real agent
+func StartFake(exports map[string]string, tlsConfig *tls.Config) (*Fake, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	opts := ServerOptions()
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	fs := localfs.NewFake()
+	s := grpc.NewServer(opts...)
+	RegisterStorageAgentServer(s, NewServer(exports, fs, true))
+	go s.Serve(l) //nolint:errcheck // stopped by Stop
+
+	return &Fake{
+		Addr:   l.Addr().String(),
+		FS:     fs,
+		server: s,
+	}, nil
+}
+
+// Stop stops serving and drops all connections
+func (f *Fake) Stop() {
+	f.server.Stop()
+}
@@ -0,0 +1,190 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/chenliu1993/simple-csi-driver/internal/localfs"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// Check if implements StorageAgentServer
+var _ StorageAgentServer = &Server{}
+
+// Server manages the volumes below the local directories backing the exports
+type Server struct {
+	// Local directory of every exported directory
+	exports       map[string]string
+	ops           localfs.Ops
+	projectQuotas bool
+}
+
+// NewServer returns an agent for the exports, a map of exported directories to the local
+// directories backing them
+func NewServer(exports map[string]string, ops localfs.Ops, projectQuotas bool) *Server {
+	cleaned := make(map[string]string, len(exports))
+	for export, dir := range exports {
+		cleaned[filepath.Join("/", export)] = filepath.Clean(dir)
+	}
+	return &Server{
+		exports:       cleaned,
+		ops:           ops,
+		projectQuotas: projectQuotas,
+	}
+}
+
+// ParseExports parses comma separated /export=/local/path mappings
+func ParseExports(s string) (map[string]string, error) {
+	exports := make(map[string]string)
+	for _, mapping := range strings.Split(s, ",") {
+		mapping = strings.TrimSpace(mapping)
+		if mapping == "" {
+			continue
+		}
+		kv := strings.SplitN(mapping, "=", 2)
+		if len(kv) != 2 || !filepath.IsAbs(kv[0]) || !filepath.IsAbs(kv[1]) {
+			return nil, fmt.Errorf("invalid export %q, expecting /export=/local/path", mapping)
+		}
+		exports[filepath.Clean(kv[0])] = filepath.Clean(kv[1])
+	}
+	return exports, nil
+}
+
+// volumes returns the volumes of basedir, which may lie below an export
+func (s *Server) volumes(basedir string) (*localfs.Volumes, error) {
+	dir := filepath.Join("/", basedir)
+	for d := dir; ; d = filepath.Dir(d) {
+		if p, ok := s.exports[d]; ok {
+			rel, err := filepath.Rel(d, dir)
+			if err != nil {
+				return nil, status.Error(codes.InvalidArgument, err.Error())
+			}
+			return &localfs.Volumes{
+				Base:          filepath.Join(p, rel),
+				Ops:           s.ops,
+				ProjectQuotas: s.projectQuotas,
+			}, nil
+		}
+		if d == "/" {
+			return nil, status.Errorf(codes.NotFound, "%s is not exported by the agent", dir)
+		}
+	}
+}
+
+// toStatus maps errors of the filesystem operations to grpc errors
+func toStatus(err error, format string, args ...interface{}) error {
+	return status.Errorf(localfs.Code(err), "%s: %v", fmt.Sprintf(format, args...), err)
+}
+
+func (s *Server) CreateDirectory(ctx context.Context, req *DirectoryRequest) (*DirectoryResponse, error) {
+	vols, err := s.volumes(req.Basedir)
+	if err != nil {
+		return nil, err
+	}
+	capacity, err := vols.Create(ctx, req.Subdir, os.FileMode(req.Mode), req.CapacityBytes, "")
+	if err != nil {
+		return nil, toStatus(err, "failed to create %s", req.Subdir)
+	}
+	klog.V(4).InfoS("Created volume directory", "basedir", req.Basedir, "subdir", req.Subdir, "capacity", capacity)
+	return &DirectoryResponse{CapacityBytes: capacity}, nil
+}
+
+func (s *Server) DeleteDirectory(ctx context.Context, req *DirectoryRequest) (*Empty, error) {
+	vols, err := s.volumes(req.Basedir)
+	if err != nil {
+		return nil, err
+	}
+	if err := vols.Delete(ctx, req.Subdir); err != nil {
+		return nil, toStatus(err, "failed to delete %s", req.Subdir)
+	}
+	klog.V(4).InfoS("Deleted volume directory", "basedir", req.Basedir, "subdir", req.Subdir)
+	return &Empty{}, nil
+}
+
+func (s *Server) SetQuota(ctx context.Context, req *QuotaRequest) (*Empty, error) {
+	if !s.projectQuotas {
+		return nil, status.Error(codes.FailedPrecondition, "the agent does not enforce project quotas")
+	}
+	vols, err := s.volumes(req.Basedir)
+	if err != nil {
+		return nil, err
+	}
+	if err := vols.SetQuota(req.Subdir, req.Bytes); err != nil {
+		return nil, toStatus(err, "failed to set quota of %s", req.Subdir)
+	}
+	return &Empty{}, nil
+}
+
+func (s *Server) CreateSnapshot(ctx context.Context, req *SnapshotRequest) (*SnapshotResponse, error) {
+	vols, err := s.volumes(req.Basedir)
+	if err != nil {
+		return nil, err
+	}
+	created, err := vols.CreateSnapshot(ctx, req.Subdir, req.Name, req.Source)
+	if err != nil {
+		return nil, toStatus(err, "failed to snapshot %s", req.Subdir)
+	}
+	return &SnapshotResponse{CreationTime: created}, nil
+}
+
+func (s *Server) DeleteSnapshot(ctx context.Context, req *SnapshotRequest) (*Empty, error) {
+	vols, err := s.volumes(req.Basedir)
+	if err != nil {
+		return nil, err
+	}
+	if err := vols.DeleteSnapshot(req.Name); err != nil {
+		return nil, toStatus(err, "failed to delete snapshot %s", req.Name)
+	}
+	return &Empty{}, nil
+}
+
+func (s *Server) Clone(ctx context.Context, req *CloneRequest) (*DirectoryResponse, error) {
+	if (req.Snapshot == "") == (req.Volume == "") {
+		return nil, status.Error(codes.InvalidArgument, "either a snapshot or a volume is required")
+	}
+	vols, err := s.volumes(req.Basedir)
+	if err != nil {
+		return nil, err
+	}
+	if req.Volume != "" {
+		capacity, err := vols.Clone(ctx, req.Subdir, os.FileMode(req.Mode), req.CapacityBytes, req.Volume)
+		if err != nil {
+			return nil, toStatus(err, "failed to clone %s into %s", req.Volume, req.Subdir)
+		}
+		return &DirectoryResponse{CapacityBytes: capacity}, nil
+	}
+	capacity, err := vols.Create(ctx, req.Subdir, os.FileMode(req.Mode), req.CapacityBytes, req.Snapshot)
+	if err != nil {
+		return nil, toStatus(err, "failed to restore snapshot %s into %s", req.Snapshot, req.Subdir)
+	}
+	return &DirectoryResponse{CapacityBytes: capacity}, nil
+}
+
+func (s *Server) Usage(ctx context.Context, req *UsageRequest) (*UsageResponse, error) {
+	vols, err := s.volumes(req.Basedir)
+	if err != nil {
+		return nil, err
+	}
+	total, available, err := vols.Usage()
+	if err != nil {
+		return nil, toStatus(err, "failed to get the usage of %s", req.Basedir)
+	}
+	return &UsageResponse{TotalBytes: total, AvailableBytes: available}, nil
+}
+
+func (s *Server) ListDirectories(ctx context.Context, req *ListRequest) (*ListResponse, error) {
+	vols, err := s.volumes(req.Basedir)
+	if err != nil {
+		return nil, err
+	}
+	subdirs, err := vols.List()
+	if err != nil {
+		return nil, toStatus(err, "failed to list %s", req.Basedir)
+	}
+	return &ListResponse{Subdirs: subdirs}, nil
+}
@@ -0,0 +1,54 @@
+package agent
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// loadTLS loads the key pair and the ca verifying the peer
+func loadTLS(certFile, keyFile, caFile string) (tls.Certificate, *x509.CertPool, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	ca, err := os.ReadFile(caFile)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return tls.Certificate{}, nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+	return cert, pool, nil
+}
+
+// ServerTLSConfig presents the certificate of the agent and only accepts controllers with
+// a client certificate signed by the ca
+func ServerTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cert, pool, err := loadTLS(certFile, keyFile, caFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// ClientTLSConfig presents the certificate of the controller and verifies agents against
+// the ca
+func ClientTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cert, pool, err := loadTLS(certFile, keyFile, caFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
@@ -0,0 +1,91 @@
+package localfs
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// Fake copies files without reflinks and keeps project quotas in memory, for tests on
+// filesystems without either
+type Fake struct {
+	lock *sync.Mutex
+
+	// Like real projects the quotas stick to the directory, not its path
+	quotas map[os.FileInfo]int64
+}
+
+func NewFake() *Fake {
+	return &Fake{
+		lock:   &sync.Mutex{},
+		quotas: make(map[os.FileInfo]int64),
+	}
+}
+
+// lookup returns the key of the quota of dir, or nil
+func (f *Fake) lookup(fi os.FileInfo) os.FileInfo {
+	for key := range f.quotas {
+		if os.SameFile(key, fi) {
+			return key
+		}
+	}
+	return nil
+}
+
+func (f *Fake) SetProjectQuota(dir, base string, bytes int64) error {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if key := f.lookup(fi); key != nil {
+		fi = key
+	}
+	f.quotas[fi] = bytes
+	return nil
+}
+
+func (f *Fake) ClearProjectQuota(dir, base string) error {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if key := f.lookup(fi); key != nil {
+		delete(f.quotas, key)
+	}
+	return nil
+}
+
+// Quota returns the limit set on dir, 0 if there is none
+func (f *Fake) Quota(dir string) int64 {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return 0
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if key := f.lookup(fi); key != nil {
+		return f.quotas[key]
+	}
+	return 0
+}
+
+func (f *Fake) CopyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
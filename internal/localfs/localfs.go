@@ -0,0 +1,393 @@
+// Package localfs manages volume directories, their project quotas and reflink snapshots
+// on the local filesystem backing an nfs export.
+package localfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/pkg/volume"
+)
+
+const (
+	// SnapshotsDir is the directory below the base holding the snapshots of its volumes
+	SnapshotsDir = ".snapshots"
+	// suffix of the file next to a snapshot recording what it was taken of
+	snapshotSourceSuffix = ".source"
+)
+
+var (
+	// ErrProjectQuotaUnsupported is returned when the filesystem has no project quotas enabled
+	ErrProjectQuotaUnsupported = errors.New("project quotas are not supported")
+	// ErrReflinkUnsupported is returned when the filesystem cannot share blocks between files
+	ErrReflinkUnsupported = errors.New("reflinks are not supported")
+	// ErrSnapshotExists is returned when a snapshot name is taken by another source
+	ErrSnapshotExists = errors.New("snapshot exists already for another source")
+	// ErrInvalidName is returned for subdirs and snapshot names outside of the base
+	ErrInvalidName = errors.New("invalid name")
+)
+
+// Ops are the filesystem operations which need support of the filesystem
+type Ops interface {
+	// SetProjectQuota limits dir below base to bytes with a project of its own
+	SetProjectQuota(dir, base string, bytes int64) error
+	// ClearProjectQuota removes the limit of the project of dir
+	ClearProjectQuota(dir, base string) error
+	// CopyFile creates dst with the content of src
+	CopyFile(src, dst string, mode os.FileMode) error
+}
+
+// Host performs the operations on the host, files are copied with reflinks
+var Host Ops = host{}
+
+type host struct{}
+
+func (host) SetProjectQuota(dir, base string, bytes int64) error {
+	return setProjectQuota(dir, base, bytes)
+}
+
+func (host) ClearProjectQuota(dir, base string) error {
+	return clearProjectQuota(dir, base)
+}
+
+func (host) CopyFile(src, dst string, mode os.FileMode) error {
+	return reflinkFile(src, dst, mode)
+}
+
+// Volumes manages volume directories and snapshots below Base, the local directory
+// backing an export
+type Volumes struct {
+	Base string
+	Ops  Ops
+	// Limit volumes to their capacity with project quotas
+	ProjectQuotas bool
+}
+
+// path returns the directory of subdir, which cannot escape Base nor lie in a hidden
+// directory such as the snapshots
+func (v *Volumes) path(subdir string) (string, error) {
+	rel := strings.TrimPrefix(filepath.Clean("/"+subdir), "/")
+	first := strings.SplitN(rel, "/", 2)[0]
+	if rel == "" || first == SnapshotsDir || strings.HasPrefix(first, ".") {
+		return "", fmt.Errorf("%w: subdir %q", ErrInvalidName, subdir)
+	}
+	return filepath.Join(v.Base, rel), nil
+}
+
+// ValidateSnapshotName makes sure name is a single directory of the snapshots which does
+// not clash with the temporary directories and source files kept next to them
+func ValidateSnapshotName(name string) error {
+	if name == "" || strings.Contains(name, "/") || strings.HasPrefix(name, ".") || strings.HasSuffix(name, snapshotSourceSuffix) {
+		return fmt.Errorf("%w: snapshot %q", ErrInvalidName, name)
+	}
+	return nil
+}
+
+// SnapshotDir returns the directory of the snapshot name
+func (v *Volumes) SnapshotDir(name string) (string, error) {
+	if err := ValidateSnapshotName(name); err != nil {
+		return "", err
+	}
+	return filepath.Join(v.Base, SnapshotsDir, name), nil
+}
+
+// Create creates the directory of subdir, restored from the snapshot if set. With project
+// quotas and a capacity the directory is limited to capacity bytes, which is returned as the
+// size of the volume. Creating an existing directory succeeds.
+func (v *Volumes) Create(ctx context.Context, subdir string, mode os.FileMode, capacity int64, snapshot string) (int64, error) {
+	var snapshotDir string
+	if snapshot != "" {
+		var err error
+		if snapshotDir, err = v.SnapshotDir(snapshot); err != nil {
+			return 0, err
+		}
+	}
+	return v.create(ctx, subdir, mode, capacity, snapshotDir)
+}
+
+// Clone creates the directory of subdir as a copy of the directory of the volume source,
+// like Create otherwise
+func (v *Volumes) Clone(ctx context.Context, subdir string, mode os.FileMode, capacity int64, source string) (int64, error) {
+	sourceDir, err := v.path(source)
+	if err != nil {
+		return 0, err
+	}
+	return v.create(ctx, subdir, mode, capacity, sourceDir)
+}
+
+// create creates the directory of subdir with the content of srcDir if set
+func (v *Volumes) create(ctx context.Context, subdir string, mode os.FileMode, capacity int64, srcDir string) (int64, error) {
+	dir, err := v.path(subdir)
+	if err != nil {
+		return 0, err
+	}
+	if srcDir != "" {
+		if _, err := os.Stat(srcDir); err != nil {
+			return 0, err
+		}
+	}
+	if !v.ProjectQuotas {
+		capacity = 0
+	}
+	if _, err := os.Stat(dir); err == nil {
+		klog.FromContext(ctx).V(4).Info("Volume directory exists already", "path", dir)
+		return capacity, nil
+	}
+
+	// Build the volume aside so a failed restore never leaves a half populated volume behind
+	tmp := filepath.Join(filepath.Dir(dir), "."+filepath.Base(dir)+".tmp")
+	if err := os.RemoveAll(tmp); err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return 0, err
+	}
+	if err := os.Mkdir(tmp, mode); err != nil {
+		return 0, err
+	}
+	err = func() error {
+		if capacity > 0 {
+			// Set before restoring, files created afterwards inherit the project
+			if err := v.Ops.SetProjectQuota(tmp, v.Base, capacity); err != nil {
+				return err
+			}
+		}
+		if srcDir != "" {
+			if err := v.copyTree(ctx, srcDir, tmp); err != nil {
+				return err
+			}
+		}
+		return os.Rename(tmp, dir)
+	}()
+	if err != nil {
+		if capacity > 0 {
+			if err := v.Ops.ClearProjectQuota(tmp, v.Base); err != nil {
+				klog.FromContext(ctx).Error(err, "Failed to clear the project quota", "path", tmp)
+			}
+		}
+		if err := os.RemoveAll(tmp); err != nil {
+			klog.FromContext(ctx).Error(err, "Failed to remove the temporary directory", "path", tmp)
+		}
+		return 0, err
+	}
+	return capacity, nil
+}
+
+// Delete removes the directory of subdir and releases its project quota
+func (v *Volumes) Delete(ctx context.Context, subdir string) error {
+	dir, err := v.path(subdir)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil
+	}
+	if v.ProjectQuotas {
+		if err := v.Ops.ClearProjectQuota(dir, v.Base); err != nil {
+			klog.FromContext(ctx).Error(err, "Failed to clear the project quota", "path", dir)
+		}
+	}
+	klog.FromContext(ctx).V(4).Info("Removing the volume directory", "path", dir)
+	return os.RemoveAll(dir)
+}
+
+// SetQuota limits the existing directory of subdir to bytes, 0 removes the limit
+func (v *Volumes) SetQuota(subdir string, bytes int64) error {
+	dir, err := v.path(subdir)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(dir); err != nil {
+		return err
+	}
+	if bytes == 0 {
+		return v.Ops.ClearProjectQuota(dir, v.Base)
+	}
+	return v.Ops.SetProjectQuota(dir, v.Base, bytes)
+}
+
+// CreateSnapshot copies the directory of subdir into the snapshot name, source is recorded
+// to tell retries from another snapshot of the same name
+func (v *Volumes) CreateSnapshot(ctx context.Context, subdir, name, source string) (time.Time, error) {
+	dir, err := v.path(subdir)
+	if err != nil {
+		return time.Time{}, err
+	}
+	snapshotDir, err := v.SnapshotDir(name)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if _, err := os.Stat(snapshotDir); os.IsNotExist(err) {
+		if _, err := os.Stat(dir); err != nil {
+			return time.Time{}, err
+		}
+		if err := os.MkdirAll(filepath.Dir(snapshotDir), 0700); err != nil {
+			return time.Time{}, err
+		}
+		tmp := filepath.Join(filepath.Dir(snapshotDir), "."+name+".tmp")
+		if err := os.RemoveAll(tmp); err != nil {
+			return time.Time{}, err
+		}
+		err := func() error {
+			if err := os.Mkdir(tmp, 0700); err != nil {
+				return err
+			}
+			if err := v.copyTree(ctx, dir, tmp); err != nil {
+				return err
+			}
+			if err := os.WriteFile(snapshotDir+snapshotSourceSuffix, []byte(source), 0600); err != nil {
+				return err
+			}
+			return os.Rename(tmp, snapshotDir)
+		}()
+		if err != nil {
+			if err := os.RemoveAll(tmp); err != nil {
+				klog.FromContext(ctx).Error(err, "Failed to remove the temporary directory", "path", tmp)
+			}
+			return time.Time{}, err
+		}
+	}
+
+	b, err := os.ReadFile(snapshotDir + snapshotSourceSuffix)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if string(b) != source {
+		return time.Time{}, fmt.Errorf("%w: %s was taken of %s", ErrSnapshotExists, name, b)
+	}
+	fi, err := os.Stat(snapshotDir + snapshotSourceSuffix)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return fi.ModTime(), nil
+}
+
+// DeleteSnapshot removes the snapshot name, removing a missing snapshot succeeds
+func (v *Volumes) DeleteSnapshot(name string) error {
+	snapshotDir, err := v.SnapshotDir(name)
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(snapshotDir); err != nil {
+		return err
+	}
+	if err := os.Remove(snapshotDir + snapshotSourceSuffix); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// List returns the volume directories directly below Base, hidden directories such as the
+// snapshots are left out
+func (v *Volumes) List() ([]string, error) {
+	entries, err := os.ReadDir(v.Base)
+	if err != nil {
+		return nil, err
+	}
+	var subdirs []string
+	for _, e := range entries {
+		if e.IsDir() && !strings.HasPrefix(e.Name(), ".") {
+			subdirs = append(subdirs, e.Name())
+		}
+	}
+	return subdirs, nil
+}
+
+// Usage returns the total and available bytes of the filesystem holding Base
+func (v *Volumes) Usage() (int64, int64, error) {
+	volumeMetrics, err := volume.NewMetricsStatFS(v.Base).GetMetrics()
+	if err != nil {
+		return 0, 0, err
+	}
+	total, ok := volumeMetrics.Capacity.AsInt64()
+	if !ok {
+		return 0, 0, fmt.Errorf("failed to transform capacity size(%v)", volumeMetrics.Capacity)
+	}
+	available, ok := volumeMetrics.Available.AsInt64()
+	if !ok {
+		return 0, 0, fmt.Errorf("failed to transform available size(%v)", volumeMetrics.Available)
+	}
+	return total, available, nil
+}
+
+// copyTree recreates the tree under src in the existing directory dst
+func (v *Volumes) copyTree(ctx context.Context, src, dst string) error {
+	return filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch mode := fi.Mode(); {
+		case rel == ".":
+			if err := os.Chmod(target, mode.Perm()); err != nil {
+				return err
+			}
+		case mode.IsDir():
+			if err := os.Mkdir(target, mode.Perm()); err != nil {
+				return err
+			}
+		case mode&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if err := os.Symlink(link, target); err != nil {
+				return err
+			}
+		case mode.IsRegular():
+			if err := v.Ops.CopyFile(path, target, mode.Perm()); err != nil {
+				return err
+			}
+		default:
+			klog.FromContext(ctx).V(4).Info("Skipping special file", "path", path)
+			return nil
+		}
+		return lchownLike(target, fi)
+	})
+}
+
+// reflinkFile creates dst sharing the blocks of src
+func reflinkFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	if err := cloneFile(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// Code maps errors of the operations to grpc codes
+func Code(err error) codes.Code {
+	switch {
+	case errors.Is(err, ErrInvalidName):
+		return codes.InvalidArgument
+	case errors.Is(err, ErrProjectQuotaUnsupported), errors.Is(err, ErrReflinkUnsupported):
+		return codes.FailedPrecondition
+	case errors.Is(err, ErrSnapshotExists), errors.Is(err, os.ErrExist):
+		return codes.AlreadyExists
+	case errors.Is(err, os.ErrNotExist):
+		return codes.NotFound
+	}
+	return codes.Internal
+}
@@ -0,0 +1,196 @@
+package localfs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+)
+
+func newTestVolumes(t *testing.T) (*Volumes, *Fake) {
+	t.Helper()
+	fs := NewFake()
+	return &Volumes{Base: t.TempDir(), Ops: fs, ProjectQuotas: true}, fs
+}
+
+func TestVolumesCreateDelete(t *testing.T) {
+	v, fs := newTestVolumes(t)
+
+	capacity, err := v.Create(context.Background(), "vol", 0750, 1<<20, "")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	dir := filepath.Join(v.Base, "vol")
+	if capacity != 1<<20 || fs.Quota(dir) != 1<<20 {
+		t.Errorf("Create() = %d, quota %d, want %d", capacity, fs.Quota(dir), 1<<20)
+	}
+	if _, err := os.Stat(filepath.Join(v.Base, ".vol.tmp")); !os.IsNotExist(err) {
+		t.Errorf("temporary directory was left behind: %v", err)
+	}
+	if _, err := v.Create(context.Background(), "vol", 0750, 1<<20, ""); err != nil {
+		t.Errorf("Create() of an existing volume error = %v", err)
+	}
+
+	if err := v.SetQuota("vol", 2<<20); err != nil || fs.Quota(dir) != 2<<20 {
+		t.Errorf("SetQuota() error = %v, quota %d", err, fs.Quota(dir))
+	}
+
+	if err := v.Delete(context.Background(), "vol"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("volume directory was not removed: %v", err)
+	}
+	if err := v.Delete(context.Background(), "vol"); err != nil {
+		t.Errorf("Delete() of a missing volume error = %v", err)
+	}
+}
+
+func TestVolumesInvalidNames(t *testing.T) {
+	v, _ := newTestVolumes(t)
+
+	tests := []struct {
+		name string
+		fn   func() error
+	}{
+		{
+			name: "base itself",
+			fn: func() error {
+				_, err := v.Create(context.Background(), "..", 0750, 0, "")
+				return err
+			},
+		},
+		{
+			name: "snapshots directory",
+			fn: func() error {
+				return v.Delete(context.Background(), SnapshotsDir)
+			},
+		},
+		{
+			name: "under the snapshots directory",
+			fn: func() error {
+				return v.Delete(context.Background(), "/"+SnapshotsDir+"/snap")
+			},
+		},
+		{
+			name: "hidden subdir",
+			fn: func() error {
+				_, err := v.Create(context.Background(), "a/../.vol.tmp", 0750, 0, "")
+				return err
+			},
+		},
+		{
+			name: "hidden snapshot",
+			fn: func() error {
+				_, err := v.CreateSnapshot(context.Background(), "vol", ".snap", "vol")
+				return err
+			},
+		},
+		{
+			name: "restore from a hidden snapshot",
+			fn: func() error {
+				_, err := v.Create(context.Background(), "vol", 0750, 0, "..")
+				return err
+			},
+		},
+		{
+			name: "snapshot clashing with a source file",
+			fn: func() error {
+				_, err := v.CreateSnapshot(context.Background(), "vol", "snap"+snapshotSourceSuffix, "vol")
+				return err
+			},
+		},
+		{
+			name: "nested snapshot",
+			fn: func() error {
+				return v.DeleteSnapshot("a/b")
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.fn(); Code(err) != codes.InvalidArgument {
+				t.Errorf("error = %v, want code %v", err, codes.InvalidArgument)
+			}
+		})
+	}
+}
+
+func TestVolumesSnapshot(t *testing.T) {
+	v, _ := newTestVolumes(t)
+	if _, err := v.Create(context.Background(), "vol", 0750, 0, ""); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(v.Base, "vol", "data"), []byte("hello"), 0600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if err := os.Symlink("data", filepath.Join(v.Base, "vol", "link")); err != nil {
+		t.Fatalf("Symlink() error = %v", err)
+	}
+
+	created, err := v.CreateSnapshot(context.Background(), "vol", "snap", "vol-id")
+	if err != nil {
+		t.Fatalf("CreateSnapshot() error = %v", err)
+	}
+	if again, err := v.CreateSnapshot(context.Background(), "vol", "snap", "vol-id"); err != nil || !again.Equal(created) {
+		t.Errorf("CreateSnapshot() retry = %v, %v, want %v", again, err, created)
+	}
+	if _, err := v.CreateSnapshot(context.Background(), "other", "snap", "other-id"); !errors.Is(err, ErrSnapshotExists) {
+		t.Errorf("CreateSnapshot() of another source error = %v, want %v", err, ErrSnapshotExists)
+	}
+
+	if _, err := v.Create(context.Background(), "restored", 0750, 0, "snap"); err != nil {
+		t.Fatalf("Create() from snapshot error = %v", err)
+	}
+	if b, err := os.ReadFile(filepath.Join(v.Base, "restored", "link")); err != nil || string(b) != "hello" {
+		t.Errorf("restored data = %q, %v", b, err)
+	}
+	if _, err := v.Create(context.Background(), "missing", 0750, 0, "nosnap"); Code(err) != codes.NotFound {
+		t.Errorf("Create() from a missing snapshot error = %v, want code %v", err, codes.NotFound)
+	}
+
+	if err := v.DeleteSnapshot("snap"); err != nil {
+		t.Fatalf("DeleteSnapshot() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(v.Base, SnapshotsDir, "snap"+snapshotSourceSuffix)); !os.IsNotExist(err) {
+		t.Errorf("snapshot source was not removed: %v", err)
+	}
+}
+
+func TestVolumesCloneList(t *testing.T) {
+	v, fs := newTestVolumes(t)
+	if _, err := v.Create(context.Background(), "vol", 0750, 0, ""); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(v.Base, "vol", "data"), []byte("hello"), 0600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if _, err := v.CreateSnapshot(context.Background(), "vol", "snap", "vol-id"); err != nil {
+		t.Fatalf("CreateSnapshot() error = %v", err)
+	}
+
+	capacity, err := v.Clone(context.Background(), "clone", 0750, 1<<20, "vol")
+	if err != nil {
+		t.Fatalf("Clone() error = %v", err)
+	}
+	if capacity != 1<<20 || fs.Quota(filepath.Join(v.Base, "clone")) != 1<<20 {
+		t.Errorf("Clone() = %d, quota %d, want %d", capacity, fs.Quota(filepath.Join(v.Base, "clone")), 1<<20)
+	}
+	if b, err := os.ReadFile(filepath.Join(v.Base, "clone", "data")); err != nil || string(b) != "hello" {
+		t.Errorf("cloned data = %q, %v", b, err)
+	}
+	if _, err := v.Clone(context.Background(), "missing", 0750, 0, "novol"); Code(err) != codes.NotFound {
+		t.Errorf("Clone() of a missing volume error = %v, want code %v", err, codes.NotFound)
+	}
+
+	subdirs, err := v.List()
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(subdirs) != 2 || subdirs[0] != "clone" || subdirs[1] != "vol" {
+		t.Errorf("List() = %v, want [clone vol]", subdirs)
+	}
+}
@@ -0,0 +1,234 @@
+package localfs
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+	mount "k8s.io/mount-utils"
+)
+
+// Project quotas go through the xfs quota interface, which ext4 implements as well
+const (
+	fsIocFsgetxattr    = 0x801c581f
+	fsIocFssetxattr    = 0x401c5820
+	fsXflagProjinherit = 0x200
+
+	qXSetQLim         = 0x5804
+	prjQuota          = 2
+	fsDquotVersion    = 1
+	fsProjQuota       = 2
+	fsDqBhard         = 1 << 3
+	quotaBlockSize    = 512
+	maxProjectID      = 1<<31 - 1
+	procSelfMountInfo = "/proc/self/mountinfo"
+)
+
+// fsxattr mirrors struct fsxattr of linux/fs.h
+type fsxattr struct {
+	Xflags     uint32
+	Extsize    uint32
+	Nextents   uint32
+	Projid     uint32
+	Cowextsize uint32
+	Pad        [8]byte
+}
+
+// fsDiskQuota mirrors struct fs_disk_quota of linux/dqblk_xfs.h
+type fsDiskQuota struct {
+	Version      int8
+	Flags        int8
+	Fieldmask    uint16
+	Id           uint32
+	BlkHardlimit uint64
+	BlkSoftlimit uint64
+	InoHardlimit uint64
+	InoSoftlimit uint64
+	Bcount       uint64
+	Icount       uint64
+	Itimer       int32
+	Btimer       int32
+	Iwarns       uint16
+	Bwarns       uint16
+	ItimerHi     int8
+	BtimerHi     int8
+	RtbtimerHi   int8
+	Padding2     int8
+	RtbHardlimit uint64
+	RtbSoftlimit uint64
+	Rtbcount     uint64
+	Rtbtimer     int32
+	Rtbwarns     uint16
+	Padding3     int16
+	Padding4     [8]byte
+}
+
+func getFsxattr(path string) (*fsxattr, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	attr := &fsxattr{}
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), fsIocFsgetxattr, uintptr(unsafe.Pointer(attr))); errno != 0 {
+		return nil, quotaError(errno)
+	}
+	return attr, nil
+}
+
+// setProjectID puts the directory into the project, new files below it inherit the project
+func setProjectID(dir string, id uint32) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	attr := &fsxattr{}
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), fsIocFsgetxattr, uintptr(unsafe.Pointer(attr))); errno != 0 {
+		return quotaError(errno)
+	}
+	attr.Projid = id
+	attr.Xflags |= fsXflagProjinherit
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), fsIocFssetxattr, uintptr(unsafe.Pointer(attr))); errno != 0 {
+		return quotaError(errno)
+	}
+	return nil
+}
+
+// setProjectLimit sets the hard block limit of the project on the filesystem holding path,
+// 0 removes the limit
+func setProjectLimit(path string, id uint32, bytes int64) error {
+	device, err := blockDevice(path)
+	if err != nil {
+		return err
+	}
+	devicePtr, err := unix.BytePtrFromString(device)
+	if err != nil {
+		return err
+	}
+	dq := fsDiskQuota{
+		Version:      fsDquotVersion,
+		Flags:        fsProjQuota,
+		Fieldmask:    fsDqBhard,
+		Id:           id,
+		BlkHardlimit: uint64((bytes + quotaBlockSize - 1) / quotaBlockSize),
+	}
+	cmd := uintptr(qXSetQLim<<8 | prjQuota)
+	if _, _, errno := unix.Syscall6(unix.SYS_QUOTACTL, cmd, uintptr(unsafe.Pointer(devicePtr)), uintptr(id), uintptr(unsafe.Pointer(&dq)), 0, 0); errno != 0 {
+		return quotaError(errno)
+	}
+	return nil
+}
+
+// setProjectQuota limits the directory to bytes with a project of its own
+func setProjectQuota(dir, base string, bytes int64) error {
+	id, err := allocateProjectID(dir)
+	if err != nil {
+		return err
+	}
+	if err := setProjectID(dir, id); err != nil {
+		return err
+	}
+	return setProjectLimit(base, id, bytes)
+}
+
+// clearProjectQuota removes the limit of the project of the directory
+func clearProjectQuota(dir, base string) error {
+	attr, err := getFsxattr(dir)
+	if err != nil {
+		return err
+	}
+	if attr.Projid == 0 {
+		return nil
+	}
+	return setProjectLimit(base, attr.Projid, 0)
+}
+
+// allocateProjectID derives a project id from the directory name which none of its
+// siblings uses yet
+func allocateProjectID(dir string) (uint32, error) {
+	entries, err := os.ReadDir(filepath.Dir(dir))
+	if err != nil {
+		return 0, err
+	}
+	used := make(map[uint32]bool)
+	for _, entry := range entries {
+		p := filepath.Join(filepath.Dir(dir), entry.Name())
+		if !entry.IsDir() || p == dir {
+			continue
+		}
+		if attr, err := getFsxattr(p); err == nil && attr.Projid != 0 {
+			used[attr.Projid] = true
+		}
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(filepath.Base(dir)))
+	id := h.Sum32()%maxProjectID + 1
+	for used[id] {
+		id = id%maxProjectID + 1
+	}
+	return id, nil
+}
+
+// blockDevice returns the device of the filesystem holding path
+func blockDevice(path string) (string, error) {
+	path, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	mis, err := mount.ParseMountInfo(procSelfMountInfo)
+	if err != nil {
+		return "", err
+	}
+	var device, mountPoint string
+	for _, mi := range mis {
+		if path != mi.MountPoint && !strings.HasPrefix(path, strings.TrimSuffix(mi.MountPoint, "/")+"/") {
+			continue
+		}
+		// The last of the longest mount points is the one visible at path
+		if len(mi.MountPoint) >= len(mountPoint) {
+			device, mountPoint = mi.Source, mi.MountPoint
+		}
+	}
+	if device == "" {
+		return "", fmt.Errorf("no filesystem is mounted at %s", path)
+	}
+	return device, nil
+}
+
+func quotaError(errno syscall.Errno) error {
+	switch errno {
+	case unix.ENOTTY, unix.EOPNOTSUPP, unix.ENOSYS, unix.ESRCH, unix.EINVAL:
+		return fmt.Errorf("%w: %v", ErrProjectQuotaUnsupported, errno)
+	}
+	return errno
+}
+
+// cloneFile makes dst share all blocks of src
+func cloneFile(dst, src *os.File) error {
+	err := unix.IoctlFileClone(int(dst.Fd()), int(src.Fd()))
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		switch errno {
+		case unix.ENOTTY, unix.EOPNOTSUPP, unix.ENOSYS, unix.EXDEV, unix.EINVAL:
+			return fmt.Errorf("%w: %v", ErrReflinkUnsupported, errno)
+		}
+	}
+	return err
+}
+
+// lchownLike gives path the owner of fi
+func lchownLike(path string, fi os.FileInfo) error {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	return os.Lchown(path, int(st.Uid), int(st.Gid))
+}
@@ -0,0 +1,23 @@
+//go:build !linux
+
+package localfs
+
+import (
+	"os"
+)
+
+func setProjectQuota(dir, base string, bytes int64) error {
+	return ErrProjectQuotaUnsupported
+}
+
+func clearProjectQuota(dir, base string) error {
+	return nil
+}
+
+func cloneFile(dst, src *os.File) error {
+	return ErrReflinkUnsupported
+}
+
+func lchownLike(path string, fi os.FileInfo) error {
+	return nil
+}
@@ -0,0 +1,191 @@
+package nfs
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/chenliu1993/simple-csi-driver/internal/agent"
+	"github.com/chenliu1993/simple-csi-driver/pkg/driver"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// agentClients holds the connections to the storage agents of nfs servers, dialed on first use
+type agentClients struct {
+	lock *sync.Mutex
+
+	addrs     map[string]string
+	tlsConfig *tls.Config
+	clients   map[string]*agent.Client
+}
+
+func newAgentClients(addrs map[string]string, tlsConfig *tls.Config) *agentClients {
+	if len(addrs) == 0 {
+		return nil
+	}
+	return &agentClients{
+		lock:      &sync.Mutex{},
+		addrs:     addrs,
+		tlsConfig: tlsConfig,
+		clients:   make(map[string]*agent.Client),
+	}
+}
+
+// ParseStorageAgents parses comma separated server=host:port mappings of nfs servers to the
+// address of their storage agent
+func ParseStorageAgents(s string) (map[string]string, error) {
+	agents, err := driver.ParseMappings(s, "server=host:port")
+	if err != nil {
+		return nil, fmt.Errorf("invalid storage agents: %v", err)
+	}
+	return agents, nil
+}
+
+// get returns the client of the agent of the server, nil if the server has none
+func (a *agentClients) get(server string) (*agent.Client, error) {
+	if a == nil {
+		return nil, nil
+	}
+	addr, ok := a.addrs[server]
+	if !ok {
+		return nil, nil
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if c, ok := a.clients[server]; ok {
+		return c, nil
+	}
+	c, err := agent.Dial(addr, a.tlsConfig)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "failed to connect to the storage agent of %s: %v", server, err)
+	}
+	klog.V(4).InfoS("Connected to storage agent", "server", server, "address", addr)
+	a.clients[server] = c
+	return c, nil
+}
+
+// agentStatus prefixes errors of the agent, keeping their code
+func agentStatus(err error, format string, args ...interface{}) error {
+	return status.Errorf(status.Code(err), "%s: %v", fmt.Sprintf(format, args...), status.Convert(err).Message())
+}
+
+// Check if implements Backend
+var _ Backend = &agentBackend{}
+
+// agentBackend has the storage agent of the nfs server manage the volume directories
+type agentBackend struct {
+	client *agent.Client
+}
+
+func (b *agentBackend) Name() string {
+	return backendAgent
+}
+
+// Provision has the agent create the volume directory, restored from the snapshot if set
+func (b *agentBackend) Provision(ctx context.Context, vol *Volume, snapshot string) (int64, error) {
+	var resp *agent.DirectoryResponse
+	var err error
+	if snapshot != "" {
+		resp, err = b.client.Clone(ctx, &agent.CloneRequest{
+			Basedir:       vol.Basedir,
+			Subdir:        vol.Subdir,
+			Snapshot:      snapshot,
+			Mode:          uint32(vol.Mode),
+			CapacityBytes: vol.CapacityBytes,
+		})
+	} else {
+		resp, err = b.client.CreateDirectory(ctx, &agent.DirectoryRequest{
+			Basedir:       vol.Basedir,
+			Subdir:        vol.Subdir,
+			Mode:          uint32(vol.Mode),
+			CapacityBytes: vol.CapacityBytes,
+		})
+	}
+	if err != nil {
+		return 0, agentStatus(err, "failed to create volume directory %s", vol.Subdir)
+	}
+	return resp.CapacityBytes, nil
+}
+
+// Clone has the agent copy the volume directory source
+func (b *agentBackend) Clone(ctx context.Context, vol *Volume, source string) (int64, error) {
+	resp, err := b.client.Clone(ctx, &agent.CloneRequest{
+		Basedir:       vol.Basedir,
+		Subdir:        vol.Subdir,
+		Volume:        source,
+		Mode:          uint32(vol.Mode),
+		CapacityBytes: vol.CapacityBytes,
+	})
+	if err != nil {
+		return 0, agentStatus(err, "failed to clone volume directory %s", source)
+	}
+	return resp.CapacityBytes, nil
+}
+
+// Delete has the agent remove the volume directory
+func (b *agentBackend) Delete(ctx context.Context, vol *Volume) error {
+	if err := b.client.DeleteDirectory(ctx, &agent.DirectoryRequest{
+		Basedir: vol.Basedir,
+		Subdir:  vol.Subdir,
+	}); err != nil {
+		return agentStatus(err, "failed to remove volume directory %s", vol.Subdir)
+	}
+	return nil
+}
+
+// Expand has the agent raise the project quota of the volume directory, agents without
+// project quotas have no limit to raise
+func (b *agentBackend) Expand(ctx context.Context, vol *Volume) (int64, error) {
+	err := b.client.SetQuota(ctx, &agent.QuotaRequest{
+		Basedir: vol.Basedir,
+		Subdir:  vol.Subdir,
+		Bytes:   vol.CapacityBytes,
+	})
+	if err != nil && status.Code(err) != codes.FailedPrecondition {
+		return 0, agentStatus(err, "failed to expand volume directory %s", vol.Subdir)
+	}
+	return vol.CapacityBytes, nil
+}
+
+// CreateSnapshot has the agent snapshot the volume directory
+func (b *agentBackend) CreateSnapshot(ctx context.Context, vol *Volume, name, sourceVolId string) (time.Time, error) {
+	resp, err := b.client.CreateSnapshot(ctx, &agent.SnapshotRequest{
+		Basedir: vol.Basedir,
+		Subdir:  vol.Subdir,
+		Name:    name,
+		Source:  sourceVolId,
+	})
+	if err != nil {
+		return time.Time{}, agentStatus(err, "failed to snapshot volume %s", sourceVolId)
+	}
+	return resp.CreationTime, nil
+}
+
+func (b *agentBackend) DeleteSnapshot(ctx context.Context, vol *Volume, name string) error {
+	if err := b.client.DeleteSnapshot(ctx, &agent.SnapshotRequest{Basedir: vol.Basedir, Name: name}); err != nil {
+		return agentStatus(err, "failed to delete snapshot %s", name)
+	}
+	return nil
+}
+
+// Capacity asks the agent for the bytes available under basedir
+func (b *agentBackend) Capacity(ctx context.Context, vol *Volume) (int64, error) {
+	resp, err := b.client.Usage(ctx, &agent.UsageRequest{Basedir: vol.Basedir})
+	if err != nil {
+		return 0, agentStatus(err, "failed to get the usage of %s", vol.Basedir)
+	}
+	return resp.AvailableBytes, nil
+}
+
+func (b *agentBackend) List(ctx context.Context, vol *Volume) ([]string, error) {
+	resp, err := b.client.ListDirectories(ctx, &agent.ListRequest{Basedir: vol.Basedir})
+	if err != nil {
+		return nil, agentStatus(err, "failed to list %s", vol.Basedir)
+	}
+	return resp.Subdirs, nil
+}
@@ -0,0 +1,83 @@
+package nfs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/chenliu1993/simple-csi-driver/internal/agent"
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+func TestAgentVolumeLifecycle(t *testing.T) {
+	root := t.TempDir()
+	fake, err := agent.StartFake(map[string]string{"/export": root}, nil)
+	if err != nil {
+		t.Fatalf("StartFake() error = %v", err)
+	}
+	defer fake.Stop()
+	d := NewFakeNfsDriver(fakeNode)
+	d.agents = newAgentClients(map[string]string{"server": fake.Addr}, nil)
+	cs := NewControllerServer(d)
+	ctx := context.Background()
+	parameters := func() map[string]string {
+		return map[string]string{
+			serverKey:          "server",
+			basedirKey:         "/export",
+			mountPermissionKey: "0750",
+		}
+	}
+
+	resp, err := cs.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:               testVolId,
+		VolumeCapabilities: []*csi.VolumeCapability{mountVolumeCapability},
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: 1 << 20},
+		Parameters:         parameters(),
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume() error = %v", err)
+	}
+	volumeDir := filepath.Join(root, testVolId)
+	if resp.Volume.CapacityBytes != 1<<20 || fake.FS.Quota(volumeDir) != 1<<20 {
+		t.Errorf("capacity = %d, quota = %d, want %d", resp.Volume.CapacityBytes, fake.FS.Quota(volumeDir), 1<<20)
+	}
+	if err := os.WriteFile(filepath.Join(volumeDir, "data"), []byte("hello"), 0600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	capacity, err := cs.GetCapacity(ctx, &csi.GetCapacityRequest{Parameters: parameters()})
+	if err != nil || capacity.AvailableCapacity <= 0 {
+		t.Errorf("GetCapacity() = %v, %v, want available bytes", capacity, err)
+	}
+
+	snap, err := cs.CreateSnapshot(ctx, &csi.CreateSnapshotRequest{Name: "snap", SourceVolumeId: resp.Volume.VolumeId})
+	if err != nil {
+		t.Fatalf("CreateSnapshot() error = %v", err)
+	}
+	if _, err := cs.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:               "restored",
+		VolumeCapabilities: []*csi.VolumeCapability{mountVolumeCapability},
+		Parameters:         parameters(),
+		VolumeContentSource: &csi.VolumeContentSource{
+			Type: &csi.VolumeContentSource_Snapshot{
+				Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: snap.Snapshot.SnapshotId},
+			},
+		},
+	}); err != nil {
+		t.Fatalf("CreateVolume() from snapshot error = %v", err)
+	}
+	if b, err := os.ReadFile(filepath.Join(root, "restored", "data")); err != nil || string(b) != "hello" {
+		t.Errorf("restored data = %q, %v", b, err)
+	}
+	if _, err := cs.DeleteSnapshot(ctx, &csi.DeleteSnapshotRequest{SnapshotId: snap.Snapshot.SnapshotId}); err != nil {
+		t.Errorf("DeleteSnapshot() error = %v", err)
+	}
+
+	if _, err := cs.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: resp.Volume.VolumeId}); err != nil {
+		t.Fatalf("DeleteVolume() error = %v", err)
+	}
+	if _, err := os.Stat(volumeDir); !os.IsNotExist(err) {
+		t.Errorf("volume directory was not removed: %v", err)
+	}
+}
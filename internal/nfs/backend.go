@@ -0,0 +1,145 @@
+package nfs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chenliu1993/simple-csi-driver/internal/nfsv3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Names of the backends, the value of the backend parameter of a StorageClass
+const (
+	backendMount     = "mount"
+	backendUserspace = "userspace"
+	backendLocal     = "local"
+	backendAgent     = "agent"
+)
+
+// Volume is a volume directory below an export of an nfs server as a backend sees it
+type Volume struct {
+	Server  string
+	Basedir string
+	Subdir  string
+	// Options a backend mounting server:basedir mounts it with
+	MountOptions string
+	// Permission bits of a created directory
+	Mode os.FileMode
+	// Requested size, only backends enforcing quotas limit the directory to it
+	CapacityBytes int64
+}
+
+// Backend manages the volume directories of nfs servers for the controller, which only
+// validates the requests and picks the backend. Operations a backend cannot perform fail
+// with FailedPrecondition.
+type Backend interface {
+	// Name is the value of the backend parameter selecting the backend
+	Name() string
+	// Provision creates the directory of vol, restored from the snapshot if set, and returns
+	// the capacity it is limited to, 0 if it has no limit
+	Provision(ctx context.Context, vol *Volume, snapshot string) (int64, error)
+	// Clone creates the directory of vol as a copy of the volume directory source
+	Clone(ctx context.Context, vol *Volume, source string) (int64, error)
+	// Delete removes the directory of vol, deleting a missing directory succeeds
+	Delete(ctx context.Context, vol *Volume) error
+	// Expand grows the directory of vol to its CapacityBytes and returns the new capacity
+	Expand(ctx context.Context, vol *Volume) (int64, error)
+	// CreateSnapshot takes the snapshot name of the directory of vol, sourceVolId tells
+	// retries from another snapshot of the same name
+	CreateSnapshot(ctx context.Context, vol *Volume, name, sourceVolId string) (time.Time, error)
+	// DeleteSnapshot removes the snapshot name of server:basedir
+	DeleteSnapshot(ctx context.Context, vol *Volume, name string) error
+	// Capacity returns the bytes available below server:basedir
+	Capacity(ctx context.Context, vol *Volume) (int64, error)
+	// List returns the volume directories directly below server:basedir
+	List(ctx context.Context, vol *Volume) ([]string, error)
+}
+
+// backend returns the backend called name managing server:basedir. Without a name the
+// most capable one is picked: local exports, then storage agents, then the userspace nfs
+// client if enabled, then mounting.
+func (cs *controllerServer) backend(name, server, basedir string) (Backend, error) {
+	local := func() (Backend, bool) {
+		base, ok := cs.driver.localExports.lookup(server, basedir)
+		if !ok {
+			return nil, false
+		}
+		return newLocalBackend(base, cs.driver.localOps, cs.driver.localProjectQuotas), true
+	}
+	agent := func() (Backend, bool, error) {
+		c, err := cs.driver.agents.get(server)
+		if err != nil || c == nil {
+			return nil, false, err
+		}
+		return &agentBackend{client: c}, true, nil
+	}
+
+	switch name {
+	case "":
+		if b, ok := local(); ok {
+			return b, nil
+		}
+		if b, ok, err := agent(); ok || err != nil {
+			return b, err
+		}
+		if cs.driver.dialNfs != nil {
+			return &userspaceBackend{dial: cs.driver.dialNfs}, nil
+		}
+		return &mountBackend{cs: cs}, nil
+	case backendLocal:
+		if b, ok := local(); ok {
+			return b, nil
+		}
+		return nil, status.Errorf(codes.FailedPrecondition, "%s:/%s is not a local export", server, strings.Trim(basedir, "/"))
+	case backendAgent:
+		b, ok, err := agent()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, status.Errorf(codes.FailedPrecondition, "%s has no storage agent", server)
+		}
+		return b, nil
+	case backendUserspace:
+		dial := cs.driver.dialNfs
+		if dial == nil {
+			dial = nfsv3.Dial
+		}
+		return &userspaceBackend{dial: dial}, nil
+	case backendMount:
+		return &mountBackend{cs: cs}, nil
+	}
+	return nil, status.Errorf(codes.InvalidArgument, "unknown backend %s", name)
+}
+
+// backendUnsupported is the error of an operation the backend cannot perform
+func backendUnsupported(b Backend, operation string) error {
+	return status.Errorf(codes.FailedPrecondition, "%s is not supported by the %s backend", operation, b.Name())
+}
+
+// volumeFromParams returns the volume described by the parameters of a request
+func volumeFromParams(parameters map[string]string) *Volume {
+	return &Volume{
+		Server:       parameters[serverKey],
+		Basedir:      parameters[basedirKey],
+		Subdir:       parameters[subdirKey],
+		MountOptions: parameters[mountOptionsKey],
+	}
+}
+
+// volumeParams returns the parameters mounting server:basedir of vol
+func volumeParams(vol *Volume) map[string]string {
+	return map[string]string{
+		serverKey:  vol.Server,
+		basedirKey: filepath.Join("/", vol.Basedir),
+		subdirKey:  vol.Subdir,
+		// Without a mode the permission of the mount does not matter
+		mountPermissionKey: strconv.FormatUint(uint64(vol.Mode), 8),
+		mountOptionsKey:    vol.MountOptions,
+	}
+}
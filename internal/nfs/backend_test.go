@@ -0,0 +1,202 @@
+package nfs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/chenliu1993/simple-csi-driver/internal/localfs"
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestBackendSelection(t *testing.T) {
+	d := NewFakeNfsDriver(fakeNode)
+	d.localExports = localExports{"server:/export": t.TempDir()}
+	d.agents = newAgentClients(map[string]string{"agented": "127.0.0.1:1"}, nil)
+	cs := NewControllerServer(d)
+
+	tests := []struct {
+		name     string
+		backend  string
+		server   string
+		want     string
+		wantCode codes.Code
+	}{
+		{
+			name:   "local export picked automatically",
+			server: "server",
+			want:   backendLocal,
+		},
+		{
+			name:   "storage agent picked automatically",
+			server: "agented",
+			want:   backendAgent,
+		},
+		{
+			name:   "mounting by default",
+			server: "other",
+			want:   backendMount,
+		},
+		{
+			name:    "mounting a local export",
+			backend: backendMount,
+			server:  "server",
+			want:    backendMount,
+		},
+		{
+			name:    "userspace client without the flag",
+			backend: backendUserspace,
+			server:  "other",
+			want:    backendUserspace,
+		},
+		{
+			name:     "local backend without local export",
+			backend:  backendLocal,
+			server:   "other",
+			wantCode: codes.FailedPrecondition,
+		},
+		{
+			name:     "agent backend without agent",
+			backend:  backendAgent,
+			server:   "other",
+			wantCode: codes.FailedPrecondition,
+		},
+		{
+			name:     "unknown backend",
+			backend:  "ceph",
+			server:   "server",
+			wantCode: codes.InvalidArgument,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := cs.backend(tt.backend, tt.server, "/export")
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("backend() error = %v, want code %v", err, tt.wantCode)
+			}
+			if err == nil && b.Name() != tt.want {
+				t.Errorf("backend() = %s, want %s", b.Name(), tt.want)
+			}
+		})
+	}
+}
+
+// A volume keeps the backend it was created with, even if another one is picked by default
+func TestVolumeKeepsBackend(t *testing.T) {
+	cs, server, root := newUserspaceControllerServer(t)
+	export := filepath.Join(root, "export")
+	if err := os.Mkdir(export, 0755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	// The local backend would be picked by default and limit the volume with a quota
+	fs := localfs.NewFake()
+	cs.driver.localExports = localExports{server + ":/export": export}
+	cs.driver.localOps = fs
+	cs.driver.localProjectQuotas = true
+	ctx := context.Background()
+
+	resp, err := cs.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:               testVolId,
+		VolumeCapabilities: []*csi.VolumeCapability{mountVolumeCapability},
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: 1 << 20},
+		Parameters: map[string]string{
+			serverKey:          server,
+			basedirKey:         "/export",
+			mountPermissionKey: "0750",
+			backendKey:         backendUserspace,
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume() error = %v", err)
+	}
+	if got := getBackendFromVolId(resp.Volume.VolumeId); got != backendUserspace {
+		t.Errorf("backend of volume %s = %q, want %q", resp.Volume.VolumeId, got, backendUserspace)
+	}
+	volumeDir := filepath.Join(export, testVolId)
+	if resp.Volume.CapacityBytes != 0 || fs.Quota(volumeDir) != 0 {
+		t.Errorf("capacity = %d, quota = %d, want no limit", resp.Volume.CapacityBytes, fs.Quota(volumeDir))
+	}
+
+	expand, err := cs.ControllerExpandVolume(ctx, &csi.ControllerExpandVolumeRequest{
+		VolumeId:      resp.Volume.VolumeId,
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 2 << 20},
+	})
+	if err != nil || expand.CapacityBytes != 2<<20 || fs.Quota(volumeDir) != 0 {
+		t.Errorf("ControllerExpandVolume() = %v, %v, quota = %d, want no limit", expand, err, fs.Quota(volumeDir))
+	}
+
+	if _, err := cs.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: resp.Volume.VolumeId}); err != nil {
+		t.Fatalf("DeleteVolume() error = %v", err)
+	}
+	if _, err := os.Stat(volumeDir); !os.IsNotExist(err) {
+		t.Errorf("volume directory was not removed: %v", err)
+	}
+}
+
+func TestLocalCloneExpandVolume(t *testing.T) {
+	cs, root, fs := newLocalControllerServer(t)
+	ctx := context.Background()
+	parameters := func() map[string]string {
+		return map[string]string{
+			serverKey:          "server",
+			basedirKey:         "/export",
+			mountPermissionKey: "0750",
+		}
+	}
+
+	resp, err := cs.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:               testVolId,
+		VolumeCapabilities: []*csi.VolumeCapability{mountVolumeCapability},
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: 1 << 20},
+		Parameters:         parameters(),
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume() error = %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, testVolId, "data"), []byte("hello"), 0600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	if _, err := cs.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:               "clone",
+		VolumeCapabilities: []*csi.VolumeCapability{mountVolumeCapability},
+		Parameters:         parameters(),
+		VolumeContentSource: &csi.VolumeContentSource{
+			Type: &csi.VolumeContentSource_Volume{
+				Volume: &csi.VolumeContentSource_VolumeSource{VolumeId: resp.Volume.VolumeId},
+			},
+		},
+	}); err != nil {
+		t.Fatalf("CreateVolume() from volume error = %v", err)
+	}
+	if b, err := os.ReadFile(filepath.Join(root, "clone", "data")); err != nil || string(b) != "hello" {
+		t.Errorf("cloned data = %q, %v", b, err)
+	}
+	if _, err := cs.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:               "elsewhere",
+		VolumeCapabilities: []*csi.VolumeCapability{mountVolumeCapability},
+		Parameters:         parameters(),
+		VolumeContentSource: &csi.VolumeContentSource{
+			Type: &csi.VolumeContentSource_Volume{
+				Volume: &csi.VolumeContentSource_VolumeSource{VolumeId: "other#export#" + testVolId},
+			},
+		},
+	}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("CreateVolume() from a volume of another server error = %v, want code %v", err, codes.InvalidArgument)
+	}
+
+	expand, err := cs.ControllerExpandVolume(ctx, &csi.ControllerExpandVolumeRequest{
+		VolumeId:      resp.Volume.VolumeId,
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 2 << 20},
+	})
+	volumeDir := filepath.Join(root, testVolId)
+	if err != nil || expand.CapacityBytes != 2<<20 || fs.Quota(volumeDir) != 2<<20 {
+		t.Errorf("ControllerExpandVolume() = %v, %v, quota = %d, want %d", expand, err, fs.Quota(volumeDir), 2<<20)
+	}
+	if _, err := cs.ControllerExpandVolume(ctx, &csi.ControllerExpandVolumeRequest{VolumeId: resp.Volume.VolumeId}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("ControllerExpandVolume() without capacity error = %v, want code %v", err, codes.InvalidArgument)
+	}
+}
@@ -0,0 +1,99 @@
+package nfs
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Config is the yaml file given with --config holding the defaults of the driver, flags
+// set on the command line override its values
+type Config struct {
+	// Directory the controller mounts nfs servers under
+	WorkingMountDir string `yaml:"workingMountDir"`
+	// Octal permissions of volumes whose StorageClass has no mountPermission parameter
+	MountPermissions string `yaml:"mountPermissions"`
+	// delete or retain
+	DefaultOnDeletePolicy string `yaml:"defaultOnDeletePolicy"`
+	// Used unless --mount-option-policy points to a policy file
+	MountOptionPolicy *MountOptionPolicy `yaml:"mountOptionPolicy"`
+	// NFS servers checked from startup on
+	HealthCheckServers []string `yaml:"healthCheckServers"`
+	// Durations like 30s
+	HealthCheckInterval string `yaml:"healthCheckInterval"`
+	HealthCheckTimeout  string `yaml:"healthCheckTimeout"`
+	ExportCacheTTL      string `yaml:"exportCacheTTL"`
+}
+
+// LoadConfig reads a config file, rejecting unknown keys and an invalid mount option policy
+func LoadConfig(configFile string) (*Config, error) {
+	content, err := os.ReadFile(configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &Config{}
+	if err := yaml.UnmarshalStrict(content, config); err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %v", configFile, err)
+	}
+	if config.MountOptionPolicy != nil {
+		if err := config.MountOptionPolicy.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid mount option policy in config %s: %v", configFile, err)
+		}
+	}
+	return config, nil
+}
+
+// withConfig returns the flags with the values of the config file filled in, except for
+// the flags set on the command line
+func (f *flags) withConfig(c *Config) (*flags, error) {
+	set := make(map[string]bool)
+	if f.fs != nil {
+		f.fs.Visit(func(fl *flag.Flag) {
+			set[fl.Name] = true
+		})
+	}
+
+	merged := *f
+	for name, value := range map[string]struct {
+		from string
+		to   *string
+	}{
+		"working-mount-dir":       {c.WorkingMountDir, &merged.workingMountDir},
+		"mount-permissions":       {c.MountPermissions, &merged.mountPermissions},
+		"default-ondelete-policy": {c.DefaultOnDeletePolicy, &merged.onDeletePolicy},
+	} {
+		if value.from != "" && !set[name] {
+			*value.to = value.from
+		}
+	}
+	for name, value := range map[string]struct {
+		from string
+		to   *time.Duration
+	}{
+		"health-check-interval": {c.HealthCheckInterval, &merged.healthInterval},
+		"health-check-timeout":  {c.HealthCheckTimeout, &merged.healthTimeout},
+		"export-cache-ttl":      {c.ExportCacheTTL, &merged.exportCacheTTL},
+	} {
+		if value.from == "" || set[name] {
+			continue
+		}
+		d, err := time.ParseDuration(value.from)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s in config: %v", name, err)
+		}
+		*value.to = d
+	}
+	if len(c.HealthCheckServers) > 0 && !set["health-check-servers"] {
+		merged.healthServers = strings.Join(c.HealthCheckServers, ",")
+	}
+	if c.MountOptionPolicy != nil && !set["mount-option-policy"] {
+		merged.mountPolicyFile = ""
+		merged.mountPolicy = c.MountOptionPolicy
+	}
+	return &merged, nil
+}
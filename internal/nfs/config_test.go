@@ -0,0 +1,145 @@
+package nfs
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/chenliu1993/simple-csi-driver/pkg/driver"
+)
+
+func TestDriverOptionsConfig(t *testing.T) {
+	type settings struct {
+		workingMountDir  string
+		mountPermissions string
+		onDeletePolicy   string
+		healthInterval   time.Duration
+		healthTimeout    time.Duration
+		exportCacheTTL   time.Duration
+		mountPolicy      *MountOptionPolicy
+	}
+	tests := []struct {
+		name    string
+		config  string
+		args    []string
+		want    settings
+		wantErr bool
+	}{
+		{
+			name: "defaults without a config",
+			want: settings{
+				workingMountDir: "/tmp",
+				onDeletePolicy:  "delete",
+				healthTimeout:   5 * time.Second,
+				exportCacheTTL:  5 * time.Minute,
+			},
+		},
+		{
+			name: "flags of the helm chart",
+			args: []string{"--mount-permissions=0", "--working-mount-dir=/var/lib/simple-csi", "--default-ondelete-policy=retain"},
+			want: settings{
+				workingMountDir:  "/var/lib/simple-csi",
+				mountPermissions: "0",
+				onDeletePolicy:   "retain",
+				healthTimeout:    5 * time.Second,
+				exportCacheTTL:   5 * time.Minute,
+			},
+		},
+		{
+			name: "values of the config",
+			config: "workingMountDir: /mnt/nfs\nmountPermissions: 0777\ndefaultOnDeletePolicy: retain\n" +
+				"healthCheckInterval: 1m\nhealthCheckTimeout: 2s\nexportCacheTTL: 10s\nmountOptionPolicy:\n  forced: [nosuid]\n",
+			want: settings{
+				workingMountDir:  "/mnt/nfs",
+				mountPermissions: "0777",
+				onDeletePolicy:   "retain",
+				healthInterval:   time.Minute,
+				healthTimeout:    2 * time.Second,
+				exportCacheTTL:   10 * time.Second,
+				mountPolicy:      &MountOptionPolicy{Forced: []string{"nosuid"}},
+			},
+		},
+		{
+			name:   "flags override the config",
+			config: "workingMountDir: /mnt/nfs\nhealthCheckTimeout: 2s\n",
+			args:   []string{"--working-mount-dir=/srv/nfs", "--health-check-timeout=1s"},
+			want: settings{
+				workingMountDir: "/srv/nfs",
+				onDeletePolicy:  "delete",
+				healthTimeout:   time.Second,
+				exportCacheTTL:  5 * time.Minute,
+			},
+		},
+		{
+			name:    "unknown config key",
+			config:  "workingDir: /mnt/nfs\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid duration in the config",
+			config:  "healthCheckTimeout: soon\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid mount option policy in the config",
+			config:  "mountOptionPolicy:\n  denied: [foo]\n",
+			wantErr: true,
+		},
+		{
+			name:    "relative working mount dir",
+			args:    []string{"--working-mount-dir=tmp"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid mount permissions",
+			args:    []string{"--mount-permissions=rwx"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown on delete policy",
+			config:  "defaultOnDeletePolicy: archive\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &flags{}
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			f.addFlags(fs)
+			args := tt.args
+			if tt.config != "" {
+				configFile := filepath.Join(t.TempDir(), "config.yaml")
+				if err := os.WriteFile(configFile, []byte(tt.config), 0644); err != nil {
+					t.Fatalf("Failed to write config file: %v", err)
+				}
+				args = append(args, "--config="+configFile)
+			}
+			if err := fs.Parse(args); err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+
+			opts, err := f.driverOptions(&driver.Options{DriverName: DriverName, Endpoint: "unix:///csi/csi.sock", NodeID: fakeNode})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("driverOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			got := settings{
+				workingMountDir:  opts.WorkingMountDir,
+				mountPermissions: opts.MountPermissions,
+				onDeletePolicy:   opts.OnDeletePolicy,
+				healthInterval:   opts.HealthCheckInterval,
+				healthTimeout:    opts.HealthCheckTimeout,
+				exportCacheTTL:   opts.ExportCacheTTL,
+				mountPolicy:      opts.MountOptionPolicy,
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("driverOptions() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
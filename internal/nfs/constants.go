@@ -1,12 +1,24 @@
 package nfs
 
 const (
-	// default mounting folder
-	nfsWorkingDir = "/tmp"
+	// default folder the controller mounts nfs servers under
+	defaultWorkingMountDir = "/tmp"
+	// default folder the docker volumes are kept in
+	defaultDockerStateDir = "/var/lib/simple-csi/docker"
 
 	seperator          = "#"
 	mountPermissionKey = "mountPermission"
 	serverKey          = "server"
 	basedirKey         = "basedir"
 	subdirKey          = "subdir"
+	mountOptionsKey    = "mountOptions"
+	// picks the backend managing the volume directories, see Backend
+	backendKey = "backend"
+
+	// subdir part of the volume ID used while querying the capacity of a server
+	capacitySubdir = ".capacity"
+
+	// what DeleteVolume does with the volume directory
+	onDeleteDelete = "delete"
+	onDeleteRetain = "retain"
 )
@@ -8,16 +8,17 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 
-	"github.com/chenliu1993/simple-csi-driver/internal/idempotency"
+	"github.com/chenliu1993/simple-csi-driver/internal/localfs"
+	"github.com/chenliu1993/simple-csi-driver/pkg/idempotency"
 	csi "github.com/container-storage-interface/spec/lib/go/csi"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 	"k8s.io/klog/v2"
 )
 
-// TODO: RetainPolicy
-
 // Check if implements csi.ControllerServer
 var _ csi.ControllerServer = &controllerServer{}
 
@@ -25,119 +26,208 @@ type controllerServer struct {
 	driver *nfsDriver
 
 	idempotency *idempotency.Idempotency
+
+	lock *sync.Mutex
+	// Volumes created since the start by name, to tell retries of CreateVolume from
+	// conflicting requests
+	created map[string]*createdVolume
+}
+
+// createdVolume is what a volume was created as
+type createdVolume struct {
+	volumeID string
+	capacity int64
 }
 
 func NewControllerServer(driver *nfsDriver) *controllerServer {
 	return &controllerServer{
 		driver: driver,
 
-		idempotency: idempotency.NewIdempotency(),
+		idempotency: idempotency.NewNamedIdempotency("controller"),
+		lock:        &sync.Mutex{},
+		created:     make(map[string]*createdVolume),
+	}
+}
+
+// checkCreated fails with AlreadyExists if the volume called name was created with a
+// capacity outside of the requested range. Volumes created before the start are not known
+// and taken as they are.
+func (cs *controllerServer) checkCreated(name string, capacityRange *csi.CapacityRange) error {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	vol, ok := cs.created[name]
+	if !ok {
+		return nil
+	}
+	required, limit := capacityRange.GetRequiredBytes(), capacityRange.GetLimitBytes()
+	if (required > 0 && vol.capacity < required) || (limit > 0 && vol.capacity > limit) {
+		return status.Errorf(codes.AlreadyExists, "volume %s exists already with a capacity of %d bytes", name, vol.capacity)
+	}
+	return nil
+}
+
+// recordCreated remembers the capacity the volume called name was created with
+func (cs *controllerServer) recordCreated(name, volumeID string, capacity int64) {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	cs.created[name] = &createdVolume{volumeID: volumeID, capacity: capacity}
+}
+
+// forgetCreated drops the volume with the ID
+func (cs *controllerServer) forgetCreated(volumeID string) {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	for name, vol := range cs.created {
+		if vol.volumeID == volumeID {
+			delete(cs.created, name)
+		}
 	}
 }
 
 // CreateVolume creates a nfs-type volume
 func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
-	klog.V(4).InfoS("Creating volume......")
+	klog.FromContext(ctx).V(4).Info("Creating volume", "name", req.GetName())
 
 	// Step 10: validate thr request parameters
-	if err := validateVolumeRequest(req); err != nil {
+	if err := validateVolumeRequest(req, cs.driver.mountPermissions); err != nil {
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
+	// Step 0: pick the first healthy one if several servers are given
+	parameters := req.GetParameters()
+	server, err := cs.driver.healthChecker.pickServer(parameters[serverKey])
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	parameters[serverKey] = server
+	if err := cs.driver.exports.validateBasedir(ctx, server, parameters[basedirKey]); err != nil {
+		return nil, err
+	}
+
 	// Step 1: check if the volume is being handled
-	if cs.idempotency.IsProcessing(req.Name) {
+	if !cs.idempotency.TryAddProcessing(req.Name) {
 		return nil, status.Error(codes.Aborted, "Volume is being handled")
 	}
-	cs.idempotency.AddProcessing(req.Name)
-
-	parameters := req.GetParameters()
+	defer cs.idempotency.RemoveProcessing(req.Name)
+	if err := cs.checkCreated(req.Name, req.GetCapacityRange()); err != nil {
+		return nil, err
+	}
 
 	// Step 2: create the volume
 	if _, ok := parameters[subdirKey]; !ok || parameters[subdirKey] == "" {
 		parameters[subdirKey] = req.GetName()
 	}
-	volId := getVolIdFromParams(parameters)
-
-	targetParentPath := getTargetParentPath(parameters[subdirKey])
-	if err := cs.preMount(ctx, parameters, volId, targetParentPath); err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+	mountPermission, err := strconv.ParseUint(parameters[mountPermissionKey], 8, 32)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
-	// Needs to unmoiunt since we are just creating the volume, not to publish it them
-	defer func() {
-		klog.V(4).InfoS("Unmounting at target path: ", targetParentPath)
-		if err := cs.preUnmount(ctx, volId, targetParentPath); err != nil {
-			klog.Warningf("failed to unmount nfs server: %v", err)
+	// Step 3: Create the actual target path with the backend the StorageClass picked
+	backend, err := cs.backend(parameters[backendKey], server, parameters[basedirKey])
+	if err != nil {
+		return nil, err
+	}
+	// Record the backend picked so later calls reach the same one whatever the config
+	// is by then
+	parameters[backendKey] = backend.Name()
+	volId := getVolIdFromParams(parameters)
+	vol := volumeFromParams(parameters)
+	vol.Mode = os.FileMode(mountPermission)
+	vol.CapacityBytes = req.GetCapacityRange().GetRequiredBytes()
+	if vol.CapacityBytes == 0 {
+		vol.CapacityBytes = req.GetCapacityRange().GetLimitBytes()
+	}
+	var capacity int64
+	switch source := req.GetVolumeContentSource(); {
+	case source == nil:
+		capacity, err = backend.Provision(ctx, vol, "")
+	case source.GetSnapshot() != nil:
+		snapshotId := source.GetSnapshot().GetSnapshotId()
+		var snapshotServer, snapshotBasedir, name string
+		if snapshotServer, snapshotBasedir, name, err = getParamsFromSnapshotId(snapshotId); err != nil {
+			return nil, status.Errorf(codes.NotFound, "snapshot %s not found: %v", snapshotId, err)
 		}
-		cs.idempotency.RemoveProcessing(req.Name)
-	}()
-
-	// Step 3: Create the actual target path
-	mountPermission, err := strconv.ParseUint(parameters[mountPermissionKey], 8, 32)
+		if snapshotServer != server || snapshotBasedir != strings.Trim(parameters[basedirKey], "/") {
+			return nil, status.Errorf(codes.InvalidArgument, "snapshot %s lies on another export", snapshotId)
+		}
+		capacity, err = backend.Provision(ctx, vol, name)
+	case source.GetVolume() != nil:
+		sourceVolId := source.GetVolume().GetVolumeId()
+		var sourceServer, sourceBasedir, subdir string
+		if sourceServer, sourceBasedir, subdir, err = getParamsFromVolId(sourceVolId); err != nil {
+			return nil, status.Errorf(codes.NotFound, "volume %s not found: %v", sourceVolId, err)
+		}
+		if sourceServer != server || sourceBasedir != strings.Trim(parameters[basedirKey], "/") {
+			return nil, status.Errorf(codes.InvalidArgument, "volume %s lies on another export", sourceVolId)
+		}
+		capacity, err = backend.Clone(ctx, vol, subdir)
+	default:
+		return nil, status.Error(codes.InvalidArgument, "unsupported volume content source")
+	}
 	if err != nil {
-		return nil, status.Error(codes.InvalidArgument, err.Error())
+		return nil, err
 	}
-	volumeMountPath := getVolumtMountPath(targetParentPath, parameters[subdirKey])
-	if err := os.MkdirAll(volumeMountPath, os.FileMode(mountPermission)); err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+	// Backends without quotas report no capacity, the volume is as large as requested then
+	if capacity == 0 {
+		cs.recordCreated(req.Name, volId, vol.CapacityBytes)
+	} else {
+		cs.recordCreated(req.Name, volId, capacity)
 	}
 
 	return &csi.CreateVolumeResponse{
 		Volume: &csi.Volume{
 			VolumeId:      volId,
-			CapacityBytes: 0,
+			CapacityBytes: capacity,
 			VolumeContext: parameters,
+			ContentSource: req.GetVolumeContentSource(),
 		},
 	}, nil
 }
 
 // DeleteVolume deletes a nfs-type volume
 func (cs *controllerServer) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
-	klog.V(4).InfoS("Deleting volume......")
+	klog.FromContext(ctx).V(4).Info("Deleting volume")
 
-	// Step 0: check if the volume is being handled
-	if cs.idempotency.IsProcessing(req.VolumeId) {
-		return nil, status.Error(codes.FailedPrecondition, "Volume is gone already")
-	}
-	cs.idempotency.AddProcessing(req.VolumeId)
-
-	// step 1: simple check
+	// step 0: simple check
 	volId := req.VolumeId
 	if volId == "" {
 		return nil, status.Error(codes.InvalidArgument, "Volume ID is required")
 	}
+
+	// Step 1: check if the volume is being handled
+	if !cs.idempotency.TryAddProcessing(volId) {
+		return nil, status.Error(codes.Aborted, "Volume is being handled")
+	}
+	defer cs.idempotency.RemoveProcessing(volId)
+
+	// IDs this driver did not hand out name no volume, which is gone already
 	server, basedir, subdir, err := getParamsFromVolId(volId)
 	if err != nil {
-		return nil, status.Error(codes.InvalidArgument, err.Error())
+		klog.FromContext(ctx).V(2).Info("Volume ID cannot be parsed, nothing to delete", "err", err)
+		return &csi.DeleteVolumeResponse{}, nil
+	}
+
+	if cs.driver.onDeletePolicy == onDeleteRetain {
+		klog.FromContext(ctx).V(2).Info("Retaining the volume directory")
+		cs.forgetCreated(volId)
+		return &csi.DeleteVolumeResponse{}, nil
 	}
-	parameters := map[string]string{}
-	parameters[serverKey] = server
-	parameters[basedirKey] = basedir
-	parameters[subdirKey] = subdir
-
-	// step 2: delete the volume target path,
-	// Since when DeleteVolume is called, the volume is no longer attached,
-	// Thus remount again
-	targetParentPath := getTargetParentPath(parameters[subdirKey])
-	if err := cs.preMount(ctx, parameters, volId, targetParentPath); err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
-	}
-	// Needs to unmoiunt since we are just creating the volume, not to publish it them
-	defer func() {
-		klog.V(4).InfoS("Unmounting at target path: ", targetParentPath)
-		if err := cs.preUnmount(ctx, volId, targetParentPath); err != nil {
-			klog.Warningf("failed to unmount nfs server: %v", err)
-		}
-		cs.idempotency.RemoveProcessing(req.VolumeId)
-	}()
 
-	volumeMountPath := getVolumtMountPath(targetParentPath, parameters[subdirKey])
-	klog.V(4).InfoS("Removing the actual volume path: ", volumeMountPath)
-	if err := os.RemoveAll(volumeMountPath); err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+	// step 2: delete the volume target path with the backend it was created by
+	backend, err := cs.backend(getBackendFromVolId(volId), server, basedir)
+	if status.Code(err) == codes.InvalidArgument {
+		klog.FromContext(ctx).V(2).Info("Volume ID names an unknown backend, nothing to delete", "err", err)
+		return &csi.DeleteVolumeResponse{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := backend.Delete(ctx, &Volume{Server: server, Basedir: basedir, Subdir: subdir}); err != nil {
+		return nil, err
 	}
+	cs.forgetCreated(volId)
 
-	cs.idempotency.RemoveProcessing(req.VolumeId)
 	return &csi.DeleteVolumeResponse{}, nil
 }
 
@@ -146,6 +236,9 @@ func (cs *controllerServer) ValidateVolumeCapabilities(ctx context.Context, req
 	if volId == "" {
 		return nil, status.Error(codes.InvalidArgument, "Volume ID is required")
 	}
+	if _, _, _, err := getParamsFromVolId(volId); err != nil {
+		return nil, status.Errorf(codes.NotFound, "volume %s not found: %v", volId, err)
+	}
 
 	if err := tryValidateVolumeCapabilities(req.GetVolumeCapabilities()); err != nil {
 		return nil, status.Error(codes.InvalidArgument, "invalid volume capabilities")
@@ -165,41 +258,163 @@ func (cs *controllerServer) ControllerGetCapabilities(ctx context.Context, req *
 	}, nil
 }
 
-// Below are unimplemented functions
-
+// CreateSnapshot reflinks the volume directory into the snapshots of its export, only the
+// local and agent backends can snapshot volumes
 func (cs *controllerServer) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "Unimplemented")
+	klog.FromContext(ctx).V(4).Info("Creating snapshot", "name", req.GetName())
+
+	name := req.GetName()
+	if name == "" {
+		return nil, status.Error(codes.InvalidArgument, "Snapshot name is required")
+	}
+	if err := localfs.ValidateSnapshotName(name); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if req.GetSourceVolumeId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "Source volume ID is required")
+	}
+	if !cs.idempotency.TryAddProcessing(name) {
+		return nil, status.Error(codes.Aborted, "Snapshot is being handled")
+	}
+	defer cs.idempotency.RemoveProcessing(name)
+
+	sourceVolId := req.GetSourceVolumeId()
+	server, basedir, subdir, err := getParamsFromVolId(sourceVolId)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	backend, err := cs.backend(getBackendFromVolId(sourceVolId), server, basedir)
+	if err != nil {
+		return nil, err
+	}
+	created, err := backend.CreateSnapshot(ctx, &Volume{Server: server, Basedir: basedir, Subdir: subdir}, name, sourceVolId)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &csi.Snapshot{
+		SnapshotId:     getSnapshotId(server, basedir, name, backend.Name()),
+		SourceVolumeId: sourceVolId,
+		CreationTime:   timestamppb.New(created),
+		ReadyToUse:     true,
+	}
+	return &csi.CreateSnapshotResponse{Snapshot: snapshot}, nil
 }
 
+// DeleteSnapshot removes a snapshot with the backend it was taken by
 func (cs *controllerServer) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "Unimplemented")
+	klog.FromContext(ctx).V(4).Info("Deleting snapshot", "snapshot_id", req.GetSnapshotId())
+
+	snapshotId := req.GetSnapshotId()
+	if snapshotId == "" {
+		return nil, status.Error(codes.InvalidArgument, "Snapshot ID is required")
+	}
+	if !cs.idempotency.TryAddProcessing(snapshotId) {
+		return nil, status.Error(codes.Aborted, "Snapshot is being handled")
+	}
+	defer cs.idempotency.RemoveProcessing(snapshotId)
+
+	server, basedir, name, err := getParamsFromSnapshotId(snapshotId)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	backend, err := cs.backend(getBackendFromVolId(snapshotId), server, basedir)
+	if err != nil {
+		return nil, err
+	}
+	if err := backend.DeleteSnapshot(ctx, &Volume{Server: server, Basedir: basedir}, name); err != nil {
+		return nil, err
+	}
+	return &csi.DeleteSnapshotResponse{}, nil
 }
 
+// Below are unimplemented functions
+
 func (cs *controllerServer) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsRequest) (*csi.ListSnapshotsResponse, error) {
 	return nil, status.Error(codes.Unimplemented, "")
 }
 
 // ControllerPublishVolume attaches a volume to a node VM
 func (cs *controllerServer) ControllerPublishVolume(ctx context.Context, req *csi.ControllerPublishVolumeRequest) (*csi.ControllerPublishVolumeResponse, error) {
-	klog.V(4).InfoS("NFS doesn't need publishing volume to node, skipping......")
+	klog.FromContext(ctx).V(4).Info("NFS doesn't need publishing volume to node, skipping")
 	return nil, status.Error(codes.Unimplemented, "Unimplemented")
 }
 
 // ControllerPublishVolume detaches a volume from a node VM
 func (cs *controllerServer) ControllerUnpublishVolume(ctx context.Context, req *csi.ControllerUnpublishVolumeRequest) (*csi.ControllerUnpublishVolumeResponse, error) {
-	klog.V(4).InfoS("NFS doesn't need unpublishing volume to node, skipping......")
+	klog.FromContext(ctx).V(4).Info("NFS doesn't need unpublishing volume to node, skipping")
 
 	return nil, status.Error(codes.Unimplemented, "Unimplemented")
 }
 
+// ControllerExpandVolume raises the limit of the volume directory, backends without limits
+// have nothing to do. NFS volumes never need expanding on the node.
 func (cs *controllerServer) ControllerExpandVolume(ctx context.Context, req *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
-	klog.V(4).InfoS("NFS doesn't need expanding volume, skipping......")
+	klog.FromContext(ctx).V(4).Info("Expanding volume", "required_bytes", req.GetCapacityRange().GetRequiredBytes())
 
-	return nil, status.Error(codes.Unimplemented, "Unimplemented")
+	volId := req.GetVolumeId()
+	if volId == "" {
+		return nil, status.Error(codes.InvalidArgument, "Volume ID is required")
+	}
+	if req.GetCapacityRange() == nil {
+		return nil, status.Error(codes.InvalidArgument, "Capacity range is required")
+	}
+	server, basedir, subdir, err := getParamsFromVolId(volId)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "volume %s not found: %v", volId, err)
+	}
+	if !cs.idempotency.TryAddProcessing(volId) {
+		return nil, status.Error(codes.Aborted, "Volume is being handled")
+	}
+	defer cs.idempotency.RemoveProcessing(volId)
+
+	backend, err := cs.backend(getBackendFromVolId(volId), server, basedir)
+	if err != nil {
+		return nil, err
+	}
+	vol := &Volume{
+		Server:        server,
+		Basedir:       basedir,
+		Subdir:        subdir,
+		CapacityBytes: req.GetCapacityRange().GetRequiredBytes(),
+	}
+	if vol.CapacityBytes == 0 {
+		vol.CapacityBytes = req.GetCapacityRange().GetLimitBytes()
+	}
+	capacity, err := backend.Expand(ctx, vol)
+	if err != nil {
+		return nil, err
+	}
+	return &csi.ControllerExpandVolumeResponse{CapacityBytes: capacity}, nil
 }
 
+// GetCapacity reports the free space of server:basedir, unhealthy servers have no capacity
 func (cs *controllerServer) GetCapacity(ctx context.Context, req *csi.GetCapacityRequest) (*csi.GetCapacityResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "")
+	klog.FromContext(ctx).V(4).Info("Getting capacity")
+
+	parameters := req.GetParameters()
+	if parameters[serverKey] == "" || parameters[basedirKey] == "" {
+		return &csi.GetCapacityResponse{}, nil
+	}
+	server, err := cs.driver.healthChecker.pickServer(parameters[serverKey])
+	if err != nil {
+		klog.FromContext(ctx).V(4).Info("No healthy nfs server, reporting no capacity", "servers", parameters[serverKey])
+		return &csi.GetCapacityResponse{AvailableCapacity: 0}, nil
+	}
+
+	backend, err := cs.backend(parameters[backendKey], server, parameters[basedirKey])
+	if err != nil {
+		return nil, err
+	}
+	available, err := backend.Capacity(ctx, &Volume{
+		Server:       server,
+		Basedir:      parameters[basedirKey],
+		Subdir:       capacitySubdir,
+		MountOptions: parameters[mountOptionsKey],
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &csi.GetCapacityResponse{AvailableCapacity: available}, nil
 }
 
 func (cs *controllerServer) ControllerGetVolume(ctx context.Context, req *csi.ControllerGetVolumeRequest) (*csi.ControllerGetVolumeResponse, error) {
@@ -210,17 +425,26 @@ func (cs *controllerServer) ListVolumes(ctx context.Context, req *csi.ListVolume
 	return nil, status.Error(codes.Unimplemented, "Unimplemented")
 }
 
-func validateVolumeRequest(req *csi.CreateVolumeRequest) error {
-	klog.V(4).InfoS("Validating volume request parameters......")
+// validateVolumeRequest checks the request, a missing mountPermission parameter is set to
+// defaultMountPermissions if that is not empty
+func validateVolumeRequest(req *csi.CreateVolumeRequest, defaultMountPermissions string) error {
+	klog.V(4).InfoS("Validating volume request parameters", "name", req.GetName())
 
 	if len(req.GetName()) == 0 {
 		return errors.New("volume name cannot be empty")
 	}
 
+	if err := tryValidateVolumeCapabilities(req.GetVolumeCapabilities()); err != nil {
+		return err
+	}
+
 	parameters := req.GetParameters()
 	if parameters == nil {
 		req.Parameters = make(map[string]string)
 	}
+	if _, ok := req.Parameters[mountPermissionKey]; !ok && defaultMountPermissions != "" {
+		req.Parameters[mountPermissionKey] = defaultMountPermissions
+	}
 
 	return validateNfsParameters(req.Parameters)
 }
@@ -242,7 +466,11 @@ func validateNfsParameters(params map[string]string) error {
 		return err
 	}
 
-	if _, ok := params[serverKey]; !ok {
+	if _, err := mergeMountOptions(splitMountOptions(params[mountOptionsKey])); err != nil {
+		return err
+	}
+
+	if len(splitServers(params[serverKey])) == 0 {
 		return errors.New("nfs server is required")
 	}
 	if _, ok := params[basedirKey]; !ok {
@@ -260,7 +488,8 @@ func getVolumtMountPath(targetParentPath string, subdir string) string {
 	return filepath.Join(targetParentPath, subdir)
 }
 
-// getVolI generates a unique volume ID based on the parameters
+// getVolI generates a unique volume ID based on the parameters, the backend is kept as a
+// fourth element so the volume is deleted by the backend which created it
 func getVolIdFromParams(parameters map[string]string) string {
 	server := strings.Trim(parameters[serverKey], "/")
 	basedir := strings.Trim(parameters[basedirKey], "/")
@@ -270,24 +499,40 @@ func getVolIdFromParams(parameters map[string]string) string {
 		basedir,
 		subDir,
 	}
+	if backend := parameters[backendKey]; backend != "" {
+		volIdElements = append(volIdElements, backend)
+	}
 	return strings.Join(volIdElements, seperator)
 }
 
 func getParamsFromVolId(volId string) (string, string, string, error) {
 	volIdElements := strings.Split(volId, seperator)
-	if len(volIdElements) != 3 {
+	if len(volIdElements) != 3 && len(volIdElements) != 4 {
 		return "", "", "", errors.New("invalid volume ID which cannot be parsed")
 	}
 	return volIdElements[0], volIdElements[1], volIdElements[2], nil
 }
 
-// getTargetPath returns the shared path of the nfs server, nfs source folder will be created under this path
-func getTargetParentPath(mountPath string) string {
-	return filepath.Join(nfsWorkingDir, mountPath)
+// getBackendFromVolId returns the backend which created the volume, empty for volumes
+// created before it was recorded
+func getBackendFromVolId(volId string) string {
+	volIdElements := strings.Split(volId, seperator)
+	if len(volIdElements) != 4 {
+		return ""
+	}
+	return volIdElements[3]
+}
+
+// getTargetPath returns the shared path of the nfs server below workingDir, nfs source folder will be created under this path
+func getTargetParentPath(workingDir, mountPath string) string {
+	if workingDir == "" {
+		workingDir = defaultWorkingMountDir
+	}
+	return filepath.Join(workingDir, mountPath)
 }
 
 func (cs *controllerServer) preMount(ctx context.Context, parameters map[string]string, volId, targetParentPath string) error {
-	klog.V(4).InfoS("do a premounting, create the subdir in advance")
+	klog.FromContext(ctx).V(4).Info("Mounting the nfs server to manage the volume directory", "target_path", targetParentPath)
 
 	volCap := &csi.VolumeCapability{
 		AccessType: &csi.VolumeCapability_Mount{
@@ -321,7 +566,7 @@ func (cs *controllerServer) preMount(ctx context.Context, parameters map[string]
 }
 
 func (cs *controllerServer) preUnmount(ctx context.Context, volId, targetParentPath string) error {
-	klog.V(4).InfoS("do a unmount since this is just pre")
+	klog.FromContext(ctx).V(4).Info("Unmounting the nfs server", "target_path", targetParentPath)
 	if _, err := cs.driver.ns.NodeUnpublishVolume(ctx, &csi.NodeUnpublishVolumeRequest{
 		TargetPath: targetParentPath,
 		VolumeId:   volId,
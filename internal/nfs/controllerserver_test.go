@@ -5,7 +5,7 @@ import (
 	"reflect"
 	"testing"
 
-	"github.com/chenliu1993/simple-csi-driver/internal/idempotency"
+	"github.com/chenliu1993/simple-csi-driver/pkg/idempotency"
 	csi "github.com/container-storage-interface/spec/lib/go/csi"
 )
 
@@ -45,10 +45,8 @@ func TestCreateVolume(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			cs := &controllerServer{
-				driver:      tt.fields.driver,
-				idempotency: tt.fields.idempotency,
-			}
+			cs := NewControllerServer(tt.fields.driver)
+			cs.idempotency = tt.fields.idempotency
 			_, err := cs.CreateVolume(tt.args.ctx, tt.args.req)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("controllerServer.CreateVolume() error = %v, wantErr %v", err, tt.wantErr)
@@ -86,16 +84,40 @@ func TestDeleteVolume(t *testing.T) {
 					VolumeId: "testDeleteVolumeReq1",
 				},
 			},
+			want: &csi.DeleteVolumeResponse{},
+		},
+		{
+			name: "delete volume with an unknown backend",
+			fields: fields{
+				driver:      NewFakeNfsDriver(fakeNode),
+				idempotency: idempotency.NewIdempotency(),
+			},
+			args: args{
+				ctx: context.Background(),
+				req: &csi.DeleteVolumeRequest{
+					VolumeId: "server#export#vol#nosuchbackend",
+				},
+			},
+			want: &csi.DeleteVolumeResponse{},
+		},
+		{
+			name: "delete volume without volId",
+			fields: fields{
+				driver:      NewFakeNfsDriver(fakeNode),
+				idempotency: idempotency.NewIdempotency(),
+			},
+			args: args{
+				ctx: context.Background(),
+				req: &csi.DeleteVolumeRequest{},
+			},
 			wantErr: true,
 		},
 		// TODO Add test cases.
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			cs := &controllerServer{
-				driver:      tt.fields.driver,
-				idempotency: tt.fields.idempotency,
-			}
+			cs := NewControllerServer(tt.fields.driver)
+			cs.idempotency = tt.fields.idempotency
 			got, err := cs.DeleteVolume(tt.args.ctx, tt.args.req)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("controllerServer.DeleteVolume() error = %v, wantErr %v", err, tt.wantErr)
@@ -128,10 +150,8 @@ func TestControllerGetCapabilities(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			cs := &controllerServer{
-				driver:      tt.fields.driver,
-				idempotency: tt.fields.idempotency,
-			}
+			cs := NewControllerServer(tt.fields.driver)
+			cs.idempotency = tt.fields.idempotency
 			got, err := cs.ControllerGetCapabilities(tt.args.ctx, tt.args.req)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("controllerServer.ControllerGetCapabilities() error = %v, wantErr %v", err, tt.wantErr)
@@ -192,6 +212,18 @@ func TestGetVolIdFromParams(t *testing.T) {
 			},
 			want: "faleServer#fakeBaseDir#fakeSubDir",
 		},
+		{
+			name: "get volume id with a backend",
+			args: args{
+				parameters: map[string]string{
+					"server":  "faleServer",
+					"basedir": "fakeBaseDir",
+					"subdir":  "fakeSubDir",
+					"backend": "userspace",
+				},
+			},
+			want: "faleServer#fakeBaseDir#fakeSubDir#userspace",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -204,7 +236,8 @@ func TestGetVolIdFromParams(t *testing.T) {
 
 func TestGetTargetParentPath(t *testing.T) {
 	type args struct {
-		volId string
+		workingDir string
+		volId      string
 	}
 	tests := []struct {
 		name string
@@ -219,10 +252,18 @@ func TestGetTargetParentPath(t *testing.T) {
 			},
 			want: "/tmp/faleServer#fakeBaseDir#fakeSubDir",
 		},
+		{
+			name: "get target parent path below the working mount dir",
+			args: args{
+				workingDir: "/var/lib/simple-csi",
+				volId:      "faleServer#fakeBaseDir#fakeSubDir",
+			},
+			want: "/var/lib/simple-csi/faleServer#fakeBaseDir#fakeSubDir",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := getTargetParentPath(tt.args.volId); got != tt.want {
+			if got := getTargetParentPath(tt.args.workingDir, tt.args.volId); got != tt.want {
 				t.Errorf("getTargetParentPath() = %v, want %v", got, tt.want)
 			}
 		})
@@ -249,6 +290,15 @@ func TestGetParamsFromVolId(t *testing.T) {
 			want1: "fakeBaseDir",
 			want2: "fakeSubDir",
 		},
+		{
+			name: "get params from volume id with a backend",
+			args: args{
+				volId: "faleServer#fakeBaseDir#fakeSubDir#userspace",
+			},
+			want:  "faleServer",
+			want1: "fakeBaseDir",
+			want2: "fakeSubDir",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -294,6 +344,16 @@ func TestValidateNfsParameters(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "validate blank server list",
+			args: args{
+				params: map[string]string{
+					"server":  " , ",
+					"basedir": "fakeBaseDir",
+				},
+			},
+			wantErr: true,
+		},
 		{
 			name: "validate empty basedir",
 			args: args{
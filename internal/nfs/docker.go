@@ -0,0 +1,448 @@
+package nfs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/klog/v2"
+)
+
+// The docker volume plugin protocol, see https://docs.docker.com/engine/extend/plugins_volume/
+const (
+	dockerContentType = "application/vnd.docker.plugins.v1.2+json"
+	// Option of docker volume create holding the capacity, e.g. 10Gi
+	dockerSizeOption = "size"
+	// File under the state dir the volumes are persisted in
+	dockerVolumesFile = "volumes.json"
+)
+
+// dockerRequest is the body of the VolumeDriver calls, each call uses a part of it
+type dockerRequest struct {
+	Name string
+	Opts map[string]string
+	// Caller of Mount and Unmount, one per container
+	ID string
+}
+
+type dockerVolumeInfo struct {
+	Name       string
+	Mountpoint string            `json:",omitempty"`
+	Status     map[string]string `json:",omitempty"`
+}
+
+type dockerResponse struct {
+	Mountpoint   string             `json:",omitempty"`
+	Volume       *dockerVolumeInfo  `json:",omitempty"`
+	Volumes      []dockerVolumeInfo `json:",omitempty"`
+	Capabilities *dockerCapability  `json:",omitempty"`
+	Err          string             `json:",omitempty"`
+}
+
+type dockerCapability struct {
+	// local as the volumes are only known to the host they were created on
+	Scope string
+}
+
+// dockerVolume is a volume created through docker, persisted in the state dir
+type dockerVolume struct {
+	VolumeID      string            `json:"volumeID"`
+	VolumeContext map[string]string `json:"volumeContext"`
+	// Callers the volume is mounted for, it is published while there are any
+	MountIDs []string `json:"mountIDs,omitempty"`
+}
+
+// dockerPlugin serves the docker volume plugin API and carries the calls out through the
+// controller and node servers of the driver. Calls of the same volume run one at a time,
+// calls of different volumes concurrently.
+type dockerPlugin struct {
+	// Guards volumes, their mount IDs, the volumes file and volumeLocks
+	lock *sync.Mutex
+
+	cs csi.ControllerServer
+	ns csi.NodeServer
+	// Holds the volumes file and the mount points
+	stateDir string
+	// Defaults of the options of docker volume create
+	defaultOptions map[string]string
+
+	volumes map[string]*dockerVolume
+	// Locks of the volumes with calls in progress
+	volumeLocks map[string]*dockerVolumeLock
+}
+
+// dockerVolumeLock serializes the calls of a volume, users counts the calls holding or
+// waiting for it so it is dropped once the last one is done
+type dockerVolumeLock struct {
+	lock  *sync.Mutex
+	users int
+}
+
+// newDockerPlugin returns the plugin with the volumes persisted in stateDir
+func newDockerPlugin(cs csi.ControllerServer, ns csi.NodeServer, stateDir string, defaultOptions map[string]string) (*dockerPlugin, error) {
+	p := &dockerPlugin{
+		lock:           &sync.Mutex{},
+		cs:             cs,
+		ns:             ns,
+		stateDir:       stateDir,
+		defaultOptions: defaultOptions,
+		volumes:        make(map[string]*dockerVolume),
+		volumeLocks:    make(map[string]*dockerVolumeLock),
+	}
+	content, err := os.ReadFile(filepath.Join(stateDir, dockerVolumesFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return p, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(content, &p.volumes); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", filepath.Join(stateDir, dockerVolumesFile), err)
+	}
+	return p, nil
+}
+
+// Handler returns the http handler of the plugin API
+func (p *dockerPlugin) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/Plugin.Activate", func(w http.ResponseWriter, r *http.Request) {
+		writeDockerResponse(w, http.StatusOK, map[string][]string{"Implements": {"VolumeDriver"}})
+	})
+	for name, call := range map[string]func(context.Context, *dockerRequest) (*dockerResponse, error){
+		"Create":       p.create,
+		"Remove":       p.remove,
+		"Mount":        p.mount,
+		"Unmount":      p.unmount,
+		"Path":         p.path,
+		"Get":          p.get,
+		"List":         p.list,
+		"Capabilities": p.capabilities,
+	} {
+		name, call := name, call
+		mux.HandleFunc("/VolumeDriver."+name, func(w http.ResponseWriter, r *http.Request) {
+			req := &dockerRequest{}
+			if err := json.NewDecoder(r.Body).Decode(req); err != nil && err != io.EOF {
+				writeDockerResponse(w, http.StatusBadRequest, &dockerResponse{Err: fmt.Sprintf("invalid request: %v", err)})
+				return
+			}
+			klog.V(4).InfoS("Docker volume call", "call", name, "volume", req.Name, "id", req.ID)
+			if err := validateDockerVolumeName(req.Name); err != nil {
+				writeDockerResponse(w, http.StatusBadRequest, &dockerResponse{Err: err.Error()})
+				return
+			}
+
+			unlock := func() {}
+			if req.Name != "" {
+				unlock = p.lockVolume(req.Name)
+			}
+			resp, err := call(r.Context(), req)
+			unlock()
+			if err != nil {
+				klog.Errorf("Docker volume call %s of volume %s failed: %v", name, req.Name, err)
+				// Docker shows the message, not the grpc code
+				writeDockerResponse(w, http.StatusInternalServerError, &dockerResponse{Err: status.Convert(err).Message()})
+				return
+			}
+			writeDockerResponse(w, http.StatusOK, resp)
+		})
+	}
+	return mux
+}
+
+// lockVolume waits until no other call of the volume name runs and returns the function
+// ending the call
+func (p *dockerPlugin) lockVolume(name string) func() {
+	p.lock.Lock()
+	l, ok := p.volumeLocks[name]
+	if !ok {
+		l = &dockerVolumeLock{lock: &sync.Mutex{}}
+		p.volumeLocks[name] = l
+	}
+	l.users++
+	p.lock.Unlock()
+
+	l.lock.Lock()
+	return func() {
+		l.lock.Unlock()
+		p.lock.Lock()
+		if l.users--; l.users == 0 {
+			delete(p.volumeLocks, name)
+		}
+		p.lock.Unlock()
+	}
+}
+
+// validateDockerVolumeName makes sure the name only names a directory of its own below the
+// mount points, an empty name is left to the calls
+func validateDockerVolumeName(name string) error {
+	if name == "" {
+		return nil
+	}
+	if name == "." || strings.ContainsAny(name, `/\`) || strings.Contains(name, "..") || name != filepath.Base(name) {
+		return fmt.Errorf("invalid volume name %q", name)
+	}
+	return nil
+}
+
+func writeDockerResponse(w http.ResponseWriter, code int, resp interface{}) {
+	w.Header().Set("Content-Type", dockerContentType)
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		klog.Errorf("Failed to write docker volume response: %v", err)
+	}
+}
+
+// create creates the volume with the options of docker volume create as StorageClass
+// parameters, creating an existing volume succeeds
+func (p *dockerPlugin) create(ctx context.Context, req *dockerRequest) (*dockerResponse, error) {
+	if req.Name == "" {
+		return nil, fmt.Errorf("volume name is required")
+	}
+	if _, err := p.volume(req.Name); err == nil {
+		return &dockerResponse{}, nil
+	}
+
+	parameters := make(map[string]string)
+	for k, v := range p.defaultOptions {
+		parameters[k] = v
+	}
+	for k, v := range req.Opts {
+		parameters[k] = v
+	}
+	var capacity *csi.CapacityRange
+	if size, ok := parameters[dockerSizeOption]; ok {
+		q, err := resource.ParseQuantity(size)
+		if err != nil {
+			return nil, fmt.Errorf("invalid size %q: %v", size, err)
+		}
+		capacity = &csi.CapacityRange{RequiredBytes: q.Value()}
+		delete(parameters, dockerSizeOption)
+	}
+
+	resp, err := p.cs.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:               req.Name,
+		CapacityRange:      capacity,
+		VolumeCapabilities: []*csi.VolumeCapability{dockerVolumeCapability()},
+		Parameters:         parameters,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &dockerResponse{}, p.update(func() {
+		p.volumes[req.Name] = &dockerVolume{
+			VolumeID:      resp.GetVolume().GetVolumeId(),
+			VolumeContext: resp.GetVolume().GetVolumeContext(),
+		}
+	})
+}
+
+// remove deletes the volume, volumes still mounted are kept
+func (p *dockerPlugin) remove(ctx context.Context, req *dockerRequest) (*dockerResponse, error) {
+	vol, err := p.volume(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	if len(vol.MountIDs) > 0 {
+		return nil, fmt.Errorf("volume %s is in use by %d containers", req.Name, len(vol.MountIDs))
+	}
+	if _, err := p.cs.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: vol.VolumeID}); err != nil {
+		return nil, err
+	}
+	return &dockerResponse{}, p.update(func() {
+		delete(p.volumes, req.Name)
+	})
+}
+
+// mount publishes the volume for its first caller, later callers share the mount
+func (p *dockerPlugin) mount(ctx context.Context, req *dockerRequest) (*dockerResponse, error) {
+	vol, err := p.volume(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	mountpoint := p.mountpoint(req.Name)
+	for _, id := range vol.MountIDs {
+		if id == req.ID {
+			return &dockerResponse{Mountpoint: mountpoint}, nil
+		}
+	}
+	if len(vol.MountIDs) == 0 {
+		if _, err := p.ns.NodePublishVolume(ctx, &csi.NodePublishVolumeRequest{
+			VolumeId:         vol.VolumeID,
+			TargetPath:       mountpoint,
+			VolumeCapability: dockerVolumeCapability(),
+			VolumeContext:    vol.VolumeContext,
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return &dockerResponse{Mountpoint: mountpoint}, p.update(func() {
+		vol.MountIDs = append(vol.MountIDs, req.ID)
+	})
+}
+
+// unmount unpublishes the volume once its last caller is gone
+func (p *dockerPlugin) unmount(ctx context.Context, req *dockerRequest) (*dockerResponse, error) {
+	vol, err := p.volume(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	var mountIDs []string
+	for _, id := range vol.MountIDs {
+		if id != req.ID {
+			mountIDs = append(mountIDs, id)
+		}
+	}
+	if len(mountIDs) == len(vol.MountIDs) {
+		klog.V(2).InfoS("Volume is not mounted for the caller", "volume", req.Name, "id", req.ID)
+		return &dockerResponse{}, nil
+	}
+	if len(mountIDs) == 0 {
+		if _, err := p.ns.NodeUnpublishVolume(ctx, &csi.NodeUnpublishVolumeRequest{
+			VolumeId:   vol.VolumeID,
+			TargetPath: p.mountpoint(req.Name),
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return &dockerResponse{}, p.update(func() {
+		vol.MountIDs = mountIDs
+	})
+}
+
+func (p *dockerPlugin) path(ctx context.Context, req *dockerRequest) (*dockerResponse, error) {
+	vol, err := p.volume(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	return &dockerResponse{Mountpoint: p.volumeInfo(req.Name, vol).Mountpoint}, nil
+}
+
+func (p *dockerPlugin) get(ctx context.Context, req *dockerRequest) (*dockerResponse, error) {
+	vol, err := p.volume(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	info := p.volumeInfo(req.Name, vol)
+	return &dockerResponse{Volume: &info}, nil
+}
+
+func (p *dockerPlugin) list(ctx context.Context, req *dockerRequest) (*dockerResponse, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	names := make([]string, 0, len(p.volumes))
+	for name := range p.volumes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	volumes := []dockerVolumeInfo{}
+	for _, name := range names {
+		info := p.volumeInfo(name, p.volumes[name])
+		info.Status = nil
+		volumes = append(volumes, info)
+	}
+	return &dockerResponse{Volumes: volumes}, nil
+}
+
+func (p *dockerPlugin) capabilities(ctx context.Context, req *dockerRequest) (*dockerResponse, error) {
+	return &dockerResponse{Capabilities: &dockerCapability{Scope: "local"}}, nil
+}
+
+func (p *dockerPlugin) volume(name string) (*dockerVolume, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	vol, ok := p.volumes[name]
+	if !ok {
+		return nil, fmt.Errorf("volume %s not found", name)
+	}
+	return vol, nil
+}
+
+// volumeInfo describes the volume to docker, the mount point only while it is mounted
+func (p *dockerPlugin) volumeInfo(name string, vol *dockerVolume) dockerVolumeInfo {
+	info := dockerVolumeInfo{
+		Name:   name,
+		Status: map[string]string{"volumeID": vol.VolumeID},
+	}
+	if len(vol.MountIDs) > 0 {
+		info.Mountpoint = p.mountpoint(name)
+	}
+	return info
+}
+
+func (p *dockerPlugin) mountpoint(name string) string {
+	return filepath.Join(p.stateDir, "mounts", name)
+}
+
+// update changes the volumes with fn and saves them
+func (p *dockerPlugin) update(fn func()) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	fn()
+	return p.save()
+}
+
+// save writes the volumes through a temporary file so a crash never leaves a partial file
+// behind, the caller holds the lock
+func (p *dockerPlugin) save() error {
+	content, err := json.Marshal(p.volumes)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(p.stateDir, 0700); err != nil {
+		return err
+	}
+	file := filepath.Join(p.stateDir, dockerVolumesFile)
+	if err := os.WriteFile(file+".tmp", content, 0600); err != nil {
+		return err
+	}
+	return os.Rename(file+".tmp", file)
+}
+
+// serve serves the plugin API on a unix socket until stopCh is closed, the socket is
+// removed afterwards
+func (p *dockerPlugin) serve(socket string, stopCh <-chan struct{}) error {
+	if err := os.MkdirAll(filepath.Dir(socket), 0755); err != nil {
+		return err
+	}
+	if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove stale docker plugin socket %s: %v", socket, err)
+	}
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", socket, err)
+	}
+	klog.V(2).InfoS("Serving the docker volume plugin API", "socket", socket, "stateDir", p.stateDir)
+
+	httpServer := &http.Server{Handler: p.Handler()}
+	go func() {
+		<-stopCh
+		httpServer.Close()
+		os.Remove(socket)
+	}()
+	go func() {
+		if err := httpServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+			klog.Errorf("Docker volume plugin server stopped: %v", err)
+		}
+	}()
+	return nil
+}
+
+// dockerVolumeCapability is how docker uses volumes: a filesystem any container can write
+func dockerVolumeCapability() *csi.VolumeCapability {
+	return &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+	}
+}
@@ -0,0 +1,254 @@
+package nfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	mount "k8s.io/mount-utils"
+)
+
+// newFakeDockerPlugin returns a plugin creating volumes in a local export and mounting them
+// with a fake mounter
+func newFakeDockerPlugin(t *testing.T, stateDir string) (*dockerPlugin, string, *mount.FakeMounter) {
+	t.Helper()
+	cs, root, _ := newLocalControllerServer(t)
+	mounter := mount.NewFakeMounter([]mount.MountPoint{})
+	ns := newFakeNodeServer(cs.driver, mounter)
+	cs.driver.ns = ns
+	p, err := newDockerPlugin(cs, ns, stateDir, map[string]string{
+		serverKey:          "server",
+		basedirKey:         "/export",
+		mountPermissionKey: "0750",
+	})
+	if err != nil {
+		t.Fatalf("newDockerPlugin() error = %v", err)
+	}
+	return p, root, mounter
+}
+
+func dockerCall(t *testing.T, handler http.Handler, call string, req *dockerRequest) (int, *dockerResponse) {
+	t.Helper()
+	body, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/VolumeDriver."+call, bytes.NewReader(body)))
+	resp := &dockerResponse{}
+	if err := json.Unmarshal(rec.Body.Bytes(), resp); err != nil {
+		t.Fatalf("%s: failed to decode %q: %v", call, rec.Body.String(), err)
+	}
+	return rec.Code, resp
+}
+
+func TestDockerPluginActivate(t *testing.T) {
+	p, _, _ := newFakeDockerPlugin(t, t.TempDir())
+	rec := httptest.NewRecorder()
+	p.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/Plugin.Activate", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != dockerContentType {
+		t.Fatalf("Activate = %d %q, want 200 %q", rec.Code, rec.Header().Get("Content-Type"), dockerContentType)
+	}
+	var got map[string][]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil || len(got["Implements"]) != 1 || got["Implements"][0] != "VolumeDriver" {
+		t.Errorf("Activate = %s, %v, want VolumeDriver", rec.Body.String(), err)
+	}
+}
+
+func TestDockerPluginLifecycle(t *testing.T) {
+	stateDir := t.TempDir()
+	p, root, mounter := newFakeDockerPlugin(t, stateDir)
+	handler := p.Handler()
+	mountpoint := filepath.Join(stateDir, "mounts", "data")
+
+	steps := []struct {
+		name     string
+		call     string
+		req      *dockerRequest
+		wantErr  bool
+		wantResp *dockerResponse
+		wantLen  int
+	}{
+		{name: "create", call: "Create", req: &dockerRequest{Name: "data", Opts: map[string]string{dockerSizeOption: "1Mi"}}},
+		{name: "create again", call: "Create", req: &dockerRequest{Name: "data"}},
+		{name: "create with invalid size", call: "Create", req: &dockerRequest{Name: "other", Opts: map[string]string{dockerSizeOption: "lots"}}, wantErr: true},
+		{name: "create without name", call: "Create", req: &dockerRequest{}, wantErr: true},
+		{name: "capabilities", call: "Capabilities", req: &dockerRequest{}, wantResp: &dockerResponse{Capabilities: &dockerCapability{Scope: "local"}}},
+		{name: "list", call: "List", req: &dockerRequest{}, wantResp: &dockerResponse{Volumes: []dockerVolumeInfo{{Name: "data"}}}},
+		{name: "path of unmounted volume", call: "Path", req: &dockerRequest{Name: "data"}, wantResp: &dockerResponse{}},
+		{name: "mount", call: "Mount", req: &dockerRequest{Name: "data", ID: "c1"}, wantResp: &dockerResponse{Mountpoint: mountpoint}, wantLen: 1},
+		{name: "mount for second container", call: "Mount", req: &dockerRequest{Name: "data", ID: "c2"}, wantResp: &dockerResponse{Mountpoint: mountpoint}, wantLen: 1},
+		{name: "path", call: "Path", req: &dockerRequest{Name: "data"}, wantResp: &dockerResponse{Mountpoint: mountpoint}, wantLen: 1},
+		{name: "remove in use", call: "Remove", req: &dockerRequest{Name: "data"}, wantErr: true, wantLen: 1},
+		{name: "unmount first container", call: "Unmount", req: &dockerRequest{Name: "data", ID: "c1"}, wantResp: &dockerResponse{}, wantLen: 1},
+		{name: "unmount last container", call: "Unmount", req: &dockerRequest{Name: "data", ID: "c2"}, wantResp: &dockerResponse{}},
+		{name: "remove", call: "Remove", req: &dockerRequest{Name: "data"}, wantResp: &dockerResponse{}},
+		{name: "get removed volume", call: "Get", req: &dockerRequest{Name: "data"}, wantErr: true},
+		{name: "mount missing volume", call: "Mount", req: &dockerRequest{Name: "missing", ID: "c1"}, wantErr: true},
+	}
+	for _, step := range steps {
+		code, resp := dockerCall(t, handler, step.call, step.req)
+		if step.wantErr {
+			if code != http.StatusInternalServerError || resp.Err == "" {
+				t.Errorf("%s: got %d %+v, want an error", step.name, code, resp)
+			}
+		} else if code != http.StatusOK || resp.Err != "" {
+			t.Errorf("%s: got %d, error %q", step.name, code, resp.Err)
+		}
+		if step.wantResp != nil {
+			got, _ := json.Marshal(resp)
+			want, _ := json.Marshal(step.wantResp)
+			if !bytes.Equal(got, want) {
+				t.Errorf("%s: response = %s, want %s", step.name, got, want)
+			}
+		}
+		if mps, _ := mounter.List(); len(mps) != step.wantLen {
+			t.Errorf("%s: %d mounts, want %d", step.name, len(mps), step.wantLen)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "data")); !os.IsNotExist(err) {
+		t.Errorf("volume directory was not removed: %v", err)
+	}
+}
+
+func TestDockerPluginGet(t *testing.T) {
+	p, _, _ := newFakeDockerPlugin(t, t.TempDir())
+	handler := p.Handler()
+	if code, resp := dockerCall(t, handler, "Create", &dockerRequest{Name: "data"}); code != http.StatusOK {
+		t.Fatalf("Create = %d %q", code, resp.Err)
+	}
+	code, resp := dockerCall(t, handler, "Get", &dockerRequest{Name: "data"})
+	if code != http.StatusOK || resp.Volume == nil {
+		t.Fatalf("Get = %d %+v, want the volume", code, resp)
+	}
+	if want := "server#export#data#local"; resp.Volume.Name != "data" || resp.Volume.Status["volumeID"] != want {
+		t.Errorf("Get = %+v, want volume data with id %s", resp.Volume, want)
+	}
+}
+
+func TestDockerPluginState(t *testing.T) {
+	stateDir := t.TempDir()
+	p, _, _ := newFakeDockerPlugin(t, stateDir)
+	handler := p.Handler()
+	for _, call := range []struct {
+		call string
+		req  *dockerRequest
+	}{
+		{call: "Create", req: &dockerRequest{Name: "data"}},
+		{call: "Create", req: &dockerRequest{Name: "logs"}},
+		{call: "Mount", req: &dockerRequest{Name: "logs", ID: "c1"}},
+	} {
+		if code, resp := dockerCall(t, handler, call.call, call.req); code != http.StatusOK {
+			t.Fatalf("%s %s = %d %q", call.call, call.req.Name, code, resp.Err)
+		}
+	}
+
+	reloaded, err := newDockerPlugin(p.cs, p.ns, stateDir, nil)
+	if err != nil {
+		t.Fatalf("newDockerPlugin() error = %v", err)
+	}
+	code, resp := dockerCall(t, reloaded.Handler(), "List", &dockerRequest{})
+	want := []dockerVolumeInfo{{Name: "data"}, {Name: "logs", Mountpoint: filepath.Join(stateDir, "mounts", "logs")}}
+	if code != http.StatusOK || len(resp.Volumes) != len(want) {
+		t.Fatalf("List after reload = %d %+v, want %+v", code, resp.Volumes, want)
+	}
+	for i := range want {
+		if resp.Volumes[i].Name != want[i].Name || resp.Volumes[i].Mountpoint != want[i].Mountpoint {
+			t.Errorf("volume %d = %+v, want %+v", i, resp.Volumes[i], want[i])
+		}
+	}
+	if got := reloaded.volumes["logs"].MountIDs; len(got) != 1 || got[0] != "c1" {
+		t.Errorf("mount ids of logs = %v, want [c1]", got)
+	}
+
+	if err := os.WriteFile(filepath.Join(stateDir, dockerVolumesFile), []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newDockerPlugin(p.cs, p.ns, stateDir, nil); err == nil {
+		t.Errorf("newDockerPlugin() with a corrupt state file succeeded")
+	}
+}
+
+func TestDockerPluginLockVolume(t *testing.T) {
+	p, _, _ := newFakeDockerPlugin(t, t.TempDir())
+
+	unlockData := p.lockVolume("data")
+	// Calls of other volumes are not held up
+	p.lockVolume("logs")()
+
+	locked := make(chan struct{})
+	go func() {
+		unlock := p.lockVolume("data")
+		close(locked)
+		unlock()
+	}()
+	select {
+	case <-locked:
+		t.Fatal("second call of data ran while the first one held the volume")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlockData()
+	select {
+	case <-locked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("second call of data did not run after the first one finished")
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if len(p.volumeLocks) != 0 {
+		t.Errorf("%d volume locks left behind, want none", len(p.volumeLocks))
+	}
+}
+
+func TestDockerPluginConcurrentVolumes(t *testing.T) {
+	p, _, _ := newFakeDockerPlugin(t, t.TempDir())
+	handler := p.Handler()
+
+	names := []string{"a", "b", "c", "d"}
+	var wg sync.WaitGroup
+	for _, name := range names {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			for _, call := range []string{"Create", "Mount", "List", "Unmount", "Remove"} {
+				if code, resp := dockerCall(t, handler, call, &dockerRequest{Name: name, ID: "c1"}); code != http.StatusOK {
+					t.Errorf("%s %s = %d %q", call, name, code, resp.Err)
+				}
+			}
+		}(name)
+	}
+	wg.Wait()
+	if code, resp := dockerCall(t, handler, "List", &dockerRequest{}); code != http.StatusOK || len(resp.Volumes) != 0 {
+		t.Errorf("List = %d %+v, want no volumes", code, resp.Volumes)
+	}
+}
+
+func TestDockerPluginInvalidNames(t *testing.T) {
+	stateDir := t.TempDir()
+	p, _, mounter := newFakeDockerPlugin(t, stateDir)
+	handler := p.Handler()
+
+	for _, name := range []string{"..", ".", "../escape", "a/b", "/abs", `a\b`, "a..b"} {
+		for _, call := range []string{"Create", "Mount", "Path", "Remove"} {
+			if code, resp := dockerCall(t, handler, call, &dockerRequest{Name: name, ID: "c1"}); code != http.StatusBadRequest || resp.Err == "" {
+				t.Errorf("%s %q = %d %q, want the name rejected", call, name, code, resp.Err)
+			}
+		}
+	}
+	if len(p.volumes) != 0 {
+		t.Errorf("volumes = %v, want none", p.volumes)
+	}
+	if mps, _ := mounter.List(); len(mps) != 0 {
+		t.Errorf("mounts = %v, want none", mps)
+	}
+	if _, err := os.Stat(filepath.Join(stateDir, "escape")); !os.IsNotExist(err) {
+		t.Errorf("escaping mount point was created: %v", err)
+	}
+}
@@ -0,0 +1,196 @@
+package nfs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chenliu1993/simple-csi-driver/internal/nfsv3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// Deadline of fetching the export list of a server
+	exportLookupTimeout = 10 * time.Second
+	// How long a failed lookup is cached, so volumes of a server without a MOUNT service do
+	// not all wait for the lookup to time out
+	exportFailureTTL = 30 * time.Second
+)
+
+// exportCache keeps the export lists of the nfs servers for ttl.
+// A nil cache disables the basedir validation.
+type exportCache struct {
+	lock *sync.Mutex
+
+	ttl     time.Duration
+	entries map[string]*exportEntry
+	// Lookups in progress by server, callers asking meanwhile share them
+	inflight map[string]*exportLookup
+
+	lookup func(ctx context.Context, server string) ([]nfsv3.Export, error)
+	// Counts the lookups, nil if they are not counted
+	metrics *driverMetrics
+}
+
+type exportEntry struct {
+	exports []nfsv3.Export
+	err     error
+	fetched time.Time
+}
+
+// fresh returns whether the entry can still be used, failures expire sooner than lists
+func (e *exportEntry) fresh(ttl time.Duration) bool {
+	if e.err != nil && exportFailureTTL < ttl {
+		ttl = exportFailureTTL
+	}
+	return time.Since(e.fetched) < ttl
+}
+
+// exportLookup is a lookup in progress, done is closed once entry is set
+type exportLookup struct {
+	done  chan struct{}
+	entry *exportEntry
+}
+
+func newExportCache(ttl time.Duration, metrics *driverMetrics) *exportCache {
+	return &exportCache{
+		lock:     &sync.Mutex{},
+		ttl:      ttl,
+		entries:  make(map[string]*exportEntry),
+		inflight: make(map[string]*exportLookup),
+		lookup:   lookupExports,
+		metrics:  metrics,
+	}
+}
+
+// get returns the export list of the server, fetching it if missing, expired or refresh is
+// set. The lock is not held while fetching, concurrent callers wait for the same lookup.
+func (c *exportCache) get(ctx context.Context, server string, refresh bool) ([]nfsv3.Export, error) {
+	c.lock.Lock()
+	entry, ok := c.entries[server]
+	if ok && !refresh && entry.fresh(c.ttl) {
+		c.lock.Unlock()
+		c.metrics.recordExportLookup("hit")
+		return entry.exports, entry.err
+	}
+	l, running := c.inflight[server]
+	if !running {
+		l = &exportLookup{done: make(chan struct{})}
+		c.inflight[server] = l
+	}
+	c.lock.Unlock()
+
+	if refresh {
+		c.metrics.recordExportLookup("refresh")
+	} else {
+		c.metrics.recordExportLookup("miss")
+	}
+	if !running {
+		go c.fetch(server, l)
+	}
+	select {
+	case <-l.done:
+		return l.entry.exports, l.entry.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// fetch looks up the exports of the server for l and caches the outcome, it is not bound
+// to any caller so one giving up does not fail the others
+func (c *exportCache) fetch(server string, l *exportLookup) {
+	ctx, cancel := context.WithTimeout(context.Background(), exportLookupTimeout)
+	defer cancel()
+	exports, err := c.lookup(ctx, server)
+	if err == nil {
+		klog.V(4).InfoS("Fetched nfs server exports", "server", server, "exports", len(exports))
+	}
+
+	c.lock.Lock()
+	l.entry = &exportEntry{exports: exports, err: err, fetched: time.Now()}
+	c.entries[server] = l.entry
+	delete(c.inflight, server)
+	c.lock.Unlock()
+	close(l.done)
+}
+
+// validateBasedir makes sure basedir lies under one of the directories exported by the server.
+// Servers whose export list cannot be fetched, e.g. NFSv4 only ones, are not validated.
+func (c *exportCache) validateBasedir(ctx context.Context, server, basedir string) error {
+	if c == nil {
+		return nil
+	}
+	exports, err := c.get(ctx, server, false)
+	if err != nil {
+		klog.Warningf("Failed to fetch exports of nfs server %s, skipping basedir validation: %v", server, err)
+		return nil
+	}
+	if _, ok := findExport(exports, basedir); ok {
+		return nil
+	}
+	// The export may have been added since the list was cached
+	if exports, err = c.get(ctx, server, true); err != nil {
+		klog.Warningf("Failed to fetch exports of nfs server %s, skipping basedir validation: %v", server, err)
+		return nil
+	}
+	if _, ok := findExport(exports, basedir); ok {
+		return nil
+	}
+
+	if len(exports) == 0 {
+		return status.Errorf(codes.FailedPrecondition, "nfs server %s exports nothing", server)
+	}
+	dirs := make([]string, 0, len(exports))
+	for _, export := range exports {
+		dirs = append(dirs, export.Dir)
+	}
+	return status.Errorf(codes.InvalidArgument, "basedir %s is not under any export of nfs server %s, exports: %s",
+		basedir, server, strings.Join(dirs, ", "))
+}
+
+// findExport returns the export dir holding basedir
+func findExport(exports []nfsv3.Export, basedir string) (string, bool) {
+	basedir = path.Clean("/" + basedir)
+	for _, export := range exports {
+		dir := path.Clean("/" + export.Dir)
+		if dir == "/" || basedir == dir || strings.HasPrefix(basedir, dir+"/") {
+			return export.Dir, true
+		}
+	}
+	return "", false
+}
+
+// lookupExports asks the MOUNT service of the server for its export list
+func lookupExports(ctx context.Context, server string) ([]nfsv3.Export, error) {
+	addr, err := nfsv3.MountAddress(ctx, server)
+	if err != nil {
+		return nil, err
+	}
+	return nfsv3.GetExports(ctx, addr)
+}
+
+// PrintExports writes the export list of the server, one export per line
+func PrintExports(ctx context.Context, server string, out io.Writer) error {
+	ctx, cancel := context.WithTimeout(ctx, exportLookupTimeout)
+	defer cancel()
+	exports, err := lookupExports(ctx, server)
+	if err != nil {
+		return err
+	}
+	for _, export := range exports {
+		groups := "*"
+		if len(export.Groups) > 0 {
+			groups = strings.Join(export.Groups, ",")
+		}
+		if _, err := fmt.Fprintf(out, "%s %s\n", export.Dir, groups); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package nfs
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/chenliu1993/simple-csi-driver/internal/nfsv3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestFindExport(t *testing.T) {
+	exports := []nfsv3.Export{{Dir: "/srv/nfs"}, {Dir: "/data/"}}
+	tests := []struct {
+		name    string
+		exports []nfsv3.Export
+		basedir string
+		want    string
+		wantOk  bool
+	}{
+		{
+			name:    "export itself",
+			exports: exports,
+			basedir: "/srv/nfs",
+			want:    "/srv/nfs",
+			wantOk:  true,
+		},
+		{
+			name:    "under export without leading slash",
+			exports: exports,
+			basedir: "data/volumes",
+			want:    "/data/",
+			wantOk:  true,
+		},
+		{
+			name:    "sibling with common prefix",
+			exports: exports,
+			basedir: "/srv/nfs2",
+		},
+		{
+			name:    "escaping the export",
+			exports: exports,
+			basedir: "/srv/nfs/../other",
+		},
+		{
+			name:    "root export",
+			exports: []nfsv3.Export{{Dir: "/"}},
+			basedir: "/anything",
+			want:    "/",
+			wantOk:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := findExport(tt.exports, tt.basedir)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("findExport() = %s, %v, want %s, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestValidateBasedir(t *testing.T) {
+	tests := []struct {
+		name     string
+		exports  []nfsv3.Export
+		err      error
+		basedir  string
+		wantCode codes.Code
+	}{
+		{
+			name:     "exported basedir",
+			exports:  []nfsv3.Export{{Dir: "/srv/nfs"}},
+			basedir:  "/srv/nfs/volumes",
+			wantCode: codes.OK,
+		},
+		{
+			name:     "typo in basedir",
+			exports:  []nfsv3.Export{{Dir: "/srv/nfs"}},
+			basedir:  "/srv/nsf",
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "nothing exported",
+			basedir:  "/srv/nfs",
+			wantCode: codes.FailedPrecondition,
+		},
+		{
+			name:     "export list unavailable",
+			err:      errors.New("program not registered"),
+			basedir:  "/srv/nfs",
+			wantCode: codes.OK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newExportCache(time.Minute, nil)
+			c.lookup = func(ctx context.Context, server string) ([]nfsv3.Export, error) {
+				return tt.exports, tt.err
+			}
+			err := c.validateBasedir(context.Background(), testServer, tt.basedir)
+			if status.Code(err) != tt.wantCode {
+				t.Errorf("validateBasedir() error = %v, want code %v", err, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestExportCacheRefresh(t *testing.T) {
+	lookups := 0
+	exports := []nfsv3.Export{{Dir: "/srv/nfs"}}
+	c := newExportCache(time.Minute, nil)
+	c.lookup = func(ctx context.Context, server string) ([]nfsv3.Export, error) {
+		lookups++
+		return exports, nil
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := c.validateBasedir(context.Background(), testServer, "/srv/nfs"); err != nil {
+			t.Fatalf("validateBasedir() error = %v", err)
+		}
+	}
+	if lookups != 1 {
+		t.Errorf("export list fetched %d times, want it cached", lookups)
+	}
+
+	// A new export is found by refetching the cached list
+	exports = append(exports, nfsv3.Export{Dir: "/data"})
+	if err := c.validateBasedir(context.Background(), testServer, "/data"); err != nil {
+		t.Errorf("validateBasedir() error = %v", err)
+	}
+	if lookups != 2 {
+		t.Errorf("export list fetched %d times, want 2", lookups)
+	}
+
+	var nilCache *exportCache
+	if err := nilCache.validateBasedir(context.Background(), testServer, "/other"); err != nil {
+		t.Errorf("nil cache should not validate, got %v", err)
+	}
+}
+
+func TestExportCacheSharedLookup(t *testing.T) {
+	var lookups int32
+	release := make(chan struct{})
+	c := newExportCache(time.Minute, nil)
+	c.lookup = func(ctx context.Context, server string) ([]nfsv3.Export, error) {
+		atomic.AddInt32(&lookups, 1)
+		if server == testServer {
+			<-release
+		}
+		return []nfsv3.Export{{Dir: "/srv/nfs"}}, nil
+	}
+
+	errs := make(chan error)
+	for i := 0; i < 5; i++ {
+		go func() {
+			_, err := c.get(context.Background(), testServer, false)
+			errs <- err
+		}()
+	}
+	// Other servers are not held up by the slow one
+	if _, err := c.get(context.Background(), "other", false); err != nil {
+		t.Fatalf("get() of another server error = %v", err)
+	}
+	// A caller giving up does not wait for the lookup
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.get(ctx, testServer, false); err != context.DeadlineExceeded {
+		t.Errorf("get() with an expired context error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	close(release)
+	for i := 0; i < 5; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("get() error = %v", err)
+		}
+	}
+	if got := atomic.LoadInt32(&lookups); got != 2 {
+		t.Errorf("export lists fetched %d times, want once per server", got)
+	}
+}
+
+func TestExportCacheFailure(t *testing.T) {
+	lookups := 0
+	c := newExportCache(time.Hour, nil)
+	c.lookup = func(ctx context.Context, server string) ([]nfsv3.Export, error) {
+		lookups++
+		return nil, errors.New("program not registered")
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.get(context.Background(), testServer, false); err == nil {
+			t.Fatalf("get() succeeded, want the lookup error")
+		}
+	}
+	if lookups != 1 {
+		t.Errorf("export list fetched %d times, want the failure cached", lookups)
+	}
+
+	// Failures expire long before export lists do
+	c.entries[testServer].fetched = time.Now().Add(-exportFailureTTL)
+	if _, err := c.get(context.Background(), testServer, false); err == nil {
+		t.Fatalf("get() succeeded, want the lookup error")
+	}
+	if lookups != 2 {
+		t.Errorf("export list fetched %d times, want the failure refetched", lookups)
+	}
+}
@@ -0,0 +1,208 @@
+package nfs
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/chenliu1993/simple-csi-driver/internal/agent"
+	"github.com/chenliu1993/simple-csi-driver/pkg/driver"
+)
+
+func init() {
+	f := &flags{}
+	driver.Register(driver.Registration{
+		Name:     DriverName,
+		AddFlags: f.addFlags,
+		New: func(opts *driver.Options, stopCh chan os.Signal) (driver.Driver, error) {
+			driverOpts, err := f.driverOptions(opts)
+			if err != nil {
+				return nil, err
+			}
+			d := NewNFSDriver(driverOpts, stopCh)
+			d.loadOptions = func() (*DriverOptions, error) {
+				return f.driverOptions(opts)
+			}
+			return d, nil
+		},
+	})
+}
+
+// flags are the command line settings of the nfs driver
+type flags struct {
+	fs *flag.FlagSet
+
+	configFile         string
+	configWatch        time.Duration
+	workingMountDir    string
+	mountPermissions   string
+	onDeletePolicy     string
+	maxVolumesPerNode  int64
+	topologyFile       string
+	mountPolicyFile    string
+	nfsVersions        string
+	nfsVersionCache    string
+	healthServers      string
+	healthInterval     time.Duration
+	healthTimeout      time.Duration
+	validateExports    bool
+	exportCacheTTL     time.Duration
+	fakeMountRoot      string
+	localExports       string
+	localProjectQuotas bool
+	storageAgents      string
+	agentTLSCert       string
+	agentTLSKey        string
+	agentTLSCA         string
+	userspaceNFSClient bool
+	dockerSocket       string
+	dockerStateDir     string
+	dockerOptions      string
+
+	// Mount option policy of the config file, used if mountPolicyFile is empty
+	mountPolicy *MountOptionPolicy
+}
+
+func (f *flags) addFlags(fs *flag.FlagSet) {
+	f.fs = fs
+	fs.StringVar(&f.configFile, "config", "", "yaml file with defaults of the driver, flags set on the command line override its values")
+	fs.DurationVar(&f.configWatch, "config-watch-interval", 10*time.Second, "how often the config file and the mount option policy are checked for changes, 0 only reloads on SIGHUP")
+	fs.StringVar(&f.workingMountDir, "working-mount-dir", defaultWorkingMountDir, "directory the controller mounts nfs servers under")
+	fs.StringVar(&f.mountPermissions, "mount-permissions", "", "octal permissions of volumes whose StorageClass has no mountPermission parameter, the parameter is required if empty")
+	fs.StringVar(&f.onDeletePolicy, "default-ondelete-policy", onDeleteDelete, "what DeleteVolume does with the volume directory, delete or retain")
+	fs.Int64Var(&f.maxVolumesPerNode, "max-volumes-per-node", 0, "maximum number of volumes that can be published on the node, 0 means unlimited")
+	fs.StringVar(&f.topologyFile, "topology-file", "", "file holding the node topology segments as key=value lines")
+	fs.StringVar(&f.mountPolicyFile, "mount-option-policy", "", "yaml file with the allowed, denied and forced mount options of the node")
+	fs.StringVar(&f.nfsVersions, "nfs-versions", "", "ordered nfs versions to negotiate when a volume requests none, e.g. 4.2,4.1,4,3")
+	fs.StringVar(&f.nfsVersionCache, "nfs-version-cache", "", "file remembering the negotiated nfs version of each server")
+	fs.StringVar(&f.healthServers, "health-check-servers", "", "comma separated nfs servers checked from startup on")
+	fs.DurationVar(&f.healthInterval, "health-check-interval", 0, "how often nfs servers are pinged, 0 (the default) disables the health checks")
+	fs.DurationVar(&f.healthTimeout, "health-check-timeout", 5*time.Second, "deadline of a single nfs server ping")
+	fs.BoolVar(&f.validateExports, "validate-exports", false, "check at volume creation that basedir lies under an export of the nfs server")
+	fs.DurationVar(&f.exportCacheTTL, "export-cache-ttl", 5*time.Minute, "how long the export list of a nfs server is cached")
+	fs.StringVar(&f.fakeMountRoot, "fake-mount-root", "", "map nfs mounts of server:/path to path under this directory instead of mounting, for development with dev-server")
+	fs.StringVar(&f.localExports, "local-exports", "", "comma separated server:/basedir=/local/path mappings of exports backed by local directories of this host")
+	fs.BoolVar(&f.localProjectQuotas, "local-project-quotas", false, "limit volumes on local exports to their capacity with project quotas")
+	fs.StringVar(&f.storageAgents, "storage-agents", "", "comma separated server=host:port addresses of the storage agents managing the volumes of nfs servers")
+	fs.StringVar(&f.agentTLSCert, "agent-tls-cert", "", "client certificate presented to the storage agents")
+	fs.StringVar(&f.agentTLSKey, "agent-tls-key", "", "key of the storage agent client certificate")
+	fs.StringVar(&f.agentTLSCA, "agent-tls-ca", "", "ca the storage agents are verified with")
+	fs.BoolVar(&f.userspaceNFSClient, "userspace-nfs-client", false, "create and delete volume directories through a userspace nfs client instead of mounting")
+	fs.StringVar(&f.dockerSocket, "docker-plugin-socket", "", "unix socket the docker volume plugin API is served on, e.g. /run/docker/plugins/simple-nfs.sock, disabled if empty")
+	fs.StringVar(&f.dockerStateDir, "docker-state-dir", defaultDockerStateDir, "directory the docker volumes and their mount points are kept in")
+	fs.StringVar(&f.dockerOptions, "docker-volume-options", "", "comma separated key=value defaults of the options of docker volume create, e.g. server=10.0.0.1,basedir=/export")
+}
+
+// driverOptions validates the flags and turns them into the options of the driver
+func (f *flags) driverOptions(opts *driver.Options) (*DriverOptions, error) {
+	if f.configFile != "" {
+		config, err := LoadConfig(f.configFile)
+		if err != nil {
+			return nil, err
+		}
+		if f, err = f.withConfig(config); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.Mode.Node() && opts.NodeID == "" {
+		return nil, fmt.Errorf("a node ID is required in %s mode", opts.Mode)
+	}
+	if !filepath.IsAbs(f.workingMountDir) {
+		return nil, fmt.Errorf("working mount dir %q is not an absolute path", f.workingMountDir)
+	}
+	if f.mountPermissions != "" {
+		if err := validateMountPermissions(f.mountPermissions); err != nil {
+			return nil, err
+		}
+	}
+	if f.onDeletePolicy != onDeleteDelete && f.onDeletePolicy != onDeleteRetain {
+		return nil, fmt.Errorf("invalid on delete policy %q, expecting %s or %s", f.onDeletePolicy, onDeleteDelete, onDeleteRetain)
+	}
+	if f.maxVolumesPerNode < 0 {
+		return nil, fmt.Errorf("invalid max volumes per node: %d", f.maxVolumesPerNode)
+	}
+	if f.configWatch < 0 {
+		return nil, fmt.Errorf("invalid config watch interval: %v", f.configWatch)
+	}
+	if f.healthTimeout <= 0 {
+		return nil, fmt.Errorf("invalid health check timeout: %v", f.healthTimeout)
+	}
+
+	mountPolicy := f.mountPolicy
+	if f.mountPolicyFile != "" {
+		var err error
+		if mountPolicy, err = LoadMountOptionPolicy(f.mountPolicyFile); err != nil {
+			return nil, fmt.Errorf("failed to load mount option policy: %v", err)
+		}
+	}
+	versions, err := ParseNFSVersions(f.nfsVersions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse nfs versions: %v", err)
+	}
+	localExports, err := ParseLocalExports(f.localExports)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse local exports: %v", err)
+	}
+	agents, err := ParseStorageAgents(f.storageAgents)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse storage agents: %v", err)
+	}
+	var agentTLSConfig *tls.Config
+	if len(agents) > 0 {
+		// The agents manage volumes on behalf of the controller, never talk to them unauthenticated
+		if agentTLSConfig, err = agent.ClientTLSConfig(f.agentTLSCert, f.agentTLSKey, f.agentTLSCA); err != nil {
+			return nil, fmt.Errorf("failed to load the storage agent tls config: %v", err)
+		}
+	}
+
+	dockerOptions, err := driver.ParseMappings(f.dockerOptions, "key=value")
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse docker volume options: %v", err)
+	}
+	if f.dockerSocket != "" && !filepath.IsAbs(f.dockerStateDir) {
+		return nil, fmt.Errorf("docker state dir %q is not an absolute path", f.dockerStateDir)
+	}
+
+	var configFiles []string
+	for _, file := range []string{f.configFile, f.mountPolicyFile} {
+		if file != "" {
+			configFiles = append(configFiles, file)
+		}
+	}
+
+	return &DriverOptions{
+		DriverName:          opts.DriverName,
+		Endpoint:            opts.Endpoint,
+		NodeID:              opts.NodeID,
+		Mode:                opts.Mode,
+		WorkingMountDir:     f.workingMountDir,
+		MountPermissions:    f.mountPermissions,
+		OnDeletePolicy:      f.onDeletePolicy,
+		MaxVolumesPerNode:   f.maxVolumesPerNode,
+		TopologyFile:        f.topologyFile,
+		MountOptionPolicy:   mountPolicy,
+		NFSVersions:         versions,
+		NFSVersionCacheFile: f.nfsVersionCache,
+		HealthCheckServers:  driver.SplitList(f.healthServers),
+		HealthCheckInterval: f.healthInterval,
+		HealthCheckTimeout:  f.healthTimeout,
+		ValidateExports:     f.validateExports,
+		ExportCacheTTL:      f.exportCacheTTL,
+		UserspaceNFSClient:  f.userspaceNFSClient,
+		FakeMountRoot:       f.fakeMountRoot,
+		LocalExports:        localExports,
+		LocalProjectQuotas:  f.localProjectQuotas,
+		StorageAgents:       agents,
+		AgentTLSConfig:      agentTLSConfig,
+		ConfigFiles:         configFiles,
+		ConfigWatchInterval: f.configWatch,
+		DockerPluginSocket:  f.dockerSocket,
+		DockerStateDir:      f.dockerStateDir,
+		DockerVolumeOptions: dockerOptions,
+		ServerOptions:       opts.Server,
+	}, nil
+}
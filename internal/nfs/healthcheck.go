@@ -0,0 +1,257 @@
+package nfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chenliu1993/simple-csi-driver/internal/oncrpc"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// Used when the portmapper of the server cannot be reached, e.g. NFSv4 only servers
+	defaultNfsPort = 2049
+	nfsV3          = 3
+)
+
+// serverHealthChecker periodically pings the nfs servers the driver knows about.
+// A nil checker treats every server as healthy.
+type serverHealthChecker struct {
+	lock *sync.RWMutex
+
+	interval time.Duration
+	timeout  time.Duration
+	servers  map[string]*serverHealth
+	// Servers tracked because they were configured rather than used by a volume
+	configured map[string]bool
+
+	ping func(ctx context.Context, server string) error
+}
+
+type serverHealth struct {
+	// Servers are healthy until the first check says otherwise
+	healthy   bool
+	lastCheck time.Time
+	lastErr   error
+}
+
+func newServerHealthChecker(servers []string, interval, timeout time.Duration) *serverHealthChecker {
+	h := &serverHealthChecker{
+		lock:     &sync.RWMutex{},
+		interval: interval,
+		timeout:  timeout,
+		servers:  make(map[string]*serverHealth),
+		ping:     pingNfsServer,
+	}
+	h.Reconfigure(servers, interval, timeout)
+	return h
+}
+
+// Reconfigure replaces the configured servers and the check timing, it takes effect from
+// the next round on. Servers which are no longer configured are dropped until a volume
+// uses them again.
+func (h *serverHealthChecker) Reconfigure(servers []string, interval, timeout time.Duration) {
+	if h == nil {
+		return
+	}
+	configured := make(map[string]bool)
+	for _, server := range servers {
+		h.AddServer(server)
+		configured[server] = true
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	for server := range h.configured {
+		if !configured[server] {
+			klog.V(4).InfoS("No longer tracking nfs server health", "server", server)
+			delete(h.servers, server)
+		}
+	}
+	h.configured = configured
+	h.interval = interval
+	h.timeout = timeout
+}
+
+// AddServer starts tracking the server, it is checked from the next round on
+func (h *serverHealthChecker) AddServer(server string) {
+	if h == nil || server == "" {
+		return
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if _, ok := h.servers[server]; !ok {
+		klog.V(4).InfoS("Tracking nfs server health", "server", server)
+		h.servers[server] = &serverHealth{healthy: true}
+	}
+}
+
+// IsHealthy reports whether the last check of the server succeeded, unknown servers are healthy
+func (h *serverHealthChecker) IsHealthy(server string) bool {
+	if h == nil {
+		return true
+	}
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	health, ok := h.servers[server]
+	return !ok || health.healthy
+}
+
+// Ready reports whether at least one of the tracked servers is healthy, or none is tracked
+func (h *serverHealthChecker) Ready() (bool, string) {
+	if h == nil {
+		return true, ""
+	}
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	if len(h.servers) == 0 {
+		return true, ""
+	}
+	var failures []string
+	for server, health := range h.servers {
+		if health.healthy {
+			return true, ""
+		}
+		failures = append(failures, fmt.Sprintf("%s: %v", server, health.lastErr))
+	}
+	sort.Strings(failures)
+	return false, "no nfs server is reachable: " + strings.Join(failures, "; ")
+}
+
+// CheckAll pings every tracked server concurrently
+func (h *serverHealthChecker) CheckAll(ctx context.Context) {
+	h.lock.RLock()
+	servers := make([]string, 0, len(h.servers))
+	for server := range h.servers {
+		servers = append(servers, server)
+	}
+	h.lock.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, server := range servers {
+		wg.Add(1)
+		go func(server string) {
+			defer wg.Done()
+			h.check(ctx, server)
+		}(server)
+	}
+	wg.Wait()
+}
+
+func (h *serverHealthChecker) check(ctx context.Context, server string) {
+	h.lock.RLock()
+	timeout := h.timeout
+	h.lock.RUnlock()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	err := h.ping(ctx, server)
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	health, ok := h.servers[server]
+	if !ok {
+		return
+	}
+	if health.healthy != (err == nil) {
+		if err != nil {
+			klog.Warningf("NFS server %s became unhealthy: %v", server, err)
+		} else {
+			klog.V(2).InfoS("NFS server became healthy", "server", server)
+		}
+	}
+	health.healthy = err == nil
+	health.lastErr = err
+	health.lastCheck = time.Now()
+}
+
+// Run checks the servers every interval until stopCh is closed
+func (h *serverHealthChecker) Run(stopCh <-chan struct{}) {
+	if h == nil || h.currentInterval() <= 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stopCh
+		cancel()
+	}()
+
+	for {
+		h.CheckAll(ctx)
+		// The interval may have been reconfigured in the meantime
+		timer := time.NewTimer(h.currentInterval())
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+func (h *serverHealthChecker) currentInterval() time.Duration {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return h.interval
+}
+
+// pickServer returns the first healthy server of the comma separated list
+func (h *serverHealthChecker) pickServer(servers string) (string, error) {
+	candidates := splitServers(servers)
+	for _, server := range candidates {
+		h.AddServer(server)
+	}
+	for _, server := range candidates {
+		if h.IsHealthy(server) {
+			return server, nil
+		}
+	}
+	return "", fmt.Errorf("none of the nfs servers %v is healthy", candidates)
+}
+
+func splitServers(servers string) []string {
+	var result []string
+	for _, server := range strings.Split(servers, ",") {
+		if server = strings.TrimSpace(server); server != "" {
+			result = append(result, server)
+		}
+	}
+	return result
+}
+
+// pingNfsServer issues a NFS NULL call to the server. Without a port in the server address
+// the port is asked from the portmapper, falling back to 2049.
+func pingNfsServer(ctx context.Context, server string) error {
+	host, port, err := net.SplitHostPort(server)
+	if err != nil {
+		host, port = server, ""
+	}
+	if port == "" {
+		nfsPort, err := oncrpc.GetPort(ctx, net.JoinHostPort(host, strconv.Itoa(oncrpc.PortmapPort)), oncrpc.ProgNFS, nfsV3)
+		if err != nil {
+			klog.V(5).InfoS("Portmapper query failed, using the default nfs port", "server", server, "err", err)
+			nfsPort = defaultNfsPort
+		}
+		port = strconv.Itoa(int(nfsPort))
+	}
+
+	err = oncrpc.Ping(ctx, net.JoinHostPort(host, port), oncrpc.ProgNFS, nfsV3)
+	// The server answered, it just does not speak NFSv3, e.g. a NFSv4 only server
+	var acceptErr *oncrpc.AcceptError
+	if errors.As(err, &acceptErr) && acceptErr.Stat == oncrpc.ProgMismatch {
+		return nil
+	}
+	return err
+}
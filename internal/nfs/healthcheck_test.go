@@ -0,0 +1,158 @@
+package nfs
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/chenliu1993/simple-csi-driver/internal/oncrpc"
+)
+
+// startFakeNfsResponder answers NULL calls of the given nfs versions on a random local port
+func startFakeNfsResponder(t *testing.T, versions ...uint32) string {
+	t.Helper()
+	s := oncrpc.NewServer()
+	for _, vers := range versions {
+		s.Register(oncrpc.ProgNFS, vers, nil)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go s.Serve(l) //nolint:errcheck // stopped by Close
+	t.Cleanup(func() { s.Close() })
+	return l.Addr().String()
+}
+
+// closedAddress returns a local address nothing listens on
+func closedAddress(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
+
+func TestPingNfsServer(t *testing.T) {
+	tests := []struct {
+		name    string
+		server  string
+		wantErr bool
+	}{
+		{
+			name:   "nfsv3 server",
+			server: startFakeNfsResponder(t, 3),
+		},
+		{
+			name:   "nfsv4 only server",
+			server: startFakeNfsResponder(t, 4),
+		},
+		{
+			name:    "nothing listening",
+			server:  closedAddress(t),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			if err := pingNfsServer(ctx, tt.server); (err != nil) != tt.wantErr {
+				t.Errorf("pingNfsServer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestServerHealthChecker(t *testing.T) {
+	healthy := startFakeNfsResponder(t, 3)
+	unhealthy := closedAddress(t)
+	h := newServerHealthChecker([]string{unhealthy}, time.Minute, time.Second)
+
+	if !h.IsHealthy(unhealthy) {
+		t.Errorf("unchecked server %s should be healthy", unhealthy)
+	}
+	h.CheckAll(context.Background())
+	if h.IsHealthy(unhealthy) {
+		t.Errorf("server %s should be unhealthy", unhealthy)
+	}
+	if ready, _ := h.Ready(); ready {
+		t.Errorf("Ready() = true without any healthy server")
+	}
+	if _, err := h.pickServer(unhealthy); err == nil {
+		t.Errorf("pickServer(%s) should fail", unhealthy)
+	}
+
+	h.AddServer(healthy)
+	h.CheckAll(context.Background())
+	if ready, msg := h.Ready(); !ready {
+		t.Errorf("Ready() = false: %s", msg)
+	}
+	got, err := h.pickServer(unhealthy + ", " + healthy)
+	if err != nil || got != healthy {
+		t.Errorf("pickServer() = %s, %v, want %s", got, err, healthy)
+	}
+	if !h.IsHealthy("unknown:2049") {
+		t.Errorf("unknown server should be healthy")
+	}
+}
+
+func TestServerHealthCheckerRecovers(t *testing.T) {
+	down := true
+	h := newServerHealthChecker([]string{testServer}, time.Minute, time.Second)
+	h.ping = func(ctx context.Context, server string) error {
+		if down {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+
+	h.CheckAll(context.Background())
+	if h.IsHealthy(testServer) {
+		t.Errorf("server %s should be unhealthy", testServer)
+	}
+	down = false
+	h.CheckAll(context.Background())
+	if !h.IsHealthy(testServer) {
+		t.Errorf("server %s should be healthy again", testServer)
+	}
+}
+
+func TestNilServerHealthChecker(t *testing.T) {
+	var h *serverHealthChecker
+	h.AddServer(testServer)
+	if !h.IsHealthy(testServer) {
+		t.Errorf("nil checker should report every server healthy")
+	}
+	if ready, _ := h.Ready(); !ready {
+		t.Errorf("nil checker should be ready")
+	}
+	if got, err := h.pickServer(" , " + testServer); err != nil || got != testServer {
+		t.Errorf("pickServer() = %s, %v, want %s", got, err, testServer)
+	}
+}
+
+func TestServerHealthCheckerReconfigure(t *testing.T) {
+	h := newServerHealthChecker([]string{"old:2049", "kept:2049"}, time.Minute, time.Second)
+	h.AddServer("volume:2049")
+
+	h.Reconfigure([]string{"kept:2049", "new:2049"}, time.Second, 2*time.Second)
+	var servers []string
+	for server := range h.servers {
+		servers = append(servers, server)
+	}
+	sort.Strings(servers)
+	if want := []string{"kept:2049", "new:2049", "volume:2049"}; !reflect.DeepEqual(servers, want) {
+		t.Errorf("servers = %v, want %v", servers, want)
+	}
+	if h.currentInterval() != time.Second || h.timeout != 2*time.Second {
+		t.Errorf("interval, timeout = %v, %v, want 1s, 2s", h.currentInterval(), h.timeout)
+	}
+}
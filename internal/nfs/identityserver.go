@@ -3,7 +3,9 @@ package nfs
 import (
 	"context"
 
+	"github.com/chenliu1993/simple-csi-driver/pkg/driver"
 	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 	"k8s.io/klog/v2"
 )
 
@@ -36,38 +38,49 @@ func NewIdentityServer(driver *nfsDriver) *identityServer {
 	}
 }
 
-// GetPluginCapabilities returens the capabilities of the nfs driver.
+// GetPluginCapabilities returens the capabilities of the nfs driver, the controller service
+// only where it is served.
 func (i *identityServer) GetPluginCapabilities(ctx context.Context, req *csi.GetPluginCapabilitiesRequest) (*csi.GetPluginCapabilitiesResponse, error) {
 	klog.V(4).InfoS("Getting nfsdriver capacities......")
-	return &csi.GetPluginCapabilitiesResponse{
-		Capabilities: []*csi.PluginCapability{
-			{
-				Type: &csi.PluginCapability_Service_{
-					Service: &csi.PluginCapability_Service{
-						Type: csi.PluginCapability_Service_CONTROLLER_SERVICE,
-					},
+	caps := []*csi.PluginCapability{
+		{
+			Type: &csi.PluginCapability_Service_{
+				Service: &csi.PluginCapability_Service{
+					Type: csi.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS,
 				},
 			},
-			{
-				Type: &csi.PluginCapability_Service_{
-					Service: &csi.PluginCapability_Service{
-						Type: csi.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS,
-					},
+		},
+		{
+			Type: &csi.PluginCapability_VolumeExpansion_{
+				VolumeExpansion: &csi.PluginCapability_VolumeExpansion{
+					Type: csi.PluginCapability_VolumeExpansion_ONLINE,
 				},
 			},
+		},
+	}
+	if i.driver.mode.Controller() {
+		caps = append([]*csi.PluginCapability{
 			{
-				Type: &csi.PluginCapability_VolumeExpansion_{
-					VolumeExpansion: &csi.PluginCapability_VolumeExpansion{
-						Type: csi.PluginCapability_VolumeExpansion_ONLINE,
+				Type: &csi.PluginCapability_Service_{
+					Service: &csi.PluginCapability_Service{
+						Type: csi.PluginCapability_Service_CONTROLLER_SERVICE,
 					},
 				},
 			},
-		},
-	}, nil
+		}, caps...)
+	}
+	return &csi.GetPluginCapabilitiesResponse{Capabilities: caps}, nil
 }
 
-// Probe is used to check the health status of nfs driver
+// Probe reports ready once the startup checks passed. The reachability of the nfs servers
+// is left to /readyz, as the livenessprobe sidecar restarts the driver when Probe fails.
 func (i *identityServer) Probe(ctx context.Context, req *csi.ProbeRequest) (*csi.ProbeResponse, error) {
 	klog.V(4).InfoS("Probing nfsdriver health status......")
-	return &csi.ProbeResponse{}, nil
+	results := i.driver.startup.run()
+	for _, result := range results {
+		if !result.OK {
+			klog.Warningf("nfs driver is not ready, check %s failed: %s", result.Name, result.Error)
+		}
+	}
+	return &csi.ProbeResponse{Ready: wrapperspb.Bool(driver.Passed(results))}, nil
 }
@@ -1 +1,104 @@
 package nfs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/chenliu1993/simple-csi-driver/pkg/driver"
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+func TestProbe(t *testing.T) {
+	tests := []struct {
+		name    string
+		servers func(t *testing.T) []string
+		startup *startupChecks
+		// Probe leaves the nfs servers to the readiness checks
+		want      bool
+		wantReady bool
+	}{
+		{
+			name:      "no health checks",
+			servers:   nil,
+			want:      true,
+			wantReady: true,
+		},
+		{
+			name:      "healthy server",
+			servers:   func(t *testing.T) []string { return []string{startFakeNfsResponder(t, 3), closedAddress(t)} },
+			want:      true,
+			wantReady: true,
+		},
+		{
+			name:      "no healthy server",
+			servers:   func(t *testing.T) []string { return []string{closedAddress(t)} },
+			want:      true,
+			wantReady: false,
+		},
+		{
+			name:      "failing startup check",
+			startup:   newStartupChecks(startupCheck{name: "failing", check: func() error { return errors.New("failed") }}),
+			want:      false,
+			wantReady: false,
+		},
+		{
+			name:      "passing startup check",
+			startup:   newStartupChecks(startupCheck{name: "passing", check: func() error { return nil }}),
+			want:      true,
+			wantReady: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewFakeNfsDriver(fakeNode)
+			d.startup = tt.startup
+			if tt.servers != nil {
+				d.healthChecker = newServerHealthChecker(tt.servers(t), time.Minute, time.Second)
+				d.healthChecker.CheckAll(context.Background())
+			}
+			got, err := NewIdentityServer(d).Probe(context.Background(), &csi.ProbeRequest{})
+			if err != nil {
+				t.Fatalf("Probe() error = %v", err)
+			}
+			if got.GetReady().GetValue() != tt.want {
+				t.Errorf("Probe() ready = %v, want %v", got.GetReady().GetValue(), tt.want)
+			}
+			if ready := driver.Passed(d.Ready(context.Background())); ready != tt.wantReady {
+				t.Errorf("Ready() passed = %v, want %v", ready, tt.wantReady)
+			}
+		})
+	}
+}
+
+func TestGetPluginCapabilities(t *testing.T) {
+	tests := []struct {
+		mode           driver.Mode
+		wantController bool
+	}{
+		{mode: "", wantController: true},
+		{mode: driver.ModeAll, wantController: true},
+		{mode: driver.ModeController, wantController: true},
+		{mode: driver.ModeNode, wantController: false},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			d := NewFakeNfsDriver(fakeNode)
+			d.mode = tt.mode
+			resp, err := NewIdentityServer(d).GetPluginCapabilities(context.Background(), &csi.GetPluginCapabilitiesRequest{})
+			if err != nil {
+				t.Fatalf("GetPluginCapabilities() error = %v", err)
+			}
+			var controller bool
+			for _, c := range resp.GetCapabilities() {
+				if c.GetService().GetType() == csi.PluginCapability_Service_CONTROLLER_SERVICE {
+					controller = true
+				}
+			}
+			if controller != tt.wantController {
+				t.Errorf("CONTROLLER_SERVICE advertised = %v, want %v", controller, tt.wantController)
+			}
+		})
+	}
+}
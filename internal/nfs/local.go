@@ -0,0 +1,179 @@
+package nfs
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/chenliu1993/simple-csi-driver/internal/localfs"
+	"github.com/chenliu1993/simple-csi-driver/pkg/driver"
+	"google.golang.org/grpc/status"
+)
+
+// localExports maps exports of nfs servers, as server:/basedir, to the local directories
+// backing them. A controller running on the nfs server host manages those directly.
+type localExports map[string]string
+
+// ParseLocalExports parses comma separated server:/basedir=/local/path mappings
+func ParseLocalExports(s string) (map[string]string, error) {
+	mappings, err := driver.ParseMappings(s, "server:/basedir=/local/path")
+	if err != nil {
+		return nil, fmt.Errorf("invalid local exports: %v", err)
+	}
+	exports := make(map[string]string)
+	for export, path := range mappings {
+		idx := strings.Index(export, ":/")
+		if idx <= 0 {
+			return nil, fmt.Errorf("invalid local export %q, export is not in server:/basedir format", export)
+		}
+		if !filepath.IsAbs(path) {
+			return nil, fmt.Errorf("invalid local export %q, local path must be absolute", export)
+		}
+		exports[localExportKey(export[:idx], export[idx+1:])] = filepath.Clean(path)
+	}
+	return exports, nil
+}
+
+func localExportKey(server, basedir string) string {
+	return server + ":" + filepath.Join("/", basedir)
+}
+
+// lookup returns the local directory backing server:basedir, basedir may lie below a mapped export
+func (e localExports) lookup(server, basedir string) (string, bool) {
+	if len(e) == 0 {
+		return "", false
+	}
+	dir := filepath.Join("/", basedir)
+	for d := dir; ; d = filepath.Dir(d) {
+		if p, ok := e[localExportKey(server, d)]; ok {
+			rel, err := filepath.Rel(d, dir)
+			if err != nil {
+				return "", false
+			}
+			return filepath.Join(p, rel), true
+		}
+		if d == "/" {
+			return "", false
+		}
+	}
+}
+
+// Check if implements Backend
+var _ Backend = &localBackend{}
+
+// localBackend manages the volumes of a local directory backing an export directly
+type localBackend struct {
+	vols *localfs.Volumes
+}
+
+func newLocalBackend(base string, ops localfs.Ops, projectQuotas bool) *localBackend {
+	return &localBackend{
+		vols: &localfs.Volumes{
+			Base:          base,
+			Ops:           ops,
+			ProjectQuotas: projectQuotas,
+		},
+	}
+}
+
+func (b *localBackend) Name() string {
+	return backendLocal
+}
+
+func (b *localBackend) Provision(ctx context.Context, vol *Volume, snapshot string) (int64, error) {
+	capacity, err := b.vols.Create(ctx, vol.Subdir, vol.Mode, vol.CapacityBytes, snapshot)
+	if err != nil {
+		return 0, localStatus(err, "failed to create volume directory %s", vol.Subdir)
+	}
+	return capacity, nil
+}
+
+func (b *localBackend) Clone(ctx context.Context, vol *Volume, source string) (int64, error) {
+	capacity, err := b.vols.Clone(ctx, vol.Subdir, vol.Mode, vol.CapacityBytes, source)
+	if err != nil {
+		return 0, localStatus(err, "failed to clone volume directory %s", source)
+	}
+	return capacity, nil
+}
+
+func (b *localBackend) Delete(ctx context.Context, vol *Volume) error {
+	if err := b.vols.Delete(ctx, vol.Subdir); err != nil {
+		return localStatus(err, "failed to remove volume directory %s", vol.Subdir)
+	}
+	return nil
+}
+
+// Expand raises the project quota of the volume directory, without project quotas there is
+// no limit to raise
+func (b *localBackend) Expand(ctx context.Context, vol *Volume) (int64, error) {
+	if !b.vols.ProjectQuotas {
+		return vol.CapacityBytes, nil
+	}
+	if err := b.vols.SetQuota(vol.Subdir, vol.CapacityBytes); err != nil {
+		return 0, localStatus(err, "failed to expand volume directory %s", vol.Subdir)
+	}
+	return vol.CapacityBytes, nil
+}
+
+// CreateSnapshot clones the volume directory into the snapshots directory of its export
+func (b *localBackend) CreateSnapshot(ctx context.Context, vol *Volume, name, sourceVolId string) (time.Time, error) {
+	created, err := b.vols.CreateSnapshot(ctx, vol.Subdir, name, sourceVolId)
+	if err != nil {
+		return time.Time{}, localStatus(err, "failed to snapshot volume %s", sourceVolId)
+	}
+	return created, nil
+}
+
+func (b *localBackend) DeleteSnapshot(ctx context.Context, vol *Volume, name string) error {
+	if err := b.vols.DeleteSnapshot(name); err != nil {
+		return localStatus(err, "failed to delete snapshot %s", name)
+	}
+	return nil
+}
+
+func (b *localBackend) Capacity(ctx context.Context, vol *Volume) (int64, error) {
+	_, available, err := b.vols.Usage()
+	if err != nil {
+		return 0, localStatus(err, "failed to get the usage of %s", vol.Basedir)
+	}
+	return available, nil
+}
+
+func (b *localBackend) List(ctx context.Context, vol *Volume) ([]string, error) {
+	subdirs, err := b.vols.List()
+	if err != nil {
+		return nil, localStatus(err, "failed to list %s", vol.Basedir)
+	}
+	return subdirs, nil
+}
+
+// localStatus maps errors of local filesystem operations to grpc codes
+func localStatus(err error, format string, args ...interface{}) error {
+	return status.Errorf(localfs.Code(err), "%s: %v", fmt.Sprintf(format, args...), err)
+}
+
+// getSnapshotId returns the id of the snapshot name of a volume on server:basedir taken
+// by the backend
+func getSnapshotId(server, basedir, name, backend string) string {
+	return getVolIdFromParams(map[string]string{
+		serverKey:  server,
+		basedirKey: basedir,
+		subdirKey:  localfs.SnapshotsDir + "/" + name,
+		backendKey: backend,
+	})
+}
+
+// getParamsFromSnapshotId returns the server, basedir and name of the snapshot id
+func getParamsFromSnapshotId(snapshotId string) (string, string, string, error) {
+	server, basedir, subdir, err := getParamsFromVolId(snapshotId)
+	if err != nil {
+		return "", "", "", err
+	}
+	name := strings.TrimPrefix(subdir, localfs.SnapshotsDir+"/")
+	if name == subdir || localfs.ValidateSnapshotName(name) != nil {
+		return "", "", "", fmt.Errorf("invalid snapshot ID %s", snapshotId)
+	}
+	return server, basedir, name, nil
+}
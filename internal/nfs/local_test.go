@@ -0,0 +1,357 @@
+package nfs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/chenliu1993/simple-csi-driver/internal/localfs"
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestParseLocalExports(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:  "empty",
+			value: "",
+			want:  map[string]string{},
+		},
+		{
+			name:  "several exports",
+			value: "10.0.0.1:/export=/srv/export, filer:2049:/data/=/data/",
+			want: map[string]string{
+				"10.0.0.1:/export": "/srv/export",
+				"filer:2049:/data": "/data",
+			},
+		},
+		{
+			name:    "missing local path",
+			value:   "10.0.0.1:/export",
+			wantErr: true,
+		},
+		{
+			name:    "missing server",
+			value:   "/export=/srv/export",
+			wantErr: true,
+		},
+		{
+			name:    "relative local path",
+			value:   "10.0.0.1:/export=srv/export",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseLocalExports(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseLocalExports() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseLocalExports() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocalExportsLookup(t *testing.T) {
+	exports := localExports{
+		"10.0.0.1:/export": "/srv/export",
+		"10.0.0.1:/":       "/srv/root",
+	}
+	tests := []struct {
+		name    string
+		server  string
+		basedir string
+		want    string
+		wantOk  bool
+	}{
+		{
+			name:    "exact export",
+			server:  "10.0.0.1",
+			basedir: "/export",
+			want:    "/srv/export",
+			wantOk:  true,
+		},
+		{
+			name:    "basedir of a volume id without leading slash",
+			server:  "10.0.0.1",
+			basedir: "export/team",
+			want:    "/srv/export/team",
+			wantOk:  true,
+		},
+		{
+			name:    "falls back to the root export",
+			server:  "10.0.0.1",
+			basedir: "/other",
+			want:    "/srv/root/other",
+			wantOk:  true,
+		},
+		{
+			name:    "other server",
+			server:  "10.0.0.2",
+			basedir: "/export",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := exports.lookup(tt.server, tt.basedir)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("lookup() = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+// newLocalControllerServer returns a controller managing server:/export in a temporary
+// directory with project quotas, the quotas set are kept by the returned fake
+func newLocalControllerServer(t *testing.T) (*controllerServer, string, *localfs.Fake) {
+	t.Helper()
+	root := t.TempDir()
+	fs := localfs.NewFake()
+	d := NewFakeNfsDriver(fakeNode)
+	d.localExports = localExports{"server:/export": root}
+	d.localOps = fs
+	d.localProjectQuotas = true
+	return NewControllerServer(d), root, fs
+}
+
+func TestLocalCreateDeleteVolume(t *testing.T) {
+	cs, root, fs := newLocalControllerServer(t)
+	ctx := context.Background()
+
+	resp, err := cs.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:               testVolId,
+		VolumeCapabilities: []*csi.VolumeCapability{mountVolumeCapability},
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: 1 << 20},
+		Parameters: map[string]string{
+			serverKey:          "server",
+			basedirKey:         "/export",
+			mountPermissionKey: "0750",
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume() error = %v", err)
+	}
+	volumeDir := filepath.Join(root, testVolId)
+	if fi, err := os.Stat(volumeDir); err != nil || !fi.IsDir() {
+		t.Fatalf("volume directory %s was not created: %v", volumeDir, err)
+	}
+	if resp.Volume.CapacityBytes != 1<<20 || fs.Quota(volumeDir) != 1<<20 {
+		t.Errorf("capacity = %d, quota = %d, want %d", resp.Volume.CapacityBytes, fs.Quota(volumeDir), 1<<20)
+	}
+
+	capacity, err := cs.GetCapacity(ctx, &csi.GetCapacityRequest{
+		Parameters: map[string]string{serverKey: "server", basedirKey: "/export"},
+	})
+	if err != nil || capacity.AvailableCapacity <= 0 {
+		t.Errorf("GetCapacity() = %v, %v, want available bytes", capacity, err)
+	}
+
+	// The volume stays with the backend which created it when the export is no longer local
+	if want := "server#export#" + testVolId + "#local"; resp.Volume.VolumeId != want {
+		t.Fatalf("volume ID = %s, want %s", resp.Volume.VolumeId, want)
+	}
+	exports := cs.driver.localExports
+	cs.driver.localExports = nil
+	if _, err := cs.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: resp.Volume.VolumeId}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("DeleteVolume() without the local export error = %v, want code %v", err, codes.FailedPrecondition)
+	}
+	cs.driver.localExports = exports
+
+	if _, err := cs.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: resp.Volume.VolumeId}); err != nil {
+		t.Fatalf("DeleteVolume() error = %v", err)
+	}
+	if _, err := os.Stat(volumeDir); !os.IsNotExist(err) {
+		t.Errorf("volume directory %s was not removed: %v", volumeDir, err)
+	}
+	if _, err := cs.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: resp.Volume.VolumeId}); err != nil {
+		t.Errorf("DeleteVolume() of a removed volume error = %v", err)
+	}
+}
+
+func TestLocalCreateVolumeCapacityConflict(t *testing.T) {
+	cs, _, _ := newLocalControllerServer(t)
+	ctx := context.Background()
+	create := func(bytes int64) error {
+		_, err := cs.CreateVolume(ctx, &csi.CreateVolumeRequest{
+			Name:               testVolId,
+			VolumeCapabilities: []*csi.VolumeCapability{mountVolumeCapability},
+			CapacityRange:      &csi.CapacityRange{RequiredBytes: bytes, LimitBytes: bytes},
+			Parameters: map[string]string{
+				serverKey:          "server",
+				basedirKey:         "/export",
+				mountPermissionKey: "0750",
+			},
+		})
+		return err
+	}
+
+	if err := create(1 << 20); err != nil {
+		t.Fatalf("CreateVolume() error = %v", err)
+	}
+	if err := create(1 << 20); err != nil {
+		t.Errorf("CreateVolume() retry error = %v", err)
+	}
+	if err := create(2 << 20); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("CreateVolume() with another capacity error = %v, want code %v", err, codes.AlreadyExists)
+	}
+
+	if _, err := cs.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: "server#export#" + testVolId + "#local"}); err != nil {
+		t.Fatalf("DeleteVolume() error = %v", err)
+	}
+	if err := create(2 << 20); err != nil {
+		t.Errorf("CreateVolume() after the deletion error = %v", err)
+	}
+}
+
+func TestDeleteVolumeInProgress(t *testing.T) {
+	cs, _, _ := newLocalControllerServer(t)
+	volId := "server#export#" + testVolId + "#local"
+	cs.idempotency.AddProcessing(volId)
+	defer cs.idempotency.RemoveProcessing(volId)
+	if _, err := cs.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: volId}); status.Code(err) != codes.Aborted {
+		t.Errorf("DeleteVolume() of a volume being deleted error = %v, want code %v", err, codes.Aborted)
+	}
+}
+
+func TestLocalRetainVolume(t *testing.T) {
+	cs, root, _ := newLocalControllerServer(t)
+	cs.driver.mountPermissions = "0750"
+	cs.driver.onDeletePolicy = onDeleteRetain
+	ctx := context.Background()
+
+	resp, err := cs.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:               testVolId,
+		VolumeCapabilities: []*csi.VolumeCapability{mountVolumeCapability},
+		Parameters:         map[string]string{serverKey: "server", basedirKey: "/export"},
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume() without mountPermission error = %v", err)
+	}
+	if got := resp.Volume.VolumeContext[mountPermissionKey]; got != "0750" {
+		t.Errorf("mountPermission = %q, want the default 0750", got)
+	}
+	volumeDir := filepath.Join(root, testVolId)
+	if fi, err := os.Stat(volumeDir); err != nil || fi.Mode().Perm() != 0750 {
+		t.Fatalf("volume directory %s = %v, %v, want mode 0750", volumeDir, fi, err)
+	}
+
+	if _, err := cs.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: resp.Volume.VolumeId}); err != nil {
+		t.Fatalf("DeleteVolume() error = %v", err)
+	}
+	if _, err := os.Stat(volumeDir); err != nil {
+		t.Errorf("retained volume directory %s was removed: %v", volumeDir, err)
+	}
+}
+
+func TestLocalSnapshot(t *testing.T) {
+	cs, root, _ := newLocalControllerServer(t)
+	ctx := context.Background()
+	parameters := func() map[string]string {
+		return map[string]string{
+			serverKey:          "server",
+			basedirKey:         "/export",
+			mountPermissionKey: "0750",
+		}
+	}
+
+	resp, err := cs.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:               testVolId,
+		VolumeCapabilities: []*csi.VolumeCapability{mountVolumeCapability},
+		Parameters:         parameters(),
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume() error = %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, testVolId, "data"), []byte("hello"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	snap, err := cs.CreateSnapshot(ctx, &csi.CreateSnapshotRequest{
+		Name:           "snap",
+		SourceVolumeId: resp.Volume.VolumeId,
+	})
+	if err != nil {
+		t.Fatalf("CreateSnapshot() error = %v", err)
+	}
+	if _, err := cs.CreateSnapshot(ctx, &csi.CreateSnapshotRequest{
+		Name:           "snap",
+		SourceVolumeId: "server#export#other",
+	}); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("CreateSnapshot() of another volume error = %v, want code %v", err, codes.AlreadyExists)
+	}
+
+	if _, err := cs.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:               "restored",
+		VolumeCapabilities: []*csi.VolumeCapability{mountVolumeCapability},
+		Parameters:         parameters(),
+		VolumeContentSource: &csi.VolumeContentSource{
+			Type: &csi.VolumeContentSource_Snapshot{
+				Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: snap.Snapshot.SnapshotId},
+			},
+		},
+	}); err != nil {
+		t.Fatalf("CreateVolume() from snapshot error = %v", err)
+	}
+	if b, err := os.ReadFile(filepath.Join(root, "restored", "data")); err != nil || string(b) != "hello" {
+		t.Errorf("restored data = %q, %v", b, err)
+	}
+
+	for _, id := range []string{"server#export#.snapshots/..", "server#export#.snapshots/snap.source", "server#export#.snapshots/a/b"} {
+		if _, err := cs.CreateVolume(ctx, &csi.CreateVolumeRequest{
+			Name:               "escaped",
+			VolumeCapabilities: []*csi.VolumeCapability{mountVolumeCapability},
+			Parameters:         parameters(),
+			VolumeContentSource: &csi.VolumeContentSource{
+				Type: &csi.VolumeContentSource_Snapshot{
+					Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: id},
+				},
+			},
+		}); status.Code(err) != codes.NotFound {
+			t.Errorf("CreateVolume() from snapshot %s error = %v, want code %v", id, err, codes.NotFound)
+		}
+	}
+	if _, err := cs.CreateSnapshot(ctx, &csi.CreateSnapshotRequest{
+		Name:           "snap.source",
+		SourceVolumeId: resp.Volume.VolumeId,
+	}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("CreateSnapshot() named like a source file error = %v, want code %v", err, codes.InvalidArgument)
+	}
+
+	// Volume IDs pointing into the snapshots never reach their data
+	for _, id := range []string{"server#export#.snapshots", "server#export#.snapshots/snap", "server#export#a/../.snapshots"} {
+		if _, err := cs.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: id}); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("DeleteVolume(%s) error = %v, want code %v", id, err, codes.InvalidArgument)
+		}
+	}
+	if b, err := os.ReadFile(filepath.Join(root, localfs.SnapshotsDir, "snap", "data")); err != nil || string(b) != "hello" {
+		t.Errorf("snapshot data after DeleteVolume = %q, %v", b, err)
+	}
+
+	if _, err := cs.DeleteSnapshot(ctx, &csi.DeleteSnapshotRequest{SnapshotId: snap.Snapshot.SnapshotId}); err != nil {
+		t.Fatalf("DeleteSnapshot() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, localfs.SnapshotsDir, "snap")); !os.IsNotExist(err) {
+		t.Errorf("snapshot was not removed: %v", err)
+	}
+}
+
+func TestSnapshotNotLocal(t *testing.T) {
+	cs := NewControllerServer(NewFakeNfsDriver(fakeNode))
+	_, err := cs.CreateSnapshot(context.Background(), &csi.CreateSnapshotRequest{
+		Name:           "snap",
+		SourceVolumeId: "server#export#vol",
+	})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("CreateSnapshot() error = %v, want code %v", err, codes.FailedPrecondition)
+	}
+}
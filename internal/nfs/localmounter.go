@@ -0,0 +1,142 @@
+package nfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"k8s.io/klog/v2"
+	mount "k8s.io/mount-utils"
+)
+
+// Check if implements mount.Interface
+var _ mount.Interface = &localMounter{}
+
+// localMounter pretends to mount nfs sources server:/path by symlinking the target to path
+// under root, the directory a local nfs server exports. The driver then runs without
+// privileges or a real server, e.g. in the sanity tests.
+type localMounter struct {
+	lock *sync.Mutex
+
+	root   string
+	mounts map[string]mount.MountPoint
+}
+
+func newLocalMounter(root string) *localMounter {
+	return &localMounter{
+		lock:   &sync.Mutex{},
+		root:   root,
+		mounts: make(map[string]mount.MountPoint),
+	}
+}
+
+// localPath returns the directory under root the nfs source maps to
+func (m *localMounter) localPath(source string) (string, error) {
+	idx := strings.Index(source, ":/")
+	if idx < 0 {
+		return "", fmt.Errorf("mount.nfs: remote share not in 'host:dir' format: %s", source)
+	}
+	p := filepath.Join(m.root, filepath.Clean(source[idx+1:]))
+	fi, err := os.Stat(p)
+	if err != nil || !fi.IsDir() {
+		return "", fmt.Errorf("mount.nfs: mounting %s failed, reason given by server: No such file or directory", source)
+	}
+	return p, nil
+}
+
+func (m *localMounter) Mount(source string, target string, fstype string, options []string) error {
+	target = filepath.Clean(target)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if fstype != "nfs" && fstype != "nfs4" {
+		return fmt.Errorf("mount: unsupported filesystem type %s", fstype)
+	}
+	if _, ok := m.mounts[target]; ok {
+		return fmt.Errorf("mount: %s is already mounted", target)
+	}
+	p, err := m.localPath(source)
+	if err != nil {
+		return err
+	}
+	// Like a real mount the target has to be an existing directory, it is hidden while mounted
+	if err := os.Remove(target); err != nil {
+		return fmt.Errorf("mount: mount point %s: %v", target, err)
+	}
+	if err := os.Symlink(p, target); err != nil {
+		return err
+	}
+	klog.V(4).InfoS("Mounted locally", "source", source, "target", target, "path", p)
+	m.mounts[target] = mount.MountPoint{Device: source, Path: target, Type: fstype, Opts: options}
+	return nil
+}
+
+func (m *localMounter) MountSensitive(source string, target string, fstype string, options []string, sensitiveOptions []string) error {
+	return m.Mount(source, target, fstype, append(options, sensitiveOptions...))
+}
+
+func (m *localMounter) MountSensitiveWithoutSystemd(source string, target string, fstype string, options []string, sensitiveOptions []string) error {
+	return m.MountSensitive(source, target, fstype, options, sensitiveOptions)
+}
+
+func (m *localMounter) MountSensitiveWithoutSystemdWithMountFlags(source string, target string, fstype string, options []string, sensitiveOptions []string, mountFlags []string) error {
+	return m.MountSensitive(source, target, fstype, options, sensitiveOptions)
+}
+
+func (m *localMounter) Unmount(target string) error {
+	target = filepath.Clean(target)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.mounts[target]; !ok {
+		return fmt.Errorf("umount: %s: not mounted", target)
+	}
+	if err := os.Remove(target); err != nil {
+		return err
+	}
+	// Bring the hidden mount point back
+	if err := os.Mkdir(target, 0750); err != nil {
+		return err
+	}
+	delete(m.mounts, target)
+	return nil
+}
+
+func (m *localMounter) List() ([]mount.MountPoint, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	mounts := make([]mount.MountPoint, 0, len(m.mounts))
+	for _, mp := range m.mounts {
+		mounts = append(mounts, mp)
+	}
+	sort.Slice(mounts, func(i, j int) bool { return mounts[i].Path < mounts[j].Path })
+	return mounts, nil
+}
+
+func (m *localMounter) IsLikelyNotMountPoint(file string) (bool, error) {
+	if _, err := os.Lstat(file); err != nil {
+		return true, err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	_, ok := m.mounts[filepath.Clean(file)]
+	return !ok, nil
+}
+
+func (m *localMounter) CanSafelySkipMountPointCheck() bool {
+	return false
+}
+
+func (m *localMounter) IsMountPoint(file string) (bool, error) {
+	notMnt, err := m.IsLikelyNotMountPoint(file)
+	return !notMnt, err
+}
+
+func (m *localMounter) GetMountRefs(pathname string) ([]string, error) {
+	return nil, nil
+}
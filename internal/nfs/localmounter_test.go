@@ -0,0 +1,84 @@
+package nfs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalMounter(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "export", "vol"), 0755); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	target := filepath.Join(t.TempDir(), "target")
+	if err := os.Mkdir(target, 0750); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	m := newLocalMounter(root)
+
+	tests := []struct {
+		name    string
+		source  string
+		fstype  string
+		wantErr bool
+	}{
+		{
+			name:    "unsupported filesystem",
+			source:  "server:/export",
+			fstype:  "ext4",
+			wantErr: true,
+		},
+		{
+			name:    "source without host",
+			source:  "/export",
+			fstype:  "nfs",
+			wantErr: true,
+		},
+		{
+			name:    "missing export",
+			source:  "server:/missing",
+			fstype:  "nfs",
+			wantErr: true,
+		},
+		{
+			name:   "mounted",
+			source: "server:/export",
+			fstype: "nfs",
+		},
+		{
+			name:    "already mounted",
+			source:  "server:/export",
+			fstype:  "nfs4",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := m.Mount(tt.source, target, tt.fstype, nil); (err != nil) != tt.wantErr {
+				t.Errorf("Mount() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if notMnt, err := m.IsLikelyNotMountPoint(target); err != nil || notMnt {
+		t.Errorf("IsLikelyNotMountPoint() = %v, %v, want false", notMnt, err)
+	}
+	if _, err := os.Stat(filepath.Join(target, "vol")); err != nil {
+		t.Errorf("volume directory is not visible through the mount: %v", err)
+	}
+	mounts, err := m.List()
+	if err != nil || len(mounts) != 1 || mounts[0].Device != "server:/export" {
+		t.Errorf("List() = %v, %v", mounts, err)
+	}
+
+	if err := m.Unmount(target); err != nil {
+		t.Fatalf("Unmount() error = %v", err)
+	}
+	if fi, err := os.Lstat(target); err != nil || !fi.IsDir() {
+		t.Errorf("mount point was not restored: %v", err)
+	}
+	if err := m.Unmount(target); err == nil {
+		t.Errorf("Unmount() of an unmounted target succeeded")
+	}
+}
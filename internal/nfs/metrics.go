@@ -0,0 +1,101 @@
+package nfs
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Operations of the mount metrics
+const (
+	mountOperation   = "mount"
+	unmountOperation = "unmount"
+)
+
+// driverMetrics are the mount and export cache metrics of a driver, registered with the
+// driver label in Run. A nil set records nothing.
+type driverMetrics struct {
+	negotiatedNfsVersion *prometheus.GaugeVec
+	mountDuration        *prometheus.HistogramVec
+	mountFailures        *prometheus.CounterVec
+	exportCacheLookups   *prometheus.CounterVec
+}
+
+// Check if implements prometheus.Collector
+var _ prometheus.Collector = &driverMetrics{}
+
+func newDriverMetrics() *driverMetrics {
+	return &driverMetrics{
+		negotiatedNfsVersion: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "simple_csi",
+			Subsystem: "nfs",
+			Name:      "negotiated_version",
+			Help:      "NFS version last negotiated with the server.",
+		}, []string{"server"}),
+
+		mountDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "simple_csi",
+			Subsystem: "nfs",
+			Name:      "mount_duration_seconds",
+			Help:      "Duration of nfs mounts and unmounts by server.",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 4, 8),
+		}, []string{"operation", "server"}),
+
+		mountFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "simple_csi",
+			Subsystem: "nfs",
+			Name:      "mount_failures_total",
+			Help:      "Failed nfs mounts and unmounts by server.",
+		}, []string{"operation", "server"}),
+
+		exportCacheLookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "simple_csi",
+			Subsystem: "nfs",
+			Name:      "export_cache_lookups_total",
+			Help:      "Export list lookups of the controller by result: hit, miss or refresh.",
+		}, []string{"result"}),
+	}
+}
+
+func (m *driverMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.negotiatedNfsVersion.Describe(ch)
+	m.mountDuration.Describe(ch)
+	m.mountFailures.Describe(ch)
+	m.exportCacheLookups.Describe(ch)
+}
+
+func (m *driverMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.negotiatedNfsVersion.Collect(ch)
+	m.mountDuration.Collect(ch)
+	m.mountFailures.Collect(ch)
+	m.exportCacheLookups.Collect(ch)
+}
+
+// recordMountOperation observes a mount or unmount of the server which started at start
+func (m *driverMetrics) recordMountOperation(operation, server string, start time.Time, err error) {
+	if m == nil {
+		return
+	}
+	m.mountDuration.WithLabelValues(operation, server).Observe(time.Since(start).Seconds())
+	if err != nil {
+		m.mountFailures.WithLabelValues(operation, server).Inc()
+	}
+}
+
+func (m *driverMetrics) recordNegotiatedVersion(server, version string) {
+	if m == nil {
+		return
+	}
+	if v, err := strconv.ParseFloat(version, 64); err == nil {
+		m.negotiatedNfsVersion.WithLabelValues(server).Set(v)
+	}
+}
+
+// recordExportLookup counts an export list lookup by its result
+func (m *driverMetrics) recordExportLookup(result string) {
+	if m == nil {
+		return
+	}
+	m.exportCacheLookups.WithLabelValues(result).Inc()
+}
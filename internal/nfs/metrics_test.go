@@ -0,0 +1,82 @@
+package nfs
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	mount "k8s.io/mount-utils"
+)
+
+// failingMounter fails every mount with err
+type failingMounter struct {
+	*mount.FakeMounter
+	err error
+}
+
+func (m *failingMounter) Mount(source, target, fstype string, options []string) error {
+	return m.err
+}
+
+func TestMountMetrics(t *testing.T) {
+	const server = "metricsServer"
+	d := NewFakeNfsDriver(fakeNode)
+	d.metrics = newDriverMetrics()
+	observed := func(operation string) uint64 {
+		m := &dto.Metric{}
+		if err := d.metrics.mountDuration.WithLabelValues(operation, server).(prometheus.Histogram).Write(m); err != nil {
+			t.Fatalf("Failed to read the %s durations: %v", operation, err)
+		}
+		return m.GetHistogram().GetSampleCount()
+	}
+	failures := func(operation string) float64 {
+		m := &dto.Metric{}
+		if err := d.metrics.mountFailures.WithLabelValues(operation, server).Write(m); err != nil {
+			t.Fatalf("Failed to read the %s failures: %v", operation, err)
+		}
+		return m.GetCounter().GetValue()
+	}
+
+	mounter := mount.NewFakeMounter([]mount.MountPoint{})
+	ns := newFakeNodeServer(d, mounter)
+	publish := func(targetPath string) error {
+		_, err := ns.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+			VolumeId:         testVolId,
+			TargetPath:       targetPath,
+			VolumeCapability: mountVolumeCapability,
+			VolumeContext: map[string]string{
+				mountPermissionKey: "0",
+				serverKey:          server,
+				basedirKey:         testBasePath,
+			},
+		})
+		return err
+	}
+
+	targetPath := filepath.Join(t.TempDir(), "target")
+	if err := publish(targetPath); err != nil {
+		t.Fatalf("NodePublishVolume() error = %v", err)
+	}
+	if _, err := ns.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{
+		VolumeId:   testVolId,
+		TargetPath: targetPath,
+	}); err != nil {
+		t.Fatalf("NodeUnpublishVolume() error = %v", err)
+	}
+	if observed(mountOperation) != 1 || observed(unmountOperation) != 1 {
+		t.Errorf("observed %d mounts and %d unmounts of %s, want 1 each", observed(mountOperation), observed(unmountOperation), server)
+	}
+
+	failingPath := filepath.Join(t.TempDir(), "failing")
+	ns.mounter = &failingMounter{FakeMounter: mounter, err: errors.New("connection timed out")}
+	if err := publish(failingPath); err == nil {
+		t.Fatalf("NodePublishVolume() of a failing mount succeeded")
+	}
+	if failures(mountOperation) != 1 || observed(mountOperation) != 2 {
+		t.Errorf("%v failed of %d observed mounts of %s, want 1 of 2", failures(mountOperation), observed(mountOperation), server)
+	}
+}
@@ -0,0 +1,143 @@
+package nfs
+
+import (
+	"context"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+	"k8s.io/kubernetes/pkg/volume"
+)
+
+// Check if implements Backend
+var _ Backend = &mountBackend{}
+
+// mountBackend mounts server:basedir on the controller to manage the volume directories
+type mountBackend struct {
+	cs *controllerServer
+}
+
+func (b *mountBackend) Name() string {
+	return backendMount
+}
+
+// withMount runs fn with server:basedir of vol mounted at a path of its own
+func (b *mountBackend) withMount(ctx context.Context, vol *Volume, targetParentPath string, fn func() error) error {
+	parameters := volumeParams(vol)
+	volId := getVolIdFromParams(parameters)
+	if err := b.cs.preMount(ctx, parameters, volId, targetParentPath); err != nil {
+		// Keep the code of the mount failure so the CO knows whether retrying helps
+		return status.Errorf(status.Code(err), "failed to mount nfs server: %v", status.Convert(err).Message())
+	}
+	// Needs to unmount since we are just managing the directories, not publishing them
+	defer func() {
+		if err := b.cs.preUnmount(ctx, volId, targetParentPath); err != nil {
+			klog.FromContext(ctx).Error(err, "Failed to unmount the nfs server", "target_path", targetParentPath)
+		}
+	}()
+	return fn()
+}
+
+func (b *mountBackend) Provision(ctx context.Context, vol *Volume, snapshot string) (int64, error) {
+	if snapshot != "" {
+		return 0, backendUnsupported(b, "restoring snapshots")
+	}
+	targetParentPath := getTargetParentPath(b.cs.driver.workingMountDir, vol.Subdir)
+	return 0, b.withMount(ctx, vol, targetParentPath, func() error {
+		volumeMountPath := getVolumtMountPath(targetParentPath, vol.Subdir)
+		if err := os.MkdirAll(volumeMountPath, vol.Mode); err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+		return nil
+	})
+}
+
+func (b *mountBackend) Clone(ctx context.Context, vol *Volume, source string) (int64, error) {
+	return 0, backendUnsupported(b, "cloning")
+}
+
+// Delete mounts server:basedir again to remove the volume directory from it, the volume
+// itself is no longer attached when DeleteVolume is called
+func (b *mountBackend) Delete(ctx context.Context, vol *Volume) error {
+	targetParentPath := getTargetParentPath(b.cs.driver.workingMountDir, vol.Subdir)
+	return b.withMount(ctx, vol, targetParentPath, func() error {
+		volumeMountPath := getVolumtMountPath(targetParentPath, vol.Subdir)
+		klog.FromContext(ctx).V(4).Info("Removing the volume directory", "path", volumeMountPath)
+		if err := os.RemoveAll(volumeMountPath); err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+		return nil
+	})
+}
+
+// Expand has nothing to do, the directories are not limited
+func (b *mountBackend) Expand(ctx context.Context, vol *Volume) (int64, error) {
+	return vol.CapacityBytes, nil
+}
+
+func (b *mountBackend) CreateSnapshot(ctx context.Context, vol *Volume, name, sourceVolId string) (time.Time, error) {
+	return time.Time{}, backendUnsupported(b, "snapshotting")
+}
+
+func (b *mountBackend) DeleteSnapshot(ctx context.Context, vol *Volume, name string) error {
+	return backendUnsupported(b, "snapshotting")
+}
+
+// withBasedir runs fn with server:basedir of vol mounted at a path of its own, so queries
+// of different exports do not collide
+func (b *mountBackend) withBasedir(ctx context.Context, vol *Volume, fn func(path string) error) error {
+	vol = &Volume{
+		Server:       vol.Server,
+		Basedir:      vol.Basedir,
+		Subdir:       capacitySubdir,
+		MountOptions: vol.MountOptions,
+	}
+	volId := getVolIdFromParams(volumeParams(vol))
+	if !b.cs.idempotency.TryAddProcessing(volId) {
+		return status.Error(codes.Aborted, "The nfs server is being queried")
+	}
+	defer b.cs.idempotency.RemoveProcessing(volId)
+
+	targetParentPath := getTargetParentPath(b.cs.driver.workingMountDir, strings.ReplaceAll(volId, "/", "-"))
+	return b.withMount(ctx, vol, targetParentPath, func() error {
+		return fn(targetParentPath)
+	})
+}
+
+// Capacity mounts server:basedir to get its available bytes
+func (b *mountBackend) Capacity(ctx context.Context, vol *Volume) (int64, error) {
+	var available int64
+	err := b.withBasedir(ctx, vol, func(path string) error {
+		volumeMetrics, err := volume.NewMetricsStatFS(path).GetMetrics()
+		if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+		var ok bool
+		if available, ok = volumeMetrics.Available.AsInt64(); !ok {
+			return status.Errorf(codes.Internal, "failed to transform available size(%v)", volumeMetrics.Available)
+		}
+		return nil
+	})
+	return available, err
+}
+
+// List mounts server:basedir to read the volume directories in it
+func (b *mountBackend) List(ctx context.Context, vol *Volume) ([]string, error) {
+	var subdirs []string
+	err := b.withBasedir(ctx, vol, func(path string) error {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+		for _, e := range entries {
+			if e.IsDir() && !strings.HasPrefix(e.Name(), ".") {
+				subdirs = append(subdirs, e.Name())
+			}
+		}
+		return nil
+	})
+	return subdirs, err
+}
@@ -0,0 +1,107 @@
+package nfs
+
+import (
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	// Messages of mount.nfs and the kernel, checked in order, see nfs(5) and mount(8)
+	mountErrorPatterns = []struct {
+		messages []string
+		code     codes.Code
+	}{
+		{
+			messages: []string{"access denied by server", "permission denied", "operation not permitted"},
+			code:     codes.PermissionDenied,
+		},
+		{
+			messages: []string{"stale file handle", "stale nfs file handle"},
+			code:     codes.Unavailable,
+		},
+		{
+			messages: []string{"no such file or directory", "does not exist"},
+			code:     codes.NotFound,
+		},
+		{
+			messages: []string{"connection timed out", "timed out"},
+			code:     codes.DeadlineExceeded,
+		},
+		{
+			messages: []string{"program not registered", "connection refused", "no route to host",
+				"network is unreachable", "host is down", "failed to resolve server", "name or service not known",
+				"server is down"},
+			code: codes.Unavailable,
+		},
+		{
+			messages: []string{"protocol not supported", "requested nfs version or transport protocol is not supported",
+				"invalid argument", "bad option", "incorrect mount option", "an incorrect mount option was specified"},
+			code: codes.InvalidArgument,
+		},
+	}
+
+	exitStatusRegexp = regexp.MustCompile(`exit status (\d+)`)
+)
+
+const (
+	// Exit statuses of mount(8)
+	mountExitIncorrectUsage = 1
+	mountExitSystemError    = 2
+	mountExitInternalBug    = 4
+	mountExitUserInterrupt  = 8
+	mountExitMtabError      = 16
+	mountExitFailure        = 32
+)
+
+// classifyMountError maps a failed mount to the gRPC code telling the CO whether retrying may help:
+// Unavailable and DeadlineExceeded are worth retrying, PermissionDenied, NotFound and InvalidArgument
+// need a change on the server or in the volume parameters first.
+func classifyMountError(err error) codes.Code {
+	if os.IsPermission(err) {
+		return codes.PermissionDenied
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, pattern := range mountErrorPatterns {
+		for _, m := range pattern.messages {
+			if strings.Contains(msg, m) {
+				return pattern.code
+			}
+		}
+	}
+
+	if match := exitStatusRegexp.FindStringSubmatch(msg); match != nil {
+		exitStatus, _ := strconv.Atoi(match[1])
+		switch exitStatus {
+		case mountExitIncorrectUsage:
+			return codes.InvalidArgument
+		case mountExitUserInterrupt:
+			return codes.Aborted
+		case mountExitMtabError, mountExitFailure:
+			return codes.Unavailable
+		case mountExitSystemError, mountExitInternalBug:
+			return codes.Internal
+		}
+	}
+	return codes.Internal
+}
+
+// mountErrorStatus converts the error of a mount into a gRPC status
+func mountErrorStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	return status.Errorf(classifyMountError(err), "mount failed: %v", err)
+}
+
+// isNfsVersionNotSupported reports whether the mount failed because the server does not speak the version
+func isNfsVersionNotSupported(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "protocol not supported") ||
+		strings.Contains(msg, "requested nfs version or transport protocol is not supported")
+}
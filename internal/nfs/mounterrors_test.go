@@ -0,0 +1,102 @@
+package nfs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newMountError(exitStatus int, output string) error {
+	return fmt.Errorf("mount failed: exit status %d\nMounting command: mount\nMounting arguments: -t nfs %s:/%s /target\nOutput: %s",
+		exitStatus, testServer, testBasePath, output)
+}
+
+func TestClassifyMountError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want codes.Code
+	}{
+		{
+			name: "Permission error",
+			err:  os.ErrPermission,
+			want: codes.PermissionDenied,
+		},
+		{
+			name: "Access denied by server",
+			err:  newMountError(32, "mount.nfs: access denied by server while mounting testServer:/testBasePath"),
+			want: codes.PermissionDenied,
+		},
+		{
+			name: "Export does not exist",
+			err:  newMountError(32, "mount.nfs: mounting testServer:/testBasePath failed, reason given by server: No such file or directory"),
+			want: codes.NotFound,
+		},
+		{
+			name: "Connection timed out",
+			err:  newMountError(32, "mount.nfs: Connection timed out"),
+			want: codes.DeadlineExceeded,
+		},
+		{
+			name: "Program not registered",
+			err:  newMountError(32, "mount.nfs: requested NFS version or transport protocol is not supported\nmount.nfs: portmap query failed: RPC: Program not registered"),
+			want: codes.Unavailable,
+		},
+		{
+			name: "Protocol not supported",
+			err:  newMountError(32, "mount.nfs: Protocol not supported"),
+			want: codes.InvalidArgument,
+		},
+		{
+			name: "Stale file handle",
+			err:  newMountError(32, "mount.nfs: Stale file handle"),
+			want: codes.Unavailable,
+		},
+		{
+			name: "Connection refused",
+			err:  newMountError(32, "mount.nfs: Connection refused"),
+			want: codes.Unavailable,
+		},
+		{
+			name: "Incorrect invocation",
+			err:  newMountError(1, "mount: only root can do that"),
+			want: codes.InvalidArgument,
+		},
+		{
+			name: "Unknown mount failure",
+			err:  newMountError(32, "mount.nfs: something went wrong"),
+			want: codes.Unavailable,
+		},
+		{
+			name: "System error",
+			err:  newMountError(2, "mount: something went wrong"),
+			want: codes.Internal,
+		},
+		{
+			name: "Unknown error",
+			err:  errors.New("something went wrong"),
+			want: codes.Internal,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyMountError(tt.err); got != tt.want {
+				t.Errorf("classifyMountError() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMountErrorStatus(t *testing.T) {
+	if err := mountErrorStatus(nil); err != nil {
+		t.Errorf("mountErrorStatus(nil) = %v, want nil", err)
+	}
+	err := mountErrorStatus(newMountError(32, "mount.nfs: access denied by server"))
+	if code := status.Code(err); code != codes.PermissionDenied {
+		t.Errorf("mountErrorStatus() code = %v, want %v", code, codes.PermissionDenied)
+	}
+}
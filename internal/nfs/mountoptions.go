@@ -0,0 +1,296 @@
+package nfs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// nfsMountOption describes a mount option the driver accepts
+type nfsMountOption struct {
+	// Options in the same group override each other, e.g. hard and soft
+	group string
+	// Whether the option takes the form name=value
+	hasValue bool
+	// Validates the value of the option, optional
+	validate func(value string) error
+}
+
+var (
+	nfsMountOptions = map[string]nfsMountOption{
+		// NFS specific options, see nfs(5)
+		"nfsvers":      {group: "nfsvers", hasValue: true, validate: validateNfsVersion},
+		"vers":         {group: "nfsvers", hasValue: true, validate: validateNfsVersion},
+		"minorversion": {group: "minorversion", hasValue: true, validate: validateUint},
+		"nconnect":     {group: "nconnect", hasValue: true, validate: validateNconnect},
+		"hard":         {group: "hard"},
+		"soft":         {group: "hard"},
+		"softreval":    {group: "softreval"},
+		"nosoftreval":  {group: "softreval"},
+		"timeo":        {group: "timeo", hasValue: true, validate: validatePositiveUint},
+		"retrans":      {group: "retrans", hasValue: true, validate: validateUint},
+		"retry":        {group: "retry", hasValue: true, validate: validateUint},
+		"sec":          {group: "sec", hasValue: true, validate: validateSecurityFlavors},
+		"rsize":        {group: "rsize", hasValue: true, validate: validatePositiveUint},
+		"wsize":        {group: "wsize", hasValue: true, validate: validatePositiveUint},
+		"acregmin":     {group: "acregmin", hasValue: true, validate: validateUint},
+		"acregmax":     {group: "acregmax", hasValue: true, validate: validateUint},
+		"acdirmin":     {group: "acdirmin", hasValue: true, validate: validateUint},
+		"acdirmax":     {group: "acdirmax", hasValue: true, validate: validateUint},
+		"actimeo":      {group: "actimeo", hasValue: true, validate: validateUint},
+		"ac":           {group: "ac"},
+		"noac":         {group: "ac"},
+		"cto":          {group: "cto"},
+		"nocto":        {group: "cto"},
+		"lock":         {group: "lock"},
+		"nolock":       {group: "lock"},
+		"local_lock":   {group: "local_lock", hasValue: true, validate: validateOneOf("all", "flock", "posix", "none")},
+		"lookupcache":  {group: "lookupcache", hasValue: true, validate: validateOneOf("all", "none", "pos", "positive")},
+		"tcp":          {group: "proto"},
+		"udp":          {group: "proto"},
+		"rdma":         {group: "proto"},
+		"proto":        {group: "proto", hasValue: true, validate: validateOneOf("tcp", "tcp6", "udp", "udp6", "rdma", "rdma6")},
+		"port":         {group: "port", hasValue: true, validate: validateUint},
+		"mountport":    {group: "mountport", hasValue: true, validate: validateUint},
+		"mountproto":   {group: "mountproto", hasValue: true, validate: validateOneOf("tcp", "tcp6", "udp", "udp6")},
+		"mountvers":    {group: "mountvers", hasValue: true, validate: validateOneOf("1", "2", "3")},
+		"mounthost":    {group: "mounthost", hasValue: true},
+		"namlen":       {group: "namlen", hasValue: true, validate: validatePositiveUint},
+		"clientaddr":   {group: "clientaddr", hasValue: true},
+		"sharecache":   {group: "sharecache"},
+		"nosharecache": {group: "sharecache"},
+		"resvport":     {group: "resvport"},
+		"noresvport":   {group: "resvport"},
+		"fsc":          {group: "fsc"},
+		"nofsc":        {group: "fsc"},
+		"acl":          {group: "acl"},
+		"noacl":        {group: "acl"},
+		"rdirplus":     {group: "rdirplus"},
+		"nordirplus":   {group: "rdirplus"},
+		"intr":         {group: "intr"},
+		"nointr":       {group: "intr"},
+		"bg":           {group: "bg"},
+		"fg":           {group: "bg"},
+
+		// Generic options, see mount(8)
+		"ro":          {group: "ro"},
+		"rw":          {group: "ro"},
+		"sync":        {group: "sync"},
+		"async":       {group: "sync"},
+		"atime":       {group: "atime"},
+		"noatime":     {group: "atime"},
+		"relatime":    {group: "atime"},
+		"norelatime":  {group: "atime"},
+		"strictatime": {group: "atime"},
+		"diratime":    {group: "diratime"},
+		"nodiratime":  {group: "diratime"},
+		"suid":        {group: "suid"},
+		"nosuid":      {group: "suid"},
+		"dev":         {group: "dev"},
+		"nodev":       {group: "dev"},
+		"exec":        {group: "exec"},
+		"noexec":      {group: "exec"},
+		"_netdev":     {group: "_netdev"},
+		"nofail":      {group: "nofail"},
+		"defaults":    {group: "defaults"},
+		"context":     {group: "context", hasValue: true},
+		"fscontext":   {group: "fscontext", hasValue: true},
+		"defcontext":  {group: "defcontext", hasValue: true},
+		"rootcontext": {group: "rootcontext", hasValue: true},
+	}
+)
+
+// parsedMountOption is a single validated mount option
+type parsedMountOption struct {
+	name  string
+	value string
+	group string
+}
+
+func (o parsedMountOption) String() string {
+	if o.value == "" {
+		return o.name
+	}
+	return o.name + "=" + o.value
+}
+
+// splitMountOptions splits a comma separated mount option string like the one of the mountOptions parameter
+func splitMountOptions(mountOptions string) []string {
+	var opts []string
+	for _, opt := range strings.Split(mountOptions, ",") {
+		if opt = strings.TrimSpace(opt); opt != "" {
+			opts = append(opts, opt)
+		}
+	}
+	return opts
+}
+
+// canonicalMountOption replaces the alias of an option with its canonical name, e.g. vers=3 becomes nfsvers=3
+func canonicalMountOption(opt string) string {
+	if opt == "vers" || strings.HasPrefix(opt, "vers=") {
+		return "nfs" + opt
+	}
+	return opt
+}
+
+// hasMountOptionGroup reports whether an option of the group, e.g. nfsvers, is in the list
+func hasMountOptionGroup(mountOpts []string, group string) bool {
+	for _, opt := range mountOpts {
+		name, _, _ := strings.Cut(opt, "=")
+		if known, ok := nfsMountOptions[name]; ok && known.group == group {
+			return true
+		}
+	}
+	return false
+}
+
+// parseMountOptions validates a single list of mount options against the known nfs options,
+// a list setting the same option group twice with different values is rejected.
+func parseMountOptions(mountOpts []string) ([]parsedMountOption, error) {
+	var parsed []parsedMountOption
+	groups := make(map[string]parsedMountOption)
+	for _, raw := range mountOpts {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+
+		name, value, hasValue := strings.Cut(raw, "=")
+		known, ok := nfsMountOptions[name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported mount option %q", raw)
+		}
+		if known.hasValue != hasValue || (hasValue && value == "") {
+			if known.hasValue {
+				return nil, fmt.Errorf("mount option %q requires a value", name)
+			}
+			return nil, fmt.Errorf("mount option %q does not take a value", name)
+		}
+		if known.validate != nil {
+			if err := known.validate(value); err != nil {
+				return nil, fmt.Errorf("invalid mount option %q: %v", raw, err)
+			}
+		}
+
+		opt := parsedMountOption{name: canonicalMountOption(name), value: value, group: known.group}
+		if prev, ok := groups[opt.group]; ok {
+			if prev.String() != opt.String() {
+				return nil, fmt.Errorf("conflicting mount options %q and %q", prev, opt)
+			}
+			continue
+		}
+		groups[opt.group] = opt
+		parsed = append(parsed, opt)
+	}
+	return parsed, nil
+}
+
+// mergeMountOptions merges the given mount option lists, an option in a later list overrides
+// the option of the same group in an earlier one, so callers pass the lists from the least
+// to the most specific, e.g. StorageClass options before the options of the PV.
+func mergeMountOptions(optionLists ...[]string) ([]string, error) {
+	var merged []parsedMountOption
+	index := make(map[string]int)
+	for _, opts := range optionLists {
+		parsed, err := parseMountOptions(opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, opt := range parsed {
+			if i, ok := index[opt.group]; ok {
+				merged[i] = opt
+				continue
+			}
+			index[opt.group] = len(merged)
+			merged = append(merged, opt)
+		}
+	}
+
+	if err := validateMountOptionCombination(merged); err != nil {
+		return nil, err
+	}
+
+	result := make([]string, 0, len(merged))
+	for _, opt := range merged {
+		result = append(result, opt.String())
+	}
+	return result, nil
+}
+
+// validateMountOptionCombination rejects options which are valid on their own but not together
+func validateMountOptionCombination(opts []parsedMountOption) error {
+	groups := make(map[string]parsedMountOption, len(opts))
+	for _, opt := range opts {
+		groups[opt.group] = opt
+	}
+
+	version, hasVersion := groups["nfsvers"]
+	if hasVersion && version.value == "2" {
+		if nconnect, ok := groups["nconnect"]; ok {
+			return fmt.Errorf("conflicting mount options %q and %q", version, nconnect)
+		}
+	}
+	if hasVersion && strings.HasPrefix(version.value, "4") {
+		if proto, ok := groups["proto"]; ok && (proto.name == "udp" || strings.HasPrefix(proto.value, "udp")) {
+			return fmt.Errorf("conflicting mount options %q and %q, NFSv4 requires a connection oriented transport", version, proto)
+		}
+		if mountvers, ok := groups["mountvers"]; ok {
+			return fmt.Errorf("conflicting mount options %q and %q, NFSv4 does not use the mount protocol", version, mountvers)
+		}
+	}
+	if minor, ok := groups["minorversion"]; ok && (!hasVersion || version.value != "4") {
+		return fmt.Errorf("mount option %q is only valid with nfsvers=4", minor)
+	}
+	return nil
+}
+
+func validateNfsVersion(value string) error {
+	return validateOneOf("2", "3", "4", "4.0", "4.1", "4.2")(value)
+}
+
+func validateNconnect(value string) error {
+	n, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return err
+	}
+	if n < 1 || n > 16 {
+		return fmt.Errorf("must be between 1 and 16")
+	}
+	return nil
+}
+
+func validateSecurityFlavors(value string) error {
+	for _, flavor := range strings.Split(value, ":") {
+		if err := validateOneOf("sys", "none", "krb5", "krb5i", "krb5p", "lkey", "lkeyi", "lkeyp", "spkm", "spkmi", "spkmp")(flavor); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateUint(value string) error {
+	_, err := strconv.ParseUint(value, 10, 32)
+	return err
+}
+
+func validatePositiveUint(value string) error {
+	n, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("must be positive")
+	}
+	return nil
+}
+
+func validateOneOf(values ...string) func(string) error {
+	return func(value string) error {
+		for _, v := range values {
+			if value == v {
+				return nil
+			}
+		}
+		return fmt.Errorf("must be one of %v", values)
+	}
+}
@@ -0,0 +1,138 @@
+package nfs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitMountOptions(t *testing.T) {
+	tests := []struct {
+		name         string
+		mountOptions string
+		want         []string
+	}{
+		{
+			name:         "Empty mount options",
+			mountOptions: "",
+			want:         nil,
+		},
+		{
+			name:         "Mount options with spaces",
+			mountOptions: " nfsvers=4.1, hard ,,",
+			want:         []string{"nfsvers=4.1", "hard"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitMountOptions(tt.mountOptions); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitMountOptions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeMountOptions(t *testing.T) {
+	tests := []struct {
+		name        string
+		optionLists [][]string
+		want        []string
+		wantErr     bool
+	}{
+		{
+			name:        "No mount options",
+			optionLists: [][]string{nil, nil},
+			want:        []string{},
+		},
+		{
+			name: "Duplicated options are merged",
+			optionLists: [][]string{
+				{"nfsvers=4.1", "hard", "hard"},
+				{"hard"},
+			},
+			want: []string{"nfsvers=4.1", "hard"},
+		},
+		{
+			name: "Later list overrides earlier one",
+			optionLists: [][]string{
+				{"nfsvers=4.1", "hard", "timeo=600"},
+				{"vers=3", "soft"},
+				{"ro"},
+			},
+			want: []string{"nfsvers=3", "soft", "timeo=600", "ro"},
+		},
+		{
+			name: "Readonly overrides rw",
+			optionLists: [][]string{
+				{"rw", "nconnect=4"},
+				{"ro"},
+			},
+			want: []string{"ro", "nconnect=4"},
+		},
+		{
+			name:        "Unknown option",
+			optionLists: [][]string{{"foo=bar"}},
+			wantErr:     true,
+		},
+		{
+			name:        "Missing value",
+			optionLists: [][]string{{"timeo"}},
+			wantErr:     true,
+		},
+		{
+			name:        "Unexpected value",
+			optionLists: [][]string{{"hard=1"}},
+			wantErr:     true,
+		},
+		{
+			name:        "Invalid nfs version",
+			optionLists: [][]string{{"nfsvers=5"}},
+			wantErr:     true,
+		},
+		{
+			name:        "Invalid nconnect",
+			optionLists: [][]string{{"nconnect=32"}},
+			wantErr:     true,
+		},
+		{
+			name:        "Invalid security flavor",
+			optionLists: [][]string{{"sec=krb5:foo"}},
+			wantErr:     true,
+		},
+		{
+			name:        "Conflicting options in one list",
+			optionLists: [][]string{{"hard", "soft"}},
+			wantErr:     true,
+		},
+		{
+			name:        "Conflicting versions in one list",
+			optionLists: [][]string{{"vers=3", "nfsvers=4.1"}},
+			wantErr:     true,
+		},
+		{
+			name:        "NFSv4 over udp",
+			optionLists: [][]string{{"nfsvers=4.1"}, {"udp"}},
+			wantErr:     true,
+		},
+		{
+			name:        "nconnect with NFSv2",
+			optionLists: [][]string{{"nfsvers=2", "nconnect=2"}},
+			wantErr:     true,
+		},
+		{
+			name:        "minorversion without NFSv4",
+			optionLists: [][]string{{"minorversion=1"}},
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mergeMountOptions(tt.optionLists...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("mergeMountOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mergeMountOptions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
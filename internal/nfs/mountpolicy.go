@@ -0,0 +1,95 @@
+package nfs
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+	"k8s.io/klog/v2"
+)
+
+// MountOptionPolicy is the node level policy every mount performed by the driver goes through
+type MountOptionPolicy struct {
+	// Option names, or name=value pairs, which may be used. Empty means every supported option.
+	Allowed []string `yaml:"allowed"`
+	// Option names, or name=value pairs, which are rejected
+	Denied []string `yaml:"denied"`
+	// Options added to every mount, overriding the requested options of the same kind
+	Forced []string `yaml:"forced"`
+}
+
+// LoadMountOptionPolicy reads and validates a mount option policy file
+func LoadMountOptionPolicy(policyFile string) (*MountOptionPolicy, error) {
+	content, err := os.ReadFile(policyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := &MountOptionPolicy{}
+	if err := yaml.UnmarshalStrict(content, policy); err != nil {
+		return nil, fmt.Errorf("failed to parse mount option policy %s: %v", policyFile, err)
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid mount option policy %s: %v", policyFile, err)
+	}
+	return policy, nil
+}
+
+// Validate checks that the policy only refers to supported options and does not contradict itself
+func (p *MountOptionPolicy) Validate() error {
+	for _, list := range [][]string{p.Allowed, p.Denied} {
+		for _, opt := range list {
+			name, _, _ := strings.Cut(opt, "=")
+			if _, ok := nfsMountOptions[name]; !ok {
+				return fmt.Errorf("unsupported mount option %q", opt)
+			}
+		}
+	}
+
+	if _, err := parseMountOptions(p.Forced); err != nil {
+		return fmt.Errorf("forced options: %v", err)
+	}
+	if rejected := p.rejected(p.Forced); len(rejected) > 0 {
+		return fmt.Errorf("forced options %v are not allowed by the policy itself", rejected)
+	}
+	return nil
+}
+
+// Apply rejects the options the policy does not allow and adds the forced ones
+func (p *MountOptionPolicy) Apply(mountOpts []string) ([]string, error) {
+	if p == nil {
+		return mountOpts, nil
+	}
+
+	if rejected := p.rejected(mountOpts); len(rejected) > 0 {
+		klog.Warningf("Mount options %v are rejected by the mount option policy", rejected)
+		return nil, fmt.Errorf("mount options %v are not allowed on this node", rejected)
+	}
+	if len(p.Forced) == 0 {
+		return mountOpts, nil
+	}
+	return mergeMountOptions(mountOpts, p.Forced)
+}
+
+func (p *MountOptionPolicy) rejected(mountOpts []string) []string {
+	var rejected []string
+	for _, opt := range mountOpts {
+		if matchMountOption(p.Denied, opt) || (len(p.Allowed) > 0 && !matchMountOption(p.Allowed, opt)) {
+			rejected = append(rejected, opt)
+		}
+	}
+	return rejected
+}
+
+// matchMountOption reports whether the option is in the list either by its name or as the exact name=value pair
+func matchMountOption(list []string, opt string) bool {
+	opt = canonicalMountOption(opt)
+	name, _, _ := strings.Cut(opt, "=")
+	for _, o := range list {
+		if o = canonicalMountOption(o); o == opt || o == name {
+			return true
+		}
+	}
+	return false
+}
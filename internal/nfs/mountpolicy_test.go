@@ -0,0 +1,154 @@
+package nfs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	mount "k8s.io/mount-utils"
+)
+
+func TestLoadMountOptionPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    *MountOptionPolicy
+		wantErr bool
+	}{
+		{
+			name:    "Valid policy",
+			content: "allowed: [nfsvers, hard, soft, nosuid, nodev]\ndenied: [nfsvers=2]\nforced: [nosuid, nodev]\n",
+			want: &MountOptionPolicy{
+				Allowed: []string{"nfsvers", "hard", "soft", "nosuid", "nodev"},
+				Denied:  []string{"nfsvers=2"},
+				Forced:  []string{"nosuid", "nodev"},
+			},
+		},
+		{
+			name:    "Unknown field",
+			content: "allow: [hard]\n",
+			wantErr: true,
+		},
+		{
+			name:    "Unsupported option",
+			content: "denied: [foo]\n",
+			wantErr: true,
+		},
+		{
+			name:    "Conflicting forced options",
+			content: "forced: [hard, soft]\n",
+			wantErr: true,
+		},
+		{
+			name:    "Forced option is denied",
+			content: "denied: [soft]\nforced: [soft]\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policyFile := filepath.Join(t.TempDir(), "policy.yaml")
+			if err := os.WriteFile(policyFile, []byte(tt.content), 0644); err != nil {
+				t.Fatalf("Failed to write policy file: %v", err)
+			}
+			got, err := LoadMountOptionPolicy(policyFile)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadMountOptionPolicy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("LoadMountOptionPolicy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMountOptionPolicyApply(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    *MountOptionPolicy
+		mountOpts []string
+		want      []string
+		wantErr   bool
+	}{
+		{
+			name:      "No policy",
+			policy:    nil,
+			mountOpts: []string{"hard"},
+			want:      []string{"hard"},
+		},
+		{
+			name:      "Denied by name",
+			policy:    &MountOptionPolicy{Denied: []string{"nolock"}},
+			mountOpts: []string{"hard", "nolock"},
+			wantErr:   true,
+		},
+		{
+			name:      "Denied by value",
+			policy:    &MountOptionPolicy{Denied: []string{"vers=2"}},
+			mountOpts: []string{"nfsvers=2"},
+			wantErr:   true,
+		},
+		{
+			name:      "Other value is not denied",
+			policy:    &MountOptionPolicy{Denied: []string{"nfsvers=2"}},
+			mountOpts: []string{"nfsvers=3"},
+			want:      []string{"nfsvers=3"},
+		},
+		{
+			name:      "Not allowed",
+			policy:    &MountOptionPolicy{Allowed: []string{"nfsvers", "hard"}},
+			mountOpts: []string{"nfsvers=4.1", "soft"},
+			wantErr:   true,
+		},
+		{
+			name: "Forced options override requested ones",
+			policy: &MountOptionPolicy{
+				Allowed: []string{"nfsvers", "hard", "soft", "nosuid"},
+				Forced:  []string{"hard", "nosuid"},
+			},
+			mountOpts: []string{"nfsvers=4.1", "soft"},
+			want:      []string{"nfsvers=4.1", "hard", "nosuid"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.policy.Apply(tt.mountOpts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MountOptionPolicy.Apply() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MountOptionPolicy.Apply() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNodePublishVolumeMountOptionPolicy(t *testing.T) {
+	driver := NewFakeNfsDriver(fakeNode)
+	driver.mountPolicy.Store(&MountOptionPolicy{Denied: []string{"nolock"}})
+	ns := newFakeNodeServer(driver, mount.NewFakeMounter([]mount.MountPoint{}))
+
+	_, err := ns.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+		VolumeId:   testVolId,
+		TargetPath: filepath.Join(t.TempDir(), "target"),
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{},
+			},
+		},
+		VolumeContext: map[string]string{
+			mountPermissionKey: "0",
+			serverKey:          testServer,
+			basedirKey:         testBasePath,
+			mountOptionsKey:    "hard,nolock",
+		},
+	})
+	if code := status.Code(err); code != codes.InvalidArgument {
+		t.Errorf("nodeServer.NodePublishVolume() code = %v, want %v", code, codes.InvalidArgument)
+	}
+}
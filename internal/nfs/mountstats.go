@@ -0,0 +1,331 @@
+package nfs
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog/v2"
+)
+
+// Where the kernel reports the statistics of the mounts of the process
+const mountStatsPath = "/proc/self/mountstats"
+
+// nfsMountStats are the statistics of an nfs mount in mountstats
+type nfsMountStats struct {
+	device       string
+	mountPoint   string
+	fstype       string
+	statsVersion string
+
+	// Bytes read from and written to the server
+	serverReadBytes    uint64
+	serverWrittenBytes uint64
+
+	operations []nfsOperationStats
+}
+
+// nfsOperationStats are the statistics of an nfs operation of a mount, the times are
+// cumulative over all requests
+type nfsOperationStats struct {
+	name          string
+	requests      uint64
+	transmissions uint64
+	majorTimeouts uint64
+	bytesSent     uint64
+	bytesReceived uint64
+	queueTime     time.Duration
+	rtt           time.Duration
+	executionTime time.Duration
+	// Only reported by kernels since 5.3
+	errors uint64
+}
+
+// retransmissions returns how often requests of the operation were sent again
+func (o nfsOperationStats) retransmissions() uint64 {
+	if o.transmissions < o.requests {
+		return 0
+	}
+	return o.transmissions - o.requests
+}
+
+// averageRTT returns the mean round trip time of the requests of the operation
+func (o nfsOperationStats) averageRTT() time.Duration {
+	if o.requests == 0 {
+		return 0
+	}
+	return o.rtt / time.Duration(o.requests)
+}
+
+// averageExecutionTime returns the mean time from queueing to completing a request
+func (o nfsOperationStats) averageExecutionTime() time.Duration {
+	if o.requests == 0 {
+		return 0
+	}
+	return o.executionTime / time.Duration(o.requests)
+}
+
+// isNfs returns true for the filesystem types of the kernel nfs client
+func isNfs(fstype string) bool {
+	return fstype == "nfs" || fstype == "nfs4"
+}
+
+// parseMountStats returns the nfs mounts in the mountstats format, other mounts are skipped.
+// The lines of a mount the parser does not know, like the transport or event counters which
+// changed between kernel versions, are ignored.
+func parseMountStats(r io.Reader) ([]*nfsMountStats, error) {
+	var mounts []*nfsMountStats
+	var current *nfsMountStats
+	perOp := false
+
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := scanner.Text()
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if fields[0] == "device" || (fields[0] == "no" && len(fields) > 1 && fields[1] == "device") {
+			mount, err := parseMountStatsDevice(fields)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", lineNumber, err)
+			}
+			current, perOp = nil, false
+			if isNfs(mount.fstype) {
+				current = mount
+				mounts = append(mounts, mount)
+			}
+			continue
+		}
+		if current == nil {
+			continue
+		}
+
+		var err error
+		switch {
+		case fields[0] == "bytes:":
+			err = parseMountStatsBytes(current, fields[1:])
+		case strings.TrimSpace(line) == "per-op statistics":
+			perOp = true
+		case perOp && strings.HasSuffix(fields[0], ":"):
+			var op nfsOperationStats
+			op, err = parseMountStatsOperation(fields)
+			current.operations = append(current.operations, op)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNumber, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return mounts, nil
+}
+
+// parseMountStatsDevice parses "device <device> mounted on <mount point> with fstype <type> [statvers=<version>]",
+// the device is missing if the line starts with "no device"
+func parseMountStatsDevice(fields []string) (*nfsMountStats, error) {
+	if len(fields) < 8 || fields[2] != "mounted" || fields[3] != "on" || fields[5] != "with" || fields[6] != "fstype" {
+		return nil, fmt.Errorf("invalid device line %q", strings.Join(fields, " "))
+	}
+	mount := &nfsMountStats{
+		mountPoint: unescapeMountStats(fields[4]),
+		fstype:     fields[7],
+	}
+	if fields[0] == "device" {
+		mount.device = unescapeMountStats(fields[1])
+	}
+	if len(fields) > 8 {
+		mount.statsVersion = strings.TrimPrefix(fields[8], "statvers=")
+	}
+	return mount, nil
+}
+
+// parseMountStatsBytes parses the byte counters "normalread normalwrite directread directwrite serverread serverwrite readpages writepages"
+func parseMountStatsBytes(mount *nfsMountStats, fields []string) error {
+	if len(fields) < 6 {
+		return fmt.Errorf("expected at least 6 byte counters, got %d", len(fields))
+	}
+	values, err := parseMountStatsCounters(fields[:6])
+	if err != nil {
+		return err
+	}
+	mount.serverReadBytes, mount.serverWrittenBytes = values[4], values[5]
+	return nil
+}
+
+// parseMountStatsOperation parses "<OPERATION>: requests transmissions major_timeouts bytes_sent bytes_received
+// queue_ms rtt_ms execute_ms [errors]"
+func parseMountStatsOperation(fields []string) (nfsOperationStats, error) {
+	name := strings.TrimSuffix(fields[0], ":")
+	counters := fields[1:]
+	if len(counters) < 8 {
+		return nfsOperationStats{}, fmt.Errorf("expected at least 8 counters of operation %s, got %d", name, len(counters))
+	}
+	if len(counters) > 9 {
+		counters = counters[:9]
+	}
+	values, err := parseMountStatsCounters(counters)
+	if err != nil {
+		return nfsOperationStats{}, fmt.Errorf("operation %s: %v", name, err)
+	}
+	op := nfsOperationStats{
+		name:          name,
+		requests:      values[0],
+		transmissions: values[1],
+		majorTimeouts: values[2],
+		bytesSent:     values[3],
+		bytesReceived: values[4],
+		queueTime:     time.Duration(values[5]) * time.Millisecond,
+		rtt:           time.Duration(values[6]) * time.Millisecond,
+		executionTime: time.Duration(values[7]) * time.Millisecond,
+	}
+	if len(values) > 8 {
+		op.errors = values[8]
+	}
+	return op, nil
+}
+
+func parseMountStatsCounters(fields []string) ([]uint64, error) {
+	values := make([]uint64, len(fields))
+	for i, field := range fields {
+		v, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid counter %q", field)
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// unescapeMountStats decodes the octal escapes the kernel writes for spaces, tabs, newlines
+// and backslashes in device names and mount points
+func unescapeMountStats(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+var (
+	volumeRPCRequestsDesc = prometheus.NewDesc("simple_csi_nfs_volume_rpc_requests_total",
+		"RPC requests of the nfs client by volume and operation.", []string{"volume_id", "operation"}, nil)
+	volumeRPCRetransmissionsDesc = prometheus.NewDesc("simple_csi_nfs_volume_rpc_retransmissions_total",
+		"RPC requests the nfs client sent again by volume and operation.", []string{"volume_id", "operation"}, nil)
+	volumeRPCAverageRTTDesc = prometheus.NewDesc("simple_csi_nfs_volume_rpc_average_rtt_seconds",
+		"Mean round trip time of the RPC requests by volume and operation.", []string{"volume_id", "operation"}, nil)
+	volumeRPCAverageExecutionDesc = prometheus.NewDesc("simple_csi_nfs_volume_rpc_average_execution_seconds",
+		"Mean time from queueing to completing the RPC requests by volume and operation.", []string{"volume_id", "operation"}, nil)
+	volumeReadBytesDesc = prometheus.NewDesc("simple_csi_nfs_volume_read_bytes_total",
+		"Bytes the nfs client read from the server by volume.", []string{"volume_id"}, nil)
+	volumeWrittenBytesDesc = prometheus.NewDesc("simple_csi_nfs_volume_written_bytes_total",
+		"Bytes the nfs client wrote to the server by volume.", []string{"volume_id"}, nil)
+)
+
+// mountStatsCollector exports the mountstats of the volumes published by the node server
+type mountStatsCollector struct {
+	path string
+	// Returns the volume ID of a mount point, empty for mounts which are not volumes
+	volumeOf func(mountPoint string) string
+}
+
+// Check if implements prometheus.Collector
+var _ prometheus.Collector = &mountStatsCollector{}
+
+func newMountStatsCollector(ns *nodeServer) *mountStatsCollector {
+	return &mountStatsCollector{path: mountStatsPath, volumeOf: ns.volumeOfTarget}
+}
+
+func (c *mountStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- volumeRPCRequestsDesc
+	ch <- volumeRPCRetransmissionsDesc
+	ch <- volumeRPCAverageRTTDesc
+	ch <- volumeRPCAverageExecutionDesc
+	ch <- volumeReadBytesDesc
+	ch <- volumeWrittenBytesDesc
+}
+
+// Collect reports every volume once, the mounts of a volume at several target paths share
+// the nfs superblock and so the statistics. Operations without requests are left out.
+func (c *mountStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	file, err := os.Open(c.path)
+	if err != nil {
+		klog.V(4).InfoS("Failed to read mountstats", "path", c.path, "err", err)
+		return
+	}
+	defer file.Close()
+	mounts, err := parseMountStats(file)
+	if err != nil {
+		klog.Errorf("Failed to parse %s: %v", c.path, err)
+		return
+	}
+
+	seen := make(map[string]bool)
+	for _, mount := range mounts {
+		volumeID := c.volumeOf(mount.mountPoint)
+		if volumeID == "" || seen[volumeID] {
+			continue
+		}
+		seen[volumeID] = true
+
+		ch <- prometheus.MustNewConstMetric(volumeReadBytesDesc, prometheus.CounterValue, float64(mount.serverReadBytes), volumeID)
+		ch <- prometheus.MustNewConstMetric(volumeWrittenBytesDesc, prometheus.CounterValue, float64(mount.serverWrittenBytes), volumeID)
+		for _, op := range mount.operations {
+			if op.requests == 0 {
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(volumeRPCRequestsDesc, prometheus.CounterValue, float64(op.requests), volumeID, op.name)
+			ch <- prometheus.MustNewConstMetric(volumeRPCRetransmissionsDesc, prometheus.CounterValue, float64(op.retransmissions()), volumeID, op.name)
+			ch <- prometheus.MustNewConstMetric(volumeRPCAverageRTTDesc, prometheus.GaugeValue, op.averageRTT().Seconds(), volumeID, op.name)
+			ch <- prometheus.MustNewConstMetric(volumeRPCAverageExecutionDesc, prometheus.GaugeValue, op.averageExecutionTime().Seconds(), volumeID, op.name)
+		}
+	}
+}
+
+// kubeletVolumeData is the part of the vol_data.json kubelet keeps next to the target path
+// of a csi volume
+type kubeletVolumeData struct {
+	DriverName   string `json:"driverName"`
+	VolumeHandle string `json:"volumeHandle"`
+}
+
+// volumeOfTarget returns the ID of the volume of this driver published at the target path,
+// empty if there is none. Target paths published before a restart of the driver are looked
+// up in the vol_data.json of kubelet.
+func (ns *nodeServer) volumeOfTarget(targetPath string) string {
+	ns.publishedLock.Lock()
+	pv, ok := ns.published[targetPath]
+	ns.publishedLock.Unlock()
+	if ok {
+		return pv.volumeID
+	}
+
+	content, err := os.ReadFile(filepath.Join(filepath.Dir(targetPath), "vol_data.json"))
+	if err != nil {
+		return ""
+	}
+	data := kubeletVolumeData{}
+	if err := json.Unmarshal(content, &data); err != nil || data.DriverName != ns.driver.name {
+		return ""
+	}
+	return data.VolumeHandle
+}
@@ -0,0 +1,227 @@
+package nfs
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestParseMountStats(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		fixture string
+		want    []*nfsMountStats
+	}{
+		{
+			fixture: "kernel-2.6.32-nfs3",
+			want: []*nfsMountStats{{
+				device:             "10.0.0.1:/srv/nfs/pvc-1",
+				mountPoint:         "/var/lib/kubelet/pods/abc/volumes/kubernetes.io~csi/pvc-1/mount",
+				fstype:             "nfs",
+				statsVersion:       "1.0",
+				serverReadBytes:    1048576,
+				serverWrittenBytes: 2097152,
+				operations: []nfsOperationStats{
+					{name: "NULL"},
+					{name: "GETATTR", requests: 20, transmissions: 20, bytesSent: 2240, bytesReceived: 2240, queueTime: 4 * ms, rtt: 40 * ms, executionTime: 48 * ms},
+					{name: "LOOKUP", requests: 10, transmissions: 12, majorTimeouts: 1, bytesSent: 1360, bytesReceived: 1200, queueTime: 2 * ms, rtt: 30 * ms, executionTime: 35 * ms},
+					{name: "READ", requests: 8, transmissions: 8, bytesSent: 1024, bytesReceived: 1049600, rtt: 80 * ms, executionTime: 84 * ms},
+					{name: "WRITE", requests: 16, transmissions: 18, bytesSent: 2099200, bytesReceived: 2176, queueTime: 10 * ms, rtt: 320 * ms, executionTime: 340 * ms},
+				},
+			}},
+		},
+		{
+			fixture: "kernel-4.18-nfs4",
+			want: []*nfsMountStats{{
+				device:             "10.0.0.2:/export/vol",
+				mountPoint:         "/mnt/with space",
+				fstype:             "nfs4",
+				statsVersion:       "1.1",
+				serverReadBytes:    4096,
+				serverWrittenBytes: 8192,
+				operations: []nfsOperationStats{
+					{name: "NULL", requests: 1, transmissions: 1, bytesSent: 44, bytesReceived: 24},
+					{name: "READ", requests: 4, transmissions: 5, bytesSent: 800, bytesReceived: 4500, queueTime: ms, rtt: 20 * ms, executionTime: 24 * ms},
+					{name: "WRITE", requests: 2, transmissions: 2, bytesSent: 8400, bytesReceived: 300, rtt: 10 * ms, executionTime: 12 * ms},
+					{name: "COMPOUND"},
+				},
+			}},
+		},
+		{
+			fixture: "kernel-5.15-nfs4",
+			want: []*nfsMountStats{
+				{
+					device:             "10.0.0.3:/export",
+					mountPoint:         "/var/lib/kubelet/pods/p1/volumes/kubernetes.io~csi/pvc-3/mount",
+					fstype:             "nfs4",
+					statsVersion:       "1.1",
+					serverReadBytes:    40960,
+					serverWrittenBytes: 20480,
+					operations: []nfsOperationStats{
+						{name: "NULL", requests: 1, transmissions: 1, bytesSent: 44, bytesReceived: 24},
+						{name: "READ", requests: 10, transmissions: 10, bytesSent: 1600, bytesReceived: 41000, queueTime: 2 * ms, rtt: 50 * ms, executionTime: 60 * ms, errors: 1},
+						{name: "WRITE", requests: 5, transmissions: 7, bytesSent: 20900, bytesReceived: 800, queueTime: 3 * ms, rtt: 25 * ms, executionTime: 30 * ms},
+						{name: "LOOKUP", requests: 3, transmissions: 3, bytesSent: 600, bytesReceived: 900, rtt: 6 * ms, executionTime: 6 * ms, errors: 3},
+					},
+				},
+				{
+					device:       "10.0.0.4:/other",
+					mountPoint:   "/mnt/other",
+					fstype:       "nfs",
+					statsVersion: "1.1",
+					operations: []nfsOperationStats{
+						{name: "GETATTR", requests: 7, transmissions: 7, bytesSent: 784, bytesReceived: 784, rtt: 7 * ms, executionTime: 8 * ms},
+					},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			file, err := os.Open(filepath.Join("testdata", "mountstats", tt.fixture))
+			if err != nil {
+				t.Fatalf("Failed to open fixture: %v", err)
+			}
+			defer file.Close()
+
+			got, err := parseMountStats(file)
+			if err != nil {
+				t.Fatalf("parseMountStats() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseMountStats() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMountStatsErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{
+			name:    "truncated device line",
+			content: "device 10.0.0.1:/export mounted on /mnt\n",
+		},
+		{
+			name:    "too few operation counters",
+			content: "device 10.0.0.1:/export mounted on /mnt with fstype nfs statvers=1.1\n\tper-op statistics\n\t        READ: 1 1 0 10\n",
+		},
+		{
+			name:    "invalid operation counter",
+			content: "device 10.0.0.1:/export mounted on /mnt with fstype nfs statvers=1.1\n\tper-op statistics\n\t        READ: 1 1 0 10 10 0 x 0\n",
+		},
+		{
+			name:    "invalid byte counter",
+			content: "device 10.0.0.1:/export mounted on /mnt with fstype nfs statvers=1.1\n\tbytes:\t1 2 3 4 -5 6 7 8\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseMountStats(strings.NewReader(tt.content)); err == nil {
+				t.Errorf("parseMountStats() succeeded")
+			}
+		})
+	}
+}
+
+func TestMountStatsCollector(t *testing.T) {
+	volumes := map[string]string{
+		"/var/lib/kubelet/pods/p1/volumes/kubernetes.io~csi/pvc-3/mount": "10.0.0.3#export#pvc-3",
+	}
+	collector := &mountStatsCollector{
+		path: filepath.Join("testdata", "mountstats", "kernel-5.15-nfs4"),
+		volumeOf: func(mountPoint string) string {
+			return volumes[mountPoint]
+		},
+	}
+	registry := prometheus.NewPedanticRegistry()
+	if err := registry.Register(collector); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather() error = %v", err)
+	}
+
+	got := make(map[string]float64)
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			key := family.GetName()
+			for _, label := range m.GetLabel() {
+				key += "," + label.GetValue()
+			}
+			if m.GetCounter() != nil {
+				got[key] = m.GetCounter().GetValue()
+			} else {
+				got[key] = m.GetGauge().GetValue()
+			}
+		}
+	}
+	want := map[string]float64{
+		"simple_csi_nfs_volume_read_bytes_total,10.0.0.3#export#pvc-3":                     40960,
+		"simple_csi_nfs_volume_written_bytes_total,10.0.0.3#export#pvc-3":                  20480,
+		"simple_csi_nfs_volume_rpc_requests_total,NULL,10.0.0.3#export#pvc-3":              1,
+		"simple_csi_nfs_volume_rpc_requests_total,READ,10.0.0.3#export#pvc-3":              10,
+		"simple_csi_nfs_volume_rpc_requests_total,WRITE,10.0.0.3#export#pvc-3":             5,
+		"simple_csi_nfs_volume_rpc_requests_total,LOOKUP,10.0.0.3#export#pvc-3":            3,
+		"simple_csi_nfs_volume_rpc_retransmissions_total,NULL,10.0.0.3#export#pvc-3":       0,
+		"simple_csi_nfs_volume_rpc_retransmissions_total,READ,10.0.0.3#export#pvc-3":       0,
+		"simple_csi_nfs_volume_rpc_retransmissions_total,WRITE,10.0.0.3#export#pvc-3":      2,
+		"simple_csi_nfs_volume_rpc_retransmissions_total,LOOKUP,10.0.0.3#export#pvc-3":     0,
+		"simple_csi_nfs_volume_rpc_average_rtt_seconds,NULL,10.0.0.3#export#pvc-3":         0,
+		"simple_csi_nfs_volume_rpc_average_rtt_seconds,READ,10.0.0.3#export#pvc-3":         0.005,
+		"simple_csi_nfs_volume_rpc_average_rtt_seconds,WRITE,10.0.0.3#export#pvc-3":        0.005,
+		"simple_csi_nfs_volume_rpc_average_rtt_seconds,LOOKUP,10.0.0.3#export#pvc-3":       0.002,
+		"simple_csi_nfs_volume_rpc_average_execution_seconds,NULL,10.0.0.3#export#pvc-3":   0,
+		"simple_csi_nfs_volume_rpc_average_execution_seconds,READ,10.0.0.3#export#pvc-3":   0.006,
+		"simple_csi_nfs_volume_rpc_average_execution_seconds,WRITE,10.0.0.3#export#pvc-3":  0.006,
+		"simple_csi_nfs_volume_rpc_average_execution_seconds,LOOKUP,10.0.0.3#export#pvc-3": 0.002,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("collected %v, want %v", got, want)
+	}
+}
+
+func TestVolumeOfTarget(t *testing.T) {
+	d := NewFakeNfsDriver(fakeNode)
+	d.name = DriverName
+	ns := newFakeNodeServer(d, nil)
+	ns.recordPublished("/published/mount", testVolId, testServer, "", nil)
+
+	podVolumes := t.TempDir()
+	for pv, data := range map[string]string{
+		"pvc-1": `{"driverName":"` + DriverName + `","volumeHandle":"server#base#pvc-1"}`,
+		"pvc-2": `{"driverName":"other.csi.k8s.io","volumeHandle":"pvc-2"}`,
+		"pvc-3": `not json`,
+	} {
+		if err := os.MkdirAll(filepath.Join(podVolumes, pv, "mount"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(podVolumes, pv, "vol_data.json"), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		targetPath string
+		want       string
+	}{
+		{targetPath: "/published/mount", want: testVolId},
+		{targetPath: filepath.Join(podVolumes, "pvc-1", "mount"), want: "server#base#pvc-1"},
+		{targetPath: filepath.Join(podVolumes, "pvc-2", "mount")},
+		{targetPath: filepath.Join(podVolumes, "pvc-3", "mount")},
+		{targetPath: "/mnt/other"},
+	}
+	for _, tt := range tests {
+		if got := ns.volumeOfTarget(tt.targetPath); got != tt.want {
+			t.Errorf("volumeOfTarget(%s) = %q, want %q", tt.targetPath, got, tt.want)
+		}
+	}
+}
@@ -1,19 +1,34 @@
 package nfs
 
 import (
+	"crypto/tls"
+	"fmt"
 	"os"
+	"sync"
+	"sync/atomic"
+	"time"
 
-	"github.com/chenliu1993/simple-csi-driver/internal/server"
+	"github.com/chenliu1993/simple-csi-driver/internal/localfs"
+	"github.com/chenliu1993/simple-csi-driver/internal/nfsv3"
+	"github.com/chenliu1993/simple-csi-driver/pkg/driver"
+	"github.com/chenliu1993/simple-csi-driver/pkg/server"
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/prometheus/client_golang/prometheus"
 	"k8s.io/klog/v2"
 )
 
+// Check if implements driver.Driver
+var _ driver.Driver = &nfsDriver{}
+
+// DriverName is the CSI name of the nfs driver
+const DriverName = "nfsplugin.csi.cliufreever.com"
+
 var (
 	controllerCapsList = []csi.ControllerServiceCapability_RPC_Type{
 		csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
-		csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME,
 		csi.ControllerServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
-		// csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
+		csi.ControllerServiceCapability_RPC_GET_CAPACITY,
+		csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
 	}
 
 	nodeCapsList = []csi.NodeServiceCapability_RPC_Type{
@@ -23,10 +38,102 @@ var (
 	}
 )
 
+// DriverOptions are the settings the nfs driver is started with
+type DriverOptions struct {
+	DriverName string
+	Endpoint   string
+	NodeID     string
+	// Services served, all if unset
+	Mode driver.Mode
+
+	// Directory the controller mounts nfs servers under, /tmp if empty
+	WorkingMountDir string
+	// Octal permissions of volumes whose StorageClass has no mountPermission parameter,
+	// empty makes the parameter required
+	MountPermissions string
+	// What DeleteVolume does with the volume directory: delete, the default, or retain
+	OnDeletePolicy string
+	// Maximum number of volumes the node can publish, 0 means no limit
+	MaxVolumesPerNode int64
+	// File holding the node topology segments as key=value lines, optional
+	TopologyFile string
+	// Policy every mount performed by the driver goes through, optional
+	MountOptionPolicy *MountOptionPolicy
+	// Ordered nfs versions to negotiate when none is requested, empty disables negotiation
+	NFSVersions []string
+	// File remembering the negotiated nfs version of each server, optional
+	NFSVersionCacheFile string
+	// NFS servers checked from startup on, servers of created volumes are added later
+	HealthCheckServers []string
+	// How often the servers are pinged, 0 disables the health checks
+	HealthCheckInterval time.Duration
+	// Deadline of a single ping
+	HealthCheckTimeout time.Duration
+	// Check at CreateVolume that basedir lies under an export of the server
+	ValidateExports bool
+	// How long the export list of a server is cached
+	ExportCacheTTL time.Duration
+	// Create and delete volume directories through the userspace nfs client instead of
+	// mounting, the controller then needs no privileges
+	UserspaceNFSClient bool
+	// Map nfs mounts of server:/path to path under this directory instead of mounting,
+	// for development against the embedded nfs server
+	FakeMountRoot string
+	// Local directories backing server:/basedir exports, the controller manages volumes
+	// on them directly instead of through nfs
+	LocalExports map[string]string
+	// Limit volumes on local exports to their requested capacity with project quotas
+	LocalProjectQuotas bool
+	// Address of the storage agent of nfs servers, which manages their volumes instead of
+	// the controller mounting them
+	StorageAgents map[string]string
+	// mTLS configuration the agents are dialed with, nil only suits the fake agent
+	AgentTLSConfig *tls.Config
+	// Files the settings are read from, a change reloads the driver
+	ConfigFiles []string
+	// How often ConfigFiles are checked for changes, 0 only reloads on SIGHUP
+	ConfigWatchInterval time.Duration
+	// Unix socket the docker volume plugin API is served on, optional
+	DockerPluginSocket string
+	// Directory the docker volumes are persisted and mounted in
+	DockerStateDir string
+	// Defaults of the options of docker volume create
+	DockerVolumeOptions map[string]string
+	// Timeouts and concurrency limits of the grpc server
+	ServerOptions server.Options
+}
+
 type nfsDriver struct {
 	name     string
 	endpoint string
 	node     string
+	mode     driver.Mode
+
+	workingMountDir  string
+	mountPermissions string
+	onDeletePolicy   string
+
+	maxVolumesPerNode int64
+	topologyFile      string
+	// Swapped by Reload while mounts go through it
+	mountPolicy   atomic.Pointer[MountOptionPolicy]
+	nfsVersions   []string
+	versionCache  *nfsVersionCache
+	healthChecker *serverHealthChecker
+	// Checks passed once before the driver reports ready
+	startup *startupChecks
+	exports *exportCache
+	metrics *driverMetrics
+	// Set when the controller talks to the servers through the userspace nfs client
+	dialNfs nfsDialer
+	// Directory mounts are mapped to instead of mounting, optional
+	fakeMountRoot string
+	// Exports the controller manages on the local filesystem
+	localExports       localExports
+	localOps           localfs.Ops
+	localProjectQuotas bool
+	// Storage agents of nfs servers, nil if there are none
+	agents *agentClients
 
 	ids csi.IdentityServer
 	cs  csi.ControllerServer
@@ -35,77 +142,162 @@ type nfsDriver struct {
 	controllerCaps []*csi.ControllerServiceCapability
 	nodeCaps       []*csi.NodeServiceCapability
 
+	// Settings the driver runs with and how to read them again, see Reload
+	reloadLock          *sync.Mutex
+	options             *DriverOptions
+	loadOptions         func() (*DriverOptions, error)
+	configFiles         []string
+	configWatchInterval time.Duration
+
+	// The docker volume plugin API is served on dockerSocket if set
+	dockerSocket   string
+	dockerStateDir string
+	dockerOptions  map[string]string
+
+	serverOptions server.Options
+
 	stopCh chan os.Signal
 }
 
-func NewNFSDriver(driverName, endpoint, node string, stopCh chan os.Signal) *nfsDriver {
+func NewNFSDriver(opts *DriverOptions, stopCh chan os.Signal) *nfsDriver {
 	klog.V(4).InfoS("Starting nfs driver...")
 	nfsClient := &nfsDriver{
-		name:     driverName,
-		endpoint: endpoint,
-		node:     node,
-		stopCh:   stopCh,
+		name:                opts.DriverName,
+		endpoint:            opts.Endpoint,
+		node:                opts.NodeID,
+		mode:                opts.Mode,
+		workingMountDir:     opts.WorkingMountDir,
+		mountPermissions:    opts.MountPermissions,
+		onDeletePolicy:      opts.OnDeletePolicy,
+		maxVolumesPerNode:   opts.MaxVolumesPerNode,
+		topologyFile:        opts.TopologyFile,
+		nfsVersions:         opts.NFSVersions,
+		versionCache:        newNfsVersionCache(opts.NFSVersionCacheFile),
+		fakeMountRoot:       opts.FakeMountRoot,
+		localExports:        opts.LocalExports,
+		localOps:            localfs.Host,
+		localProjectQuotas:  opts.LocalProjectQuotas,
+		agents:              newAgentClients(opts.StorageAgents, opts.AgentTLSConfig),
+		reloadLock:          &sync.Mutex{},
+		options:             opts,
+		configFiles:         opts.ConfigFiles,
+		configWatchInterval: opts.ConfigWatchInterval,
+		dockerSocket:        opts.DockerPluginSocket,
+		dockerStateDir:      opts.DockerStateDir,
+		dockerOptions:       opts.DockerVolumeOptions,
+		serverOptions:       opts.ServerOptions,
+		metrics:             newDriverMetrics(),
+		stopCh:              stopCh,
+	}
+	nfsClient.mountPolicy.Store(opts.MountOptionPolicy)
+	if opts.HealthCheckInterval > 0 {
+		nfsClient.healthChecker = newServerHealthChecker(opts.HealthCheckServers, opts.HealthCheckInterval, opts.HealthCheckTimeout)
+	}
+
+	if opts.UserspaceNFSClient {
+		nfsClient.dialNfs = nfsv3.Dial
 	}
+	if opts.ValidateExports {
+		nfsClient.exports = newExportCache(opts.ExportCacheTTL, nfsClient.metrics)
+	}
+	nfsClient.startup = nfsClient.newStartupChecks()
 
 	nfsClient.ids = NewIdentityServer(nfsClient)
 	nfsClient.cs = NewControllerServer(nfsClient)
 	nfsClient.ns = NewNodeServer(nfsClient)
 
-	nfsClient.AddControllerCapabilities(controllerCapsList)
-	nfsClient.AddNodeCapabilities(nodeCapsList)
+	caps := &driver.Capabilities{
+		Controller: append([]csi.ControllerServiceCapability_RPC_Type{}, controllerCapsList...),
+		Node:       nodeCapsList,
+	}
+	if len(nfsClient.localExports) > 0 || nfsClient.agents != nil {
+		// Reflink snapshots and clones need access to the filesystem backing the export
+		caps.Controller = append(caps.Controller,
+			csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
+			csi.ControllerServiceCapability_RPC_CLONE_VOLUME,
+		)
+	}
+	nfsClient.controllerCaps = caps.ControllerServiceCapabilities()
+	nfsClient.nodeCaps = caps.NodeServiceCapabilities()
 
 	return nfsClient
 }
 
-func (nd *nfsDriver) Run() {
-	s := server.NewNonBlockingGRPCServer()
-	s.Start(nd.endpoint,
-		nd.ids,
-		nd.cs,
-		nd.ns,
-	)
-	go func() {
-		<-nd.stopCh
-		klog.V(4).InfoS("Stopping nfs driver...")
-		s.Stop()
-		klog.Flush()
-	}()
-
-	s.Wait()
+func (nd *nfsDriver) Name() string {
+	return nd.name
 }
 
-func (nd *nfsDriver) AddControllerCapabilities(caps []csi.ControllerServiceCapability_RPC_Type) {
-	var ndCtrCaps []*csi.ControllerServiceCapability
-	for _, cap := range caps {
-		ndCtrCaps = append(ndCtrCaps, NewControllerServiceCapability(cap))
+// Run serves the driver until it is stopped or its grpc server fails
+func (nd *nfsDriver) Run() error {
+	// Metrics are labeled with the driver, several nfs drivers may run in one process
+	registerer := prometheus.WrapRegistererWith(prometheus.Labels{"driver": nd.name}, prometheus.DefaultRegisterer)
+	// Registered collectors are unregistered once Run returns
+	var unregister []prometheus.Collector
+	register := func(name string, collector prometheus.Collector) {
+		if err := registerer.Register(collector); err != nil {
+			klog.Warningf("Failed to register the %s metrics: %v", name, err)
+			return
+		}
+		unregister = append(unregister, collector)
 	}
-	nd.controllerCaps = append(nd.controllerCaps, ndCtrCaps...)
-}
+	defer func() {
+		for _, collector := range unregister {
+			registerer.Unregister(collector)
+		}
+	}()
+	register("nfs", nd.metrics)
 
-func (nd *nfsDriver) AddNodeCapabilities(caps []csi.NodeServiceCapability_RPC_Type) {
-	var ndNodeCaps []*csi.NodeServiceCapability
-	for _, cap := range caps {
-		ndNodeCaps = append(ndNodeCaps, NewNodeServiceCapability(cap))
+	// The controller still publishes through the node server internally, it is just not served
+	var cs csi.ControllerServer
+	var ns csi.NodeServer
+	if nd.mode.Controller() {
+		cs = nd.cs
+		if controllerServer, ok := nd.cs.(*controllerServer); ok {
+			register("controller in-flight operations", controllerServer.idempotency)
+		}
 	}
-	nd.nodeCaps = append(nd.nodeCaps, ndNodeCaps...)
-}
-
-func NewControllerServiceCapability(cap csi.ControllerServiceCapability_RPC_Type) *csi.ControllerServiceCapability {
-	return &csi.ControllerServiceCapability{
-		Type: &csi.ControllerServiceCapability_Rpc{
-			Rpc: &csi.ControllerServiceCapability_RPC{
-				Type: cap,
-			},
-		},
+	if nd.mode.Node() {
+		ns = nd.ns
+		if nodeServer, ok := nd.ns.(*nodeServer); ok {
+			register("node in-flight operations", nodeServer.targetLocks)
+			register("mountstats", newMountStatsCollector(nodeServer))
+		}
 	}
-}
+	serverOptions := nd.serverOptions
+	serverOptions.Registerer = registerer
+	s := server.NewNonBlockingGRPCServer(serverOptions)
+	s.Start(nd.endpoint,
+		nd.ids,
+		cs,
+		ns,
+	)
+	backgroundStopCh := make(chan struct{})
+	go nd.healthChecker.Run(backgroundStopCh)
+	go nd.watchConfigFiles(fileVersions(nd.configFiles), backgroundStopCh)
+	defer close(backgroundStopCh)
 
-func NewNodeServiceCapability(cap csi.NodeServiceCapability_RPC_Type) *csi.NodeServiceCapability {
-	return &csi.NodeServiceCapability{
-		Type: &csi.NodeServiceCapability_Rpc{
-			Rpc: &csi.NodeServiceCapability_RPC{
-				Type: cap,
-			},
-		},
+	if nd.dockerSocket != "" {
+		plugin, err := newDockerPlugin(nd.cs, nd.ns, nd.dockerStateDir, nd.dockerOptions)
+		if err == nil {
+			err = plugin.serve(nd.dockerSocket, backgroundStopCh)
+		}
+		if err != nil {
+			s.Stop()
+			return fmt.Errorf("failed to serve the docker volume plugin: %v", err)
+		}
 	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-nd.stopCh:
+			klog.V(4).InfoS("Stopping nfs driver...")
+			s.Stop()
+			klog.Flush()
+		case <-done:
+		}
+	}()
+
+	return s.Wait()
 }
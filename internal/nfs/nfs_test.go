@@ -1,11 +1,21 @@
 package nfs
 
 import (
-	"fmt"
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/chenliu1993/simple-csi-driver/pkg/driver"
 	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 )
 
 const (
@@ -26,119 +36,132 @@ func TestNewFakeNfsDriver(t *testing.T) {
 	assert.Equal(t, fakeNode, d.node)
 }
 
-func TestNewControllerServiceCapability(t *testing.T) {
-	tcs := []struct {
-		description  string
-		capability   csi.ControllerServiceCapability_RPC_Type
-		expectedCaps *csi.ControllerServiceCapability
+func TestRunMode(t *testing.T) {
+	tests := []struct {
+		mode           driver.Mode
+		wantController bool
+		wantNode       bool
 	}{
-		{
-			description: "CREATE_DELETE_VOLUME",
-			capability:  csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
-			expectedCaps: &csi.ControllerServiceCapability{
-				Type: &csi.ControllerServiceCapability_Rpc{
-					Rpc: &csi.ControllerServiceCapability_RPC{
-						Type: csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
-					},
-				},
-			},
-		},
-		{
-			description: "PUBLISH_UNPUBLISH_VOLUME",
-			capability:  csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME,
-			expectedCaps: &csi.ControllerServiceCapability{
-				Type: &csi.ControllerServiceCapability_Rpc{
-					Rpc: &csi.ControllerServiceCapability_RPC{
-						Type: csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME,
-					},
-				},
-			},
-		},
-		{
-
-			description: "EXPAND_VOLUME",
-			capability:  csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
-			expectedCaps: &csi.ControllerServiceCapability{
-				Type: &csi.ControllerServiceCapability_Rpc{
-					Rpc: &csi.ControllerServiceCapability_RPC{
-						Type: csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
-					},
-				},
-			},
-		},
-		{
-
-			description: "SINGLE_NODE_MULTI_WRITER",
-			capability:  csi.ControllerServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
-			expectedCaps: &csi.ControllerServiceCapability{
-				Type: &csi.ControllerServiceCapability_Rpc{
-					Rpc: &csi.ControllerServiceCapability_RPC{
-						Type: csi.ControllerServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
-					},
-				},
-			},
-		},
+		{mode: driver.ModeAll, wantController: true, wantNode: true},
+		{mode: driver.ModeController, wantController: true},
+		{mode: driver.ModeNode, wantNode: true},
 	}
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			socket := filepath.Join(t.TempDir(), "csi.sock")
+			stopCh := make(chan os.Signal, 1)
+			d := NewNFSDriver(&DriverOptions{
+				DriverName: DriverName,
+				Endpoint:   "unix://" + socket,
+				NodeID:     fakeNode,
+				Mode:       tt.mode,
+			}, stopCh)
+			done := make(chan error, 1)
+			go func() {
+				done <- d.Run()
+			}()
+			defer func() {
+				stopCh <- syscall.SIGTERM
+				if err := <-done; err != nil {
+					t.Errorf("Run() error = %v", err)
+				}
+			}()
+
+			conn, err := grpc.Dial("unix://"+socket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+			if err != nil {
+				t.Fatalf("Dial() error = %v", err)
+			}
+			defer conn.Close()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if _, err := csi.NewIdentityClient(conn).GetPluginInfo(ctx, &csi.GetPluginInfoRequest{}, grpc.WaitForReady(true)); err != nil {
+				t.Fatalf("GetPluginInfo() error = %v", err)
+			}
 
-	for _, tc := range tcs {
-		t.Run(tc.description, func(t *testing.T) {
-			actualCaps := NewControllerServiceCapability(tc.capability)
-			if actualCaps == nil {
-				t.Fatalf("NewControllerServiceCapability returned nil")
+			_, err = csi.NewControllerClient(conn).ControllerGetCapabilities(ctx, &csi.ControllerGetCapabilitiesRequest{})
+			if served := status.Code(err) != codes.Unimplemented; served != tt.wantController {
+				t.Errorf("controller service served = %v, want %v: %v", served, tt.wantController, err)
+			}
+			_, err = csi.NewNodeClient(conn).NodeGetCapabilities(ctx, &csi.NodeGetCapabilitiesRequest{})
+			if served := status.Code(err) != codes.Unimplemented; served != tt.wantNode {
+				t.Errorf("node service served = %v, want %v: %v", served, tt.wantNode, err)
 			}
-			assert.Equal(t, tc.expectedCaps, actualCaps, fmt.Sprintf("Expected: %v, got: %v", tc.expectedCaps, actualCaps))
 		})
 	}
 }
 
-func TestNewNodeServiceCapability(t *testing.T) {
-	tcs := []struct {
-		description  string
-		capability   csi.NodeServiceCapability_RPC_Type
-		expectedCaps *csi.NodeServiceCapability
-	}{
-		{
-			description: "SINGLE_NODE_MULTI_WRITER",
-			capability:  csi.NodeServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
-			expectedCaps: &csi.NodeServiceCapability{
-				Type: &csi.NodeServiceCapability_Rpc{
-					Rpc: &csi.NodeServiceCapability_RPC{
-						Type: csi.NodeServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
-					},
-				},
-			},
-		},
-		{
-			description: "GET_VOLUME_STATS",
-			capability:  csi.NodeServiceCapability_RPC_GET_VOLUME_STATS,
-			expectedCaps: &csi.NodeServiceCapability{
-				Type: &csi.NodeServiceCapability_Rpc{
-					Rpc: &csi.NodeServiceCapability_RPC{
-						Type: csi.NodeServiceCapability_RPC_GET_VOLUME_STATS,
-					},
-				},
-			},
-		},
-		{
-			description: "UNKNOWN",
-			capability:  csi.NodeServiceCapability_RPC_UNKNOWN,
-			expectedCaps: &csi.NodeServiceCapability{
-				Type: &csi.NodeServiceCapability_Rpc{
-					Rpc: &csi.NodeServiceCapability_RPC{
-						Type: csi.NodeServiceCapability_RPC_UNKNOWN,
-					},
-				},
-			},
-		},
+func TestRunMetricsDriverLabel(t *testing.T) {
+	// driverSeries returns the drivers labeling the series of the metric
+	driverSeries := func(name string) map[string]int {
+		families, err := prometheus.DefaultGatherer.Gather()
+		if err != nil {
+			t.Fatalf("Gather() error = %v", err)
+		}
+		drivers := make(map[string]int)
+		for _, family := range families {
+			if family.GetName() != name {
+				continue
+			}
+			for _, m := range family.GetMetric() {
+				for _, label := range m.GetLabel() {
+					if label.GetName() == "driver" {
+						drivers[label.GetValue()]++
+					}
+				}
+			}
+		}
+		return drivers
 	}
 
-	for _, tc := range tcs {
-		t.Run(tc.description, func(t *testing.T) {
-			actualCaps := NewNodeServiceCapability(tc.capability)
-			if actualCaps == nil {
-				t.Fatalf("NewNodeServiceCapability returned nil")
+	names := []string{"alpha.nfs.csi.example.com", "beta.nfs.csi.example.com"}
+	var stops []func()
+	for _, name := range names {
+		socket := filepath.Join(t.TempDir(), "csi.sock")
+		stopCh := make(chan os.Signal, 1)
+		d := NewNFSDriver(&DriverOptions{
+			DriverName: name,
+			Endpoint:   "unix://" + socket,
+			NodeID:     fakeNode,
+		}, stopCh)
+		done := make(chan error, 1)
+		go func() {
+			done <- d.Run()
+		}()
+		stops = append(stops, func() {
+			stopCh <- syscall.SIGTERM
+			if err := <-done; err != nil {
+				t.Errorf("Run() error = %v", err)
 			}
-			assert.Equal(t, tc.expectedCaps, actualCaps, fmt.Sprintf("Expected: %v, got: %v", tc.expectedCaps, actualCaps))
 		})
+
+		conn, err := grpc.Dial("unix://"+socket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			t.Fatalf("Dial() error = %v", err)
+		}
+		defer conn.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if _, err := csi.NewIdentityClient(conn).GetPluginInfo(ctx, &csi.GetPluginInfoRequest{}, grpc.WaitForReady(true)); err != nil {
+			t.Fatalf("GetPluginInfo() error = %v", err)
+		}
+	}
+
+	for _, metric := range []string{"simple_csi_grpc_requests_total", "simple_csi_inflight_operations"} {
+		drivers := driverSeries(metric)
+		for _, name := range names {
+			if drivers[name] == 0 {
+				t.Errorf("%s has no series of driver %s: %v", metric, name, drivers)
+			}
+		}
+	}
+	if drivers := driverSeries("simple_csi_inflight_operations"); drivers[names[0]] != 2 {
+		t.Errorf("simple_csi_inflight_operations has %d series of driver %s, want the controller and the node one", drivers[names[0]], names[0])
+	}
+
+	for _, stop := range stops {
+		stop()
+	}
+	if drivers := driverSeries("simple_csi_grpc_requests_total"); len(drivers) != 0 {
+		t.Errorf("simple_csi_grpc_requests_total still has series of stopped drivers: %v", drivers)
 	}
 }
@@ -5,9 +5,13 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/chenliu1993/simple-csi-driver/pkg/idempotency"
 	csi "github.com/container-storage-interface/spec/lib/go/csi"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -23,18 +27,43 @@ type nodeServer struct {
 	driver *nfsDriver
 
 	mounter mount.Interface
+
+	// Serializes publish and unpublish calls on the same target path
+	targetLocks *idempotency.Idempotency
+
+	// Record the arguments each target path was published with
+	publishedLock *sync.Mutex
+	published     map[string]*publishedVolume
+}
+
+// publishedVolume is what a target path was mounted with, used to tell a
+// repeated publish apart from a conflicting one
+type publishedVolume struct {
+	volumeID  string
+	server    string
+	source    string
+	mountOpts []string
 }
 
 // NewNodeServer returens a functional node server
 func NewNodeServer(driver *nfsDriver) *nodeServer {
+	var mounter mount.Interface = mount.New("")
+	if driver.fakeMountRoot != "" {
+		klog.Warningf("Mounts are mapped to directories under %s instead of mounting", driver.fakeMountRoot)
+		mounter = newLocalMounter(driver.fakeMountRoot)
+	}
 	return &nodeServer{
-		driver:  driver,
-		mounter: mount.New(""),
+		driver:        driver,
+		mounter:       mounter,
+		targetLocks:   idempotency.NewNamedIdempotency("node"),
+		publishedLock: &sync.Mutex{},
+		published:     make(map[string]*publishedVolume),
 	}
 }
 
 func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
-	klog.V(4).InfoS("Begin to publish volume")
+	logger := klog.FromContext(ctx)
+	logger.V(4).Info("Publishing volume")
 
 	// Step 0: check the necessary parameters
 	volumeID := req.GetVolumeId()
@@ -52,19 +81,35 @@ func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 		return nil, status.Error(codes.InvalidArgument, "Volume capability is required")
 	}
 
-	mountOpts := volumeCapability.GetMount().GetMountFlags()
+	var readonlyOpts []string
 	if req.GetReadonly() {
-		mountOpts = append(mountOpts, "ro")
+		readonlyOpts = append(readonlyOpts, "ro")
+	}
+	// StorageClass options are overridden by the ones of the PV, which are overridden by readonly
+	mountOpts, err := mergeMountOptions(
+		splitMountOptions(req.GetVolumeContext()[mountOptionsKey]),
+		volumeCapability.GetMount().GetMountFlags(),
+		readonlyOpts,
+	)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	// kubelet may retry a publish after a timeout while the first call is still running
+	if !ns.targetLocks.TryAddProcessing(targetPath) {
+		return nil, status.Errorf(codes.Aborted, "An operation on target path %s is already in progress", targetPath)
 	}
+	defer ns.targetLocks.RemoveProcessing(targetPath)
 
 	var server, basedir string
 	volumeContext := req.GetVolumeContext()
 
-	mountPermissionsValue := volumeContext[mountPermissionKey]
-	var (
-		mountPermissions uint64
-		err              error
-	)
+	mountPermissionsValue, ok := volumeContext[mountPermissionKey]
+	if !ok && ns.driver.mountPermissions != "" {
+		// Statically provisioned volumes may lack the parameter
+		mountPermissionsValue = ns.driver.mountPermissions
+	}
+	var mountPermissions uint64
 	if mountPermissions, err = strconv.ParseUint(mountPermissionsValue, 8, 32); err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, err.Error())
 	}
@@ -99,32 +144,35 @@ func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 		}
 	}
 	if !notMnt {
+		if err := ns.checkPublished(targetPath, volumeID, source, mountOpts); err != nil {
+			return nil, status.Error(codes.AlreadyExists, err.Error())
+		}
+		logger.V(4).Info("Target path is already published with the same arguments")
 		return &csi.NodePublishVolumeResponse{}, nil
 	}
 
 	// Step 1: do mount
-	klog.V(4).Infof("NodePublishVolume: volumeID(%v) source(%s) targetPath(%s) mountflags(%v)", volumeID, source, targetPath, mountOpts)
-	err = ns.mounter.Mount(source, targetPath, "nfs", mountOpts)
-	if err != nil {
-		if os.IsPermission(err) {
-			return nil, status.Errorf(codes.PermissionDenied, err.Error())
-		}
-		if strings.Contains(err.Error(), "invalid argument") {
-			return nil, status.Errorf(codes.InvalidArgument, "invalid argument: %v", err)
-		}
-		return nil, status.Errorf(codes.Internal, "mount failed: %v", err)
+	logger.V(4).Info("Mounting volume", "source", source, "mount_options", mountOpts)
+	if err := ns.mountNFS(ctx, server, source, targetPath, mountOpts); err != nil {
+		return nil, err
 	}
 
 	// Step 2: check the rightness of the mount result
 	if mountPermissions > 0 {
 		if err := checkMountPermissions(targetPath, os.FileMode(mountPermissions)); err != nil {
+			// Unmount so a retry publishes from scratch instead of taking the mount as done
+			if err := mount.CleanupMountPoint(targetPath, ns.mounter, false); err != nil {
+				logger.Error(err, "Failed to unmount after the permission check failed")
+			}
 			return nil, status.Error(codes.Internal, err.Error())
 		}
 	} else {
-		klog.V(4).InfoS("No validate since it is 0")
+		logger.V(4).Info("Mount permissions are not checked as they are 0")
 	}
 
-	klog.V(4).InfoS("Mount succeeded")
+	// Only a publish which fully succeeded is recorded
+	ns.recordPublished(targetPath, volumeID, server, source, mountOpts)
+	logger.V(4).Info("Mount succeeded")
 	return &csi.NodePublishVolumeResponse{}, nil
 }
 
@@ -139,9 +187,20 @@ func (ns *nodeServer) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpu
 		return nil, status.Error(codes.InvalidArgument, "Target path is required")
 	}
 
-	if err := mount.CleanupMountPoint(targetPath, ns.mounter, false); err != nil {
+	if !ns.targetLocks.TryAddProcessing(targetPath) {
+		return nil, status.Errorf(codes.Aborted, "An operation on target path %s is already in progress", targetPath)
+	}
+	defer ns.targetLocks.RemoveProcessing(targetPath)
+
+	start := time.Now()
+	server := ns.publishedServer(targetPath)
+	err := mount.CleanupMountPoint(targetPath, ns.mounter, false)
+	ns.driver.metrics.recordMountOperation(unmountOperation, server, start, err)
+	klog.FromContext(ctx).V(4).Info("Unmounted nfs", "server", server, "target_path", targetPath, "duration", time.Since(start).String(), "err", err)
+	if err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to unmount %s: %v", targetPath, err.Error())
 	}
+	ns.forgetPublished(targetPath)
 
 	return &csi.NodeUnpublishVolumeResponse{}, nil
 }
@@ -155,9 +214,21 @@ func (ns *nodeServer) NodeGetCapabilities(context.Context, *csi.NodeGetCapabilit
 
 // NodeGetInfo implements csi.NodeServer.
 func (ns *nodeServer) NodeGetInfo(context.Context, *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
-	return &csi.NodeGetInfoResponse{
-		NodeId: ns.driver.node,
-	}, nil
+	segments, err := getNodeTopology(ns.driver.topologyFile)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to discover node topology: %v", err)
+	}
+
+	resp := &csi.NodeGetInfoResponse{
+		NodeId:            ns.driver.node,
+		MaxVolumesPerNode: ns.driver.maxVolumesPerNode,
+	}
+	if len(segments) > 0 {
+		resp.AccessibleTopology = &csi.Topology{
+			Segments: segments,
+		}
+	}
+	return resp, nil
 }
 
 // NodeGetVolumeStats implements csi.NodeServer.
@@ -242,6 +313,61 @@ func (ns *nodeServer) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandV
 	return nil, status.Error(codes.Unimplemented, "NodeExpandVolume is not implemented")
 }
 
+// mountNFS mounts the nfs source at the target path after applying the mount option policy
+// of the node, every nfs mount of the driver should go through it. When nfs versions to
+// negotiate are configured and no version is requested, the versions are tried in order,
+// starting with the one which last worked for the server. Errors are gRPC statuses.
+func (ns *nodeServer) mountNFS(ctx context.Context, server, source, targetPath string, mountOpts []string) error {
+	logger := klog.FromContext(ctx)
+	policy := ns.driver.mountPolicy.Load()
+	opts, err := policy.Apply(mountOpts)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if len(ns.driver.nfsVersions) == 0 || hasMountOptionGroup(opts, "nfsvers") {
+		return mountErrorStatus(ns.mount(ctx, server, source, targetPath, opts))
+	}
+
+	cached, _ := ns.driver.versionCache.Get(server)
+	var mountErr error
+	for _, version := range negotiationOrder(ns.driver.nfsVersions, cached) {
+		versionOpts, err := mergeMountOptions(mountOpts, []string{"nfsvers=" + version})
+		if err == nil {
+			versionOpts, err = policy.Apply(versionOpts)
+		}
+		if err != nil {
+			logger.V(4).Info("Skipping nfs version", "server", server, "version", version, "reason", err.Error())
+			continue
+		}
+
+		mountErr = ns.mount(ctx, server, source, targetPath, versionOpts)
+		if mountErr == nil {
+			logger.V(2).Info("Negotiated nfs version", "server", server, "version", version)
+			ns.driver.versionCache.Set(server, version)
+			ns.driver.metrics.recordNegotiatedVersion(server, version)
+			return nil
+		}
+		if !isNfsVersionNotSupported(mountErr) {
+			return mountErrorStatus(mountErr)
+		}
+		logger.V(2).Info("NFS version is not supported by the server, trying the next one", "server", server, "version", version)
+	}
+	if mountErr == nil {
+		return status.Errorf(codes.InvalidArgument, "none of the nfs versions %v can be used with mount options %v", ns.driver.nfsVersions, mountOpts)
+	}
+	return mountErrorStatus(mountErr)
+}
+
+// mount mounts the nfs source and records the mount metrics of the server
+func (ns *nodeServer) mount(ctx context.Context, server, source, targetPath string, mountOpts []string) error {
+	start := time.Now()
+	err := ns.mounter.Mount(source, targetPath, "nfs", mountOpts)
+	ns.driver.metrics.recordMountOperation(mountOperation, server, start, err)
+	klog.FromContext(ctx).V(4).Info("Mounted nfs", "server", server, "source", source, "target_path", targetPath, "mount_options", mountOpts, "duration", time.Since(start).String(), "err", err)
+	return err
+}
+
 func checkMountPermissions(targetPath string, mode os.FileMode) error {
 	info, err := os.Lstat(targetPath)
 	if err != nil {
@@ -250,12 +376,104 @@ func checkMountPermissions(targetPath string, mode os.FileMode) error {
 
 	perm := info.Mode().Perm()
 	if perm != mode {
-		klog.V(4).InfoS("mode mismatch, changing...")
+		klog.V(4).InfoS("Changing the mode of the target path", "target_path", targetPath, "mode", perm, "want", mode)
 		if err := os.Chmod(targetPath, mode); err != nil {
 			return err
 		}
-	} else {
-		klog.V(4).InfoS("mode match")
 	}
 	return nil
 }
+
+// recordPublished remembers the arguments the target path has been mounted with
+func (ns *nodeServer) recordPublished(targetPath, volumeID, server, source string, mountOpts []string) {
+	ns.publishedLock.Lock()
+	defer ns.publishedLock.Unlock()
+
+	ns.published[targetPath] = &publishedVolume{
+		volumeID:  volumeID,
+		server:    server,
+		source:    source,
+		mountOpts: normalizeMountOptions(mountOpts),
+	}
+}
+
+// publishedServer returns the server the target path was published from, empty if unknown
+func (ns *nodeServer) publishedServer(targetPath string) string {
+	ns.publishedLock.Lock()
+	defer ns.publishedLock.Unlock()
+
+	if pv, ok := ns.published[targetPath]; ok {
+		return pv.server
+	}
+	return ""
+}
+
+func (ns *nodeServer) forgetPublished(targetPath string) {
+	ns.publishedLock.Lock()
+	defer ns.publishedLock.Unlock()
+
+	delete(ns.published, targetPath)
+}
+
+// checkPublished returns an error if the already mounted target path was published with
+// arguments different from the current request. When the driver restarted in between and
+// there is no record, only the mount source and the readonly flag can be compared.
+func (ns *nodeServer) checkPublished(targetPath, volumeID, source string, mountOpts []string) error {
+	ns.publishedLock.Lock()
+	pv, ok := ns.published[targetPath]
+	ns.publishedLock.Unlock()
+
+	if ok {
+		if pv.volumeID != volumeID {
+			return fmt.Errorf("target path %s is already published for volume %s", targetPath, pv.volumeID)
+		}
+		if pv.source != source {
+			return fmt.Errorf("target path %s is already published from %s", targetPath, pv.source)
+		}
+		if opts := normalizeMountOptions(mountOpts); strings.Join(opts, ",") != strings.Join(pv.mountOpts, ",") {
+			return fmt.Errorf("target path %s is already published with mount options %v", targetPath, pv.mountOpts)
+		}
+		return nil
+	}
+
+	mountPoints, err := ns.mounter.List()
+	if err != nil {
+		return fmt.Errorf("failed to list mount points: %v", err)
+	}
+	for _, mp := range mountPoints {
+		if mp.Path != targetPath {
+			continue
+		}
+		if mp.Device != source {
+			return fmt.Errorf("target path %s is already published from %s", targetPath, mp.Device)
+		}
+		if hasMountOption(mp.Opts, "ro") != hasMountOption(mountOpts, "ro") {
+			return fmt.Errorf("target path %s is already published with mount options %v", targetPath, mp.Opts)
+		}
+	}
+	return nil
+}
+
+// normalizeMountOptions returns a sorted copy of the options without duplicates
+func normalizeMountOptions(mountOpts []string) []string {
+	seen := make(map[string]bool, len(mountOpts))
+	opts := make([]string, 0, len(mountOpts))
+	for _, opt := range mountOpts {
+		if opt == "" || seen[opt] {
+			continue
+		}
+		seen[opt] = true
+		opts = append(opts, opt)
+	}
+	sort.Strings(opts)
+	return opts
+}
+
+func hasMountOption(mountOpts []string, opt string) bool {
+	for _, o := range mountOpts {
+		if o == opt {
+			return true
+		}
+	}
+	return false
+}
@@ -5,9 +5,13 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"sync"
 	"testing"
 
+	"github.com/chenliu1993/simple-csi-driver/pkg/idempotency"
 	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	mount "k8s.io/mount-utils"
 )
 
@@ -24,6 +28,16 @@ const (
 	testSubPath                  = "testSubPath"
 )
 
+func newFakeNodeServer(driver *nfsDriver, mounter mount.Interface) *nodeServer {
+	return &nodeServer{
+		driver:        driver,
+		mounter:       mounter,
+		targetLocks:   idempotency.NewIdempotency(),
+		publishedLock: &sync.Mutex{},
+		published:     make(map[string]*publishedVolume),
+	}
+}
+
 func TestValidateMountPermissions(t *testing.T) {
 	type args struct {
 		targetPath string
@@ -300,10 +314,7 @@ func TestNodePublishVolume(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			ns := &nodeServer{
-				driver:  tt.fields.driver,
-				mounter: tt.fields.mounter,
-			}
+			ns := newFakeNodeServer(tt.fields.driver, tt.fields.mounter)
 			got, err := ns.NodePublishVolume(tt.args.ctx, tt.args.req)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("nodeServer.NodePublishVolume() error = %v, wantErr %v", err, tt.wantErr)
@@ -386,10 +397,7 @@ func TestNodeUnpublishVolume(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			ns := &nodeServer{
-				driver:  tt.fields.driver,
-				mounter: tt.fields.mounter,
-			}
+			ns := newFakeNodeServer(tt.fields.driver, tt.fields.mounter)
 			got, err := ns.NodeUnpublishVolume(tt.args.ctx, tt.args.req)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("nodeServer.NodeUnpublishVolume() error = %v, wantErr %v", err, tt.wantErr)
@@ -401,3 +409,94 @@ func TestNodeUnpublishVolume(t *testing.T) {
 		})
 	}
 }
+
+func TestNodePublishVolumeIdempotency(t *testing.T) {
+	targetPath := filepath.Join(t.TempDir(), "target")
+	ns := newFakeNodeServer(NewFakeNfsDriver(fakeNode), mount.NewFakeMounter([]mount.MountPoint{}))
+
+	newReq := func(volumeId string, readonly bool, flags ...string) *csi.NodePublishVolumeRequest {
+		return &csi.NodePublishVolumeRequest{
+			VolumeId:   volumeId,
+			TargetPath: targetPath,
+			Readonly:   readonly,
+			VolumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{
+					Mount: &csi.VolumeCapability_MountVolume{
+						MountFlags: flags,
+					},
+				},
+			},
+			VolumeContext: map[string]string{
+				mountPermissionKey: "0",
+				serverKey:          testServer,
+				basedirKey:         testBasePath,
+			},
+		}
+	}
+
+	if _, err := ns.NodePublishVolume(context.Background(), newReq(testVolId, false, "hard")); err != nil {
+		t.Fatalf("first NodePublishVolume() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		req      *csi.NodePublishVolumeRequest
+		wantCode codes.Code
+	}{
+		{
+			name:     "Same arguments",
+			req:      newReq(testVolId, false, "hard"),
+			wantCode: codes.OK,
+		},
+		{
+			name:     "Different readonly flag",
+			req:      newReq(testVolId, true, "hard"),
+			wantCode: codes.AlreadyExists,
+		},
+		{
+			name:     "Different mount flags",
+			req:      newReq(testVolId, false, "soft"),
+			wantCode: codes.AlreadyExists,
+		},
+		{
+			name:     "Different volume id",
+			req:      newReq("otherVolId", false, "hard"),
+			wantCode: codes.AlreadyExists,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ns.NodePublishVolume(context.Background(), tt.req)
+			if code := status.Code(err); code != tt.wantCode {
+				t.Errorf("nodeServer.NodePublishVolume() code = %v, want %v", code, tt.wantCode)
+			}
+		})
+	}
+
+	t.Run("Operation in progress", func(t *testing.T) {
+		ns.targetLocks.AddProcessing(targetPath)
+		defer ns.targetLocks.RemoveProcessing(targetPath)
+
+		_, err := ns.NodePublishVolume(context.Background(), newReq(testVolId, false, "hard"))
+		if code := status.Code(err); code != codes.Aborted {
+			t.Errorf("nodeServer.NodePublishVolume() code = %v, want %v", code, codes.Aborted)
+		}
+		_, err = ns.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{
+			VolumeId:   testVolId,
+			TargetPath: targetPath,
+		})
+		if code := status.Code(err); code != codes.Aborted {
+			t.Errorf("nodeServer.NodeUnpublishVolume() code = %v, want %v", code, codes.Aborted)
+		}
+	})
+
+	if _, err := ns.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{
+		VolumeId:   testVolId,
+		TargetPath: targetPath,
+	}); err != nil {
+		t.Fatalf("NodeUnpublishVolume() error = %v", err)
+	}
+	if _, ok := ns.published[targetPath]; ok {
+		t.Errorf("published record of %s is not removed after unpublish", targetPath)
+	}
+}
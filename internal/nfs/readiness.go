@@ -0,0 +1,143 @@
+package nfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/chenliu1993/simple-csi-driver/pkg/driver"
+	"github.com/chenliu1993/simple-csi-driver/pkg/server"
+	"k8s.io/klog/v2"
+)
+
+// Check if implements driver.HealthChecker
+var _ driver.HealthChecker = &nfsDriver{}
+
+// Names of the health checks of the driver
+const (
+	csiEndpointCheck     = "csi-endpoint"
+	mountHelperCheck     = "mount-helper"
+	workingMountDirCheck = "working-mount-dir"
+	nfsServersCheck      = "nfs-servers"
+)
+
+// The nfs mount helper is looked for in these directories besides PATH, which often
+// leaves out the sbin directories
+var mountHelperDirs = []string{"/sbin", "/usr/sbin"}
+
+// startupChecks are checks the driver has to pass once before it reports ready.
+// A nil set has no checks.
+type startupChecks struct {
+	lock *sync.Mutex
+
+	checks []startupCheck
+	passed map[string]bool
+}
+
+type startupCheck struct {
+	name  string
+	check func() error
+}
+
+func newStartupChecks(checks ...startupCheck) *startupChecks {
+	return &startupChecks{
+		lock:   &sync.Mutex{},
+		checks: checks,
+		passed: make(map[string]bool),
+	}
+}
+
+// run runs the checks which have not passed yet and returns the results of all of them
+func (c *startupChecks) run() []driver.CheckResult {
+	if c == nil {
+		return nil
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	var results []driver.CheckResult
+	for _, check := range c.checks {
+		var err error
+		if !c.passed[check.name] {
+			if err = check.check(); err == nil {
+				klog.V(2).InfoS("Startup check passed", "check", check.name)
+				c.passed[check.name] = true
+			}
+		}
+		results = append(results, driver.NewCheckResult(check.name, err))
+	}
+	return results
+}
+
+// newStartupChecks returns the startup checks for the services the driver serves and how
+// it reaches the servers
+func (nd *nfsDriver) newStartupChecks() *startupChecks {
+	var checks []startupCheck
+	// The controller mounts the servers to manage volume directories unless it speaks nfs itself
+	controllerMounts := nd.mode.Controller() && nd.dialNfs == nil
+	if nd.fakeMountRoot == "" && (nd.mode.Node() || controllerMounts) {
+		checks = append(checks, startupCheck{name: mountHelperCheck, check: findMountHelper})
+	}
+	if controllerMounts {
+		workingMountDir := nd.workingMountDir
+		if workingMountDir == "" {
+			workingMountDir = defaultWorkingMountDir
+		}
+		checks = append(checks, startupCheck{name: workingMountDirCheck, check: func() error {
+			return checkWritable(workingMountDir)
+		}})
+	}
+	return newStartupChecks(checks...)
+}
+
+// Live checks the CSI endpoint of the driver answers
+func (nd *nfsDriver) Live(ctx context.Context) []driver.CheckResult {
+	_, err := server.Probe(ctx, nd.endpoint)
+	return []driver.CheckResult{driver.NewCheckResult(csiEndpointCheck, err)}
+}
+
+// Ready runs the startup checks which did not pass yet and, if health checks are on,
+// checks at least one nfs server is reachable
+func (nd *nfsDriver) Ready(ctx context.Context) []driver.CheckResult {
+	results := nd.startup.run()
+	if nd.healthChecker != nil {
+		var err error
+		if ready, msg := nd.healthChecker.Ready(); !ready {
+			err = errors.New(msg)
+		}
+		results = append(results, driver.NewCheckResult(nfsServersCheck, err))
+	}
+	return results
+}
+
+// findMountHelper returns an error if mount.nfs is missing
+func findMountHelper() error {
+	if _, err := exec.LookPath("mount.nfs"); err == nil {
+		return nil
+	}
+	for _, dir := range mountHelperDirs {
+		if fi, err := os.Stat(filepath.Join(dir, "mount.nfs")); err == nil && fi.Mode()&0111 != 0 {
+			return nil
+		}
+	}
+	return fmt.Errorf("mount.nfs was not found in PATH or %s, install nfs-utils or nfs-common", strings.Join(mountHelperDirs, ", "))
+}
+
+// checkWritable creates the directory if missing and returns an error if files cannot be
+// created in it
+func checkWritable(dir string) error {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".simple-csi-check-")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
@@ -0,0 +1,123 @@
+package nfs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/chenliu1993/simple-csi-driver/internal/nfsv3"
+	"github.com/chenliu1993/simple-csi-driver/pkg/driver"
+)
+
+func TestStartupChecksRun(t *testing.T) {
+	calls := 0
+	errs := []error{errors.New("not yet"), nil, errors.New("broken again")}
+	checks := newStartupChecks(startupCheck{name: "flaky", check: func() error {
+		calls++
+		return errs[calls-1]
+	}})
+
+	want := [][]driver.CheckResult{
+		{{Name: "flaky", Error: "not yet"}},
+		{{Name: "flaky", OK: true}},
+		// Passed checks are not run again
+		{{Name: "flaky", OK: true}},
+	}
+	for i, w := range want {
+		if got := checks.run(); !reflect.DeepEqual(got, w) {
+			t.Errorf("run() #%d = %+v, want %+v", i+1, got, w)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("check ran %d times, want 2", calls)
+	}
+	if got := (*startupChecks)(nil).run(); got != nil {
+		t.Errorf("run() of nil checks = %v, want none", got)
+	}
+}
+
+func TestNewStartupChecks(t *testing.T) {
+	tests := []struct {
+		name          string
+		mode          driver.Mode
+		userspace     bool
+		fakeMountRoot string
+		want          []string
+	}{
+		{
+			name: "controller and node",
+			mode: driver.ModeAll,
+			want: []string{mountHelperCheck, workingMountDirCheck},
+		},
+		{
+			name: "node",
+			mode: driver.ModeNode,
+			want: []string{mountHelperCheck},
+		},
+		{
+			name:      "controller with the userspace nfs client",
+			mode:      driver.ModeController,
+			userspace: true,
+		},
+		{
+			name:          "fake mounts",
+			mode:          driver.ModeAll,
+			fakeMountRoot: "/tmp/fake",
+			want:          []string{workingMountDirCheck},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewFakeNfsDriver(fakeNode)
+			d.mode = tt.mode
+			d.fakeMountRoot = tt.fakeMountRoot
+			if tt.userspace {
+				d.dialNfs = nfsv3.Dial
+			}
+			var got []string
+			for _, check := range d.newStartupChecks().checks {
+				got = append(got, check.name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("newStartupChecks() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindMountHelper(t *testing.T) {
+	t.Setenv("PATH", "")
+	dir := t.TempDir()
+	defer func(dirs []string) { mountHelperDirs = dirs }(mountHelperDirs)
+	mountHelperDirs = []string{dir}
+
+	if err := findMountHelper(); err == nil {
+		t.Errorf("findMountHelper() succeeded without mount.nfs")
+	}
+	if err := os.WriteFile(filepath.Join(dir, "mount.nfs"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := findMountHelper(); err != nil {
+		t.Errorf("findMountHelper() error = %v", err)
+	}
+}
+
+func TestCheckWritable(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "working")
+	if err := checkWritable(dir); err != nil {
+		t.Errorf("checkWritable() error = %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("checkWritable() left %d files behind", len(entries))
+	}
+
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkWritable(file); err == nil {
+		t.Errorf("checkWritable() of a file succeeded")
+	}
+}
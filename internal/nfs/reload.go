@@ -0,0 +1,149 @@
+package nfs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/chenliu1993/simple-csi-driver/pkg/driver"
+	"k8s.io/klog/v2"
+)
+
+// Check if implements driver.Reloader
+var _ driver.Reloader = &nfsDriver{}
+
+// reloadableOptions are the DriverOptions Reload applies to the running driver, changes of
+// the others take effect on the next restart
+var reloadableOptions = map[string]bool{
+	"MountOptionPolicy":   true,
+	"HealthCheckServers":  true,
+	"HealthCheckInterval": true,
+	"HealthCheckTimeout":  true,
+}
+
+// uncomparedOptions are built anew on every load and never compare equal
+var uncomparedOptions = map[string]bool{
+	"AgentTLSConfig": true,
+}
+
+// optionChange is a changed setting of the driver
+type optionChange struct {
+	name string
+	old  string
+	new  string
+}
+
+// Reload reads the flags, the config file and the mount option policy again and swaps in
+// the reloadable settings. Invalid settings are rejected and the running ones kept.
+func (nd *nfsDriver) Reload() error {
+	nd.reloadLock.Lock()
+	defer nd.reloadLock.Unlock()
+
+	if nd.loadOptions == nil {
+		return errors.New("the driver was not started from flags, there is nothing to reload")
+	}
+	opts, err := nd.loadOptions()
+	if err != nil {
+		return err
+	}
+	if (nd.options.HealthCheckInterval > 0) != (opts.HealthCheckInterval > 0) {
+		return errors.New("turning the health checks on or off needs a restart")
+	}
+
+	changes, ignored := diffOptions(nd.options, opts)
+	for _, name := range ignored {
+		klog.Warningf("Setting %s changed, it takes effect on the next restart", name)
+	}
+	if len(changes) == 0 {
+		klog.V(2).InfoS("No reloadable setting changed")
+		return nil
+	}
+	for _, change := range changes {
+		klog.InfoS("Reloading setting", "setting", change.name, "old", change.old, "new", change.new)
+	}
+
+	nd.mountPolicy.Store(opts.MountOptionPolicy)
+	nd.healthChecker.Reconfigure(opts.HealthCheckServers, opts.HealthCheckInterval, opts.HealthCheckTimeout)
+
+	applied := *nd.options
+	applied.MountOptionPolicy = opts.MountOptionPolicy
+	applied.HealthCheckServers = opts.HealthCheckServers
+	applied.HealthCheckInterval = opts.HealthCheckInterval
+	applied.HealthCheckTimeout = opts.HealthCheckTimeout
+	nd.options = &applied
+	return nil
+}
+
+// diffOptions returns the changed reloadable settings and the names of the other changed ones
+func diffOptions(old, new *DriverOptions) ([]optionChange, []string) {
+	var changes []optionChange
+	var ignored []string
+	oldValue, newValue := reflect.ValueOf(*old), reflect.ValueOf(*new)
+	for i := 0; i < oldValue.NumField(); i++ {
+		name := oldValue.Type().Field(i).Name
+		if uncomparedOptions[name] {
+			continue
+		}
+		o, n := oldValue.Field(i).Interface(), newValue.Field(i).Interface()
+		if reflect.DeepEqual(o, n) {
+			continue
+		}
+		if !reloadableOptions[name] {
+			ignored = append(ignored, name)
+			continue
+		}
+		changes = append(changes, optionChange{name: name, old: formatOption(o), new: formatOption(n)})
+	}
+	return changes, ignored
+}
+
+func formatOption(value interface{}) string {
+	if policy, ok := value.(*MountOptionPolicy); ok {
+		if policy == nil {
+			return "none"
+		}
+		return fmt.Sprintf("%+v", *policy)
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// watchConfigFiles reloads the driver when its config files no longer have the versions
+// last, the files are checked every configWatchInterval until stopCh is closed
+func (nd *nfsDriver) watchConfigFiles(last []string, stopCh <-chan struct{}) {
+	if len(nd.configFiles) == 0 || nd.configWatchInterval <= 0 {
+		return
+	}
+	files := nd.configFiles
+	ticker := time.NewTicker(nd.configWatchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+		current := fileVersions(files)
+		if reflect.DeepEqual(current, last) {
+			continue
+		}
+		last = current
+		klog.V(2).InfoS("Config files changed, reloading", "files", files)
+		if err := nd.Reload(); err != nil {
+			klog.Errorf("Failed to reload the changed config, keeping the running settings: %v", err)
+		}
+	}
+}
+
+// fileVersions returns the modification time and size of the files, empty for missing ones.
+// Stat follows symlinks, so a ConfigMap volume swapping its data directory is noticed.
+func fileVersions(files []string) []string {
+	versions := make([]string, len(files))
+	for i, file := range files {
+		if fi, err := os.Stat(file); err == nil {
+			versions[i] = fmt.Sprintf("%d/%d", fi.ModTime().UnixNano(), fi.Size())
+		}
+	}
+	return versions
+}
@@ -0,0 +1,127 @@
+package nfs
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/chenliu1993/simple-csi-driver/pkg/driver"
+)
+
+// newConfiguredNfsDriver creates a driver from the flags and the config file the way the
+// registration does
+func newConfiguredNfsDriver(t *testing.T, config string, args ...string) (*nfsDriver, string) {
+	t.Helper()
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, configFile, config)
+
+	f := &flags{}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	f.addFlags(fs)
+	if err := fs.Parse(append(args, "--config="+configFile)); err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	opts := &driver.Options{DriverName: DriverName, Endpoint: fakeEndpoint, NodeID: fakeNode}
+	driverOpts, err := f.driverOptions(opts)
+	if err != nil {
+		t.Fatalf("driverOptions() error = %v", err)
+	}
+	d := NewNFSDriver(driverOpts, nil)
+	d.loadOptions = func() (*DriverOptions, error) {
+		return f.driverOptions(opts)
+	}
+	return d, configFile
+}
+
+func writeConfig(t *testing.T, configFile, config string) {
+	t.Helper()
+	if err := os.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	d, configFile := newConfiguredNfsDriver(t,
+		"healthCheckServers: [a:2049]\nhealthCheckInterval: 30s\nmountOptionPolicy:\n  denied: [nolock]\n",
+		"--working-mount-dir=/tmp")
+
+	writeConfig(t, configFile, "healthCheckServers: [b:2049]\nhealthCheckInterval: 1m\nmountOptionPolicy:\n  forced: [nosuid]\n")
+	if err := d.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if got, want := d.mountPolicy.Load(), (&MountOptionPolicy{Forced: []string{"nosuid"}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("mount option policy = %+v, want %+v", got, want)
+	}
+	if _, ok := d.healthChecker.servers["b:2049"]; !ok || len(d.healthChecker.servers) != 1 {
+		t.Errorf("health checked servers = %v, want only b:2049", d.healthChecker.servers)
+	}
+	if d.healthChecker.currentInterval() != time.Minute {
+		t.Errorf("health check interval = %v, want 1m", d.healthChecker.currentInterval())
+	}
+
+	// Invalid settings keep the running ones
+	for _, config := range []string{
+		"mountOptionPolicy:\n  denied: [foo]\n",
+		"healthCheckTimeout: soon\n",
+		"healthCheckInterval: 0s\n",
+		"unknown: true\n",
+	} {
+		writeConfig(t, configFile, config)
+		if err := d.Reload(); err == nil {
+			t.Errorf("Reload() of %q succeeded", config)
+		}
+	}
+	if d.mountPolicy.Load() == nil || len(d.healthChecker.servers) != 1 {
+		t.Errorf("settings were changed by an invalid config")
+	}
+
+	// Settings which are not reloadable are left alone
+	writeConfig(t, configFile, "healthCheckServers: [b:2049]\nhealthCheckInterval: 1m\nmountOptionPolicy:\n  forced: [nosuid]\nworkingMountDir: /mnt\n")
+	if err := d.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if d.workingMountDir != "/tmp" {
+		t.Errorf("working mount dir = %s, want it kept until a restart", d.workingMountDir)
+	}
+}
+
+func TestDiffOptions(t *testing.T) {
+	old := &DriverOptions{
+		WorkingMountDir:     "/tmp",
+		HealthCheckInterval: time.Minute,
+		MountOptionPolicy:   &MountOptionPolicy{Denied: []string{"nolock"}},
+	}
+	new := &DriverOptions{
+		WorkingMountDir:     "/mnt",
+		HealthCheckInterval: time.Minute,
+	}
+
+	changes, ignored := diffOptions(old, new)
+	wantChanges := []optionChange{{name: "MountOptionPolicy", old: "{Allowed:[] Denied:[nolock] Forced:[]}", new: "none"}}
+	if !reflect.DeepEqual(changes, wantChanges) {
+		t.Errorf("diffOptions() changes = %v, want %v", changes, wantChanges)
+	}
+	if !reflect.DeepEqual(ignored, []string{"WorkingMountDir"}) {
+		t.Errorf("diffOptions() ignored = %v, want [WorkingMountDir]", ignored)
+	}
+}
+
+func TestWatchConfigFiles(t *testing.T) {
+	d, configFile := newConfiguredNfsDriver(t, "mountOptionPolicy:\n  denied: [nolock]\n", "--config-watch-interval=10ms")
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go d.watchConfigFiles(fileVersions(d.configFiles), stopCh)
+
+	writeConfig(t, configFile, "mountOptionPolicy:\n  denied: [nolock, soft]\n")
+	want := &MountOptionPolicy{Denied: []string{"nolock", "soft"}}
+	deadline := time.Now().Add(5 * time.Second)
+	for !reflect.DeepEqual(d.mountPolicy.Load(), want) {
+		if time.Now().After(deadline) {
+			t.Fatalf("mount option policy = %+v, want the changed file reloaded", d.mountPolicy.Load())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,67 @@
+package nfs
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	// Comma separated key=value topology segments, overrides the ones from the topology file
+	topologyEnvKey = "CSI_NODE_TOPOLOGY"
+)
+
+// getNodeTopology collects the topology segments of the node from the topology file
+// and the CSI_NODE_TOPOLOGY environment variable, the latter wins on duplicated keys.
+func getNodeTopology(topologyFile string) (map[string]string, error) {
+	segments := make(map[string]string)
+
+	if topologyFile != "" {
+		content, err := os.ReadFile(topologyFile)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				return nil, err
+			}
+			klog.V(4).InfoS("Topology file does not exist, skipping", "topologyFile", topologyFile)
+		}
+		scanner := bufio.NewScanner(strings.NewReader(string(content)))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			if err := parseTopologySegment(line, segments); err != nil {
+				return nil, fmt.Errorf("%s: %v", topologyFile, err)
+			}
+		}
+	}
+
+	if value := os.Getenv(topologyEnvKey); value != "" {
+		for _, pair := range strings.Split(value, ",") {
+			if strings.TrimSpace(pair) == "" {
+				continue
+			}
+			if err := parseTopologySegment(pair, segments); err != nil {
+				return nil, fmt.Errorf("%s: %v", topologyEnvKey, err)
+			}
+		}
+	}
+
+	return segments, nil
+}
+
+func parseTopologySegment(pair string, segments map[string]string) error {
+	kv := strings.SplitN(pair, "=", 2)
+	if len(kv) != 2 {
+		return fmt.Errorf("invalid topology segment %q, expecting key=value", pair)
+	}
+	key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+	if key == "" {
+		return fmt.Errorf("invalid topology segment %q, key cannot be empty", pair)
+	}
+	segments[key] = value
+	return nil
+}
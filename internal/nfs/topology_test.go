@@ -0,0 +1,78 @@
+package nfs
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGetNodeTopology(t *testing.T) {
+	topologyFile := filepath.Join(t.TempDir(), "topology")
+	content := "# node topology\ntopology.kubernetes.io/region=east\n\ntopology.kubernetes.io/zone = east-1a\n"
+	if err := os.WriteFile(topologyFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write topology file: %v", err)
+	}
+	wrongFile := filepath.Join(t.TempDir(), "wrong")
+	if err := os.WriteFile(wrongFile, []byte("zone\n"), 0644); err != nil {
+		t.Fatalf("Failed to write topology file: %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		topologyFile string
+		env          string
+		want         map[string]string
+		wantErr      bool
+	}{
+		{
+			name: "No topology",
+			want: map[string]string{},
+		},
+		{
+			name:         "Missing topology file",
+			topologyFile: filepath.Join(t.TempDir(), "missing"),
+			want:         map[string]string{},
+		},
+		{
+			name:         "Topology from file",
+			topologyFile: topologyFile,
+			want: map[string]string{
+				"topology.kubernetes.io/region": "east",
+				"topology.kubernetes.io/zone":   "east-1a",
+			},
+		},
+		{
+			name:         "Environment overrides file",
+			topologyFile: topologyFile,
+			env:          "topology.kubernetes.io/zone=east-1b,rack=r1",
+			want: map[string]string{
+				"topology.kubernetes.io/region": "east",
+				"topology.kubernetes.io/zone":   "east-1b",
+				"rack":                          "r1",
+			},
+		},
+		{
+			name:         "Wrong topology file",
+			topologyFile: wrongFile,
+			wantErr:      true,
+		},
+		{
+			name:    "Wrong environment",
+			env:     "=east",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(topologyEnvKey, tt.env)
+			got, err := getNodeTopology(tt.topologyFile)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getNodeTopology() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getNodeTopology() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package nfs
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/chenliu1993/simple-csi-driver/internal/nfsv3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// nfsDialer mounts dir of the server through the userspace nfs client
+type nfsDialer func(ctx context.Context, server, dir string) (*nfsv3.Client, error)
+
+// Check if implements Backend
+var _ Backend = &userspaceBackend{}
+
+// userspaceBackend manages the volume directories through the userspace nfs client, so the
+// controller does not need privileges to mount
+type userspaceBackend struct {
+	dial nfsDialer
+}
+
+func (b *userspaceBackend) Name() string {
+	return backendUserspace
+}
+
+// withNfsClient runs fn with a userspace client mounting server:basedir of vol
+func (b *userspaceBackend) withNfsClient(ctx context.Context, vol *Volume, fn func(c *nfsv3.Client) error) error {
+	basedir := filepath.Join(string(filepath.Separator), vol.Basedir)
+	c, err := b.dial(ctx, vol.Server, basedir)
+	if err != nil {
+		return nfsClientStatus(err, "failed to mount nfs server %s:%s", vol.Server, basedir)
+	}
+	defer func() {
+		if err := c.Close(ctx); err != nil {
+			klog.FromContext(ctx).Error(err, "Failed to close the nfs client", "server", vol.Server, "basedir", basedir)
+		}
+	}()
+	return fn(c)
+}
+
+// Provision creates the volume directory with the userspace nfs client
+func (b *userspaceBackend) Provision(ctx context.Context, vol *Volume, snapshot string) (int64, error) {
+	if snapshot != "" {
+		return 0, backendUnsupported(b, "restoring snapshots")
+	}
+	return 0, b.withNfsClient(ctx, vol, func(c *nfsv3.Client) error {
+		if _, err := c.MkdirAll(ctx, vol.Subdir, uint32(vol.Mode)); err != nil {
+			return nfsClientStatus(err, "failed to create volume directory %s", vol.Subdir)
+		}
+		return nil
+	})
+}
+
+func (b *userspaceBackend) Clone(ctx context.Context, vol *Volume, source string) (int64, error) {
+	return 0, backendUnsupported(b, "cloning")
+}
+
+// Delete removes the volume directory with the userspace nfs client
+func (b *userspaceBackend) Delete(ctx context.Context, vol *Volume) error {
+	return b.withNfsClient(ctx, vol, func(c *nfsv3.Client) error {
+		klog.FromContext(ctx).V(4).Info("Removing the volume directory", "subdir", vol.Subdir)
+		if err := c.RemoveAll(ctx, vol.Subdir); err != nil {
+			return nfsClientStatus(err, "failed to remove volume directory %s", vol.Subdir)
+		}
+		return nil
+	})
+}
+
+// Expand has nothing to do, the directories are not limited
+func (b *userspaceBackend) Expand(ctx context.Context, vol *Volume) (int64, error) {
+	return vol.CapacityBytes, nil
+}
+
+func (b *userspaceBackend) CreateSnapshot(ctx context.Context, vol *Volume, name, sourceVolId string) (time.Time, error) {
+	return time.Time{}, backendUnsupported(b, "snapshotting")
+}
+
+func (b *userspaceBackend) DeleteSnapshot(ctx context.Context, vol *Volume, name string) error {
+	return backendUnsupported(b, "snapshotting")
+}
+
+// Capacity returns the bytes available under server:basedir
+func (b *userspaceBackend) Capacity(ctx context.Context, vol *Volume) (int64, error) {
+	var available int64
+	err := b.withNfsClient(ctx, vol, func(c *nfsv3.Client) error {
+		stat, err := c.FSStat(ctx, c.Root())
+		if err != nil {
+			return nfsClientStatus(err, "failed to get the usage of %s", vol.Basedir)
+		}
+		available = int64(stat.AvailBytes)
+		return nil
+	})
+	return available, err
+}
+
+// List reads the volume directories under server:basedir
+func (b *userspaceBackend) List(ctx context.Context, vol *Volume) ([]string, error) {
+	var subdirs []string
+	err := b.withNfsClient(ctx, vol, func(c *nfsv3.Client) error {
+		entries, err := c.ReadDirPlus(ctx, c.Root())
+		if err != nil {
+			return nfsClientStatus(err, "failed to list %s", vol.Basedir)
+		}
+		for _, e := range entries {
+			if e.Attr != nil && e.Attr.Type == nfsv3.TypeDirectory && !strings.HasPrefix(e.Name, ".") {
+				subdirs = append(subdirs, e.Name)
+			}
+		}
+		return nil
+	})
+	return subdirs, err
+}
+
+// nfsClientStatus maps errors of the userspace nfs client to grpc codes
+func nfsClientStatus(err error, format string, args ...interface{}) error {
+	code := codes.Internal
+	var nfsErr nfsv3.Status
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		code = codes.DeadlineExceeded
+	case errors.Is(err, context.Canceled):
+		code = codes.Canceled
+	case errors.Is(err, nfsv3.ErrInsecurePort):
+		code = codes.FailedPrecondition
+	case errors.As(err, &nfsErr):
+		switch nfsErr {
+		case nfsv3.ErrNoEnt:
+			code = codes.NotFound
+		case nfsv3.ErrPerm, nfsv3.ErrAcces, nfsv3.ErrROFS:
+			code = codes.PermissionDenied
+		case nfsv3.ErrNoSpc, nfsv3.ErrDQuot:
+			code = codes.ResourceExhausted
+		case nfsv3.ErrNotDir, nfsv3.ErrNameTooLong, nfsv3.ErrInval:
+			code = codes.InvalidArgument
+		case nfsv3.ErrStale, nfsv3.ErrJukebox, nfsv3.ErrServerFault:
+			code = codes.Unavailable
+		}
+	default:
+		// The server could not be reached or talked to
+		code = codes.Unavailable
+	}
+	return status.Errorf(code, format+": %v", append(args, err)...)
+}
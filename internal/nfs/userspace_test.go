@@ -0,0 +1,138 @@
+package nfs
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/chenliu1993/simple-csi-driver/internal/nfsv3"
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var mountVolumeCapability = &csi.VolumeCapability{
+	AccessType: &csi.VolumeCapability_Mount{
+		Mount: &csi.VolumeCapability_MountVolume{},
+	},
+	AccessMode: &csi.VolumeCapability_AccessMode{
+		Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+	},
+}
+
+// newUserspaceControllerServer returns a controller talking to an in-process nfs server
+// exporting a temporary directory, and the address and directory of that server
+func newUserspaceControllerServer(t *testing.T) (*controllerServer, string, string) {
+	t.Helper()
+	root := t.TempDir()
+	s, err := nfsv3.NewServer(root)
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go s.Serve(l) //nolint:errcheck // stopped by Close
+	t.Cleanup(func() { s.Close() })
+
+	d := NewFakeNfsDriver(fakeNode)
+	d.dialNfs = func(ctx context.Context, server, dir string) (*nfsv3.Client, error) {
+		return nfsv3.NewClient(ctx, server, server, dir)
+	}
+	return NewControllerServer(d), l.Addr().String(), root
+}
+
+func TestUserspaceCreateDeleteVolume(t *testing.T) {
+	cs, server, root := newUserspaceControllerServer(t)
+	ctx := context.Background()
+	if err := os.Mkdir(filepath.Join(root, "export"), 0755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+
+	resp, err := cs.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:               testVolId,
+		VolumeCapabilities: []*csi.VolumeCapability{mountVolumeCapability},
+		Parameters: map[string]string{
+			serverKey:          server,
+			basedirKey:         "/export",
+			mountPermissionKey: "0750",
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume() error = %v", err)
+	}
+	volumeDir := filepath.Join(root, "export", testVolId)
+	fi, err := os.Stat(volumeDir)
+	if err != nil {
+		t.Fatalf("volume directory was not created: %v", err)
+	}
+	if fi.Mode().Perm() != 0750 {
+		t.Errorf("volume directory mode = %o, want 750", fi.Mode().Perm())
+	}
+	if err := os.WriteFile(filepath.Join(volumeDir, "data"), []byte("data"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	capacity, err := cs.GetCapacity(ctx, &csi.GetCapacityRequest{
+		Parameters: map[string]string{serverKey: server, basedirKey: "/export"},
+	})
+	if err != nil {
+		t.Fatalf("GetCapacity() error = %v", err)
+	}
+	if capacity.AvailableCapacity <= 0 {
+		t.Errorf("GetCapacity() = %d, want free space", capacity.AvailableCapacity)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := cs.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: resp.Volume.VolumeId}); err != nil {
+			t.Fatalf("DeleteVolume() error = %v", err)
+		}
+	}
+	if _, err := os.Stat(volumeDir); !os.IsNotExist(err) {
+		t.Errorf("volume directory still exists: %v", err)
+	}
+}
+
+func TestUserspaceCreateVolumeMissingBasedir(t *testing.T) {
+	cs, server, _ := newUserspaceControllerServer(t)
+	_, err := cs.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+		Name:               testVolId,
+		VolumeCapabilities: []*csi.VolumeCapability{mountVolumeCapability},
+		Parameters: map[string]string{
+			serverKey:          server,
+			basedirKey:         "/missing",
+			mountPermissionKey: "0750",
+		},
+	})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("CreateVolume() error = %v, want code %v", err, codes.NotFound)
+	}
+}
+
+func TestNfsClientStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want codes.Code
+	}{
+		{name: "missing file", err: nfsv3.ErrNoEnt, want: codes.NotFound},
+		{name: "permission", err: nfsv3.ErrAcces, want: codes.PermissionDenied},
+		{name: "quota", err: nfsv3.ErrDQuot, want: codes.ResourceExhausted},
+		{name: "stale handle", err: nfsv3.ErrStale, want: codes.Unavailable},
+		{name: "io error", err: nfsv3.ErrIO, want: codes.Internal},
+		{name: "timeout", err: context.DeadlineExceeded, want: codes.DeadlineExceeded},
+		{name: "insecure port", err: fmt.Errorf("%w: rpc: call rejected", nfsv3.ErrInsecurePort), want: codes.FailedPrecondition},
+		{name: "connection refused", err: &net.OpError{Op: "dial", Err: os.ErrNotExist}, want: codes.Unavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := status.Code(nfsClientStatus(tt.err, "failed")); got != tt.want {
+				t.Errorf("nfsClientStatus() code = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
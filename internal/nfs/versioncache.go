@@ -0,0 +1,119 @@
+package nfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"k8s.io/klog/v2"
+)
+
+// nfsVersionCache remembers the nfs version each server was successfully mounted with,
+// it is persisted to a node local file if one is configured.
+type nfsVersionCache struct {
+	lock *sync.Mutex
+
+	cacheFile string
+	versions  map[string]string
+}
+
+func newNfsVersionCache(cacheFile string) *nfsVersionCache {
+	c := &nfsVersionCache{
+		lock:      &sync.Mutex{},
+		cacheFile: cacheFile,
+		versions:  make(map[string]string),
+	}
+	if cacheFile == "" {
+		return c
+	}
+
+	content, err := os.ReadFile(cacheFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			klog.Warningf("Failed to read nfs version cache %s: %v", cacheFile, err)
+		}
+		return c
+	}
+	if err := json.Unmarshal(content, &c.versions); err != nil {
+		klog.Warningf("Ignoring corrupted nfs version cache %s: %v", cacheFile, err)
+		c.versions = make(map[string]string)
+	}
+	return c
+}
+
+func (c *nfsVersionCache) Get(server string) (string, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	version, ok := c.versions[server]
+	return version, ok
+}
+
+func (c *nfsVersionCache) Set(server, version string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.versions[server] == version {
+		return
+	}
+	c.versions[server] = version
+	if c.cacheFile == "" {
+		return
+	}
+	if err := c.save(); err != nil {
+		klog.Warningf("Failed to save nfs version cache %s: %v", c.cacheFile, err)
+	}
+}
+
+// save writes the cache through a temporary file so a crash never leaves a partial file behind
+func (c *nfsVersionCache) save() error {
+	content, err := json.Marshal(c.versions)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.cacheFile), 0755); err != nil {
+		return err
+	}
+	tmpFile := c.cacheFile + ".tmp"
+	if err := os.WriteFile(tmpFile, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, c.cacheFile)
+}
+
+// ParseNFSVersions parses the comma separated, ordered list of nfs versions to negotiate
+func ParseNFSVersions(versions string) ([]string, error) {
+	var result []string
+	seen := make(map[string]bool)
+	for _, version := range strings.Split(versions, ",") {
+		if version = strings.TrimSpace(version); version == "" {
+			continue
+		}
+		if err := validateNfsVersion(version); err != nil {
+			return nil, fmt.Errorf("invalid nfs version %q: %v", version, err)
+		}
+		if seen[version] {
+			continue
+		}
+		seen[version] = true
+		result = append(result, version)
+	}
+	return result, nil
+}
+
+// negotiationOrder returns the versions to try for the server, the cached one first
+func negotiationOrder(versions []string, cached string) []string {
+	if cached == "" {
+		return versions
+	}
+	order := []string{cached}
+	for _, version := range versions {
+		if version != cached {
+			order = append(order, version)
+		}
+	}
+	return order
+}
@@ -0,0 +1,150 @@
+package nfs
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	mount "k8s.io/mount-utils"
+)
+
+// versionMounter only accepts mounts of the given nfs versions
+type versionMounter struct {
+	*mount.FakeMounter
+
+	supported []string
+	tried     []string
+}
+
+func (m *versionMounter) Mount(source string, target string, fstype string, options []string) error {
+	for _, opt := range options {
+		if version, ok := strings.CutPrefix(opt, "nfsvers="); ok {
+			m.tried = append(m.tried, version)
+			for _, v := range m.supported {
+				if v == version {
+					return m.FakeMounter.Mount(source, target, fstype, options)
+				}
+			}
+		}
+	}
+	return errors.New("mount failed: exit status 32\nOutput: mount.nfs: Protocol not supported")
+}
+
+func TestNfsVersionCache(t *testing.T) {
+	cacheFile := filepath.Join(t.TempDir(), "cache", "versions.json")
+
+	cache := newNfsVersionCache(cacheFile)
+	if _, ok := cache.Get(testServer); ok {
+		t.Fatalf("Expected empty cache")
+	}
+	cache.Set(testServer, "4.1")
+
+	reloaded := newNfsVersionCache(cacheFile)
+	if version, ok := reloaded.Get(testServer); !ok || version != "4.1" {
+		t.Errorf("Expected cached version 4.1, got %q", version)
+	}
+}
+
+func TestParseNFSVersions(t *testing.T) {
+	tests := []struct {
+		name     string
+		versions string
+		want     []string
+		wantErr  bool
+	}{
+		{
+			name:     "Empty versions",
+			versions: "",
+			want:     nil,
+		},
+		{
+			name:     "Ordered versions",
+			versions: "4.2, 4.1,4,3,4.1",
+			want:     []string{"4.2", "4.1", "4", "3"},
+		},
+		{
+			name:     "Invalid version",
+			versions: "4.2,5",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseNFSVersions(tt.versions)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseNFSVersions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseNFSVersions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNegotiationOrder(t *testing.T) {
+	versions := []string{"4.2", "4.1", "3"}
+	if got := negotiationOrder(versions, ""); !reflect.DeepEqual(got, versions) {
+		t.Errorf("negotiationOrder() = %v, want %v", got, versions)
+	}
+	if got, want := negotiationOrder(versions, "3"), []string{"3", "4.2", "4.1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("negotiationOrder() = %v, want %v", got, want)
+	}
+}
+
+func TestNodePublishVolumeVersionNegotiation(t *testing.T) {
+	driver := NewFakeNfsDriver(fakeNode)
+	driver.nfsVersions = []string{"4.2", "4.1", "3"}
+	driver.versionCache = newNfsVersionCache("")
+
+	newReq := func(targetPath, mountOptions string) *csi.NodePublishVolumeRequest {
+		return &csi.NodePublishVolumeRequest{
+			VolumeId:   testVolId,
+			TargetPath: targetPath,
+			VolumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{
+					Mount: &csi.VolumeCapability_MountVolume{},
+				},
+			},
+			VolumeContext: map[string]string{
+				mountPermissionKey: "0",
+				serverKey:          testServer,
+				basedirKey:         testBasePath,
+				mountOptionsKey:    mountOptions,
+			},
+		}
+	}
+
+	mounter := &versionMounter{FakeMounter: mount.NewFakeMounter([]mount.MountPoint{}), supported: []string{"3"}}
+	ns := newFakeNodeServer(driver, mounter)
+	if _, err := ns.NodePublishVolume(context.Background(), newReq(filepath.Join(t.TempDir(), "target"), "")); err != nil {
+		t.Fatalf("NodePublishVolume() error = %v", err)
+	}
+	if want := []string{"4.2", "4.1", "3"}; !reflect.DeepEqual(mounter.tried, want) {
+		t.Errorf("Tried versions %v, want %v", mounter.tried, want)
+	}
+	if version, _ := driver.versionCache.Get(testServer); version != "3" {
+		t.Errorf("Cached version %q, want 3", version)
+	}
+
+	// The cached version is tried first
+	mounter.tried = nil
+	if _, err := ns.NodePublishVolume(context.Background(), newReq(filepath.Join(t.TempDir(), "target"), "")); err != nil {
+		t.Fatalf("NodePublishVolume() error = %v", err)
+	}
+	if want := []string{"3"}; !reflect.DeepEqual(mounter.tried, want) {
+		t.Errorf("Tried versions %v, want %v", mounter.tried, want)
+	}
+
+	// A requested version is not negotiated
+	mounter.tried = nil
+	if _, err := ns.NodePublishVolume(context.Background(), newReq(filepath.Join(t.TempDir(), "target"), "nfsvers=4.1")); err == nil {
+		t.Errorf("Expected NodePublishVolume() to fail with an unsupported version")
+	}
+	if want := []string{"4.1"}; !reflect.DeepEqual(mounter.tried, want) {
+		t.Errorf("Tried versions %v, want %v", mounter.tried, want)
+	}
+}
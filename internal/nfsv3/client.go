@@ -0,0 +1,514 @@
+package nfsv3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/chenliu1993/simple-csi-driver/internal/oncrpc"
+)
+
+const (
+	// Used when the portmapper of the server cannot be reached
+	defaultPort = 2049
+
+	// Sizes asked for in READDIRPLUS replies
+	readdirDirCount = 8 << 10
+	readdirMaxCount = 64 << 10
+)
+
+// ErrInsecurePort is returned when the server refused the credentials of the client. The
+// client connects from an unprivileged port, which knfsd refuses for exports without the
+// insecure option.
+var ErrInsecurePort = errors.New("nfs: server refused the calls of the client, its exports need the insecure option to accept unprivileged ports")
+
+// Client talks NFSv3 to a single export of a server as root (AUTH_SYS uid 0),
+// calls are serialized over one connection.
+type Client struct {
+	mountAddr string
+	dir       string
+	root      FileHandle
+
+	rpc *oncrpc.Client
+}
+
+// Dial mounts dir of the server (host or host:port). The MOUNT and NFS ports are asked from the
+// portmapper, if it cannot be reached both are expected on the port of the server address.
+func Dial(ctx context.Context, server, dir string) (*Client, error) {
+	host, port, err := net.SplitHostPort(server)
+	if err != nil {
+		host, port = server, ""
+	}
+
+	mountAddr, err := MountAddress(ctx, host)
+	if err != nil {
+		if port == "" {
+			return nil, err
+		}
+		mountAddr = server
+	}
+	nfsAddr := server
+	if port == "" {
+		nfsPort, err := oncrpc.GetPort(ctx, net.JoinHostPort(host, strconv.Itoa(oncrpc.PortmapPort)), oncrpc.ProgNFS, Version)
+		if err != nil {
+			nfsPort = defaultPort
+		}
+		nfsAddr = net.JoinHostPort(host, strconv.Itoa(int(nfsPort)))
+	}
+	return NewClient(ctx, mountAddr, nfsAddr, dir)
+}
+
+// NewClient mounts dir through the MOUNT service at mountAddr and connects to the NFS service at nfsAddr
+func NewClient(ctx context.Context, mountAddr, nfsAddr, dir string) (*Client, error) {
+	auth := rootAuth()
+	mountClient, err := oncrpc.Dial(ctx, mountAddr)
+	if err != nil {
+		return nil, err
+	}
+	defer mountClient.Close()
+	mountClient.Auth = auth
+
+	root, err := mount(ctx, mountClient, dir)
+	if err != nil {
+		return nil, authError(err)
+	}
+
+	rpc, err := oncrpc.Dial(ctx, nfsAddr)
+	if err != nil {
+		return nil, err
+	}
+	rpc.Auth = auth
+	return &Client{
+		mountAddr: mountAddr,
+		dir:       dir,
+		root:      root,
+		rpc:       rpc,
+	}, nil
+}
+
+func rootAuth() oncrpc.Auth {
+	hostname, _ := os.Hostname()
+	return oncrpc.NewAuthSys(hostname, 0, 0, nil)
+}
+
+// Root returns the handle of the mounted directory
+func (c *Client) Root() FileHandle {
+	return c.root
+}
+
+// Close tells the server the directory is no longer mounted and closes the connection
+func (c *Client) Close(ctx context.Context) error {
+	if mountClient, err := oncrpc.Dial(ctx, c.mountAddr); err == nil {
+		mountClient.Auth = c.rpc.Auth
+		// Best effort, the server does not rely on it
+		_ = unmount(ctx, mountClient, c.dir)
+		mountClient.Close()
+	}
+	return c.rpc.Close()
+}
+
+func (c *Client) call(ctx context.Context, proc uint32, args *oncrpc.Writer) (*oncrpc.Reader, error) {
+	results, err := c.rpc.Call(ctx, oncrpc.ProgNFS, Version, proc, args.Bytes())
+	if err != nil {
+		return nil, authError(err)
+	}
+	return oncrpc.NewReader(results), nil
+}
+
+// authError tells rejected credentials apart from other failures of a call
+func authError(err error) error {
+	var rejectErr *oncrpc.RejectError
+	if errors.As(err, &rejectErr) && !rejectErr.RPCMismatch {
+		return fmt.Errorf("%w: %v", ErrInsecurePort, err)
+	}
+	return err
+}
+
+func encodeDirOpArgs(w *oncrpc.Writer, dir FileHandle, name string) {
+	encodeFileHandle(w, dir)
+	w.String(name)
+}
+
+// Getattr returns the attributes of the file
+func (c *Client) Getattr(ctx context.Context, fh FileHandle) (*Attr, error) {
+	w := oncrpc.NewWriter()
+	encodeFileHandle(w, fh)
+	r, err := c.call(ctx, procGetattr, w)
+	if err != nil {
+		return nil, err
+	}
+	if err := decodeStatus(r); err != nil {
+		return nil, err
+	}
+	return decodeAttr(r)
+}
+
+// Setattr changes the attributes of the file
+func (c *Client) Setattr(ctx context.Context, fh FileHandle, attr SetAttr) error {
+	w := oncrpc.NewWriter()
+	encodeFileHandle(w, fh)
+	encodeSetAttr(w, attr)
+	// No guard on the ctime of the file
+	w.Bool(false)
+	r, err := c.call(ctx, procSetattr, w)
+	if err != nil {
+		return err
+	}
+	return decodeStatus(r)
+}
+
+// Lookup returns the handle and attributes of name in dir
+func (c *Client) Lookup(ctx context.Context, dir FileHandle, name string) (FileHandle, *Attr, error) {
+	w := oncrpc.NewWriter()
+	encodeDirOpArgs(w, dir, name)
+	r, err := c.call(ctx, procLookup, w)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := decodeStatus(r); err != nil {
+		return nil, nil, err
+	}
+	fh, err := decodeFileHandle(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	attr, err := decodePostOpAttr(r)
+	return fh, attr, err
+}
+
+// Mkdir creates the directory name in dir with the given mode
+func (c *Client) Mkdir(ctx context.Context, dir FileHandle, name string, mode uint32) (FileHandle, error) {
+	w := oncrpc.NewWriter()
+	encodeDirOpArgs(w, dir, name)
+	encodeSetAttr(w, SetAttr{Mode: &mode})
+	r, err := c.call(ctx, procMkdir, w)
+	if err != nil {
+		return nil, err
+	}
+	if err := decodeStatus(r); err != nil {
+		return nil, err
+	}
+	fh, err := decodePostOpFileHandle(r)
+	if err != nil {
+		return nil, err
+	}
+	if fh == nil {
+		// The server may leave the handle out, ask for it
+		fh, _, err = c.Lookup(ctx, dir, name)
+	}
+	return fh, err
+}
+
+// Create creates the regular file name in dir with the given mode, failing if it exists
+func (c *Client) Create(ctx context.Context, dir FileHandle, name string, mode uint32) (FileHandle, error) {
+	w := oncrpc.NewWriter()
+	encodeDirOpArgs(w, dir, name)
+	w.Uint32(createGuarded)
+	encodeSetAttr(w, SetAttr{Mode: &mode})
+	r, err := c.call(ctx, procCreate, w)
+	if err != nil {
+		return nil, err
+	}
+	if err := decodeStatus(r); err != nil {
+		return nil, err
+	}
+	fh, err := decodePostOpFileHandle(r)
+	if err != nil {
+		return nil, err
+	}
+	if fh == nil {
+		fh, _, err = c.Lookup(ctx, dir, name)
+	}
+	return fh, err
+}
+
+// Write writes data at offset of the file and waits for it to be stable
+func (c *Client) Write(ctx context.Context, fh FileHandle, offset uint64, data []byte) (int, error) {
+	w := oncrpc.NewWriter()
+	encodeFileHandle(w, fh)
+	w.Uint64(offset)
+	w.Uint32(uint32(len(data)))
+	w.Uint32(FileSync)
+	w.Opaque(data)
+	r, err := c.call(ctx, procWrite, w)
+	if err != nil {
+		return 0, err
+	}
+	if err := decodeStatus(r); err != nil {
+		return 0, err
+	}
+	if err := decodeWcc(r); err != nil {
+		return 0, err
+	}
+	count, err := r.Uint32()
+	return int(count), err
+}
+
+// Read reads up to count bytes at offset of the file and reports whether the end was reached
+func (c *Client) Read(ctx context.Context, fh FileHandle, offset uint64, count uint32) ([]byte, bool, error) {
+	w := oncrpc.NewWriter()
+	encodeFileHandle(w, fh)
+	w.Uint64(offset)
+	w.Uint32(count)
+	r, err := c.call(ctx, procRead, w)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := decodeStatus(r); err != nil {
+		return nil, false, err
+	}
+	if _, err := decodePostOpAttr(r); err != nil {
+		return nil, false, err
+	}
+	if _, err := r.Uint32(); err != nil {
+		return nil, false, err
+	}
+	eof, err := r.Bool()
+	if err != nil {
+		return nil, false, err
+	}
+	data, err := r.Opaque(int(count))
+	return data, eof, err
+}
+
+// Rename moves fromName in fromDir to toName in toDir, replacing what is there
+func (c *Client) Rename(ctx context.Context, fromDir FileHandle, fromName string, toDir FileHandle, toName string) error {
+	w := oncrpc.NewWriter()
+	encodeDirOpArgs(w, fromDir, fromName)
+	encodeDirOpArgs(w, toDir, toName)
+	r, err := c.call(ctx, procRename, w)
+	if err != nil {
+		return err
+	}
+	return decodeStatus(r)
+}
+
+// Remove removes the file name from dir
+func (c *Client) Remove(ctx context.Context, dir FileHandle, name string) error {
+	return c.remove(ctx, procRemove, dir, name)
+}
+
+// Rmdir removes the empty directory name from dir
+func (c *Client) Rmdir(ctx context.Context, dir FileHandle, name string) error {
+	return c.remove(ctx, procRmdir, dir, name)
+}
+
+func (c *Client) remove(ctx context.Context, proc uint32, dir FileHandle, name string) error {
+	w := oncrpc.NewWriter()
+	encodeDirOpArgs(w, dir, name)
+	r, err := c.call(ctx, proc, w)
+	if err != nil {
+		return err
+	}
+	return decodeStatus(r)
+}
+
+// ReadDirPlus returns every entry of dir but "." and ".."
+func (c *Client) ReadDirPlus(ctx context.Context, dir FileHandle) ([]DirEntry, error) {
+	var entries []DirEntry
+	var cookie uint64
+	cookieVerf := make([]byte, cookieVerfLength)
+	for {
+		w := oncrpc.NewWriter()
+		encodeFileHandle(w, dir)
+		w.Uint64(cookie)
+		w.FixedOpaque(cookieVerf)
+		w.Uint32(readdirDirCount)
+		w.Uint32(readdirMaxCount)
+		r, err := c.call(ctx, procReaddirplus, w)
+		if err != nil {
+			return nil, err
+		}
+		if err := decodeStatus(r); err != nil {
+			return nil, err
+		}
+		if _, err := decodePostOpAttr(r); err != nil {
+			return nil, err
+		}
+		if cookieVerf, err = r.FixedOpaque(cookieVerfLength); err != nil {
+			return nil, err
+		}
+
+		read := 0
+		for {
+			more, err := r.Bool()
+			if err != nil {
+				return nil, err
+			}
+			if !more {
+				break
+			}
+			read++
+			entry, err := decodeDirEntry(r)
+			if err != nil {
+				return nil, err
+			}
+			cookie = entry.Cookie
+			if entry.Name != "." && entry.Name != ".." {
+				entries = append(entries, *entry)
+			}
+		}
+		eof, err := r.Bool()
+		if err != nil {
+			return nil, err
+		}
+		if eof {
+			return entries, nil
+		}
+		if read == 0 {
+			return nil, errors.New("nfs: READDIRPLUS made no progress")
+		}
+	}
+}
+
+func decodeDirEntry(r *oncrpc.Reader) (*DirEntry, error) {
+	entry := &DirEntry{}
+	var err error
+	if entry.Fileid, err = r.Uint64(); err != nil {
+		return nil, err
+	}
+	if entry.Name, err = r.String(maxDirEntryName); err != nil {
+		return nil, err
+	}
+	if entry.Cookie, err = r.Uint64(); err != nil {
+		return nil, err
+	}
+	if entry.Attr, err = decodePostOpAttr(r); err != nil {
+		return nil, err
+	}
+	if entry.Handle, err = decodePostOpFileHandle(r); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// FSStat returns the usage of the file system holding the file
+func (c *Client) FSStat(ctx context.Context, fh FileHandle) (*FSStat, error) {
+	w := oncrpc.NewWriter()
+	encodeFileHandle(w, fh)
+	r, err := c.call(ctx, procFsstat, w)
+	if err != nil {
+		return nil, err
+	}
+	if err := decodeStatus(r); err != nil {
+		return nil, err
+	}
+	if _, err := decodePostOpAttr(r); err != nil {
+		return nil, err
+	}
+	stat := &FSStat{}
+	for _, v := range []*uint64{&stat.TotalBytes, &stat.FreeBytes, &stat.AvailBytes, &stat.TotalFiles, &stat.FreeFiles, &stat.AvailFiles} {
+		if *v, err = r.Uint64(); err != nil {
+			return nil, err
+		}
+	}
+	return stat, nil
+}
+
+// splitPath returns the elements of a slash separated path relative to the mounted directory,
+// ".." never leaves it.
+func splitPath(p string) []string {
+	p = path.Clean("/" + p)
+	if p == "/" {
+		return nil
+	}
+	return strings.Split(strings.TrimPrefix(p, "/"), "/")
+}
+
+// LookupPath walks the path relative to the mounted directory
+func (c *Client) LookupPath(ctx context.Context, p string) (FileHandle, error) {
+	var err error
+	fh := c.root
+	for _, name := range splitPath(p) {
+		if fh, _, err = c.Lookup(ctx, fh, name); err != nil {
+			return nil, err
+		}
+	}
+	return fh, nil
+}
+
+// MkdirAll creates the path relative to the mounted directory and any missing parent with mode,
+// existing directories are left alone.
+func (c *Client) MkdirAll(ctx context.Context, p string, mode uint32) (FileHandle, error) {
+	fh := c.root
+	for _, name := range splitPath(p) {
+		next, attr, err := c.Lookup(ctx, fh, name)
+		switch {
+		case err == nil:
+			if attr != nil && attr.Type != TypeDirectory {
+				return nil, ErrNotDir
+			}
+		case errors.Is(err, ErrNoEnt):
+			next, err = c.Mkdir(ctx, fh, name, mode)
+			// Lost a race with another client
+			if errors.Is(err, ErrExist) {
+				next, _, err = c.Lookup(ctx, fh, name)
+			}
+			if err != nil {
+				return nil, err
+			}
+		default:
+			return nil, err
+		}
+		fh = next
+	}
+	return fh, nil
+}
+
+// RemoveAll removes the path relative to the mounted directory and everything below it,
+// a missing path is not an error.
+func (c *Client) RemoveAll(ctx context.Context, p string) error {
+	names := splitPath(p)
+	if len(names) == 0 {
+		return errors.New("nfs: refusing to remove the mounted directory")
+	}
+	parent, err := c.LookupPath(ctx, path.Join(names[:len(names)-1]...))
+	if errors.Is(err, ErrNoEnt) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return c.removeAll(ctx, parent, names[len(names)-1])
+}
+
+func (c *Client) removeAll(ctx context.Context, dir FileHandle, name string) error {
+	fh, attr, err := c.Lookup(ctx, dir, name)
+	if errors.Is(err, ErrNoEnt) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if attr == nil {
+		if attr, err = c.Getattr(ctx, fh); err != nil {
+			return err
+		}
+	}
+	if attr.Type != TypeDirectory {
+		return ignoreNotExist(c.Remove(ctx, dir, name))
+	}
+
+	entries, err := c.ReadDirPlus(ctx, fh)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := c.removeAll(ctx, fh, entry.Name); err != nil {
+			return err
+		}
+	}
+	return ignoreNotExist(c.Rmdir(ctx, dir, name))
+}
+
+func ignoreNotExist(err error) error {
+	if errors.Is(err, ErrNoEnt) {
+		return nil
+	}
+	return err
+}
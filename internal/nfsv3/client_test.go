@@ -0,0 +1,276 @@
+package nfsv3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/chenliu1993/simple-csi-driver/internal/oncrpc"
+)
+
+// startServer serves a temporary directory and returns a client mounting dir of it
+func startServer(t *testing.T, dir string) (string, *Client) {
+	t.Helper()
+	root := t.TempDir()
+	s, err := NewServer(root)
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go s.Serve(l) //nolint:errcheck // stopped by Close
+	t.Cleanup(func() { s.Close() })
+
+	if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+		t.Fatalf("Failed to create %s: %v", dir, err)
+	}
+	c, err := NewClient(context.Background(), l.Addr().String(), l.Addr().String(), dir)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	t.Cleanup(func() { c.Close(context.Background()) })
+	return filepath.Join(root, dir), c
+}
+
+func TestMkdirAll(t *testing.T) {
+	root, c := startServer(t, "/export")
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.MkdirAll(ctx, "a/b/c", 0750); err != nil {
+			t.Fatalf("MkdirAll() error = %v", err)
+		}
+	}
+	fi, err := os.Stat(filepath.Join(root, "a/b/c"))
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if fi.Mode().Perm() != 0750 {
+		t.Errorf("mode = %o, want 750", fi.Mode().Perm())
+	}
+
+	fh, err := c.LookupPath(ctx, "/a/b/c")
+	if err != nil {
+		t.Fatalf("LookupPath() error = %v", err)
+	}
+	attr, err := c.Getattr(ctx, fh)
+	if err != nil {
+		t.Fatalf("Getattr() error = %v", err)
+	}
+	if attr.Type != TypeDirectory || attr.Mode != 0750 {
+		t.Errorf("Getattr() = type %d mode %o, want a 750 directory", attr.Type, attr.Mode)
+	}
+
+	if err := os.WriteFile(filepath.Join(root, "file"), nil, 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if _, err := c.MkdirAll(ctx, "file/sub", 0750); !errors.Is(err, ErrNotDir) {
+		t.Errorf("MkdirAll() under a file error = %v, want %v", err, ErrNotDir)
+	}
+	// ".." does not leave the export
+	if _, err := c.MkdirAll(ctx, "../escape", 0750); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "escape")); err != nil {
+		t.Errorf("escape was not created in the export: %v", err)
+	}
+}
+
+func TestSetattr(t *testing.T) {
+	root, c := startServer(t, "/")
+	ctx := context.Background()
+
+	fh, err := c.Mkdir(ctx, c.Root(), "dir", 0700)
+	if err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	mode := uint32(0777)
+	if err := c.Setattr(ctx, fh, SetAttr{Mode: &mode}); err != nil {
+		t.Fatalf("Setattr() error = %v", err)
+	}
+	fi, err := os.Stat(filepath.Join(root, "dir"))
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if fi.Mode().Perm() != 0777 {
+		t.Errorf("mode = %o, want 777", fi.Mode().Perm())
+	}
+}
+
+func TestReadDirPlus(t *testing.T) {
+	root, c := startServer(t, "/export")
+	ctx := context.Background()
+
+	// Enough entries to need several replies
+	var want []string
+	for i := 0; i < 1000; i++ {
+		name := fmt.Sprintf("%s-%04d", strings.Repeat("x", 100), i)
+		if err := os.WriteFile(filepath.Join(root, name), nil, 0644); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+		want = append(want, name)
+	}
+
+	entries, err := c.ReadDirPlus(ctx, c.Root())
+	if err != nil {
+		t.Fatalf("ReadDirPlus() error = %v", err)
+	}
+	var got []string
+	for _, entry := range entries {
+		if entry.Handle == nil || entry.Attr == nil || entry.Attr.Type != TypeRegular {
+			t.Fatalf("entry %s misses its handle or attributes", entry.Name)
+		}
+		got = append(got, entry.Name)
+	}
+	sort.Strings(got)
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("ReadDirPlus() returned %d entries, want %d", len(got), len(want))
+	}
+}
+
+func TestRemoveAll(t *testing.T) {
+	root, c := startServer(t, "/export")
+	ctx := context.Background()
+
+	if err := os.MkdirAll(filepath.Join(root, "vol/a/b"), 0755); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	for _, name := range []string{"vol/file", "vol/a/file", "vol/a/b/file"} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte("data"), 0644); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+
+	if err := c.RemoveAll(ctx, "vol"); err != nil {
+		t.Fatalf("RemoveAll() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "vol")); !os.IsNotExist(err) {
+		t.Errorf("vol still exists: %v", err)
+	}
+	if err := c.RemoveAll(ctx, "vol"); err != nil {
+		t.Errorf("RemoveAll() of a missing path error = %v", err)
+	}
+	if err := c.RemoveAll(ctx, "missing/vol"); err != nil {
+		t.Errorf("RemoveAll() under a missing parent error = %v", err)
+	}
+	if err := c.RemoveAll(ctx, "/"); err == nil {
+		t.Errorf("RemoveAll() of the export should fail")
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	root, c := startServer(t, "/")
+	ctx := context.Background()
+
+	if err := os.MkdirAll(filepath.Join(root, "dir/sub"), 0755); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "file"), nil, 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	if _, _, err := c.Lookup(ctx, c.Root(), "missing"); !errors.Is(err, ErrNoEnt) {
+		t.Errorf("Lookup() error = %v, want %v", err, ErrNoEnt)
+	}
+	if err := c.Rmdir(ctx, c.Root(), "dir"); !errors.Is(err, ErrNotEmpty) {
+		t.Errorf("Rmdir() error = %v, want %v", err, ErrNotEmpty)
+	}
+	if err := c.Remove(ctx, c.Root(), "dir"); !errors.Is(err, ErrIsDir) {
+		t.Errorf("Remove() error = %v, want %v", err, ErrIsDir)
+	}
+	if err := c.Rmdir(ctx, c.Root(), "file"); !errors.Is(err, ErrNotDir) {
+		t.Errorf("Rmdir() error = %v, want %v", err, ErrNotDir)
+	}
+	if _, err := c.Mkdir(ctx, c.Root(), "dir", 0755); !errors.Is(err, ErrExist) {
+		t.Errorf("Mkdir() error = %v, want %v", err, ErrExist)
+	}
+
+	// ".." does not leave the export
+	fh, _, err := c.Lookup(ctx, c.Root(), "..")
+	if err != nil {
+		t.Fatalf("Lookup(..) error = %v", err)
+	}
+	if string(fh) != string(c.Root()) {
+		t.Errorf("Lookup(..) of the root = %x, want %x", fh, c.Root())
+	}
+
+	// Handles of removed files are stale
+	sub, err := c.LookupPath(ctx, "dir/sub")
+	if err != nil {
+		t.Fatalf("LookupPath() error = %v", err)
+	}
+	if err := os.Remove(filepath.Join(root, "dir/sub")); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if _, err := c.Getattr(ctx, sub); !errors.Is(err, ErrStale) {
+		t.Errorf("Getattr() error = %v, want %v", err, ErrStale)
+	}
+
+	stat, err := c.FSStat(ctx, c.Root())
+	if err != nil {
+		t.Fatalf("FSStat() error = %v", err)
+	}
+	if stat.TotalBytes == 0 || stat.AvailBytes > stat.TotalBytes {
+		t.Errorf("FSStat() = %+v", stat)
+	}
+}
+
+func TestMountMissingDir(t *testing.T) {
+	s, err := NewServer(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go s.Serve(l) //nolint:errcheck // stopped by Close
+	defer s.Close()
+
+	_, err = NewClient(context.Background(), l.Addr().String(), l.Addr().String(), "/missing")
+	if !errors.Is(err, ErrNoEnt) {
+		t.Errorf("NewClient() error = %v, want %v", err, ErrNoEnt)
+	}
+}
+
+func TestMountRejected(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		results []byte
+		want    error
+	}{
+		{name: "credential too weak", err: &oncrpc.RejectError{AuthStat: oncrpc.AuthTooWeak}, want: ErrInsecurePort},
+		{name: "credential rejected", err: &oncrpc.RejectError{AuthStat: oncrpc.AuthRejectedCred}, want: ErrInsecurePort},
+		{name: "access denied", results: []byte{0, 0, 0, byte(ErrAcces)}, want: ErrAcces},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := oncrpc.NewServer()
+			s.Register(oncrpc.ProgMount, MountVersion, map[uint32]oncrpc.Handler{
+				mountProcMnt: func(call *oncrpc.Call) ([]byte, error) {
+					return tt.results, tt.err
+				},
+			})
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("Failed to listen: %v", err)
+			}
+			go s.Serve(l) //nolint:errcheck // stopped by Close
+			defer s.Close()
+
+			_, err = NewClient(context.Background(), l.Addr().String(), l.Addr().String(), "/export")
+			if !errors.Is(err, tt.want) || !strings.Contains(err.Error(), "insecure") {
+				t.Errorf("NewClient() error = %v, want %v mentioning the insecure option", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+// Package nfsv3 implements the client side of the NFSv3 and MOUNTv3 protocols (RFC 1813)
+// on top of the oncrpc package.
+package nfsv3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/chenliu1993/simple-csi-driver/internal/oncrpc"
+)
+
+const (
+	// MountVersion is the version of the MOUNT program spoken by the client
+	MountVersion = 3
+
+	mountProcNull   = 0
+	mountProcMnt    = 1
+	mountProcUmnt   = 3
+	mountProcExport = 5
+
+	maxPathLen = 1024
+	maxNameLen = 255
+)
+
+// Export is an exported directory and the client groups allowed to mount it
+type Export struct {
+	Dir    string
+	Groups []string
+}
+
+// MountAddress asks the portmapper of host for the address of its MOUNT service,
+// a port in host is ignored since it belongs to the nfs service.
+func MountAddress(ctx context.Context, host string) (string, error) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	port, err := oncrpc.GetPort(ctx, net.JoinHostPort(host, strconv.Itoa(oncrpc.PortmapPort)), oncrpc.ProgMount, MountVersion)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(port))), nil
+}
+
+// GetExports returns the export list of the MOUNT service at addr
+func GetExports(ctx context.Context, addr string) ([]Export, error) {
+	c, err := oncrpc.Dial(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	results, err := c.Call(ctx, oncrpc.ProgMount, MountVersion, mountProcExport, nil)
+	if err != nil {
+		return nil, err
+	}
+	return decodeExports(oncrpc.NewReader(results))
+}
+
+func decodeExports(r *oncrpc.Reader) ([]Export, error) {
+	var exports []Export
+	for {
+		more, err := r.Bool()
+		if err != nil || !more {
+			return exports, err
+		}
+		var export Export
+		if export.Dir, err = r.String(maxPathLen); err != nil {
+			return nil, err
+		}
+		for {
+			more, err := r.Bool()
+			if err != nil {
+				return nil, err
+			}
+			if !more {
+				break
+			}
+			group, err := r.String(maxNameLen)
+			if err != nil {
+				return nil, err
+			}
+			export.Groups = append(export.Groups, group)
+		}
+		exports = append(exports, export)
+	}
+}
+
+func encodeExports(w *oncrpc.Writer, exports []Export) {
+	for _, export := range exports {
+		w.Bool(true)
+		w.String(export.Dir)
+		for _, group := range export.Groups {
+			w.Bool(true)
+			w.String(group)
+		}
+		w.Bool(false)
+	}
+	w.Bool(false)
+}
+
+// mount issues MNT for dir and returns the handle of the mounted directory
+func mount(ctx context.Context, c *oncrpc.Client, dir string) (FileHandle, error) {
+	w := oncrpc.NewWriter()
+	w.String(dir)
+	results, err := c.Call(ctx, oncrpc.ProgMount, MountVersion, mountProcMnt, w.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	r := oncrpc.NewReader(results)
+	if err := decodeStatus(r); err != nil {
+		if errors.Is(err, ErrAcces) {
+			// mountd answers calls from unprivileged ports to secure exports this way too
+			return nil, fmt.Errorf("mount %s: %w, the export has to allow this host and, with the insecure option, unprivileged ports", dir, err)
+		}
+		return nil, fmt.Errorf("mount %s: %w", dir, err)
+	}
+	// The accepted auth flavors which follow are not needed, AUTH_SYS is always sent
+	return decodeFileHandle(r)
+}
+
+// unmount issues UMNT for dir, the server only uses it for its list of mounts
+func unmount(ctx context.Context, c *oncrpc.Client, dir string) error {
+	w := oncrpc.NewWriter()
+	w.String(dir)
+	_, err := c.Call(ctx, oncrpc.ProgMount, MountVersion, mountProcUmnt, w.Bytes())
+	return err
+}
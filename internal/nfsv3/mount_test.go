@@ -0,0 +1,68 @@
+package nfsv3
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/chenliu1993/simple-csi-driver/internal/oncrpc"
+)
+
+func startMountServer(t *testing.T, exports []Export) string {
+	t.Helper()
+	s := oncrpc.NewServer()
+	s.Register(oncrpc.ProgMount, MountVersion, map[uint32]oncrpc.Handler{
+		mountProcExport: func(call *oncrpc.Call) ([]byte, error) {
+			w := oncrpc.NewWriter()
+			encodeExports(w, exports)
+			return w.Bytes(), nil
+		},
+	})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go s.Serve(l) //nolint:errcheck // stopped by Close
+	t.Cleanup(func() { s.Close() })
+	return l.Addr().String()
+}
+
+func TestGetExports(t *testing.T) {
+	tests := []struct {
+		name    string
+		exports []Export
+	}{
+		{
+			name: "no exports",
+		},
+		{
+			name: "exports with and without groups",
+			exports: []Export{
+				{Dir: "/srv/nfs", Groups: []string{"10.0.0.0/8", "*.example.com"}},
+				{Dir: "/data"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := startMountServer(t, tt.exports)
+			got, err := GetExports(context.Background(), addr)
+			if err != nil {
+				t.Fatalf("GetExports() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.exports) {
+				t.Errorf("GetExports() = %v, want %v", got, tt.exports)
+			}
+		})
+	}
+}
+
+func TestDecodeExportsTruncated(t *testing.T) {
+	w := oncrpc.NewWriter()
+	w.Bool(true)
+	w.String("/srv/nfs")
+	if _, err := decodeExports(oncrpc.NewReader(w.Bytes())); err == nil {
+		t.Errorf("decodeExports() of a truncated list should fail")
+	}
+}
@@ -0,0 +1,1142 @@
+package nfsv3
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/chenliu1993/simple-csi-driver/internal/oncrpc"
+)
+
+const (
+	// Room kept in READDIRPLUS replies for everything but the entries
+	readdirReplyOverhead = 256
+)
+
+var errGarbageArgs = &oncrpc.AcceptError{Stat: oncrpc.GarbageArgs}
+
+// Server serves a local directory over MOUNTv3 and NFSv3 as the export "/". It is meant for
+// tests and development: handles do not survive a restart, every caller is root and symlinks
+// in the middle of a path are followed.
+type Server struct {
+	root string
+	rpc  *oncrpc.Server
+
+	// Changes whenever the server restarts so clients resend unstable writes
+	writeVerf []byte
+
+	lock *sync.Mutex
+	// handle id -> slash separated path relative to root, "" is the root
+	paths  map[uint64]string
+	ids    map[string]uint64
+	nextID uint64
+}
+
+// NewServer returns a server exporting the directory root
+func NewServer(root string) (*Server, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", root)
+	}
+
+	s := &Server{
+		root:   root,
+		rpc:    oncrpc.NewServer(),
+		lock:   &sync.Mutex{},
+		paths:  make(map[uint64]string),
+		ids:    make(map[string]uint64),
+		nextID: 1,
+	}
+	s.writeVerf = make([]byte, cookieVerfLength)
+	binary.BigEndian.PutUint64(s.writeVerf, uint64(time.Now().UnixNano()))
+	s.rpc.Register(oncrpc.ProgMount, MountVersion, map[uint32]oncrpc.Handler{
+		mountProcMnt:    s.handleMnt,
+		mountProcUmnt:   s.handleUmnt,
+		mountProcExport: s.handleExport,
+	})
+	s.rpc.Register(oncrpc.ProgNFS, Version, map[uint32]oncrpc.Handler{
+		procGetattr:     s.handleGetattr,
+		procSetattr:     s.handleSetattr,
+		procLookup:      s.handleLookup,
+		procAccess:      s.handleAccess,
+		procReadlink:    s.handleReadlink,
+		procRead:        s.handleRead,
+		procWrite:       s.handleWrite,
+		procCreate:      s.handleCreate,
+		procMkdir:       s.handleMkdir,
+		procSymlink:     s.handleSymlink,
+		procMknod:       s.handleMknod,
+		procRemove:      s.handleRemove,
+		procRmdir:       s.handleRmdir,
+		procRename:      s.handleRename,
+		procLink:        s.handleLink,
+		procReaddir:     s.handleReaddir,
+		procReaddirplus: s.handleReaddirplus,
+		procFsstat:      s.handleFsstat,
+		procFsinfo:      s.handleFsinfo,
+		procPathconf:    s.handlePathconf,
+		procCommit:      s.handleCommit,
+	})
+	return s, nil
+}
+
+// Root returns the exported directory
+func (s *Server) Root() string {
+	return s.root
+}
+
+// Serve serves MOUNT and NFS on the listener until it fails or the server is closed
+func (s *Server) Serve(l net.Listener) error {
+	return s.rpc.Serve(l)
+}
+
+func (s *Server) Close() error {
+	return s.rpc.Close()
+}
+
+// handleFor returns the handle of the relative path, assigning one if needed
+func (s *Server) handleFor(rel string) FileHandle {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	id, ok := s.ids[rel]
+	if !ok {
+		id = s.nextID
+		s.nextID++
+		s.ids[rel] = id
+		s.paths[id] = rel
+	}
+	fh := make(FileHandle, 8)
+	binary.BigEndian.PutUint64(fh, id)
+	return fh
+}
+
+// pathOf returns the relative path of the handle
+func (s *Server) pathOf(fh FileHandle) (string, error) {
+	if len(fh) != 8 {
+		return "", ErrBadHandle
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	rel, ok := s.paths[binary.BigEndian.Uint64(fh)]
+	if !ok {
+		return "", ErrStale
+	}
+	return rel, nil
+}
+
+// forget drops the handles of the relative path and everything below it
+func (s *Server) forget(rel string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for p, id := range s.ids {
+		if p == rel || strings.HasPrefix(p, rel+"/") {
+			delete(s.ids, p)
+			delete(s.paths, id)
+		}
+	}
+}
+
+// moved keeps the handles of the relative path and everything below it valid after a rename
+func (s *Server) moved(from, to string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	under := func(p, dir string) bool {
+		return p == dir || strings.HasPrefix(p, dir+"/")
+	}
+	moves := make(map[string]uint64)
+	for p, id := range s.ids {
+		if under(p, from) {
+			moves[p] = id
+		}
+	}
+	// Files replaced by the rename are gone
+	for p, id := range s.ids {
+		if _, ok := moves[p]; !ok && under(p, to) {
+			delete(s.ids, p)
+			delete(s.paths, id)
+		}
+	}
+	for p := range moves {
+		delete(s.ids, p)
+	}
+	for p, id := range moves {
+		newPath := to + strings.TrimPrefix(p, from)
+		s.ids[newPath] = id
+		s.paths[id] = newPath
+	}
+}
+
+func (s *Server) localPath(rel string) string {
+	return filepath.Join(s.root, filepath.FromSlash(rel))
+}
+
+// resolve returns the relative path and attributes of the handle
+func (s *Server) resolve(fh FileHandle) (string, *Attr, error) {
+	rel, err := s.pathOf(fh)
+	if err != nil {
+		return "", nil, err
+	}
+	fi, err := os.Lstat(s.localPath(rel))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil, ErrStale
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	return rel, fileAttr(fi), nil
+}
+
+// resolveDir is resolve for handles which must be directories
+func (s *Server) resolveDir(fh FileHandle) (string, *Attr, error) {
+	rel, attr, err := s.resolve(fh)
+	if err == nil && attr.Type != TypeDirectory {
+		err = ErrNotDir
+	}
+	return rel, attr, err
+}
+
+// child returns the relative path of name in the relative directory dir
+func child(dir, name string) (string, error) {
+	switch {
+	case name == "" || strings.Contains(name, "/"):
+		return "", ErrInval
+	case len(name) > maxDirEntryName:
+		return "", ErrNameTooLong
+	case name == ".":
+		return dir, nil
+	case name == "..":
+		if dir == "" {
+			return "", nil
+		}
+		parent := path.Dir(dir)
+		if parent == "." {
+			parent = ""
+		}
+		return parent, nil
+	case dir == "":
+		return name, nil
+	}
+	return dir + "/" + name, nil
+}
+
+func fileAttr(fi os.FileInfo) *Attr {
+	mtime := Time{Seconds: uint32(fi.ModTime().Unix()), Nseconds: uint32(fi.ModTime().Nanosecond())}
+	attr := &Attr{
+		Type:  fileType(fi.Mode()),
+		Mode:  uint32(fi.Mode().Perm()),
+		Nlink: 1,
+		Size:  uint64(fi.Size()),
+		Used:  uint64(fi.Size()),
+		Atime: mtime,
+		Mtime: mtime,
+		Ctime: mtime,
+	}
+	if fi.Mode()&os.ModeSetuid != 0 {
+		attr.Mode |= syscall.S_ISUID
+	}
+	if fi.Mode()&os.ModeSetgid != 0 {
+		attr.Mode |= syscall.S_ISGID
+	}
+	if fi.Mode()&os.ModeSticky != 0 {
+		attr.Mode |= syscall.S_ISVTX
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		attr.Nlink = uint32(st.Nlink)
+		attr.UID = st.Uid
+		attr.GID = st.Gid
+		attr.Used = uint64(st.Blocks) * 512
+		attr.Fsid = uint64(st.Dev)
+		attr.Fileid = st.Ino
+	}
+	return attr
+}
+
+func fileType(mode os.FileMode) FileType {
+	switch {
+	case mode.IsDir():
+		return TypeDirectory
+	case mode&os.ModeSymlink != 0:
+		return TypeSymlink
+	case mode&os.ModeNamedPipe != 0:
+		return TypeFIFO
+	case mode&os.ModeSocket != 0:
+		return TypeSocket
+	case mode&os.ModeCharDevice != 0:
+		return TypeChar
+	case mode&os.ModeDevice != 0:
+		return TypeBlock
+	}
+	return TypeRegular
+}
+
+// toStatus maps the error of a local file operation to a nfs status
+func toStatus(err error) Status {
+	var status Status
+	if errors.As(err, &status) {
+		return status
+	}
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		switch errno {
+		case syscall.EPERM:
+			return ErrPerm
+		case syscall.ENOENT:
+			return ErrNoEnt
+		case syscall.EACCES:
+			return ErrAcces
+		case syscall.EEXIST:
+			return ErrExist
+		case syscall.ENOTDIR:
+			return ErrNotDir
+		case syscall.EISDIR:
+			return ErrIsDir
+		case syscall.EINVAL:
+			return ErrInval
+		case syscall.ENOSPC:
+			return ErrNoSpc
+		case syscall.EROFS:
+			return ErrROFS
+		case syscall.ENAMETOOLONG:
+			return ErrNameTooLong
+		case syscall.ENOTEMPTY:
+			return ErrNotEmpty
+		case syscall.EDQUOT:
+			return ErrDQuot
+		}
+	}
+	return ErrIO
+}
+
+func (s *Server) setAttr(rel string, attr SetAttr) error {
+	p := s.localPath(rel)
+	if attr.Mode != nil {
+		mode := os.FileMode(*attr.Mode & 0777)
+		if *attr.Mode&syscall.S_ISUID != 0 {
+			mode |= os.ModeSetuid
+		}
+		if *attr.Mode&syscall.S_ISGID != 0 {
+			mode |= os.ModeSetgid
+		}
+		if *attr.Mode&syscall.S_ISVTX != 0 {
+			mode |= os.ModeSticky
+		}
+		if err := os.Chmod(p, mode); err != nil {
+			return err
+		}
+	}
+	if attr.UID != nil || attr.GID != nil {
+		uid, gid := -1, -1
+		if attr.UID != nil {
+			uid = int(*attr.UID)
+		}
+		if attr.GID != nil {
+			gid = int(*attr.GID)
+		}
+		if err := os.Lchown(p, uid, gid); err != nil {
+			return err
+		}
+	}
+	if attr.Size != nil {
+		if err := os.Truncate(p, int64(*attr.Size)); err != nil {
+			return err
+		}
+	}
+	if attr.Atime != nil || attr.Mtime != nil {
+		fi, err := os.Lstat(p)
+		if err != nil {
+			return err
+		}
+		atime, mtime := fi.ModTime(), fi.ModTime()
+		if attr.Atime != nil {
+			atime = attr.Atime.time()
+		}
+		if attr.Mtime != nil {
+			mtime = attr.Mtime.time()
+		}
+		if err := os.Chtimes(p, atime, mtime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) handleMnt(call *oncrpc.Call) ([]byte, error) {
+	dir, err := oncrpc.NewReader(call.Args).String(maxPathLen)
+	if err != nil {
+		return nil, errGarbageArgs
+	}
+	w := oncrpc.NewWriter()
+	rel := strings.Join(splitPath(dir), "/")
+	fi, err := os.Stat(s.localPath(rel))
+	if err == nil && !fi.IsDir() {
+		err = ErrNotDir
+	}
+	if err == nil {
+		w.Uint32(uint32(StatusOK))
+		encodeFileHandle(w, s.handleFor(rel))
+		// auth_flavors
+		w.Uint32(1)
+		w.Uint32(oncrpc.AuthFlavorSys)
+		return w.Bytes(), nil
+	}
+	w.Uint32(uint32(toStatus(err)))
+	return w.Bytes(), nil
+}
+
+func (s *Server) handleUmnt(call *oncrpc.Call) ([]byte, error) {
+	return nil, nil
+}
+
+func (s *Server) handleExport(call *oncrpc.Call) ([]byte, error) {
+	w := oncrpc.NewWriter()
+	encodeExports(w, []Export{{Dir: "/"}})
+	return w.Bytes(), nil
+}
+
+func (s *Server) handleGetattr(call *oncrpc.Call) ([]byte, error) {
+	fh, err := decodeFileHandle(oncrpc.NewReader(call.Args))
+	if err != nil {
+		return nil, errGarbageArgs
+	}
+	w := oncrpc.NewWriter()
+	_, attr, err := s.resolve(fh)
+	if err != nil {
+		w.Uint32(uint32(toStatus(err)))
+		return w.Bytes(), nil
+	}
+	w.Uint32(uint32(StatusOK))
+	encodeAttr(w, attr)
+	return w.Bytes(), nil
+}
+
+func (s *Server) handleSetattr(call *oncrpc.Call) ([]byte, error) {
+	r := oncrpc.NewReader(call.Args)
+	fh, err := decodeFileHandle(r)
+	if err != nil {
+		return nil, errGarbageArgs
+	}
+	attr, err := decodeSetAttr(r)
+	if err != nil {
+		return nil, errGarbageArgs
+	}
+	// The ctime guard is not checked
+
+	w := oncrpc.NewWriter()
+	rel, _, err := s.resolve(fh)
+	if err == nil {
+		err = s.setAttr(rel, attr)
+	}
+	if err != nil {
+		w.Uint32(uint32(toStatus(err)))
+		encodeWcc(w, nil)
+		return w.Bytes(), nil
+	}
+	_, after, _ := s.resolve(fh)
+	w.Uint32(uint32(StatusOK))
+	encodeWcc(w, after)
+	return w.Bytes(), nil
+}
+
+func decodeDirOpArgs(r *oncrpc.Reader) (FileHandle, string, error) {
+	dir, err := decodeFileHandle(r)
+	if err != nil {
+		return nil, "", err
+	}
+	name, err := r.String(maxPathLen)
+	return dir, name, err
+}
+
+func (s *Server) handleLookup(call *oncrpc.Call) ([]byte, error) {
+	dir, name, err := decodeDirOpArgs(oncrpc.NewReader(call.Args))
+	if err != nil {
+		return nil, errGarbageArgs
+	}
+	w := oncrpc.NewWriter()
+	dirRel, dirAttr, err := s.resolveDir(dir)
+	var rel string
+	if err == nil {
+		rel, err = child(dirRel, name)
+	}
+	var fi os.FileInfo
+	if err == nil {
+		fi, err = os.Lstat(s.localPath(rel))
+	}
+	if err != nil {
+		w.Uint32(uint32(toStatus(err)))
+		encodePostOpAttr(w, dirAttr)
+		return w.Bytes(), nil
+	}
+	w.Uint32(uint32(StatusOK))
+	encodeFileHandle(w, s.handleFor(rel))
+	encodePostOpAttr(w, fileAttr(fi))
+	encodePostOpAttr(w, dirAttr)
+	return w.Bytes(), nil
+}
+
+func (s *Server) handleMkdir(call *oncrpc.Call) ([]byte, error) {
+	r := oncrpc.NewReader(call.Args)
+	dir, name, err := decodeDirOpArgs(r)
+	if err != nil {
+		return nil, errGarbageArgs
+	}
+	attr, err := decodeSetAttr(r)
+	if err != nil {
+		return nil, errGarbageArgs
+	}
+
+	rel, err := s.newChild(dir, name)
+	if err == nil {
+		err = os.Mkdir(s.localPath(rel), 0755)
+	}
+	if err == nil {
+		// The requested mode is set explicitly so the umask of the server does not apply
+		err = s.setAttr(rel, attr)
+	}
+	if err != nil {
+		return replyWccStatus(err), nil
+	}
+	return s.replyCreated(rel, dir), nil
+}
+
+func (s *Server) handleRemove(call *oncrpc.Call) ([]byte, error) {
+	return s.handleRemoveEntry(call, false)
+}
+
+func (s *Server) handleRmdir(call *oncrpc.Call) ([]byte, error) {
+	return s.handleRemoveEntry(call, true)
+}
+
+func (s *Server) handleRemoveEntry(call *oncrpc.Call, isDir bool) ([]byte, error) {
+	dir, name, err := decodeDirOpArgs(oncrpc.NewReader(call.Args))
+	if err != nil {
+		return nil, errGarbageArgs
+	}
+	w := oncrpc.NewWriter()
+	dirRel, _, err := s.resolveDir(dir)
+	var rel string
+	if err == nil {
+		rel, err = child(dirRel, name)
+	}
+	if err == nil && (name == "." || name == "..") {
+		err = ErrInval
+	}
+	var fi os.FileInfo
+	if err == nil {
+		fi, err = os.Lstat(s.localPath(rel))
+	}
+	if err == nil {
+		switch {
+		case isDir && !fi.IsDir():
+			err = ErrNotDir
+		case !isDir && fi.IsDir():
+			err = ErrIsDir
+		default:
+			// os.Remove would fall back to rmdir for files and the other way round
+			if isDir {
+				err = syscall.Rmdir(s.localPath(rel))
+			} else {
+				err = syscall.Unlink(s.localPath(rel))
+			}
+		}
+	}
+	if err != nil {
+		w.Uint32(uint32(toStatus(err)))
+		encodeWcc(w, nil)
+		return w.Bytes(), nil
+	}
+	s.forget(rel)
+	_, dirAttr, _ := s.resolve(dir)
+	w.Uint32(uint32(StatusOK))
+	encodeWcc(w, dirAttr)
+	return w.Bytes(), nil
+}
+
+func (s *Server) handleReaddir(call *oncrpc.Call) ([]byte, error) {
+	return s.readdir(call, false)
+}
+
+func (s *Server) handleReaddirplus(call *oncrpc.Call) ([]byte, error) {
+	return s.readdir(call, true)
+}
+
+// readdir serves READDIR and READDIRPLUS, the latter adds attributes and handles to the entries
+func (s *Server) readdir(call *oncrpc.Call, plus bool) ([]byte, error) {
+	r := oncrpc.NewReader(call.Args)
+	dir, err := decodeFileHandle(r)
+	if err != nil {
+		return nil, errGarbageArgs
+	}
+	cookie, err := r.Uint64()
+	if err != nil {
+		return nil, errGarbageArgs
+	}
+	if _, err := r.FixedOpaque(cookieVerfLength); err != nil {
+		return nil, errGarbageArgs
+	}
+	maxCount, err := r.Uint32()
+	if err != nil {
+		return nil, errGarbageArgs
+	}
+	if plus {
+		// dircount comes first, only maxcount is honored
+		if maxCount, err = r.Uint32(); err != nil {
+			return nil, errGarbageArgs
+		}
+	}
+
+	w := oncrpc.NewWriter()
+	dirRel, dirAttr, err := s.resolveDir(dir)
+	var entries []os.DirEntry
+	if err == nil {
+		entries, err = os.ReadDir(s.localPath(dirRel))
+	}
+	if err == nil && cookie > uint64(len(entries)) {
+		err = ErrBadCookie
+	}
+	if err != nil {
+		w.Uint32(uint32(toStatus(err)))
+		encodePostOpAttr(w, dirAttr)
+		return w.Bytes(), nil
+	}
+
+	w.Uint32(uint32(StatusOK))
+	encodePostOpAttr(w, dirAttr)
+	// Cookies are positions in the sorted listing, the verifier is not used
+	w.FixedOpaque(make([]byte, cookieVerfLength))
+	size := readdirReplyOverhead
+	eof := true
+	written := 0
+	for i := int(cookie); i < len(entries); i++ {
+		rel, _ := child(dirRel, entries[i].Name())
+		fi, err := os.Lstat(s.localPath(rel))
+		if err != nil {
+			// Removed while listing
+			continue
+		}
+		entry := oncrpc.NewWriter()
+		entry.Bool(true)
+		entry.Uint64(fileAttr(fi).Fileid)
+		entry.String(entries[i].Name())
+		entry.Uint64(uint64(i + 1))
+		if plus {
+			encodePostOpAttr(entry, fileAttr(fi))
+			encodePostOpFileHandle(entry, s.handleFor(rel))
+		}
+		if size += len(entry.Bytes()); size > int(maxCount) {
+			eof = false
+			break
+		}
+		// Entries are already 4 byte aligned
+		w.FixedOpaque(entry.Bytes())
+		written++
+	}
+	if written == 0 && !eof {
+		w = oncrpc.NewWriter()
+		w.Uint32(uint32(ErrTooSmall))
+		encodePostOpAttr(w, dirAttr)
+		return w.Bytes(), nil
+	}
+	w.Bool(false)
+	w.Bool(eof)
+	return w.Bytes(), nil
+}
+
+func (s *Server) handleFsstat(call *oncrpc.Call) ([]byte, error) {
+	fh, err := decodeFileHandle(oncrpc.NewReader(call.Args))
+	if err != nil {
+		return nil, errGarbageArgs
+	}
+	w := oncrpc.NewWriter()
+	rel, attr, err := s.resolve(fh)
+	var st syscall.Statfs_t
+	if err == nil {
+		err = syscall.Statfs(s.localPath(rel), &st)
+	}
+	if err != nil {
+		w.Uint32(uint32(toStatus(err)))
+		encodePostOpAttr(w, attr)
+		return w.Bytes(), nil
+	}
+	bsize := uint64(st.Bsize)
+	w.Uint32(uint32(StatusOK))
+	encodePostOpAttr(w, attr)
+	w.Uint64(uint64(st.Blocks) * bsize)
+	w.Uint64(uint64(st.Bfree) * bsize)
+	w.Uint64(uint64(st.Bavail) * bsize)
+	w.Uint64(uint64(st.Files))
+	w.Uint64(uint64(st.Ffree))
+	w.Uint64(uint64(st.Ffree))
+	// invarsec, the file system may change at any time
+	w.Uint32(0)
+	return w.Bytes(), nil
+}
+
+const (
+	// Largest READ and WRITE the server handles
+	maxIOSize = 1 << 20
+
+	// FSINFO properties: hard links, symlinks, homogeneous pathconf, settable times
+	fsinfoProperties = 0x1 | 0x2 | 0x8 | 0x10
+)
+
+// replyStatus encodes a failure whose fail body is post_op_attr
+func replyStatus(err error) []byte {
+	w := oncrpc.NewWriter()
+	w.Uint32(uint32(toStatus(err)))
+	w.Bool(false)
+	return w.Bytes()
+}
+
+// replyWccStatus encodes a failure whose fail body is wcc_data
+func replyWccStatus(err error) []byte {
+	w := oncrpc.NewWriter()
+	w.Uint32(uint32(toStatus(err)))
+	encodeWcc(w, nil)
+	return w.Bytes()
+}
+
+func (s *Server) handleAccess(call *oncrpc.Call) ([]byte, error) {
+	r := oncrpc.NewReader(call.Args)
+	fh, err := decodeFileHandle(r)
+	if err != nil {
+		return nil, errGarbageArgs
+	}
+	access, err := r.Uint32()
+	if err != nil {
+		return nil, errGarbageArgs
+	}
+	_, attr, err := s.resolve(fh)
+	if err != nil {
+		return replyStatus(err), nil
+	}
+	// Every caller is root
+	w := oncrpc.NewWriter()
+	w.Uint32(uint32(StatusOK))
+	encodePostOpAttr(w, attr)
+	w.Uint32(access)
+	return w.Bytes(), nil
+}
+
+func (s *Server) handleReadlink(call *oncrpc.Call) ([]byte, error) {
+	fh, err := decodeFileHandle(oncrpc.NewReader(call.Args))
+	if err != nil {
+		return nil, errGarbageArgs
+	}
+	rel, attr, err := s.resolve(fh)
+	if err == nil && attr.Type != TypeSymlink {
+		err = ErrInval
+	}
+	var target string
+	if err == nil {
+		target, err = os.Readlink(s.localPath(rel))
+	}
+	if err != nil {
+		return replyStatus(err), nil
+	}
+	w := oncrpc.NewWriter()
+	w.Uint32(uint32(StatusOK))
+	encodePostOpAttr(w, attr)
+	w.String(target)
+	return w.Bytes(), nil
+}
+
+func (s *Server) handleRead(call *oncrpc.Call) ([]byte, error) {
+	r := oncrpc.NewReader(call.Args)
+	fh, err := decodeFileHandle(r)
+	if err != nil {
+		return nil, errGarbageArgs
+	}
+	offset, err := r.Uint64()
+	if err != nil {
+		return nil, errGarbageArgs
+	}
+	count, err := r.Uint32()
+	if err != nil {
+		return nil, errGarbageArgs
+	}
+	if count > maxIOSize {
+		count = maxIOSize
+	}
+
+	rel, attr, err := s.resolve(fh)
+	if err == nil && attr.Type != TypeRegular {
+		err = ErrInval
+		if attr.Type == TypeDirectory {
+			err = ErrIsDir
+		}
+	}
+	data := make([]byte, count)
+	n := 0
+	if err == nil {
+		var f *os.File
+		if f, err = os.Open(s.localPath(rel)); err == nil {
+			n, err = f.ReadAt(data, int64(offset))
+			f.Close()
+			if errors.Is(err, io.EOF) {
+				err = nil
+			}
+		}
+	}
+	if err != nil {
+		return replyStatus(err), nil
+	}
+	w := oncrpc.NewWriter()
+	w.Uint32(uint32(StatusOK))
+	encodePostOpAttr(w, attr)
+	w.Uint32(uint32(n))
+	w.Bool(offset+uint64(n) >= attr.Size)
+	w.Opaque(data[:n])
+	return w.Bytes(), nil
+}
+
+func (s *Server) handleWrite(call *oncrpc.Call) ([]byte, error) {
+	r := oncrpc.NewReader(call.Args)
+	fh, err := decodeFileHandle(r)
+	if err != nil {
+		return nil, errGarbageArgs
+	}
+	offset, err := r.Uint64()
+	if err != nil {
+		return nil, errGarbageArgs
+	}
+	if _, err := r.Uint32(); err != nil {
+		return nil, errGarbageArgs
+	}
+	stable, err := r.Uint32()
+	if err != nil {
+		return nil, errGarbageArgs
+	}
+	data, err := r.Opaque(maxIOSize)
+	if err != nil {
+		return nil, errGarbageArgs
+	}
+
+	rel, attr, err := s.resolve(fh)
+	if err == nil && attr.Type != TypeRegular {
+		err = ErrInval
+		if attr.Type == TypeDirectory {
+			err = ErrIsDir
+		}
+	}
+	if err == nil {
+		var f *os.File
+		if f, err = os.OpenFile(s.localPath(rel), os.O_WRONLY, 0); err == nil {
+			_, err = f.WriteAt(data, int64(offset))
+			if err == nil && stable != Unstable {
+				err = f.Sync()
+			}
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+		}
+	}
+	if err != nil {
+		return replyWccStatus(err), nil
+	}
+	_, after, _ := s.resolve(fh)
+	w := oncrpc.NewWriter()
+	w.Uint32(uint32(StatusOK))
+	encodeWcc(w, after)
+	w.Uint32(uint32(len(data)))
+	if stable != Unstable {
+		stable = FileSync
+	}
+	w.Uint32(stable)
+	w.FixedOpaque(s.writeVerf)
+	return w.Bytes(), nil
+}
+
+// replyCreated encodes the result of CREATE, MKDIR and SYMLINK
+func (s *Server) replyCreated(rel string, dir FileHandle) []byte {
+	fh := s.handleFor(rel)
+	_, objAttr, _ := s.resolve(fh)
+	_, dirAttr, _ := s.resolve(dir)
+	w := oncrpc.NewWriter()
+	w.Uint32(uint32(StatusOK))
+	encodePostOpFileHandle(w, fh)
+	encodePostOpAttr(w, objAttr)
+	encodeWcc(w, dirAttr)
+	return w.Bytes()
+}
+
+// newChild returns the relative path of a file to create as name in dir
+func (s *Server) newChild(dir FileHandle, name string) (string, error) {
+	dirRel, _, err := s.resolveDir(dir)
+	if err != nil {
+		return "", err
+	}
+	if name == "." || name == ".." {
+		return "", ErrExist
+	}
+	return child(dirRel, name)
+}
+
+func (s *Server) handleCreate(call *oncrpc.Call) ([]byte, error) {
+	r := oncrpc.NewReader(call.Args)
+	dir, name, err := decodeDirOpArgs(r)
+	if err != nil {
+		return nil, errGarbageArgs
+	}
+	how, err := r.Uint32()
+	if err != nil {
+		return nil, errGarbageArgs
+	}
+	var attr SetAttr
+	var verf []byte
+	switch how {
+	case createUnchecked, createGuarded:
+		if attr, err = decodeSetAttr(r); err != nil {
+			return nil, errGarbageArgs
+		}
+	case createExclusive:
+		if verf, err = r.FixedOpaque(cookieVerfLength); err != nil {
+			return nil, errGarbageArgs
+		}
+	default:
+		return nil, errGarbageArgs
+	}
+
+	rel, err := s.newChild(dir, name)
+	if err != nil {
+		return replyWccStatus(err), nil
+	}
+	p := s.localPath(rel)
+	flags := os.O_WRONLY | os.O_CREATE
+	if how != createUnchecked {
+		flags |= os.O_EXCL
+	}
+	f, err := os.OpenFile(p, flags, 0644)
+	switch {
+	case err == nil:
+		f.Close()
+		if how == createExclusive {
+			// Like knfsd the verifier is kept in the times to recognize a retransmitted create
+			t := verfTime(verf)
+			attr = SetAttr{Atime: &t, Mtime: &t}
+		}
+		err = s.setAttr(rel, attr)
+	case how == createExclusive && errors.Is(err, os.ErrExist):
+		fi, statErr := os.Lstat(p)
+		if statErr == nil && fileAttr(fi).Mtime == verfTime(verf) {
+			err = nil
+		}
+	}
+	if err != nil {
+		return replyWccStatus(err), nil
+	}
+	return s.replyCreated(rel, dir), nil
+}
+
+func verfTime(verf []byte) Time {
+	return Time{
+		Seconds:  binary.BigEndian.Uint32(verf[:4]),
+		Nseconds: binary.BigEndian.Uint32(verf[4:]) % 1e9,
+	}
+}
+
+func (s *Server) handleSymlink(call *oncrpc.Call) ([]byte, error) {
+	r := oncrpc.NewReader(call.Args)
+	dir, name, err := decodeDirOpArgs(r)
+	if err != nil {
+		return nil, errGarbageArgs
+	}
+	attr, err := decodeSetAttr(r)
+	if err != nil {
+		return nil, errGarbageArgs
+	}
+	target, err := r.String(maxPathLen)
+	if err != nil {
+		return nil, errGarbageArgs
+	}
+
+	rel, err := s.newChild(dir, name)
+	if err == nil {
+		err = os.Symlink(target, s.localPath(rel))
+	}
+	if err == nil && (attr.UID != nil || attr.GID != nil) {
+		// Modes and times of symlinks cannot be changed
+		err = s.setAttr(rel, SetAttr{UID: attr.UID, GID: attr.GID})
+	}
+	if err != nil {
+		return replyWccStatus(err), nil
+	}
+	return s.replyCreated(rel, dir), nil
+}
+
+func (s *Server) handleMknod(call *oncrpc.Call) ([]byte, error) {
+	return replyWccStatus(ErrNotSupp), nil
+}
+
+func (s *Server) handleRename(call *oncrpc.Call) ([]byte, error) {
+	r := oncrpc.NewReader(call.Args)
+	fromDir, fromName, err := decodeDirOpArgs(r)
+	if err != nil {
+		return nil, errGarbageArgs
+	}
+	toDir, toName, err := decodeDirOpArgs(r)
+	if err != nil {
+		return nil, errGarbageArgs
+	}
+
+	var from, to string
+	fromRel, _, err := s.resolveDir(fromDir)
+	if err == nil {
+		from, err = child(fromRel, fromName)
+	}
+	var toRel string
+	if err == nil {
+		toRel, _, err = s.resolveDir(toDir)
+	}
+	if err == nil {
+		to, err = child(toRel, toName)
+	}
+	for _, name := range []string{fromName, toName} {
+		if err == nil && (name == "." || name == "..") {
+			err = ErrInval
+		}
+	}
+	if err == nil {
+		err = os.Rename(s.localPath(from), s.localPath(to))
+	}
+	w := oncrpc.NewWriter()
+	if err != nil {
+		w.Uint32(uint32(toStatus(err)))
+		encodeWcc(w, nil)
+		encodeWcc(w, nil)
+		return w.Bytes(), nil
+	}
+	s.moved(from, to)
+	_, fromAttr, _ := s.resolve(fromDir)
+	_, toAttr, _ := s.resolve(toDir)
+	w.Uint32(uint32(StatusOK))
+	encodeWcc(w, fromAttr)
+	encodeWcc(w, toAttr)
+	return w.Bytes(), nil
+}
+
+func (s *Server) handleLink(call *oncrpc.Call) ([]byte, error) {
+	r := oncrpc.NewReader(call.Args)
+	fh, err := decodeFileHandle(r)
+	if err != nil {
+		return nil, errGarbageArgs
+	}
+	dir, name, err := decodeDirOpArgs(r)
+	if err != nil {
+		return nil, errGarbageArgs
+	}
+
+	rel, attr, err := s.resolve(fh)
+	if err == nil && attr.Type == TypeDirectory {
+		err = ErrIsDir
+	}
+	var link string
+	if err == nil {
+		link, err = s.newChild(dir, name)
+	}
+	if err == nil {
+		err = os.Link(s.localPath(rel), s.localPath(link))
+	}
+	w := oncrpc.NewWriter()
+	if err != nil {
+		w.Uint32(uint32(toStatus(err)))
+		encodePostOpAttr(w, nil)
+		encodeWcc(w, nil)
+		return w.Bytes(), nil
+	}
+	_, attr, _ = s.resolve(fh)
+	_, dirAttr, _ := s.resolve(dir)
+	w.Uint32(uint32(StatusOK))
+	encodePostOpAttr(w, attr)
+	encodeWcc(w, dirAttr)
+	return w.Bytes(), nil
+}
+
+func (s *Server) handleFsinfo(call *oncrpc.Call) ([]byte, error) {
+	fh, err := decodeFileHandle(oncrpc.NewReader(call.Args))
+	if err != nil {
+		return nil, errGarbageArgs
+	}
+	_, attr, err := s.resolve(fh)
+	if err != nil {
+		return replyStatus(err), nil
+	}
+	w := oncrpc.NewWriter()
+	w.Uint32(uint32(StatusOK))
+	encodePostOpAttr(w, attr)
+	// rtmax, rtpref, rtmult, wtmax, wtpref, wtmult, dtpref
+	for _, v := range []uint32{maxIOSize, maxIOSize, 4096, maxIOSize, maxIOSize, 4096, readdirMaxCount} {
+		w.Uint32(v)
+	}
+	w.Uint64(1<<63 - 1)
+	encodeTime(w, Time{Nseconds: 1})
+	w.Uint32(fsinfoProperties)
+	return w.Bytes(), nil
+}
+
+func (s *Server) handlePathconf(call *oncrpc.Call) ([]byte, error) {
+	fh, err := decodeFileHandle(oncrpc.NewReader(call.Args))
+	if err != nil {
+		return nil, errGarbageArgs
+	}
+	_, attr, err := s.resolve(fh)
+	if err != nil {
+		return replyStatus(err), nil
+	}
+	w := oncrpc.NewWriter()
+	w.Uint32(uint32(StatusOK))
+	encodePostOpAttr(w, attr)
+	w.Uint32(32000)
+	w.Uint32(maxDirEntryName)
+	// no_trunc, chown_restricted, case_insensitive, case_preserving
+	w.Bool(true)
+	w.Bool(true)
+	w.Bool(false)
+	w.Bool(true)
+	return w.Bytes(), nil
+}
+
+func (s *Server) handleCommit(call *oncrpc.Call) ([]byte, error) {
+	fh, err := decodeFileHandle(oncrpc.NewReader(call.Args))
+	if err != nil {
+		return nil, errGarbageArgs
+	}
+	rel, attr, err := s.resolve(fh)
+	if err == nil && attr.Type != TypeRegular {
+		err = ErrInval
+	}
+	if err == nil {
+		var f *os.File
+		if f, err = os.OpenFile(s.localPath(rel), os.O_WRONLY, 0); err == nil {
+			err = f.Sync()
+			f.Close()
+		}
+	}
+	if err != nil {
+		return replyWccStatus(err), nil
+	}
+	w := oncrpc.NewWriter()
+	w.Uint32(uint32(StatusOK))
+	encodeWcc(w, attr)
+	w.FixedOpaque(s.writeVerf)
+	return w.Bytes(), nil
+}
@@ -0,0 +1,192 @@
+package nfsv3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/chenliu1993/simple-csi-driver/internal/oncrpc"
+)
+
+func TestServerReadWrite(t *testing.T) {
+	root, c := startServer(t, "/")
+	ctx := context.Background()
+
+	fh, err := c.Create(ctx, c.Root(), "file", 0600)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if _, err := c.Create(ctx, c.Root(), "file", 0600); !errors.Is(err, ErrExist) {
+		t.Errorf("Create() of an existing file error = %v, want %v", err, ErrExist)
+	}
+	for i, chunk := range []string{"hello ", "world"} {
+		n, err := c.Write(ctx, fh, uint64(i*len("hello ")), []byte(chunk))
+		if err != nil || n != len(chunk) {
+			t.Fatalf("Write() = %d, %v", n, err)
+		}
+	}
+
+	got, err := os.ReadFile(filepath.Join(root, "file"))
+	if err != nil || string(got) != "hello world" {
+		t.Errorf("file content = %q, %v, want %q", got, err, "hello world")
+	}
+	data, eof, err := c.Read(ctx, fh, 6, 100)
+	if err != nil || string(data) != "world" || !eof {
+		t.Errorf("Read() = %q, %v, %v, want %q at eof", data, eof, err, "world")
+	}
+	data, eof, err = c.Read(ctx, fh, 0, 5)
+	if err != nil || string(data) != "hello" || eof {
+		t.Errorf("Read() = %q, %v, %v, want %q before eof", data, eof, err, "hello")
+	}
+}
+
+func TestServerRename(t *testing.T) {
+	root, c := startServer(t, "/")
+	ctx := context.Background()
+
+	dir, err := c.MkdirAll(ctx, "a/b", 0755)
+	if err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	fh, err := c.Create(ctx, dir, "file", 0644)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	if err := c.Rename(ctx, c.Root(), "a", c.Root(), "moved"); err != nil {
+		t.Fatalf("Rename() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "moved/b/file")); err != nil {
+		t.Errorf("renamed file is missing: %v", err)
+	}
+	// Handles below the renamed directory stay valid
+	if _, err := c.Write(ctx, fh, 0, []byte("data")); err != nil {
+		t.Errorf("Write() after rename error = %v", err)
+	}
+	if err := c.Rename(ctx, c.Root(), "missing", c.Root(), "other"); !errors.Is(err, ErrNoEnt) {
+		t.Errorf("Rename() of a missing file error = %v, want %v", err, ErrNoEnt)
+	}
+}
+
+// TestServerProcedures issues the procedures the client has no method for
+func TestServerProcedures(t *testing.T) {
+	root, c := startServer(t, "/")
+	ctx := context.Background()
+	if err := os.WriteFile(filepath.Join(root, "file"), []byte("data"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if err := os.Symlink("file", filepath.Join(root, "link")); err != nil {
+		t.Fatalf("Symlink() error = %v", err)
+	}
+	file, err := c.LookupPath(ctx, "file")
+	if err != nil {
+		t.Fatalf("LookupPath() error = %v", err)
+	}
+	link, err := c.LookupPath(ctx, "link")
+	if err != nil {
+		t.Fatalf("LookupPath() error = %v", err)
+	}
+
+	fhArgs := func(fh FileHandle, extra func(w *oncrpc.Writer)) *oncrpc.Writer {
+		w := oncrpc.NewWriter()
+		encodeFileHandle(w, fh)
+		if extra != nil {
+			extra(w)
+		}
+		return w
+	}
+	tests := []struct {
+		name  string
+		proc  uint32
+		args  *oncrpc.Writer
+		check func(r *oncrpc.Reader) error
+	}{
+		{
+			name: "access",
+			proc: procAccess,
+			args: fhArgs(file, func(w *oncrpc.Writer) { w.Uint32(0x3f) }),
+		},
+		{
+			name: "readlink",
+			proc: procReadlink,
+			args: fhArgs(link, nil),
+			check: func(r *oncrpc.Reader) error {
+				if _, err := decodePostOpAttr(r); err != nil {
+					return err
+				}
+				target, err := r.String(maxPathLen)
+				if err == nil && target != "file" {
+					err = errors.New("wrong link target " + target)
+				}
+				return err
+			},
+		},
+		{
+			name: "readdir",
+			proc: procReaddir,
+			args: fhArgs(c.Root(), func(w *oncrpc.Writer) {
+				w.Uint64(0)
+				w.FixedOpaque(make([]byte, cookieVerfLength))
+				w.Uint32(4096)
+			}),
+		},
+		{
+			name: "fsinfo",
+			proc: procFsinfo,
+			args: fhArgs(c.Root(), nil),
+		},
+		{
+			name: "pathconf",
+			proc: procPathconf,
+			args: fhArgs(c.Root(), nil),
+		},
+		{
+			name: "commit",
+			proc: procCommit,
+			args: fhArgs(file, func(w *oncrpc.Writer) {
+				w.Uint64(0)
+				w.Uint32(0)
+			}),
+		},
+		{
+			name: "link",
+			proc: procLink,
+			args: fhArgs(file, func(w *oncrpc.Writer) { encodeDirOpArgs(w, c.Root(), "hardlink") }),
+		},
+		{
+			name: "symlink",
+			proc: procSymlink,
+			args: fhArgs(c.Root(), func(w *oncrpc.Writer) {
+				w.String("symlink")
+				encodeSetAttr(w, SetAttr{})
+				w.String("file")
+			}),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := c.call(ctx, tt.proc, tt.args)
+			if err != nil {
+				t.Fatalf("call error = %v", err)
+			}
+			if err := decodeStatus(r); err != nil {
+				t.Fatalf("status = %v", err)
+			}
+			if tt.check != nil {
+				if err := tt.check(r); err != nil {
+					t.Errorf("reply: %v", err)
+				}
+			}
+		})
+	}
+
+	for _, name := range []string{"hardlink", "symlink"} {
+		got, err := os.ReadFile(filepath.Join(root, name))
+		if err != nil || !bytes.Equal(got, []byte("data")) {
+			t.Errorf("%s reads %q, %v", name, got, err)
+		}
+	}
+}
@@ -0,0 +1,410 @@
+package nfsv3
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/chenliu1993/simple-csi-driver/internal/oncrpc"
+)
+
+const (
+	// Version is the version of the NFS program spoken by the client
+	Version = 3
+
+	procNull        = 0
+	procGetattr     = 1
+	procSetattr     = 2
+	procLookup      = 3
+	procAccess      = 4
+	procReadlink    = 5
+	procRead        = 6
+	procWrite       = 7
+	procCreate      = 8
+	procMkdir       = 9
+	procSymlink     = 10
+	procMknod       = 11
+	procRemove      = 12
+	procRmdir       = 13
+	procRename      = 14
+	procLink        = 15
+	procReaddir     = 16
+	procReaddirplus = 17
+	procFsstat      = 18
+	procFsinfo      = 19
+	procPathconf    = 20
+	procCommit      = 21
+
+	maxFileHandleSize = 64
+)
+
+// FileHandle is the opaque handle the server identifies a file with
+type FileHandle []byte
+
+// Status is the nfsstat3 of a failed procedure, it is also used for mountstat3
+type Status uint32
+
+const (
+	StatusOK          Status = 0
+	ErrPerm           Status = 1
+	ErrNoEnt          Status = 2
+	ErrIO             Status = 5
+	ErrAcces          Status = 13
+	ErrExist          Status = 17
+	ErrNotDir         Status = 20
+	ErrIsDir          Status = 21
+	ErrInval          Status = 22
+	ErrNoSpc          Status = 28
+	ErrROFS           Status = 30
+	ErrNameTooLong    Status = 63
+	ErrNotEmpty       Status = 66
+	ErrDQuot          Status = 69
+	ErrStale          Status = 70
+	ErrBadHandle      Status = 10001
+	ErrBadCookie      Status = 10003
+	ErrNotSupp        Status = 10004
+	ErrTooSmall       Status = 10005
+	ErrServerFault    Status = 10006
+	ErrJukebox        Status = 10008
+	statusUnknownName        = "unknown error"
+)
+
+var statusNames = map[Status]string{
+	ErrPerm:        "not owner",
+	ErrNoEnt:       "no such file or directory",
+	ErrIO:          "i/o error",
+	ErrAcces:       "permission denied",
+	ErrExist:       "file exists",
+	ErrNotDir:      "not a directory",
+	ErrIsDir:       "is a directory",
+	ErrInval:       "invalid argument",
+	ErrNoSpc:       "no space left on device",
+	ErrROFS:        "read-only file system",
+	ErrNameTooLong: "file name too long",
+	ErrNotEmpty:    "directory not empty",
+	ErrDQuot:       "quota exceeded",
+	ErrStale:       "stale file handle",
+	ErrBadHandle:   "illegal file handle",
+	ErrBadCookie:   "bad readdir cookie",
+	ErrNotSupp:     "operation not supported",
+	ErrTooSmall:    "buffer too small",
+	ErrServerFault: "server fault",
+	ErrJukebox:     "try again later",
+}
+
+func (s Status) Error() string {
+	name, ok := statusNames[s]
+	if !ok {
+		name = statusUnknownName
+	}
+	return fmt.Sprintf("nfs: %s (%d)", name, uint32(s))
+}
+
+// FileType is the ftype3 of a file
+type FileType uint32
+
+const (
+	TypeRegular   FileType = 1
+	TypeDirectory FileType = 2
+	TypeBlock     FileType = 3
+	TypeChar      FileType = 4
+	TypeSymlink   FileType = 5
+	TypeSocket    FileType = 6
+	TypeFIFO      FileType = 7
+)
+
+// Time is a nfstime3
+type Time struct {
+	Seconds  uint32
+	Nseconds uint32
+}
+
+// Attr are the fattr3 attributes of a file
+type Attr struct {
+	Type   FileType
+	Mode   uint32
+	Nlink  uint32
+	UID    uint32
+	GID    uint32
+	Size   uint64
+	Used   uint64
+	Rdev   [2]uint32
+	Fsid   uint64
+	Fileid uint64
+	Atime  Time
+	Mtime  Time
+	Ctime  Time
+}
+
+// SetAttr are the sattr3 attributes to change, nil fields are left alone
+type SetAttr struct {
+	Mode  *uint32
+	UID   *uint32
+	GID   *uint32
+	Size  *uint64
+	Atime *Time
+	Mtime *Time
+}
+
+// DirEntry is an entry returned by READDIRPLUS, Handle and Attr may be missing
+type DirEntry struct {
+	Fileid uint64
+	Name   string
+	Cookie uint64
+	Attr   *Attr
+	Handle FileHandle
+}
+
+// FSStat is the space and file usage of a file system
+type FSStat struct {
+	TotalBytes uint64
+	FreeBytes  uint64
+	// Free bytes available to the caller
+	AvailBytes uint64
+	TotalFiles uint64
+	FreeFiles  uint64
+	AvailFiles uint64
+}
+
+// stable_how of WRITE
+const (
+	Unstable = 0
+	DataSync = 1
+	FileSync = 2
+)
+
+// createmode3 of CREATE
+const (
+	createUnchecked = 0
+	createGuarded   = 1
+	createExclusive = 2
+)
+
+// time_how of sattr3
+const (
+	dontChange       = 0
+	setToServerTime  = 1
+	setToClientTime  = 2
+	maxDirEntryName  = 255
+	cookieVerfLength = 8
+)
+
+func encodeTime(w *oncrpc.Writer, t Time) {
+	w.Uint32(t.Seconds)
+	w.Uint32(t.Nseconds)
+}
+
+func decodeTime(r *oncrpc.Reader) (Time, error) {
+	var t Time
+	var err error
+	if t.Seconds, err = r.Uint32(); err != nil {
+		return t, err
+	}
+	t.Nseconds, err = r.Uint32()
+	return t, err
+}
+
+func encodeAttr(w *oncrpc.Writer, a *Attr) {
+	w.Uint32(uint32(a.Type))
+	w.Uint32(a.Mode)
+	w.Uint32(a.Nlink)
+	w.Uint32(a.UID)
+	w.Uint32(a.GID)
+	w.Uint64(a.Size)
+	w.Uint64(a.Used)
+	w.Uint32(a.Rdev[0])
+	w.Uint32(a.Rdev[1])
+	w.Uint64(a.Fsid)
+	w.Uint64(a.Fileid)
+	encodeTime(w, a.Atime)
+	encodeTime(w, a.Mtime)
+	encodeTime(w, a.Ctime)
+}
+
+func decodeAttr(r *oncrpc.Reader) (*Attr, error) {
+	a := &Attr{}
+	fields := []*uint32{(*uint32)(&a.Type), &a.Mode, &a.Nlink, &a.UID, &a.GID}
+	for _, f := range fields {
+		v, err := r.Uint32()
+		if err != nil {
+			return nil, err
+		}
+		*f = v
+	}
+	var err error
+	if a.Size, err = r.Uint64(); err != nil {
+		return nil, err
+	}
+	if a.Used, err = r.Uint64(); err != nil {
+		return nil, err
+	}
+	for i := range a.Rdev {
+		if a.Rdev[i], err = r.Uint32(); err != nil {
+			return nil, err
+		}
+	}
+	if a.Fsid, err = r.Uint64(); err != nil {
+		return nil, err
+	}
+	if a.Fileid, err = r.Uint64(); err != nil {
+		return nil, err
+	}
+	for _, t := range []*Time{&a.Atime, &a.Mtime, &a.Ctime} {
+		if *t, err = decodeTime(r); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// post_op_attr
+func encodePostOpAttr(w *oncrpc.Writer, a *Attr) {
+	w.Bool(a != nil)
+	if a != nil {
+		encodeAttr(w, a)
+	}
+}
+
+func decodePostOpAttr(r *oncrpc.Reader) (*Attr, error) {
+	follows, err := r.Bool()
+	if err != nil || !follows {
+		return nil, err
+	}
+	return decodeAttr(r)
+}
+
+// wcc_data, the pre operation attributes are never sent
+func encodeWcc(w *oncrpc.Writer, after *Attr) {
+	w.Bool(false)
+	encodePostOpAttr(w, after)
+}
+
+func decodeWcc(r *oncrpc.Reader) error {
+	follows, err := r.Bool()
+	if err != nil {
+		return err
+	}
+	if follows {
+		// wcc_attr: size, mtime, ctime
+		if _, err := r.Uint64(); err != nil {
+			return err
+		}
+		for i := 0; i < 2; i++ {
+			if _, err := decodeTime(r); err != nil {
+				return err
+			}
+		}
+	}
+	_, err = decodePostOpAttr(r)
+	return err
+}
+
+func encodeFileHandle(w *oncrpc.Writer, fh FileHandle) {
+	w.Opaque(fh)
+}
+
+func decodeFileHandle(r *oncrpc.Reader) (FileHandle, error) {
+	fh, err := r.Opaque(maxFileHandleSize)
+	return FileHandle(fh), err
+}
+
+// post_op_fh3
+func encodePostOpFileHandle(w *oncrpc.Writer, fh FileHandle) {
+	w.Bool(fh != nil)
+	if fh != nil {
+		encodeFileHandle(w, fh)
+	}
+}
+
+func decodePostOpFileHandle(r *oncrpc.Reader) (FileHandle, error) {
+	follows, err := r.Bool()
+	if err != nil || !follows {
+		return nil, err
+	}
+	return decodeFileHandle(r)
+}
+
+func encodeSetAttr(w *oncrpc.Writer, s SetAttr) {
+	for _, v := range []*uint32{s.Mode, s.UID, s.GID} {
+		w.Bool(v != nil)
+		if v != nil {
+			w.Uint32(*v)
+		}
+	}
+	w.Bool(s.Size != nil)
+	if s.Size != nil {
+		w.Uint64(*s.Size)
+	}
+	for _, t := range []*Time{s.Atime, s.Mtime} {
+		if t == nil {
+			w.Uint32(dontChange)
+			continue
+		}
+		w.Uint32(setToClientTime)
+		encodeTime(w, *t)
+	}
+}
+
+func decodeSetAttr(r *oncrpc.Reader) (SetAttr, error) {
+	var s SetAttr
+	for _, v := range []**uint32{&s.Mode, &s.UID, &s.GID} {
+		set, err := r.Bool()
+		if err != nil {
+			return s, err
+		}
+		if set {
+			value, err := r.Uint32()
+			if err != nil {
+				return s, err
+			}
+			*v = &value
+		}
+	}
+	set, err := r.Bool()
+	if err != nil {
+		return s, err
+	}
+	if set {
+		size, err := r.Uint64()
+		if err != nil {
+			return s, err
+		}
+		s.Size = &size
+	}
+	for _, t := range []**Time{&s.Atime, &s.Mtime} {
+		how, err := r.Uint32()
+		if err != nil {
+			return s, err
+		}
+		switch how {
+		case dontChange:
+		case setToServerTime:
+			now := time.Now()
+			*t = &Time{Seconds: uint32(now.Unix()), Nseconds: uint32(now.Nanosecond())}
+		case setToClientTime:
+			value, err := decodeTime(r)
+			if err != nil {
+				return s, err
+			}
+			*t = &value
+		default:
+			return s, fmt.Errorf("nfs: invalid time_how %d", how)
+		}
+	}
+	return s, nil
+}
+
+// decodeStatus reads the nfsstat3 leading every result
+func decodeStatus(r *oncrpc.Reader) error {
+	stat, err := r.Uint32()
+	if err != nil {
+		return err
+	}
+	if Status(stat) != StatusOK {
+		return Status(stat)
+	}
+	return nil
+}
+
+func (t Time) time() time.Time {
+	return time.Unix(int64(t.Seconds), int64(t.Nseconds))
+}
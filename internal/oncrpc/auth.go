@@ -0,0 +1,97 @@
+package oncrpc
+
+import (
+	"fmt"
+	"time"
+)
+
+// Auth flavors
+const (
+	AuthFlavorNone = 0
+	AuthFlavorSys  = 1
+)
+
+// Auth is an opaque_auth credential or verifier
+type Auth struct {
+	Flavor uint32
+	Body   []byte
+}
+
+// AuthNone is the empty credential
+var AuthNone = Auth{Flavor: AuthFlavorNone}
+
+// AuthSys is the body of an AUTH_SYS credential
+type AuthSys struct {
+	MachineName string
+	UID         uint32
+	GID         uint32
+	GIDs        []uint32
+}
+
+// NewAuthSys returns an AUTH_SYS credential
+func NewAuthSys(machineName string, uid, gid uint32, gids []uint32) Auth {
+	w := NewWriter()
+	w.Uint32(uint32(time.Now().Unix()))
+	w.String(machineName)
+	w.Uint32(uid)
+	w.Uint32(gid)
+	w.Uint32(uint32(len(gids)))
+	for _, g := range gids {
+		w.Uint32(g)
+	}
+	return Auth{Flavor: AuthFlavorSys, Body: w.Bytes()}
+}
+
+// ParseAuthSys decodes the body of an AUTH_SYS credential
+func ParseAuthSys(auth Auth) (*AuthSys, error) {
+	if auth.Flavor != AuthFlavorSys {
+		return nil, fmt.Errorf("rpc: credential flavor %d is not AUTH_SYS", auth.Flavor)
+	}
+	r := NewReader(auth.Body)
+	if _, err := r.Uint32(); err != nil {
+		return nil, err
+	}
+	sys := &AuthSys{}
+	var err error
+	if sys.MachineName, err = r.String(255); err != nil {
+		return nil, err
+	}
+	if sys.UID, err = r.Uint32(); err != nil {
+		return nil, err
+	}
+	if sys.GID, err = r.Uint32(); err != nil {
+		return nil, err
+	}
+	n, err := r.Uint32()
+	if err != nil {
+		return nil, err
+	}
+	if n > 16 {
+		return nil, fmt.Errorf("rpc: AUTH_SYS with %d groups", n)
+	}
+	for i := uint32(0); i < n; i++ {
+		g, err := r.Uint32()
+		if err != nil {
+			return nil, err
+		}
+		sys.GIDs = append(sys.GIDs, g)
+	}
+	return sys, nil
+}
+
+func (a Auth) encode(w *Writer) {
+	w.Uint32(a.Flavor)
+	w.Opaque(a.Body)
+}
+
+func decodeAuth(r *Reader) (Auth, error) {
+	flavor, err := r.Uint32()
+	if err != nil {
+		return Auth{}, err
+	}
+	body, err := r.Opaque(maxAuthSize)
+	if err != nil {
+		return Auth{}, err
+	}
+	return Auth{Flavor: flavor, Body: body}, nil
+}
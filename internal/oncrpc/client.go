@@ -0,0 +1,131 @@
+package oncrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Client issues calls over a single TCP connection, one call at a time. A call failing
+// half way closes the connection, as the next reply could not be told apart from the rest
+// of the broken one, and later calls fail.
+type Client struct {
+	lock *sync.Mutex
+	conn net.Conn
+	xid  uint32
+	// Why the connection was closed, nil while it is usable
+	broken error
+
+	// Credential sent with every call
+	Auth Auth
+}
+
+// Dial connects to the rpc server at addr (host:port)
+func Dial(ctx context.Context, addr string) (*Client, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		lock: &sync.Mutex{},
+		conn: conn,
+		xid:  rand.Uint32(),
+		Auth: AuthNone,
+	}, nil
+}
+
+func (c *Client) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.broken != nil {
+		return nil
+	}
+	c.broken = net.ErrClosed
+	return c.conn.Close()
+}
+
+// Call calls the procedure with the encoded arguments and returns the encoded results,
+// the deadline and cancellation of ctx abort the call.
+func (c *Client) Call(ctx context.Context, prog, vers, proc uint32, args []byte) ([]byte, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.broken != nil {
+		return nil, fmt.Errorf("rpc: connection is closed: %w", c.broken)
+	}
+	deadline, _ := ctx.Deadline()
+	if err := c.conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			// Unblocks the pending read or write
+			_ = c.conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	c.xid++
+	xid := c.xid
+	msg := encodeCall(&Call{
+		Xid:  xid,
+		Prog: prog,
+		Vers: vers,
+		Proc: proc,
+		Cred: c.Auth,
+		Verf: AuthNone,
+		Args: args,
+	})
+	if err := writeRecord(c.conn, msg); err != nil {
+		return nil, c.fail(ctx, err)
+	}
+	record, err := readRecord(c.conn)
+	if err != nil {
+		return nil, c.fail(ctx, err)
+	}
+	replyXid, results, err := decodeReply(record)
+	if replyXid != xid {
+		// Replies come in order, one for another call means the stream is out of step
+		if err == nil {
+			err = fmt.Errorf("reply to call %d, want %d", replyXid, xid)
+		}
+		return nil, c.fail(ctx, err)
+	}
+	return results, err
+}
+
+// fail closes the connection after a call failed half way and returns the error of the call
+func (c *Client) fail(ctx context.Context, err error) error {
+	if _, ok := ctx.Deadline(); ok && errors.Is(err, os.ErrDeadlineExceeded) {
+		// The connection deadline can pass just before the one of ctx
+		err = context.DeadlineExceeded
+	} else if ctx.Err() != nil {
+		err = ctx.Err()
+	} else {
+		err = fmt.Errorf("rpc: %w", err)
+	}
+	c.broken = err
+	c.conn.Close()
+	return err
+}
+
+// Ping issues the NULL procedure of the program at addr (host:port)
+func Ping(ctx context.Context, addr string, prog, vers uint32) error {
+	c, err := Dial(ctx, addr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	_, err = c.Call(ctx, prog, vers, 0, nil)
+	return err
+}
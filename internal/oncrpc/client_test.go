@@ -0,0 +1,315 @@
+package oncrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+const (
+	testProg = 400000
+)
+
+// startTestServer serves the server on a random local port and returns its address
+func startTestServer(t *testing.T, s *Server) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go s.Serve(l) //nolint:errcheck // stopped by Close
+	t.Cleanup(func() { s.Close() })
+	return l.Addr().String()
+}
+
+func newEchoServer() *Server {
+	s := NewServer()
+	s.Register(testProg, 1, map[uint32]Handler{
+		1: func(call *Call) ([]byte, error) {
+			return call.Args, nil
+		},
+		2: func(call *Call) ([]byte, error) {
+			sys, err := ParseAuthSys(call.Cred)
+			if err != nil {
+				return nil, &AcceptError{Stat: GarbageArgs}
+			}
+			w := NewWriter()
+			w.Uint32(sys.UID)
+			w.String(sys.MachineName)
+			return w.Bytes(), nil
+		},
+		3: func(call *Call) ([]byte, error) {
+			return nil, errors.New("boom")
+		},
+		4: func(call *Call) ([]byte, error) {
+			time.Sleep(300 * time.Millisecond)
+			return nil, nil
+		},
+	})
+	s.Register(testProg, 3, nil)
+	return s
+}
+
+func TestClientCall(t *testing.T) {
+	addr := startTestServer(t, newEchoServer())
+	ctx := context.Background()
+
+	c, err := Dial(ctx, addr)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer c.Close()
+
+	w := NewWriter()
+	w.String("hello")
+	results, err := c.Call(ctx, testProg, 1, 1, w.Bytes())
+	if err != nil || !bytes.Equal(results, w.Bytes()) {
+		t.Errorf("Call() = %v, %v, want %v", results, err, w.Bytes())
+	}
+
+	c.Auth = NewAuthSys("client", 1000, 1000, []uint32{1000})
+	results, err = c.Call(ctx, testProg, 1, 2, nil)
+	if err != nil {
+		t.Fatalf("Call() error = %v", err)
+	}
+	r := NewReader(results)
+	if uid, _ := r.Uint32(); uid != 1000 {
+		t.Errorf("Server saw uid %d, want 1000", uid)
+	}
+	if name, _ := r.String(255); name != "client" {
+		t.Errorf("Server saw machine name %q, want client", name)
+	}
+
+	tests := []struct {
+		name string
+		prog uint32
+		vers uint32
+		proc uint32
+		stat AcceptStat
+	}{
+		{name: "Program unavailable", prog: testProg + 1, vers: 1, proc: 0, stat: ProgUnavail},
+		{name: "Version mismatch", prog: testProg, vers: 2, proc: 0, stat: ProgMismatch},
+		{name: "Procedure unavailable", prog: testProg, vers: 1, proc: 9, stat: ProcUnavail},
+		{name: "System error", prog: testProg, vers: 1, proc: 3, stat: SystemErr},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := c.Call(ctx, tt.prog, tt.vers, tt.proc, nil)
+			if !IsAcceptStat(err, tt.stat) {
+				t.Errorf("Call() error = %v, want %v", err, tt.stat)
+			}
+		})
+	}
+
+	_, err = c.Call(ctx, testProg, 2, 0, nil)
+	var acceptErr *AcceptError
+	if !errors.As(err, &acceptErr) || acceptErr.Low != 1 || acceptErr.High != 3 {
+		t.Errorf("Call() error = %v, want versions 1 to 3", err)
+	}
+}
+
+func TestClientCallTimeout(t *testing.T) {
+	addr := startTestServer(t, newEchoServer())
+
+	c, err := Dial(context.Background(), addr)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.Call(ctx, testProg, 1, 4, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Call() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	// The late reply must not be taken for the one of the next call
+	if _, err := c.Call(context.Background(), testProg, 1, 0, nil); err == nil {
+		t.Errorf("Call() after a timed out call succeeded, want the connection closed")
+	}
+}
+
+// startRawServer answers the calls on every connection with reply, which gets the xid
+// of the call and returns the bytes to send back
+func startRawServer(t *testing.T, reply func(xid uint32) []byte) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				for {
+					record, err := readRecord(conn)
+					if err != nil || len(record) < 4 {
+						return
+					}
+					if _, err := conn.Write(reply(binary.BigEndian.Uint32(record))); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// record frames msg as the last fragment of a record
+func record(msg []byte) []byte {
+	var buf bytes.Buffer
+	writeRecord(&buf, msg) //nolint:errcheck // writes to memory
+	return buf.Bytes()
+}
+
+func TestClientMalformedReply(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply func(xid uint32) []byte
+	}{
+		{
+			name:  "too short for an xid",
+			reply: func(xid uint32) []byte { return record([]byte{1, 2}) },
+		},
+		{
+			name: "another xid",
+			reply: func(xid uint32) []byte {
+				return record(encodeReply(xid+1, nil, nil))
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := Dial(context.Background(), startRawServer(t, tt.reply))
+			if err != nil {
+				t.Fatalf("Dial() error = %v", err)
+			}
+			defer c.Close()
+
+			// Without a deadline the call has to fail by itself
+			errCh := make(chan error, 1)
+			go func() {
+				_, err := c.Call(context.Background(), testProg, 1, 0, nil)
+				errCh <- err
+			}()
+			select {
+			case err := <-errCh:
+				if err == nil {
+					t.Errorf("Call() succeeded, want the malformed reply rejected")
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("Call() did not return")
+			}
+		})
+	}
+}
+
+func TestClientPartialReply(t *testing.T) {
+	calls := 0
+	addr := startRawServer(t, func(xid uint32) []byte {
+		calls++
+		reply := record(encodeReply(xid, nil, []byte{0, 0, 0, 7}))
+		if calls == 1 {
+			// Only part of the first reply arrives before the call gives up
+			return reply[:6]
+		}
+		return reply
+	})
+	c, err := Dial(context.Background(), addr)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.Call(ctx, testProg, 1, 0, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Call() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if _, err := c.Call(context.Background(), testProg, 1, 0, nil); err == nil {
+		t.Errorf("Call() after a partial reply succeeded, want the connection closed")
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("Close() of a closed connection error = %v", err)
+	}
+}
+
+func TestPing(t *testing.T) {
+	addr := startTestServer(t, newEchoServer())
+	if err := Ping(context.Background(), addr, testProg, 1); err != nil {
+		t.Errorf("Ping() error = %v", err)
+	}
+	if err := Ping(context.Background(), addr, testProg+1, 1); !IsAcceptStat(err, ProgUnavail) {
+		t.Errorf("Ping() error = %v, want %v", err, ProgUnavail)
+	}
+}
+
+func TestGetPort(t *testing.T) {
+	s := NewServer()
+	s.RegisterPortmap(func(prog, vers, proto uint32) uint32 {
+		if prog == ProgNFS && vers == 3 && proto == IPProtoTCP {
+			return 2049
+		}
+		return 0
+	})
+	addr := startTestServer(t, s)
+
+	port, err := GetPort(context.Background(), addr, ProgNFS, 3)
+	if err != nil || port != 2049 {
+		t.Errorf("GetPort() = %v, %v, want 2049", port, err)
+	}
+	if _, err := GetPort(context.Background(), addr, ProgMount, 3); err != ErrProgNotRegistered {
+		t.Errorf("GetPort() error = %v, want %v", err, ErrProgNotRegistered)
+	}
+}
+
+func TestReadRecordFragments(t *testing.T) {
+	var buf bytes.Buffer
+	for i, fragment := range []string{"frag", "mented"} {
+		marker := uint32(len(fragment))
+		if i == 1 {
+			marker |= lastFragment
+		}
+		binary.Write(&buf, binary.BigEndian, marker) //nolint:errcheck // writes to a buffer
+		buf.WriteString(fragment)
+	}
+
+	record, err := readRecord(&buf)
+	if err != nil || string(record) != "fragmented" {
+		t.Errorf("readRecord() = %q, %v, want fragmented", record, err)
+	}
+}
+
+func TestRejectedCall(t *testing.T) {
+	addr := startTestServer(t, newEchoServer())
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close()
+
+	w := NewWriter()
+	for _, v := range []uint32{1, msgCall, 3, testProg, 1, 0} {
+		w.Uint32(v)
+	}
+	if err := writeRecord(conn, w.Bytes()); err != nil {
+		t.Fatalf("writeRecord() error = %v", err)
+	}
+	record, err := readRecord(conn)
+	if err != nil {
+		t.Fatalf("readRecord() error = %v", err)
+	}
+	var rejectErr *RejectError
+	if _, _, err := decodeReply(record); !errors.As(err, &rejectErr) || !rejectErr.RPCMismatch {
+		t.Errorf("decodeReply() error = %v, want rpc mismatch", err)
+	}
+}
@@ -0,0 +1,70 @@
+package oncrpc
+
+import (
+	"context"
+	"errors"
+)
+
+const (
+	// PortmapPort is the well known port of the portmapper (rpcbind)
+	PortmapPort = 111
+
+	portmapVersion     = 2
+	portmapProcGetPort = 3
+
+	// Protocols of the portmapper mappings
+	IPProtoTCP = 6
+	IPProtoUDP = 17
+)
+
+// ErrProgNotRegistered is returned when the portmapper has no mapping for the program
+var ErrProgNotRegistered = errors.New("rpc: program not registered")
+
+// GetPort asks the portmapper at portmapAddr (host:port) for the TCP port of the program
+func GetPort(ctx context.Context, portmapAddr string, prog, vers uint32) (uint32, error) {
+	c, err := Dial(ctx, portmapAddr)
+	if err != nil {
+		return 0, err
+	}
+	defer c.Close()
+
+	w := NewWriter()
+	w.Uint32(prog)
+	w.Uint32(vers)
+	w.Uint32(IPProtoTCP)
+	w.Uint32(0)
+	results, err := c.Call(ctx, ProgPortmap, portmapVersion, portmapProcGetPort, w.Bytes())
+	if err != nil {
+		return 0, err
+	}
+
+	port, err := NewReader(results).Uint32()
+	if err != nil {
+		return 0, err
+	}
+	if port == 0 {
+		return 0, ErrProgNotRegistered
+	}
+	return port, nil
+}
+
+// RegisterPortmap serves the GETPORT procedure of the portmapper, lookup returns the port
+// of the program or 0 if it is not registered.
+func (s *Server) RegisterPortmap(lookup func(prog, vers, proto uint32) uint32) {
+	s.Register(ProgPortmap, portmapVersion, map[uint32]Handler{
+		portmapProcGetPort: func(call *Call) ([]byte, error) {
+			r := NewReader(call.Args)
+			var args [4]uint32
+			for i := range args {
+				v, err := r.Uint32()
+				if err != nil {
+					return nil, &AcceptError{Stat: GarbageArgs}
+				}
+				args[i] = v
+			}
+			w := NewWriter()
+			w.Uint32(lookup(args[0], args[1], args[2]))
+			return w.Bytes(), nil
+		},
+	})
+}
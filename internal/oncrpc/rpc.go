@@ -0,0 +1,308 @@
+// Package oncrpc is a small ONC RPC (RFC 5531) implementation over TCP, it is enough to talk
+// to the portmapper, MOUNT and NFS programs of a nfs server without the kernel client.
+package oncrpc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	rpcVersion = 2
+
+	msgCall  = 0
+	msgReply = 1
+
+	replyAccepted = 0
+	replyDenied   = 1
+
+	// Largest record accepted, nfs READ/WRITE replies stay well below it
+	maxRecordSize = 4 << 20
+	// Largest opaque_auth body allowed by RFC 5531
+	maxAuthSize = 400
+
+	lastFragment = 1 << 31
+)
+
+// Well known programs
+const (
+	ProgPortmap = 100000
+	ProgNFS     = 100003
+	ProgMount   = 100005
+)
+
+// AcceptStat is the status of an accepted call
+type AcceptStat uint32
+
+const (
+	Success      AcceptStat = 0
+	ProgUnavail  AcceptStat = 1
+	ProgMismatch AcceptStat = 2
+	ProcUnavail  AcceptStat = 3
+	GarbageArgs  AcceptStat = 4
+	SystemErr    AcceptStat = 5
+)
+
+func (s AcceptStat) String() string {
+	switch s {
+	case Success:
+		return "success"
+	case ProgUnavail:
+		return "program unavailable"
+	case ProgMismatch:
+		return "program version mismatch"
+	case ProcUnavail:
+		return "procedure unavailable"
+	case GarbageArgs:
+		return "garbage arguments"
+	case SystemErr:
+		return "system error"
+	}
+	return fmt.Sprintf("accept status %d", uint32(s))
+}
+
+// AcceptError is returned when the server accepted the call but did not execute it
+type AcceptError struct {
+	Stat AcceptStat
+	// Supported versions, only set for ProgMismatch
+	Low, High uint32
+}
+
+func (e *AcceptError) Error() string {
+	if e.Stat == ProgMismatch {
+		return fmt.Sprintf("rpc: %s, supported versions %d to %d", e.Stat, e.Low, e.High)
+	}
+	return fmt.Sprintf("rpc: %s", e.Stat)
+}
+
+// AuthStat is the reason the server rejected the credentials of a call
+type AuthStat uint32
+
+const (
+	AuthOK           AuthStat = 0
+	AuthBadCred      AuthStat = 1
+	AuthRejectedCred AuthStat = 2
+	AuthBadVerf      AuthStat = 3
+	AuthRejectedVerf AuthStat = 4
+	AuthTooWeak      AuthStat = 5
+	AuthInvalidResp  AuthStat = 6
+	AuthFailed       AuthStat = 7
+)
+
+func (s AuthStat) String() string {
+	switch s {
+	case AuthOK:
+		return "ok"
+	case AuthBadCred:
+		return "bad credential"
+	case AuthRejectedCred:
+		return "credential rejected"
+	case AuthBadVerf:
+		return "bad verifier"
+	case AuthRejectedVerf:
+		return "verifier rejected"
+	case AuthTooWeak:
+		return "credential too weak"
+	case AuthInvalidResp:
+		return "invalid response verifier"
+	case AuthFailed:
+		return "authentication failed"
+	}
+	return fmt.Sprintf("auth status %d", uint32(s))
+}
+
+// RejectError is returned when the server rejected the call
+type RejectError struct {
+	// Set when the rpc version is not supported
+	RPCMismatch bool
+	// Auth status of the rejection otherwise
+	AuthStat AuthStat
+}
+
+func (e *RejectError) Error() string {
+	if e.RPCMismatch {
+		return "rpc: call rejected, rpc version mismatch"
+	}
+	return fmt.Sprintf("rpc: call rejected, %s", e.AuthStat)
+}
+
+// IsAcceptStat reports whether err is an AcceptError with the given status
+func IsAcceptStat(err error, stat AcceptStat) bool {
+	var acceptErr *AcceptError
+	return errors.As(err, &acceptErr) && acceptErr.Stat == stat
+}
+
+// Call is a decoded rpc call
+type Call struct {
+	Xid  uint32
+	Prog uint32
+	Vers uint32
+	Proc uint32
+	Cred Auth
+	Verf Auth
+	// Encoded arguments of the procedure
+	Args []byte
+}
+
+func encodeCall(call *Call) []byte {
+	w := NewWriter()
+	w.Uint32(call.Xid)
+	w.Uint32(msgCall)
+	w.Uint32(rpcVersion)
+	w.Uint32(call.Prog)
+	w.Uint32(call.Vers)
+	w.Uint32(call.Proc)
+	call.Cred.encode(w)
+	call.Verf.encode(w)
+	w.FixedOpaque(call.Args)
+	return w.Bytes()
+}
+
+func decodeCall(msg []byte) (*Call, error) {
+	r := NewReader(msg)
+	call := &Call{}
+	var (
+		msgType, version uint32
+		err              error
+	)
+	for _, v := range []*uint32{&call.Xid, &msgType, &version, &call.Prog, &call.Vers, &call.Proc} {
+		if *v, err = r.Uint32(); err != nil {
+			return nil, err
+		}
+	}
+	if msgType != msgCall {
+		return nil, fmt.Errorf("rpc: unexpected message type %d", msgType)
+	}
+	if version != rpcVersion {
+		return call, &RejectError{RPCMismatch: true}
+	}
+	if call.Cred, err = decodeAuth(r); err != nil {
+		return nil, err
+	}
+	if call.Verf, err = decodeAuth(r); err != nil {
+		return nil, err
+	}
+	call.Args = r.Remaining()
+	return call, nil
+}
+
+// encodeReply encodes an accepted reply, results are only written for Success
+func encodeReply(xid uint32, err error, results []byte) []byte {
+	w := NewWriter()
+	w.Uint32(xid)
+	w.Uint32(msgReply)
+
+	var rejectErr *RejectError
+	if errors.As(err, &rejectErr) {
+		w.Uint32(replyDenied)
+		if rejectErr.RPCMismatch {
+			w.Uint32(0)
+			w.Uint32(rpcVersion)
+			w.Uint32(rpcVersion)
+		} else {
+			w.Uint32(1)
+			w.Uint32(uint32(rejectErr.AuthStat))
+		}
+		return w.Bytes()
+	}
+
+	w.Uint32(replyAccepted)
+	AuthNone.encode(w)
+	if err == nil {
+		w.Uint32(uint32(Success))
+		w.FixedOpaque(results)
+		return w.Bytes()
+	}
+
+	acceptErr := &AcceptError{Stat: SystemErr}
+	errors.As(err, &acceptErr)
+	w.Uint32(uint32(acceptErr.Stat))
+	if acceptErr.Stat == ProgMismatch {
+		w.Uint32(acceptErr.Low)
+		w.Uint32(acceptErr.High)
+	}
+	return w.Bytes()
+}
+
+// decodeReply returns the xid and the results of a reply, or the error the server replied with
+func decodeReply(msg []byte) (uint32, []byte, error) {
+	r := NewReader(msg)
+	xid, err := r.Uint32()
+	if err != nil {
+		return 0, nil, err
+	}
+	msgType, err := r.Uint32()
+	if err != nil {
+		return xid, nil, err
+	}
+	if msgType != msgReply {
+		return xid, nil, fmt.Errorf("rpc: unexpected message type %d", msgType)
+	}
+
+	replyStat, err := r.Uint32()
+	if err != nil {
+		return xid, nil, err
+	}
+	if replyStat == replyDenied {
+		rejectStat, err := r.Uint32()
+		if err != nil {
+			return xid, nil, err
+		}
+		if rejectStat == 0 {
+			return xid, nil, &RejectError{RPCMismatch: true}
+		}
+		authStat, _ := r.Uint32()
+		return xid, nil, &RejectError{AuthStat: AuthStat(authStat)}
+	}
+
+	if _, err := decodeAuth(r); err != nil {
+		return xid, nil, err
+	}
+	stat, err := r.Uint32()
+	if err != nil {
+		return xid, nil, err
+	}
+	switch AcceptStat(stat) {
+	case Success:
+		return xid, r.Remaining(), nil
+	case ProgMismatch:
+		low, _ := r.Uint32()
+		high, _ := r.Uint32()
+		return xid, nil, &AcceptError{Stat: ProgMismatch, Low: low, High: high}
+	}
+	return xid, nil, &AcceptError{Stat: AcceptStat(stat)}
+}
+
+// writeRecord sends the message as a single record marked fragment
+func writeRecord(w io.Writer, msg []byte) error {
+	buf := make([]byte, 4, 4+len(msg))
+	binary.BigEndian.PutUint32(buf, lastFragment|uint32(len(msg)))
+	_, err := w.Write(append(buf, msg...))
+	return err
+}
+
+// readRecord reads the fragments of a record
+func readRecord(r io.Reader) ([]byte, error) {
+	var record []byte
+	header := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return nil, err
+		}
+		marker := binary.BigEndian.Uint32(header)
+		size := int(marker &^ lastFragment)
+		if len(record)+size > maxRecordSize {
+			return nil, fmt.Errorf("rpc: record exceeds %d bytes", maxRecordSize)
+		}
+		fragment := make([]byte, size)
+		if _, err := io.ReadFull(r, fragment); err != nil {
+			return nil, err
+		}
+		record = append(record, fragment...)
+		if marker&lastFragment != 0 {
+			return record, nil
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package oncrpc
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	"k8s.io/klog/v2"
+)
+
+// Handler executes a procedure and returns its encoded results, returning an AcceptError
+// replies with its status, any other error replies with SystemErr.
+type Handler func(call *Call) ([]byte, error)
+
+// Server serves registered programs over TCP
+type Server struct {
+	lock *sync.Mutex
+	wg   sync.WaitGroup
+
+	// prog -> vers -> proc
+	programs  map[uint32]map[uint32]map[uint32]Handler
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+}
+
+func NewServer() *Server {
+	return &Server{
+		lock:      &sync.Mutex{},
+		programs:  make(map[uint32]map[uint32]map[uint32]Handler),
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+}
+
+// Register adds the procedures of a program version, the NULL procedure is added if missing
+func (s *Server) Register(prog, vers uint32, procs map[uint32]Handler) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.programs[prog]; !ok {
+		s.programs[prog] = make(map[uint32]map[uint32]Handler)
+	}
+	handlers := make(map[uint32]Handler, len(procs)+1)
+	handlers[0] = func(*Call) ([]byte, error) { return nil, nil }
+	for proc, h := range procs {
+		handlers[proc] = h
+	}
+	s.programs[prog][vers] = handlers
+}
+
+// Serve accepts connections until the listener fails or the server is closed
+func (s *Server) Serve(l net.Listener) error {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return net.ErrClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.lock.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.lock.Lock()
+			closed := s.closed
+			delete(s.listeners, l)
+			s.lock.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+
+		s.lock.Lock()
+		if s.closed {
+			s.lock.Unlock()
+			conn.Close()
+			return nil
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.lock.Unlock()
+
+		go s.serveConn(conn)
+	}
+}
+
+// Close stops the listeners and the open connections
+func (s *Server) Close() error {
+	s.lock.Lock()
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	s.lock.Unlock()
+
+	s.wg.Wait()
+	return nil
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		s.lock.Lock()
+		delete(s.conns, conn)
+		s.lock.Unlock()
+		s.wg.Done()
+	}()
+
+	for {
+		record, err := readRecord(conn)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				klog.V(4).InfoS("Closing rpc connection", "remote", conn.RemoteAddr(), "err", err)
+			}
+			return
+		}
+
+		call, err := decodeCall(record)
+		if call == nil {
+			klog.V(4).InfoS("Dropping malformed rpc call", "remote", conn.RemoteAddr(), "err", err)
+			return
+		}
+		var results []byte
+		if err == nil {
+			results, err = s.dispatch(call)
+		}
+		if err := writeRecord(conn, encodeReply(call.Xid, err, results)); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) dispatch(call *Call) ([]byte, error) {
+	s.lock.Lock()
+	versions, ok := s.programs[call.Prog]
+	var handler Handler
+	if ok {
+		var procs map[uint32]Handler
+		if procs, ok = versions[call.Vers]; !ok {
+			low, high := ^uint32(0), uint32(0)
+			for v := range versions {
+				if v < low {
+					low = v
+				}
+				if v > high {
+					high = v
+				}
+			}
+			s.lock.Unlock()
+			return nil, &AcceptError{Stat: ProgMismatch, Low: low, High: high}
+		}
+		handler = procs[call.Proc]
+	}
+	s.lock.Unlock()
+
+	if !ok {
+		return nil, &AcceptError{Stat: ProgUnavail}
+	}
+	if handler == nil {
+		return nil, &AcceptError{Stat: ProcUnavail}
+	}
+	return handler(call)
+}
@@ -0,0 +1,140 @@
+package oncrpc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// ErrShortBuffer is returned when a message ends before the value being decoded
+var ErrShortBuffer = errors.New("xdr: short buffer")
+
+// Writer encodes values in XDR (RFC 4506)
+type Writer struct {
+	buf []byte
+}
+
+func NewWriter() *Writer {
+	return &Writer{}
+}
+
+// Bytes returns the encoded message
+func (w *Writer) Bytes() []byte {
+	return w.buf
+}
+
+func (w *Writer) Uint32(v uint32) {
+	w.buf = binary.BigEndian.AppendUint32(w.buf, v)
+}
+
+func (w *Writer) Uint64(v uint64) {
+	w.buf = binary.BigEndian.AppendUint64(w.buf, v)
+}
+
+func (w *Writer) Bool(v bool) {
+	if v {
+		w.Uint32(1)
+	} else {
+		w.Uint32(0)
+	}
+}
+
+// FixedOpaque writes the bytes padded to a multiple of four, without a length
+func (w *Writer) FixedOpaque(v []byte) {
+	w.buf = append(w.buf, v...)
+	if pad := (4 - len(v)%4) % 4; pad > 0 {
+		w.buf = append(w.buf, make([]byte, pad)...)
+	}
+}
+
+// Opaque writes variable length bytes
+func (w *Writer) Opaque(v []byte) {
+	w.Uint32(uint32(len(v)))
+	w.FixedOpaque(v)
+}
+
+func (w *Writer) String(v string) {
+	w.Opaque([]byte(v))
+}
+
+// Reader decodes values in XDR (RFC 4506)
+type Reader struct {
+	buf []byte
+}
+
+func NewReader(buf []byte) *Reader {
+	return &Reader{buf: buf}
+}
+
+// Remaining returns the bytes which have not been decoded yet
+func (r *Reader) Remaining() []byte {
+	return r.buf
+}
+
+func (r *Reader) next(n int) ([]byte, error) {
+	if n < 0 || len(r.buf) < n {
+		return nil, ErrShortBuffer
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b, nil
+}
+
+func (r *Reader) Uint32() (uint32, error) {
+	b, err := r.next(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(b), nil
+}
+
+func (r *Reader) Uint64() (uint64, error) {
+	b, err := r.next(8)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+func (r *Reader) Bool() (bool, error) {
+	v, err := r.Uint32()
+	if err != nil {
+		return false, err
+	}
+	switch v {
+	case 0:
+		return false, nil
+	case 1:
+		return true, nil
+	}
+	return false, fmt.Errorf("xdr: invalid bool %d", v)
+}
+
+// FixedOpaque reads n bytes and the padding following them
+func (r *Reader) FixedOpaque(n int) ([]byte, error) {
+	b, err := r.next(n)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.next((4 - n%4) % 4); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Opaque reads variable length bytes of at most max bytes
+func (r *Reader) Opaque(max int) ([]byte, error) {
+	n, err := r.Uint32()
+	if err != nil {
+		return nil, err
+	}
+	if int64(n) > int64(max) {
+		return nil, fmt.Errorf("xdr: opaque of %d bytes exceeds the limit of %d", n, max)
+	}
+	return r.FixedOpaque(int(n))
+}
+
+func (r *Reader) String(max int) (string, error) {
+	b, err := r.Opaque(max)
+	return string(b), err
+}
@@ -0,0 +1,62 @@
+package oncrpc
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestXdrRoundTrip(t *testing.T) {
+	w := NewWriter()
+	w.Uint32(7)
+	w.Uint64(1 << 40)
+	w.Bool(true)
+	w.String("nfs")
+	w.Opaque([]byte{1, 2, 3, 4, 5})
+	w.FixedOpaque([]byte{9, 9})
+
+	if len(w.Bytes())%4 != 0 {
+		t.Fatalf("Encoded length %d is not a multiple of 4", len(w.Bytes()))
+	}
+
+	r := NewReader(w.Bytes())
+	if v, err := r.Uint32(); err != nil || v != 7 {
+		t.Errorf("Uint32() = %v, %v", v, err)
+	}
+	if v, err := r.Uint64(); err != nil || v != 1<<40 {
+		t.Errorf("Uint64() = %v, %v", v, err)
+	}
+	if v, err := r.Bool(); err != nil || !v {
+		t.Errorf("Bool() = %v, %v", v, err)
+	}
+	if v, err := r.String(16); err != nil || v != "nfs" {
+		t.Errorf("String() = %v, %v", v, err)
+	}
+	if v, err := r.Opaque(16); err != nil || !bytes.Equal(v, []byte{1, 2, 3, 4, 5}) {
+		t.Errorf("Opaque() = %v, %v", v, err)
+	}
+	if v, err := r.FixedOpaque(2); err != nil || !bytes.Equal(v, []byte{9, 9}) {
+		t.Errorf("FixedOpaque() = %v, %v", v, err)
+	}
+	if len(r.Remaining()) != 0 {
+		t.Errorf("Remaining() = %v, want empty", r.Remaining())
+	}
+	if _, err := r.Uint32(); err != ErrShortBuffer {
+		t.Errorf("Uint32() on empty buffer error = %v, want %v", err, ErrShortBuffer)
+	}
+}
+
+func TestXdrOpaqueLimit(t *testing.T) {
+	w := NewWriter()
+	w.Opaque(make([]byte, 32))
+	if _, err := NewReader(w.Bytes()).Opaque(16); err == nil {
+		t.Errorf("Expected an error for an opaque exceeding the limit")
+	}
+}
+
+func TestXdrInvalidBool(t *testing.T) {
+	w := NewWriter()
+	w.Uint32(2)
+	if _, err := NewReader(w.Bytes()).Bool(); err == nil {
+		t.Errorf("Expected an error for an invalid bool")
+	}
+}
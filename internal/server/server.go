@@ -1,117 +0,0 @@
-/*
-Copyright 2017 The Kubernetes Authors.
-
-Licensed under the Apache License, Version 2.0 (the "License");
-you may not use this file except in compliance with the License.
-You may obtain a copy of the License at
-
-    http://www.apache.org/licenses/LICENSE-2.0
-
-Unless required by applicable law or agreed to in writing, software
-distributed under the License is distributed on an "AS IS" BASIS,
-WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
-See the License for the specific language governing permissions and
-limitations under the License.
-*/
-
-// This file is copied to avoid glog deuplicate import issue
-
-package server
-
-import (
-	"net"
-	"os"
-	"sync"
-
-	"google.golang.org/grpc"
-	"k8s.io/klog/v2"
-
-	"github.com/container-storage-interface/spec/lib/go/csi"
-)
-
-// Defines Non blocking GRPC server interfaces
-type NonBlockingGRPCServer interface {
-	// Start services at the endpoint
-	Start(endpoint string, ids csi.IdentityServer, cs csi.ControllerServer, ns csi.NodeServer)
-	// Waits for the service to stop
-	Wait()
-	// Stops the service gracefully
-	Stop()
-	// Stops the service forcefully
-	ForceStop()
-}
-
-func NewNonBlockingGRPCServer() NonBlockingGRPCServer {
-	return &nonBlockingGRPCServer{}
-}
-
-// NonBlocking server
-type nonBlockingGRPCServer struct {
-	wg     sync.WaitGroup
-	server *grpc.Server
-}
-
-func (s *nonBlockingGRPCServer) Start(endpoint string, ids csi.IdentityServer, cs csi.ControllerServer, ns csi.NodeServer) {
-
-	s.wg.Add(1)
-
-	go s.serve(endpoint, ids, cs, ns)
-
-	return
-}
-
-func (s *nonBlockingGRPCServer) Wait() {
-	s.wg.Wait()
-}
-
-func (s *nonBlockingGRPCServer) Stop() {
-	s.server.GracefulStop()
-}
-
-func (s *nonBlockingGRPCServer) ForceStop() {
-	s.server.Stop()
-}
-
-func (s *nonBlockingGRPCServer) serve(endpoint string, ids csi.IdentityServer, cs csi.ControllerServer, ns csi.NodeServer) {
-
-	proto, addr, err := ParseEndpoint(endpoint)
-	if err != nil {
-		klog.Fatal(err.Error())
-	}
-
-	if proto == "unix" {
-		addr = "/" + addr
-		if err := os.Remove(addr); err != nil && !os.IsNotExist(err) {
-			klog.Fatalf("Failed to remove %s, error: %s", addr, err.Error())
-		}
-	}
-
-	listener, err := net.Listen(proto, addr)
-	if err != nil {
-		klog.Fatalf("Failed to listen: %v", err)
-	}
-
-	opts := []grpc.ServerOption{
-		grpc.UnaryInterceptor(logGRPC),
-	}
-	server := grpc.NewServer(opts...)
-	s.server = server
-
-	if ids != nil {
-		csi.RegisterIdentityServer(server, ids)
-	}
-	if cs != nil {
-		csi.RegisterControllerServer(server, cs)
-	}
-	if ns != nil {
-		csi.RegisterNodeServer(server, ns)
-	}
-
-	klog.Infof("Listening for connections on address: %#v", listener.Addr())
-
-	err = server.Serve(listener)
-	if err != nil {
-		klog.Fatalf("Failed to serve: %v", err)
-	}
-
-}
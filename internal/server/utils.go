@@ -1,45 +0,0 @@
-package server
-
-import (
-	"context"
-	"errors"
-	"fmt"
-	"strings"
-
-	"github.com/kubernetes-csi/csi-lib-utils/protosanitizer"
-	"google.golang.org/grpc"
-	"k8s.io/klog/v2"
-)
-
-func ParseEndpoint(ep string) (string, string, error) {
-	if strings.HasPrefix(strings.ToLower(ep), "unix://") || strings.HasPrefix(strings.ToLower(ep), "tcp://") {
-		s := strings.SplitN(ep, "://", 2)
-		if s[1] != "" {
-			return s[0], s[1], nil
-		}
-	}
-	return "", "", errors.New(fmt.Sprintf("Invalid endpoint: %v", ep))
-}
-
-func logGRPC(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-	level := klog.Level(getLogLevel(info.FullMethod))
-	klog.V(level).Infof("GRPC call: %s", info.FullMethod)
-	klog.V(level).Infof("GRPC request: %s", protosanitizer.StripSecrets(req))
-
-	resp, err := handler(ctx, req)
-	if err != nil {
-		klog.Errorf("GRPC error: %v", err)
-	} else {
-		klog.V(level).Infof("GRPC response: %s", protosanitizer.StripSecrets(resp))
-	}
-	return resp, err
-}
-
-func getLogLevel(method string) int32 {
-	if method == "/csi.v1.Identity/Probe" ||
-		method == "/csi.v1.Node/NodeGetCapabilities" ||
-		method == "/csi.v1.Node/NodeGetVolumeStats" {
-		return 8
-	}
-	return 2
-}
@@ -1,49 +1,161 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
 	"strings"
-	"sync"
 	"syscall"
+	"time"
 
 	_ "net/http/pprof"
 
 	"github.com/chenliu1993/simple-csi-driver/internal/nfs"
+	"github.com/chenliu1993/simple-csi-driver/pkg/driver"
+	"github.com/chenliu1993/simple-csi-driver/pkg/logging"
+	"github.com/chenliu1993/simple-csi-driver/pkg/registration"
+	"github.com/chenliu1993/simple-csi-driver/pkg/server"
 	"github.com/chenliu1993/simple-csi-driver/pkg/utils"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"k8s.io/klog/v2"
 )
 
-const (
-	// General Plugin Name
-	TypePluginName = "plugin.csi.cliufreever.com"
-	// NFS CSI NAME
-	TypePluginNFS = "nfsplugin.csi.cliufreever.com"
-)
-
 var (
-	endpoint = flag.String("endpoint", "unix://tmp/csi.sock", "CSI endpoint")
-	driver   = flag.String("driver", TypePluginNFS, "name of the driver")
-	nodeName = flag.String("node", "", "node name")
+	endpoint           = flag.String("endpoint", "unix://tmp/csi.sock", "CSI endpoint")
+	driverNames        = flag.String("driver", nfs.DriverName, "comma separated names of the drivers to run, a name may be shortened to its first label")
+	nodeName           = flag.String("node", "", "node name")
+	nodeIDFromHostname = flag.Bool("node-id-from-hostname", false, "use the hostname as node name if --node is not set")
+	driverEndpoints    = flag.String("driver-endpoints", "", "comma separated driver=endpoint overrides of --endpoint, every driver needs an endpoint of its own")
+	driverNodeIDs      = flag.String("driver-node-ids", "", "comma separated driver=node overrides of --node, a node ID for every driver makes --node optional")
+	mode               = flag.String("mode", string(driver.ModeAll), "CSI services to serve: controller, node or all, the node name is only required for node and all")
+	metricsAddress     = flag.String("metrics-address", "", "address the prometheus metrics are served at on /metrics, e.g. :29644, disabled if empty")
+	registrationDir    = flag.String("plugin-registration-dir", "", "kubelet plugins_registry directory the drivers register in instead of through node-driver-registrar, e.g. /var/lib/kubelet/plugins_registry, disabled if empty")
+	registrationPath   = flag.String("kubelet-registration-path", "", "path of the CSI socket as kubelet sees it, the socket of the endpoint if empty, only for a single driver")
+	healthAddress      = flag.String("health-address", "", "address the liveness and readiness of the drivers are served at on /healthz and /readyz, e.g. :29653, may equal --metrics-address, disabled if empty")
+	csiDriverName      = flag.String("drivername", "", "CSI name the driver is served as instead of its registered name, only when a single driver is run")
+	logFormat          = flag.String("log-format", logging.FormatText, "format of the log output: text or json, a json object per line")
+	grpcTimeout        = flag.Duration("grpc-timeout", 2*time.Minute, "deadline of CSI calls unless the CO sets an earlier one, 0 for none")
+	grpcMethodTimeouts = flag.String("grpc-method-timeouts", "", "comma separated method=duration overrides of --grpc-timeout, e.g. NodePublishVolume=5m")
+	grpcMaxConcurrent  = flag.Int("grpc-max-concurrent", 0, "CSI calls of a method served at once, further calls wait until their deadline, 0 for no limit")
+	grpcMethodLimits   = flag.String("grpc-method-max-concurrent", "", "comma separated method=limit overrides of --grpc-max-concurrent, e.g. CreateVolume=10")
 )
 
+// Deadline of the checks behind /healthz and /readyz
+const healthTimeout = 5 * time.Second
+
+func init() {
+	// The helm chart passes the node name as --nodeid
+	flag.StringVar(nodeName, "nodeid", "", "alias of --node")
+}
+
 func main() {
 	klog.InitFlags(nil)
+	driver.AddFlags(flag.CommandLine)
 	flag.Parse()
+	if err := logging.SetFormat(*logFormat, os.Stderr); err != nil {
+		klog.Fatalf("Invalid log format: %v", err)
+	}
+
+	if flag.NArg() > 0 {
+		if err := runCommand(flag.Args()); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	driverMode, err := driver.ParseMode(*mode)
+	if err != nil {
+		klog.Fatalf("Invalid mode: %v", err)
+	}
+	if driverMode.Node() && *nodeName == "" && *nodeIDFromHostname {
+		if err := nodeNameFromHostname(); err != nil {
+			klog.Fatalf("Failed to determine node name: %v", err)
+		}
+	}
+
+	// Drivers register themselves and their flags
+	var registrations []driver.Registration
+	var names []string
+	for _, name := range driver.SplitList(*driverNames) {
+		r, ok := driver.Lookup(name)
+		if !ok {
+			klog.Fatalf("Unknown driver %s, registered drivers are %s", name, strings.Join(registeredNames(), ", "))
+		}
+		registrations = append(registrations, r)
+		names = append(names, r.Name)
+	}
 
-	if *nodeName == "" {
-		klog.Warning("Node name is required.")
+	stopCh := make(chan os.Signal, 1)
+	signal.Notify(stopCh, syscall.SIGINT, syscall.SIGTERM)
+	stopChs := utils.GenMultiChs(names, stopCh)
+
+	endpoints, err := driver.ParseMappings(*driverEndpoints, "driver=endpoint")
+	if err != nil {
+		klog.Fatalf("Failed to parse driver endpoints: %v", err)
+	}
+	nodeIDs, err := driver.ParseMappings(*driverNodeIDs, "driver=node")
+	if err != nil {
+		klog.Fatalf("Failed to parse driver node IDs: %v", err)
+	}
+	grpcOptions, err := serverOptions()
+	if err != nil {
+		klog.Fatalf("Invalid grpc options: %v", err)
+	}
+	options, err := driver.ResolveOptions(registrations, driver.Options{
+		DriverName: *csiDriverName,
+		Endpoint:   *endpoint,
+		NodeID:     *nodeName,
+		Mode:       driverMode,
+		Server:     grpcOptions,
+	}, endpoints, nodeIDs)
+	if err != nil {
+		// Drivers serving the node service need --node, --node-id-from-hostname or their own --driver-node-ids
+		klog.Fatalf("Invalid driver options: %v", err)
+	}
+
+	// Creating the drivers validates their flags before anything is served
+	var drivers []driver.Driver
+	for i, r := range registrations {
+		d, err := r.New(options[i], stopChs[r.Name])
+		if err != nil {
+			klog.Fatalf("Failed to create driver %s: %v", r.Name, err)
+		}
+		klog.V(2).Infof("CSI endpoint for driver %s: %s, node %s, mode %s", r.Name, options[i].Endpoint, options[i].NodeID, driverMode)
+		drivers = append(drivers, d)
+	}
+
+	muxes := make(map[string]*http.ServeMux)
+	muxAt := func(address string) *http.ServeMux {
+		if _, ok := muxes[address]; !ok {
+			muxes[address] = http.NewServeMux()
+		}
+		return muxes[address]
+	}
+	if *metricsAddress != "" {
+		muxAt(*metricsAddress).Handle("/metrics", promhttp.Handler())
+	}
+	if *healthAddress != "" {
+		health := driver.HealthHandler(drivers, healthTimeout)
+		muxAt(*healthAddress).Handle("/healthz", health)
+		muxAt(*healthAddress).Handle("/readyz", health)
+	}
+	for address, mux := range muxes {
+		if err := serveHTTP(address, mux); err != nil {
+			klog.Fatalf("Failed to serve on %s: %v", address, err)
+		}
 	}
 
-	// For debugging
 	pprofPort := os.Getenv("PPROF_PORT")
 	if pprofPort != "" {
 		if _, err := strconv.Atoi(pprofPort); err == nil {
-			klog.V(2).InfoS("Enable pprof at: %s", pprofPort)
+			klog.V(2).InfoS("Enabling pprof", "port", pprofPort)
 			go func() {
 				err := http.ListenAndServe(fmt.Sprintf("0.0.0.0:%v", pprofPort), nil)
 				klog.V(2).ErrorS(err, "Start pprof error")
@@ -51,39 +163,150 @@ func main() {
 		}
 	}
 
-	klog.V(2).Infof("Driver %s is running at %s on node %s", *driver, *endpoint, *nodeName)
-
-	driverList := *driver
-	drivers := strings.Split(driverList, ",")
-	// Start the relevant drivers
-	var wg sync.WaitGroup
+	// SIGHUP reloads the settings of the drivers which support it
+	reloadCh := make(chan os.Signal, 1)
+	signal.Notify(reloadCh, syscall.SIGHUP)
+	go func() {
+		for range reloadCh {
+			klog.V(2).Info("Received SIGHUP, reloading the drivers")
+			driver.Reload(drivers)
+		}
+	}()
 
-	for idx, d := range drivers {
-		if !strings.Contains(d, TypePluginName) {
-			drivers[idx] = d + "." + TypePluginName
+	var kubeletRegistrations []*registration.Server
+	if *registrationDir != "" && driverMode.Node() {
+		kubeletRegistrations, err = registerWithKubelet(drivers, options)
+		if err != nil {
+			klog.Fatalf("Failed to register with kubelet: %v", err)
 		}
 	}
 
-	stopCh := make(chan os.Signal, 1)
-	signal.Notify(stopCh, syscall.SIGTERM)
-	stopChs := utils.GenMultiChs(drivers, stopCh)
+	// A failing driver is reported and leaves the others running
 	go utils.SendToMultiChs(stopChs, stopCh)
+	failures := driver.Supervise(drivers)
+	// Kubelet deregisters the drivers as their sockets go away
+	for _, r := range kubeletRegistrations {
+		r.Stop()
+	}
+	if len(failures) > 0 {
+		klog.Errorf("%d of %d drivers failed", len(failures), len(drivers))
+		klog.Flush()
+		os.Exit(1)
+	}
+	klog.V(2).Infof("Driver %s stopped", *driverNames)
+	os.Exit(0)
+}
+
+// nodeNameFromHostname sets the node name to the hostname
+func nodeNameFromHostname() error {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	if hostname == "" {
+		return errors.New("hostname is empty")
+	}
+	klog.V(2).InfoS("Using hostname as node name", "node", hostname)
+	*nodeName = hostname
+	return nil
+}
+
+// serverOptions returns the timeouts and concurrency limits of the CSI calls given by the
+// flags, methods are named without their service such as NodePublishVolume
+func serverOptions() (server.Options, error) {
+	opts := server.Options{
+		Timeout:             *grpcTimeout,
+		MethodTimeouts:      make(map[string]time.Duration),
+		MaxConcurrent:       *grpcMaxConcurrent,
+		MethodMaxConcurrent: make(map[string]int),
+	}
+	if opts.Timeout < 0 || opts.MaxConcurrent < 0 {
+		return opts, errors.New("--grpc-timeout and --grpc-max-concurrent cannot be negative")
+	}
 
-	for _, driver := range drivers {
-		wg.Add(1)
-		klog.V(2).Infof("CSI endpoint for driver %s: %s", driver, *endpoint)
-
-		switch driver {
-		case TypePluginNFS:
-			go func(endpoint string) {
-				defer wg.Done()
-				nfsDriver := nfs.NewNFSDriver(TypePluginNFS, endpoint, *nodeName, stopChs[TypePluginNFS])
-				nfsDriver.Run()
-			}(*endpoint)
+	timeouts, err := driver.ParseMappings(*grpcMethodTimeouts, "method=duration")
+	if err != nil {
+		return opts, err
+	}
+	for method, value := range timeouts {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout < 0 {
+			return opts, fmt.Errorf("invalid timeout %q of %s", value, method)
 		}
+		opts.MethodTimeouts[method] = timeout
 	}
 
-	wg.Wait()
-	klog.V(2).Infof("Driver %s stopped", *driver)
-	os.Exit(0)
+	limits, err := driver.ParseMappings(*grpcMethodLimits, "method=limit")
+	if err != nil {
+		return opts, err
+	}
+	for method, value := range limits {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			return opts, fmt.Errorf("invalid concurrency limit %q of %s", value, method)
+		}
+		opts.MethodMaxConcurrent[method] = limit
+	}
+	return opts, nil
+}
+
+// serveHTTP serves the handler at address in the background, failing to listen is
+// reported right away
+func serveHTTP(address string, handler http.Handler) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	klog.V(2).InfoS("Serving http", "address", listener.Addr().String())
+	go func() {
+		err := http.Serve(listener, handler)
+		klog.ErrorS(err, "Http server stopped", "address", address)
+	}()
+	return nil
+}
+
+// registerWithKubelet serves the kubelet registration socket of every driver once its CSI
+// endpoint answers, kubelet calls the driver right after registering it
+func registerWithKubelet(drivers []driver.Driver, options []*driver.Options) ([]*registration.Server, error) {
+	if *registrationPath != "" && len(drivers) > 1 {
+		return nil, errors.New("--kubelet-registration-path is only supported for a single driver")
+	}
+	var servers []*registration.Server
+	for i, d := range drivers {
+		endpoint := *registrationPath
+		if endpoint == "" {
+			var err error
+			if endpoint, err = registration.KubeletEndpoint(options[i].Endpoint); err != nil {
+				return nil, fmt.Errorf("driver %s: %v", d.Name(), err)
+			}
+		}
+		s := registration.NewServer(d.Name(), endpoint, *registrationDir)
+		servers = append(servers, s)
+
+		go func(name, csiEndpoint string) {
+			for {
+				ctx, cancel := context.WithTimeout(context.Background(), healthTimeout)
+				_, err := server.Probe(ctx, csiEndpoint)
+				cancel()
+				if err == nil {
+					break
+				}
+				klog.V(4).InfoS("Waiting for the CSI endpoint before registering with kubelet", "driver", name, "err", err)
+				time.Sleep(time.Second)
+			}
+			if err := s.Start(); err != nil {
+				klog.Errorf("Failed to register driver %s with kubelet: %v", name, err)
+			}
+		}(d.Name(), options[i].Endpoint)
+	}
+	return servers, nil
+}
+
+// registeredNames returns the names of the registered drivers
+func registeredNames() []string {
+	var names []string
+	for _, r := range driver.Registered() {
+		names = append(names, r.Name)
+	}
+	return names
 }
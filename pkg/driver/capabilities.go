@@ -0,0 +1,51 @@
+package driver
+
+import (
+	"github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+func NewControllerServiceCapability(cap csi.ControllerServiceCapability_RPC_Type) *csi.ControllerServiceCapability {
+	return &csi.ControllerServiceCapability{
+		Type: &csi.ControllerServiceCapability_Rpc{
+			Rpc: &csi.ControllerServiceCapability_RPC{
+				Type: cap,
+			},
+		},
+	}
+}
+
+func NewNodeServiceCapability(cap csi.NodeServiceCapability_RPC_Type) *csi.NodeServiceCapability {
+	return &csi.NodeServiceCapability{
+		Type: &csi.NodeServiceCapability_Rpc{
+			Rpc: &csi.NodeServiceCapability_RPC{
+				Type: cap,
+			},
+		},
+	}
+}
+
+// Capabilities are the controller and node capabilities a driver declares
+type Capabilities struct {
+	Controller []csi.ControllerServiceCapability_RPC_Type
+	Node       []csi.NodeServiceCapability_RPC_Type
+}
+
+// ControllerServiceCapabilities returns the declared controller capabilities as advertised
+// by ControllerGetCapabilities
+func (c *Capabilities) ControllerServiceCapabilities() []*csi.ControllerServiceCapability {
+	var caps []*csi.ControllerServiceCapability
+	for _, cap := range c.Controller {
+		caps = append(caps, NewControllerServiceCapability(cap))
+	}
+	return caps
+}
+
+// NodeServiceCapabilities returns the declared node capabilities as advertised by
+// NodeGetCapabilities
+func (c *Capabilities) NodeServiceCapabilities() []*csi.NodeServiceCapability {
+	var caps []*csi.NodeServiceCapability
+	for _, cap := range c.Node {
+		caps = append(caps, NewNodeServiceCapability(cap))
+	}
+	return caps
+}
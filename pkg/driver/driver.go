@@ -0,0 +1,114 @@
+// Package driver is the framework the CSI drivers of the plugin are built on: the Driver
+// interface, a registry the plugin binary starts drivers from, capability builders and
+// parsing of options shared by drivers.
+package driver
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/chenliu1993/simple-csi-driver/pkg/server"
+)
+
+// Driver is a CSI driver the plugin binary can run
+type Driver interface {
+	// Name is the CSI name of the driver
+	Name() string
+	// Run serves the CSI services of the driver until it is stopped, an error means it
+	// failed rather than being stopped
+	Run() error
+}
+
+// Options are the settings every driver is started with, driver specific settings come
+// from the flags the driver registers
+type Options struct {
+	// CSI name the driver is served as, its registered name unless renamed
+	DriverName string
+	// Endpoint the CSI services are served at, unique per driver
+	Endpoint string
+	// Only required when the node service is served
+	NodeID string
+	// Services the driver serves, all if unset
+	Mode Mode
+	// Timeouts and concurrency limits of the calls of the CSI services
+	Server server.Options
+}
+
+// Registration describes a driver to the registry
+type Registration struct {
+	// CSI name of the driver
+	Name string
+	// AddFlags registers the flags of the driver, optional
+	AddFlags func(fs *flag.FlagSet)
+	// New creates the driver once the flags are parsed, the driver stops when stopCh fires
+	New func(opts *Options, stopCh chan os.Signal) (Driver, error)
+}
+
+var (
+	registryLock = &sync.Mutex{}
+	registry     = make(map[string]Registration)
+)
+
+// Register adds a driver to the registry, usually from the init function of its package.
+// Registering a name twice panics.
+func Register(r Registration) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	if r.Name == "" || r.New == nil {
+		panic("driver: registration needs a name and a constructor")
+	}
+	if _, ok := registry[r.Name]; ok {
+		panic(fmt.Sprintf("driver: %s is registered twice", r.Name))
+	}
+	registry[r.Name] = r
+}
+
+// Lookup returns the registered driver called name. A short name selects the driver whose
+// name starts with it followed by a dot, e.g. nfsplugin for nfsplugin.csi.cliufreever.com.
+func Lookup(name string) (Registration, bool) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	if r, ok := registry[name]; ok {
+		return r, true
+	}
+	var found []Registration
+	for n, r := range registry {
+		if strings.HasPrefix(n, name+".") {
+			found = append(found, r)
+		}
+	}
+	if len(found) != 1 {
+		return Registration{}, false
+	}
+	return found[0], true
+}
+
+// Registered returns the registered drivers ordered by name
+func Registered() []Registration {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	registrations := make([]Registration, 0, len(registry))
+	for _, r := range registry {
+		registrations = append(registrations, r)
+	}
+	sort.Slice(registrations, func(i, j int) bool {
+		return registrations[i].Name < registrations[j].Name
+	})
+	return registrations
+}
+
+// AddFlags registers the flags of every registered driver on fs
+func AddFlags(fs *flag.FlagSet) {
+	for _, r := range Registered() {
+		if r.AddFlags != nil {
+			r.AddFlags(fs)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package driver
+
+import (
+	"flag"
+	"os"
+	"testing"
+)
+
+type fakeDriver struct {
+	name string
+	run  func() error
+}
+
+func (d *fakeDriver) Name() string {
+	return d.name
+}
+
+func (d *fakeDriver) Run() error {
+	if d.run == nil {
+		return nil
+	}
+	return d.run()
+}
+
+func TestRegistry(t *testing.T) {
+	var size int
+	Register(Registration{
+		Name: "fakeplugin.csi.example.com",
+		AddFlags: func(fs *flag.FlagSet) {
+			fs.IntVar(&size, "fake-size", 1, "size of the fake")
+		},
+		New: func(opts *Options, stopCh chan os.Signal) (Driver, error) {
+			return &fakeDriver{name: opts.DriverName}, nil
+		},
+	})
+
+	tests := []struct {
+		name   string
+		lookup string
+		wantOk bool
+	}{
+		{
+			name:   "full name",
+			lookup: "fakeplugin.csi.example.com",
+			wantOk: true,
+		},
+		{
+			name:   "short name",
+			lookup: "fakeplugin",
+			wantOk: true,
+		},
+		{
+			name:   "prefix which is no label",
+			lookup: "fake",
+		},
+		{
+			name:   "unknown",
+			lookup: "other.csi.example.com",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, ok := Lookup(tt.lookup)
+			if ok != tt.wantOk {
+				t.Fatalf("Lookup(%q) ok = %v, want %v", tt.lookup, ok, tt.wantOk)
+			}
+			if ok && r.Name != "fakeplugin.csi.example.com" {
+				t.Errorf("Lookup(%q) = %s", tt.lookup, r.Name)
+			}
+		})
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	AddFlags(fs)
+	if err := fs.Parse([]string{"--fake-size=3"}); err != nil || size != 3 {
+		t.Errorf("Parse() error = %v, size = %d, want 3", err, size)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Register() of a registered name did not panic")
+		}
+	}()
+	Register(Registration{
+		Name: "fakeplugin.csi.example.com",
+		New: func(opts *Options, stopCh chan os.Signal) (Driver, error) {
+			return nil, nil
+		},
+	})
+}
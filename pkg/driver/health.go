@@ -0,0 +1,85 @@
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// CheckResult is the outcome of a health check of a driver
+type CheckResult struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// NewCheckResult returns the result of the check called name, which failed with err unless
+// it is nil
+func NewCheckResult(name string, err error) CheckResult {
+	if err != nil {
+		return CheckResult{Name: name, Error: err.Error()}
+	}
+	return CheckResult{Name: name, OK: true}
+}
+
+// Passed returns true if none of the checks failed
+func Passed(results []CheckResult) bool {
+	for _, r := range results {
+		if !r.OK {
+			return false
+		}
+	}
+	return true
+}
+
+// HealthChecker is implemented by drivers which report their health
+type HealthChecker interface {
+	// Live checks whether the driver still serves, a driver failing them should be restarted
+	Live(ctx context.Context) []CheckResult
+	// Ready checks whether the driver is able to serve volumes
+	Ready(ctx context.Context) []CheckResult
+}
+
+// HealthReport is the body of the health endpoints
+type HealthReport struct {
+	OK bool `json:"ok"`
+	// Results of the checks by driver name
+	Drivers map[string][]CheckResult `json:"drivers"`
+}
+
+// HealthHandler serves the liveness of the drivers at /healthz and their readiness at
+// /readyz, each check bounded by timeout. The report is answered with 503 if a check of
+// any driver failed. Drivers which are no HealthChecker pass.
+func HealthHandler(drivers []Driver, timeout time.Duration) http.Handler {
+	mux := http.NewServeMux()
+	handle := func(check func(h HealthChecker, ctx context.Context) []CheckResult) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+
+			report := HealthReport{OK: true, Drivers: make(map[string][]CheckResult)}
+			for _, d := range drivers {
+				results := []CheckResult{}
+				if h, ok := d.(HealthChecker); ok {
+					results = check(h, ctx)
+				}
+				report.Drivers[d.Name()] = results
+				report.OK = report.OK && Passed(results)
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			if !report.OK {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+			if err := json.NewEncoder(w).Encode(report); err != nil {
+				klog.Errorf("Failed to write the health report: %v", err)
+			}
+		}
+	}
+	mux.Handle("/healthz", handle(HealthChecker.Live))
+	mux.Handle("/readyz", handle(HealthChecker.Ready))
+	return mux
+}
@@ -0,0 +1,74 @@
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type checkedDriver struct {
+	fakeDriver
+	live  []CheckResult
+	ready []CheckResult
+}
+
+func (d *checkedDriver) Live(ctx context.Context) []CheckResult {
+	return d.live
+}
+
+func (d *checkedDriver) Ready(ctx context.Context) []CheckResult {
+	return d.ready
+}
+
+func TestHealthHandler(t *testing.T) {
+	serving := NewCheckResult("csi-endpoint", nil)
+	unreachable := NewCheckResult("nfs-servers", errors.New("no nfs server is reachable"))
+	handler := HealthHandler([]Driver{
+		&checkedDriver{fakeDriver: fakeDriver{name: "checked"}, live: []CheckResult{serving}, ready: []CheckResult{serving, unreachable}},
+		&fakeDriver{name: "unchecked"},
+	}, time.Second)
+
+	tests := []struct {
+		path       string
+		wantStatus int
+		want       HealthReport
+	}{
+		{
+			path:       "/healthz",
+			wantStatus: http.StatusOK,
+			want: HealthReport{OK: true, Drivers: map[string][]CheckResult{
+				"checked":   {serving},
+				"unchecked": {},
+			}},
+		},
+		{
+			path:       "/readyz",
+			wantStatus: http.StatusServiceUnavailable,
+			want: HealthReport{Drivers: map[string][]CheckResult{
+				"checked":   {serving, unreachable},
+				"unchecked": {},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			var got HealthReport
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatalf("Failed to decode %q: %v", rec.Body.String(), err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("report = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
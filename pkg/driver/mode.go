@@ -0,0 +1,34 @@
+package driver
+
+import "fmt"
+
+// Mode selects the CSI services a driver serves, the identity service is always served
+type Mode string
+
+const (
+	// ModeController serves the controller service, e.g. in the controller deployment
+	ModeController Mode = "controller"
+	// ModeNode serves the node service, e.g. in the node daemonset
+	ModeNode Mode = "node"
+	// ModeAll serves both services
+	ModeAll Mode = "all"
+)
+
+// ParseMode parses the value of --mode
+func ParseMode(s string) (Mode, error) {
+	switch mode := Mode(s); mode {
+	case ModeController, ModeNode, ModeAll:
+		return mode, nil
+	}
+	return "", fmt.Errorf("invalid mode %q, expecting %s, %s or %s", s, ModeController, ModeNode, ModeAll)
+}
+
+// Controller reports whether the controller service is served, an unset mode serves all
+func (m Mode) Controller() bool {
+	return m != ModeNode
+}
+
+// Node reports whether the node service is served, an unset mode serves all
+func (m Mode) Node() bool {
+	return m != ModeController
+}
@@ -0,0 +1,37 @@
+package driver
+
+import "testing"
+
+func TestParseMode(t *testing.T) {
+	tests := []struct {
+		value          string
+		wantController bool
+		wantNode       bool
+		wantErr        bool
+	}{
+		{value: "controller", wantController: true},
+		{value: "node", wantNode: true},
+		{value: "all", wantController: true, wantNode: true},
+		{value: "", wantErr: true},
+		{value: "Node", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			mode, err := ParseMode(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseMode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if mode.Controller() != tt.wantController || mode.Node() != tt.wantNode {
+				t.Errorf("mode %s serves controller %v and node %v, want %v and %v", mode, mode.Controller(), mode.Node(), tt.wantController, tt.wantNode)
+			}
+		})
+	}
+
+	var unset Mode
+	if !unset.Controller() || !unset.Node() {
+		t.Errorf("an unset mode should serve all services")
+	}
+}
@@ -0,0 +1,84 @@
+package driver
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SplitList splits a comma separated option value, dropping blanks around and between items
+func SplitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// ParseMappings parses a comma separated list of key=value mappings, format describes a
+// mapping in errors. Keys and values must not be empty, values may contain '='.
+func ParseMappings(s, format string) (map[string]string, error) {
+	mappings := make(map[string]string)
+	for _, mapping := range SplitList(s) {
+		kv := strings.SplitN(mapping, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("invalid mapping %q, expecting %s", mapping, format)
+		}
+		mappings[kv[0]] = kv[1]
+	}
+	return mappings, nil
+}
+
+// ResolveOptions returns the options of every registration: defaults apply to all drivers
+// and endpoints and nodeIDs, keyed by driver names which may be short, override them per
+// driver. A DriverName in defaults renames the driver and is only accepted for a single
+// one. Drivers sharing an endpoint are rejected, they would take the socket from each other,
+// as are drivers serving the node service without a node ID.
+func ResolveOptions(registrations []Registration, defaults Options, endpoints, nodeIDs map[string]string) ([]*Options, error) {
+	if defaults.DriverName != "" && len(registrations) > 1 {
+		return nil, fmt.Errorf("driver name %s given for %d drivers, only one can be renamed", defaults.DriverName, len(registrations))
+	}
+	options := make(map[string]*Options, len(registrations))
+	var resolved []*Options
+	for _, r := range registrations {
+		if _, ok := options[r.Name]; ok {
+			return nil, fmt.Errorf("driver %s is given twice", r.Name)
+		}
+		opts := defaults
+		if opts.DriverName == "" {
+			opts.DriverName = r.Name
+		}
+		options[r.Name] = &opts
+		resolved = append(resolved, &opts)
+	}
+
+	override := func(values map[string]string, what string, set func(opts *Options, value string)) error {
+		for name, value := range values {
+			r, ok := Lookup(name)
+			if !ok || options[r.Name] == nil {
+				return fmt.Errorf("%s given for %s, which is not run", what, name)
+			}
+			set(options[r.Name], value)
+		}
+		return nil
+	}
+	if err := override(endpoints, "endpoint", func(opts *Options, value string) { opts.Endpoint = value }); err != nil {
+		return nil, err
+	}
+	if err := override(nodeIDs, "node ID", func(opts *Options, value string) { opts.NodeID = value }); err != nil {
+		return nil, err
+	}
+
+	endpointDrivers := make(map[string]string)
+	for _, opts := range resolved {
+		if other, ok := endpointDrivers[opts.Endpoint]; ok {
+			return nil, fmt.Errorf("drivers %s and %s are both served at %s, give them endpoints of their own", other, opts.DriverName, opts.Endpoint)
+		}
+		endpointDrivers[opts.Endpoint] = opts.DriverName
+		if opts.Mode.Node() && opts.NodeID == "" {
+			return nil, fmt.Errorf("driver %s serves the node service without a node ID", opts.DriverName)
+		}
+	}
+	return resolved, nil
+}
@@ -0,0 +1,197 @@
+package driver
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestSplitList(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  []string
+	}{
+		{
+			name:  "empty",
+			value: "",
+		},
+		{
+			name:  "blanks and empty items",
+			value: " a, ,b ,",
+			want:  []string{"a", "b"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SplitList(tt.value); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SplitList() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMappings(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:  "several mappings",
+			value: "a=1, b=x=y",
+			want:  map[string]string{"a": "1", "b": "x=y"},
+		},
+		{
+			name:    "missing value",
+			value:   "a=",
+			wantErr: true,
+		},
+		{
+			name:    "missing key",
+			value:   "=1",
+			wantErr: true,
+		},
+		{
+			name:    "no mapping",
+			value:   "a",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseMappings(tt.value, "key=value")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseMappings() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseMappings() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveOptions(t *testing.T) {
+	newDriver := func(opts *Options, stopCh chan os.Signal) (Driver, error) {
+		return nil, nil
+	}
+	Register(Registration{Name: "alpha.csi.example.com", New: newDriver})
+	Register(Registration{Name: "beta.csi.example.com", New: newDriver})
+	alpha, _ := Lookup("alpha")
+	beta, _ := Lookup("beta")
+	defaults := Options{Endpoint: "unix:///csi/csi.sock", NodeID: "node"}
+
+	tests := []struct {
+		name          string
+		registrations []Registration
+		driverName    string
+		mode          Mode
+		withoutNodeID bool
+		endpoints     map[string]string
+		nodeIDs       map[string]string
+		want          []Options
+		wantErr       bool
+	}{
+		{
+			name:          "single driver on the default endpoint",
+			registrations: []Registration{alpha},
+			want: []Options{
+				{DriverName: "alpha.csi.example.com", Endpoint: "unix:///csi/csi.sock", NodeID: "node"},
+			},
+		},
+		{
+			name:          "drivers with endpoints and node IDs of their own",
+			registrations: []Registration{alpha, beta},
+			endpoints:     map[string]string{"beta": "unix:///csi/beta.sock"},
+			nodeIDs:       map[string]string{"alpha.csi.example.com": "alpha-node"},
+			want: []Options{
+				{DriverName: "alpha.csi.example.com", Endpoint: "unix:///csi/csi.sock", NodeID: "alpha-node"},
+				{DriverName: "beta.csi.example.com", Endpoint: "unix:///csi/beta.sock", NodeID: "node"},
+			},
+		},
+		{
+			name:          "drivers sharing the endpoint",
+			registrations: []Registration{alpha, beta},
+			wantErr:       true,
+		},
+		{
+			name:          "endpoint of a driver which is not run",
+			registrations: []Registration{alpha},
+			endpoints:     map[string]string{"beta": "unix:///csi/beta.sock"},
+			wantErr:       true,
+		},
+		{
+			name:          "driver given twice",
+			registrations: []Registration{alpha, alpha},
+			wantErr:       true,
+		},
+		{
+			name:          "renamed driver",
+			registrations: []Registration{alpha},
+			driverName:    "alpha.csi.k8s.io",
+			want: []Options{
+				{DriverName: "alpha.csi.k8s.io", Endpoint: "unix:///csi/csi.sock", NodeID: "node"},
+			},
+		},
+		{
+			name:          "name given for several drivers",
+			registrations: []Registration{alpha, beta},
+			driverName:    "alpha.csi.k8s.io",
+			endpoints:     map[string]string{"beta": "unix:///csi/beta.sock"},
+			wantErr:       true,
+		},
+		{
+			name:          "node IDs of every driver without a default",
+			registrations: []Registration{alpha, beta},
+			withoutNodeID: true,
+			endpoints:     map[string]string{"beta": "unix:///csi/beta.sock"},
+			nodeIDs:       map[string]string{"alpha": "alpha-node", "beta": "beta-node"},
+			want: []Options{
+				{DriverName: "alpha.csi.example.com", Endpoint: "unix:///csi/csi.sock", NodeID: "alpha-node"},
+				{DriverName: "beta.csi.example.com", Endpoint: "unix:///csi/beta.sock", NodeID: "beta-node"},
+			},
+		},
+		{
+			name:          "driver without a node ID",
+			registrations: []Registration{alpha, beta},
+			withoutNodeID: true,
+			endpoints:     map[string]string{"beta": "unix:///csi/beta.sock"},
+			nodeIDs:       map[string]string{"alpha": "alpha-node"},
+			wantErr:       true,
+		},
+		{
+			name:          "controller without a node ID",
+			registrations: []Registration{alpha},
+			mode:          ModeController,
+			withoutNodeID: true,
+			want: []Options{
+				{DriverName: "alpha.csi.example.com", Endpoint: "unix:///csi/csi.sock", Mode: ModeController},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := defaults
+			opts.DriverName = tt.driverName
+			opts.Mode = tt.mode
+			if tt.withoutNodeID {
+				opts.NodeID = ""
+			}
+			got, err := ResolveOptions(tt.registrations, opts, tt.endpoints, tt.nodeIDs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResolveOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			var options []Options
+			for _, opts := range got {
+				options = append(options, *opts)
+			}
+			if !reflect.DeepEqual(options, tt.want) {
+				t.Errorf("ResolveOptions() = %v, want %v", options, tt.want)
+			}
+		})
+	}
+}
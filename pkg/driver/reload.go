@@ -0,0 +1,32 @@
+package driver
+
+import (
+	"k8s.io/klog/v2"
+)
+
+// Reloader is implemented by drivers which can apply changed settings while running
+type Reloader interface {
+	// Reload rereads the settings of the driver and applies the ones which can change
+	// while running. Invalid settings are rejected and the running ones kept.
+	Reload() error
+}
+
+// Reload reloads the drivers implementing Reloader, e.g. on SIGHUP. A failing driver keeps
+// its settings and is reported with its name, the failures are returned by driver name.
+func Reload(drivers []Driver) map[string]error {
+	failures := make(map[string]error)
+	for _, d := range drivers {
+		r, ok := d.(Reloader)
+		if !ok {
+			klog.V(4).Infof("Driver %s cannot reload its settings", d.Name())
+			continue
+		}
+		if err := r.Reload(); err != nil {
+			klog.Errorf("Failed to reload driver %s, keeping its settings: %v", d.Name(), err)
+			failures[d.Name()] = err
+			continue
+		}
+		klog.V(2).Infof("Driver %s reloaded its settings", d.Name())
+	}
+	return failures
+}
@@ -0,0 +1,35 @@
+package driver
+
+import (
+	"errors"
+	"testing"
+)
+
+type reloadingDriver struct {
+	fakeDriver
+	reloads int
+	err     error
+}
+
+func (d *reloadingDriver) Reload() error {
+	d.reloads++
+	return d.err
+}
+
+func TestReload(t *testing.T) {
+	invalid := errors.New("invalid config")
+	ok := &reloadingDriver{fakeDriver: fakeDriver{name: "ok"}}
+	failing := &reloadingDriver{fakeDriver: fakeDriver{name: "failing"}, err: invalid}
+	failures := Reload([]Driver{
+		ok,
+		failing,
+		&fakeDriver{name: "static"},
+	})
+
+	if len(failures) != 1 || !errors.Is(failures["failing"], invalid) {
+		t.Errorf("Reload() = %v, want only the failing driver", failures)
+	}
+	if ok.reloads != 1 || failing.reloads != 1 {
+		t.Errorf("reloads = %d, %d, want every reloader reloaded once", ok.reloads, failing.reloads)
+	}
+}
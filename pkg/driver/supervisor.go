@@ -0,0 +1,45 @@
+package driver
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync"
+
+	"k8s.io/klog/v2"
+)
+
+// Supervise runs the drivers until every one of them returned. A driver failing or
+// panicking is reported with its name and leaves the others running. The failures are
+// returned by driver name.
+func Supervise(drivers []Driver) map[string]error {
+	var wg sync.WaitGroup
+	lock := &sync.Mutex{}
+	failures := make(map[string]error)
+
+	for _, d := range drivers {
+		wg.Add(1)
+		go func(d Driver) {
+			defer wg.Done()
+			if err := run(d); err != nil {
+				klog.Errorf("Driver %s failed: %v", d.Name(), err)
+				lock.Lock()
+				failures[d.Name()] = err
+				lock.Unlock()
+				return
+			}
+			klog.V(2).Infof("Driver %s stopped", d.Name())
+		}(d)
+	}
+	wg.Wait()
+	return failures
+}
+
+// run runs the driver, a panic is turned into its failure
+func run(d Driver) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v\n%s", r, debug.Stack())
+		}
+	}()
+	return d.Run()
+}
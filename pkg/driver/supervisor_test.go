@@ -0,0 +1,25 @@
+package driver
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSupervise(t *testing.T) {
+	failure := errors.New("failed to listen")
+	failures := Supervise([]Driver{
+		&fakeDriver{name: "ok"},
+		&fakeDriver{name: "failing", run: func() error { return failure }},
+		&fakeDriver{name: "panicking", run: func() error { panic("boom") }},
+	})
+
+	if len(failures) != 2 {
+		t.Fatalf("Supervise() = %v, want the failing and the panicking driver", failures)
+	}
+	if !errors.Is(failures["failing"], failure) {
+		t.Errorf("failure of failing = %v, want %v", failures["failing"], failure)
+	}
+	if failures["panicking"] == nil {
+		t.Errorf("panic of panicking was not reported")
+	}
+}
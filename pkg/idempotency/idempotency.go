@@ -0,0 +1,102 @@
+package idempotency
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog/v2"
+)
+
+type Idempotency struct {
+	lock *sync.Mutex
+
+	// Reccord the volume that is being handled
+	processing map[string]bool
+	// Counts the volumes being handled, nil if they are not reported
+	inflight prometheus.Gauge
+}
+
+// Check if implements prometheus.Collector
+var _ prometheus.Collector = &Idempotency{}
+
+func NewIdempotency() *Idempotency {
+	return &Idempotency{
+		lock:       &sync.Mutex{},
+		processing: make(map[string]bool),
+	}
+}
+
+// NewNamedIdempotency returns an Idempotency reporting the volumes being handled as the
+// in-flight operations of component once it is registered, e.g. with the driver label
+func NewNamedIdempotency(component string) *Idempotency {
+	i := NewIdempotency()
+	i.inflight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace:   "simple_csi",
+		Name:        "inflight_operations",
+		Help:        "Operations being handled by component.",
+		ConstLabels: prometheus.Labels{"component": component},
+	})
+	return i
+}
+
+// Describe describes the in-flight operations, an unnamed Idempotency has no metrics
+func (i *Idempotency) Describe(ch chan<- *prometheus.Desc) {
+	if i.inflight != nil {
+		i.inflight.Describe(ch)
+	}
+}
+
+func (i *Idempotency) Collect(ch chan<- prometheus.Metric) {
+	if i.inflight != nil {
+		i.inflight.Collect(ch)
+	}
+}
+
+func (i *Idempotency) IsProcessing(volumeId string) bool {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	_, ok := i.processing[volumeId]
+	return ok
+}
+
+// Caller should make sure there is no volume is being handled(call isProcessing() first)
+func (i *Idempotency) AddProcessing(volumeId string) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	i.add(volumeId)
+}
+
+// TryAddProcessing marks the volume as being handled and returns true, or returns false
+// if it is already being handled. Unlike IsProcessing followed by AddProcessing the check
+// and the mark happen under one lock, so concurrent callers cannot both succeed.
+func (i *Idempotency) TryAddProcessing(volumeId string) bool {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	if _, ok := i.processing[volumeId]; ok {
+		return false
+	}
+	i.add(volumeId)
+	return true
+}
+
+func (i *Idempotency) RemoveProcessing(volumeId string) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	klog.V(4).InfoS("Remove volume from processing list", "volumeId", volumeId)
+	if _, ok := i.processing[volumeId]; ok && i.inflight != nil {
+		i.inflight.Dec()
+	}
+	delete(i.processing, volumeId)
+}
+
+// add marks the volume as being handled, the lock must be held
+func (i *Idempotency) add(volumeId string) {
+	if _, ok := i.processing[volumeId]; !ok && i.inflight != nil {
+		i.inflight.Inc()
+	}
+	i.processing[volumeId] = true
+}
@@ -0,0 +1,124 @@
+package idempotency
+
+import (
+	"sync"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func initTestIdentity() *Idempotency {
+	return &Idempotency{
+		lock: &sync.Mutex{},
+		processing: map[string]bool{
+			"testVolumeId1": true,
+		},
+	}
+
+}
+
+func TestIsProcessing(t *testing.T) {
+	idempotency := initTestIdentity()
+	tcs := []struct {
+		description string
+		volumeId    string
+		expected    bool
+	}{
+		{
+			description: "testVolumeId1 expects to be processing",
+			volumeId:    "testVolumeId1",
+			expected:    true,
+		},
+		{
+			description: "testVolumeId2 expects not to be processing",
+			volumeId:    "testVolumeId2",
+			expected:    false,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.description, func(t *testing.T) {
+			if idempotency.IsProcessing(tc.volumeId) != tc.expected {
+				t.Errorf("Expected %v, got %v", tc.expected, idempotency.IsProcessing(tc.volumeId))
+			}
+		})
+	}
+}
+
+func TestAddProcessing(t *testing.T) {
+	idempotency := initTestIdentity()
+	idempotency.AddProcessing("testVolumeId2")
+
+	actualResult := idempotency.IsProcessing("testVolumeId2")
+	if actualResult != true {
+		t.Errorf("Expected %v, got %v", true, actualResult)
+	}
+}
+
+func TestTryAddProcessing(t *testing.T) {
+	idempotency := initTestIdentity()
+	tcs := []struct {
+		description string
+		volumeId    string
+		expected    bool
+	}{
+		{
+			description: "testVolumeId1 is already processing",
+			volumeId:    "testVolumeId1",
+			expected:    false,
+		},
+		{
+			description: "testVolumeId2 can be added",
+			volumeId:    "testVolumeId2",
+			expected:    true,
+		},
+		{
+			description: "testVolumeId2 cannot be added twice",
+			volumeId:    "testVolumeId2",
+			expected:    false,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.description, func(t *testing.T) {
+			if actual := idempotency.TryAddProcessing(tc.volumeId); actual != tc.expected {
+				t.Errorf("Expected %v, got %v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestRemoveProcessing(t *testing.T) {
+	idempotency := initTestIdentity()
+	idempotency.RemoveProcessing("testVolumeId1")
+
+	actualResult := idempotency.IsProcessing("testVolumeId1")
+	if actualResult != false {
+		t.Errorf("Expected %v, got %v", false, actualResult)
+	}
+}
+
+func TestNamedIdempotencyInflight(t *testing.T) {
+	idempotency := NewNamedIdempotency("test")
+	inflight := func() float64 {
+		m := &dto.Metric{}
+		if err := idempotency.inflight.Write(m); err != nil {
+			t.Fatalf("Failed to read the in-flight operations: %v", err)
+		}
+		return m.GetGauge().GetValue()
+	}
+
+	idempotency.AddProcessing("testVolumeId1")
+	idempotency.AddProcessing("testVolumeId1")
+	idempotency.TryAddProcessing("testVolumeId2")
+	idempotency.TryAddProcessing("testVolumeId2")
+	if got := inflight(); got != 2 {
+		t.Errorf("Expected 2 operations in flight, got %v", got)
+	}
+	idempotency.RemoveProcessing("testVolumeId1")
+	idempotency.RemoveProcessing("testVolumeId1")
+	idempotency.RemoveProcessing("testVolumeId2")
+	if got := inflight(); got != 0 {
+		t.Errorf("Expected no operation in flight, got %v", got)
+	}
+}
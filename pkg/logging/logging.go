@@ -0,0 +1,116 @@
+// Package logging sets up the log output of the drivers and carries the fields of a CSI
+// call, such as its request ID, through the context into the helpers it runs.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/funcr"
+	"k8s.io/klog/v2"
+)
+
+// Log formats
+const (
+	// FormatText is the klog text format
+	FormatText = "text"
+	// FormatJSON writes a json object per line
+	FormatJSON = "json"
+)
+
+// Keys of the fields logged for CSI calls
+const (
+	RequestIDKey         = "request_id"
+	MethodKey            = "method"
+	VolumeIDKey          = "volume_id"
+	TargetPathKey        = "target_path"
+	StagingTargetPathKey = "staging_target_path"
+	ServerKey            = "server"
+	DurationKey          = "duration"
+	CodeKey              = "code"
+)
+
+// SetFormat makes klog write in format to w, klog still decides what is logged by -v and
+// -vmodule. It has to be called before anything logs.
+func SetFormat(format string, w io.Writer) error {
+	switch format {
+	case FormatText, "":
+		klog.ClearLogger()
+	case FormatJSON:
+		logger := funcr.NewJSON(func(obj string) {
+			fmt.Fprintln(w, obj)
+		}, funcr.Options{
+			LogTimestamp: true,
+			// klog checks the verbosity before handing lines over
+			Verbosity: math.MaxInt32,
+		})
+		klog.SetLogger(logr.New(&trimmingSink{LogSink: logger.GetSink()}))
+	default:
+		return fmt.Errorf("unknown log format %q, use %s or %s", format, FormatText, FormatJSON)
+	}
+	return nil
+}
+
+// trimmingSink drops the line break klog ends the messages of Infof and the like with
+type trimmingSink struct {
+	logr.LogSink
+}
+
+func (s *trimmingSink) Info(level int, msg string, keysAndValues ...interface{}) {
+	s.LogSink.Info(level, strings.TrimSuffix(msg, "\n"), keysAndValues...)
+}
+
+func (s *trimmingSink) Error(err error, msg string, keysAndValues ...interface{}) {
+	s.LogSink.Error(err, strings.TrimSuffix(msg, "\n"), keysAndValues...)
+}
+
+func (s *trimmingSink) WithValues(keysAndValues ...interface{}) logr.LogSink {
+	return &trimmingSink{LogSink: s.LogSink.WithValues(keysAndValues...)}
+}
+
+func (s *trimmingSink) WithName(name string) logr.LogSink {
+	return &trimmingSink{LogSink: s.LogSink.WithName(name)}
+}
+
+func (s *trimmingSink) WithCallDepth(depth int) logr.LogSink {
+	if sink, ok := s.LogSink.(logr.CallDepthLogSink); ok {
+		return &trimmingSink{LogSink: sink.WithCallDepth(depth)}
+	}
+	return s
+}
+
+type requestIDKey struct{}
+
+// NewRequestID returns a random ID telling the log lines of a call apart from others
+func NewRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// WithRequestID returns ctx carrying the request ID and a logger which adds it and the
+// fields in keysAndValues to every line
+func WithRequestID(ctx context.Context, id string, keysAndValues ...interface{}) context.Context {
+	ctx = context.WithValue(ctx, requestIDKey{}, id)
+	return WithValues(ctx, append([]interface{}{RequestIDKey, id}, keysAndValues...)...)
+}
+
+// RequestID returns the request ID of the call ctx belongs to, empty outside of calls
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// WithValues returns ctx with a logger adding the fields in keysAndValues to those of the
+// logger of ctx
+func WithValues(ctx context.Context, keysAndValues ...interface{}) context.Context {
+	return klog.NewContext(ctx, klog.LoggerWithValues(klog.FromContext(ctx), keysAndValues...))
+}
@@ -0,0 +1,65 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"k8s.io/klog/v2"
+)
+
+func TestSetFormat(t *testing.T) {
+	var out bytes.Buffer
+	if err := SetFormat(FormatJSON, &out); err != nil {
+		t.Fatalf("SetFormat() error = %v", err)
+	}
+	defer SetFormat(FormatText, nil)
+
+	ctx := WithRequestID(context.Background(), "0123456789abcdef", MethodKey, "/csi.v1.Node/NodePublishVolume")
+	ctx = WithValues(ctx, TargetPathKey, "/target")
+	klog.FromContext(ctx).Info("Mounting", ServerKey, "server")
+	klog.FromContext(ctx).Error(errors.New("mount failed"), "Failed to mount")
+	klog.Infof("Unstructured %s\n", "line")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("got %d lines, want 3:\n%s", len(lines), out.String())
+	}
+	want := []map[string]interface{}{
+		{"msg": "Mounting", RequestIDKey: "0123456789abcdef", MethodKey: "/csi.v1.Node/NodePublishVolume", TargetPathKey: "/target", ServerKey: "server"},
+		{"msg": "Failed to mount", RequestIDKey: "0123456789abcdef", "error": "mount failed"},
+		{"msg": "Unstructured line"},
+	}
+	for i, line := range lines {
+		var got map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &got); err != nil {
+			t.Fatalf("line %d %q is not json: %v", i, line, err)
+		}
+		for k, v := range want[i] {
+			if got[k] != v {
+				t.Errorf("line %d: %s = %v, want %v", i, k, got[k], v)
+			}
+		}
+	}
+
+	if err := SetFormat("xml", &out); err == nil {
+		t.Errorf("SetFormat(xml) succeeded")
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	if id := RequestID(context.Background()); id != "" {
+		t.Errorf("RequestID() outside of a call = %q, want empty", id)
+	}
+	id := NewRequestID()
+	if len(id) != 16 || id == NewRequestID() {
+		t.Errorf("NewRequestID() = %q, want 16 random hex digits", id)
+	}
+	ctx := WithValues(WithRequestID(context.Background(), id), VolumeIDKey, "vol")
+	if got := RequestID(ctx); got != id {
+		t.Errorf("RequestID() = %q, want %q", got, id)
+	}
+}
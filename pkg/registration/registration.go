@@ -0,0 +1,193 @@
+// Package registration registers CSI drivers with kubelet through the plugin registration
+// API, in place of the node-driver-registrar sidecar.
+package registration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/chenliu1993/simple-csi-driver/pkg/server"
+	"google.golang.org/grpc"
+	"k8s.io/klog/v2"
+	registerapi "k8s.io/kubelet/pkg/apis/pluginregistration/v1"
+)
+
+const (
+	// How long the socket is withdrawn after kubelet rejected the driver
+	defaultRetryInterval = 30 * time.Second
+)
+
+// CSI versions the drivers speak
+var supportedVersions = []string{"1.0.0"}
+
+// Server serves the registration socket of a driver in the plugins_registry directory of
+// kubelet. Kubelet registers the driver when the socket shows up and deregisters it when it
+// is removed.
+type Server struct {
+	lock *sync.Mutex
+
+	info          registerapi.PluginInfo
+	socket        string
+	retryInterval time.Duration
+
+	server     *grpc.Server
+	stopped    bool
+	registered bool
+	lastErr    error
+}
+
+// NewServer returns the registration of the driver called name, whose CSI socket kubelet
+// reaches at endpoint, serving in registryDir
+func NewServer(name, endpoint, registryDir string) *Server {
+	return &Server{
+		lock: &sync.Mutex{},
+		info: registerapi.PluginInfo{
+			Type:              registerapi.CSIPlugin,
+			Name:              name,
+			Endpoint:          endpoint,
+			SupportedVersions: supportedVersions,
+		},
+		socket:        SocketPath(registryDir, name),
+		retryInterval: defaultRetryInterval,
+	}
+}
+
+// SocketPath returns the registration socket of the driver called name, named like the one
+// of node-driver-registrar so either can take over from the other
+func SocketPath(registryDir, name string) string {
+	return filepath.Join(registryDir, name+"-reg.sock")
+}
+
+// KubeletEndpoint returns the socket path of a unix CSI endpoint
+func KubeletEndpoint(endpoint string) (string, error) {
+	proto, addr, err := server.ParseEndpoint(endpoint)
+	if err != nil {
+		return "", err
+	}
+	if proto != "unix" {
+		return "", fmt.Errorf("kubelet only reaches drivers on unix sockets, not at %s", endpoint)
+	}
+	return filepath.Clean("/" + addr), nil
+}
+
+// Start serves the registration socket in the background, a socket left behind by a
+// previous run is replaced
+func (s *Server) Start() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.stopped {
+		return errors.New("registration server is stopped")
+	}
+	return s.serve()
+}
+
+// serve listens on the socket and serves it, the lock has to be held
+func (s *Server) serve() error {
+	if err := os.Remove(s.socket); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove stale registration socket %s: %v", s.socket, err)
+	}
+	listener, err := net.Listen("unix", s.socket)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", s.socket, err)
+	}
+
+	grpcServer := grpc.NewServer()
+	registerapi.RegisterRegistrationServer(grpcServer, s)
+	s.server = grpcServer
+	klog.V(2).InfoS("Serving kubelet plugin registration", "driver", s.info.Name, "socket", s.socket, "endpoint", s.info.Endpoint)
+	go func() {
+		if err := grpcServer.Serve(listener); err != nil && err != grpc.ErrServerStopped {
+			klog.Errorf("Registration server of driver %s stopped: %v", s.info.Name, err)
+		}
+	}()
+	return nil
+}
+
+// Stop stops serving and removes the socket, kubelet deregisters the driver
+func (s *Server) Stop() {
+	s.lock.Lock()
+	s.stopped = true
+	s.registered = false
+	grpcServer := s.server
+	s.server = nil
+	s.lock.Unlock()
+
+	s.withdraw(grpcServer)
+}
+
+// withdraw stops the grpc server, which waits for running calls, and removes the socket
+func (s *Server) withdraw(grpcServer *grpc.Server) {
+	if grpcServer != nil {
+		grpcServer.Stop()
+	}
+	if err := os.Remove(s.socket); err != nil && !os.IsNotExist(err) {
+		klog.Errorf("Failed to remove registration socket %s: %v", s.socket, err)
+	}
+}
+
+// Registered returns whether kubelet registered the driver, and why it did not if it said so
+func (s *Server) Registered() (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.registered, s.lastErr
+}
+
+// GetInfo describes the driver to kubelet
+func (s *Server) GetInfo(ctx context.Context, req *registerapi.InfoRequest) (*registerapi.PluginInfo, error) {
+	klog.V(4).InfoS("Kubelet asked for plugin info", "driver", s.info.Name)
+	return &registerapi.PluginInfo{
+		Type:              s.info.Type,
+		Name:              s.info.Name,
+		Endpoint:          s.info.Endpoint,
+		SupportedVersions: s.info.SupportedVersions,
+	}, nil
+}
+
+// NotifyRegistrationStatus records the outcome of the registration. The socket of a
+// rejected driver is withdrawn and served again after the retry interval, which makes
+// kubelet try again.
+func (s *Server) NotifyRegistrationStatus(ctx context.Context, status *registerapi.RegistrationStatus) (*registerapi.RegistrationStatusResponse, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if status.PluginRegistered {
+		klog.V(2).InfoS("Registered with kubelet", "driver", s.info.Name)
+		s.registered, s.lastErr = true, nil
+		return &registerapi.RegistrationStatusResponse{}, nil
+	}
+
+	klog.Errorf("Kubelet failed to register driver %s, retrying in %v: %s", s.info.Name, s.retryInterval, status.Error)
+	s.registered, s.lastErr = false, errors.New(status.Error)
+	time.AfterFunc(s.retryInterval, s.retry)
+	return &registerapi.RegistrationStatusResponse{}, nil
+}
+
+// retry offers the socket to kubelet again
+func (s *Server) retry() {
+	s.lock.Lock()
+	if s.stopped || s.registered {
+		s.lock.Unlock()
+		return
+	}
+	grpcServer := s.server
+	s.server = nil
+	s.lock.Unlock()
+
+	s.withdraw(grpcServer)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.stopped {
+		return
+	}
+	if err := s.serve(); err != nil {
+		klog.Errorf("Failed to serve the registration of driver %s again: %v", s.info.Name, err)
+	}
+}
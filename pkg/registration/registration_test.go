@@ -0,0 +1,145 @@
+package registration
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	registerapi "k8s.io/kubelet/pkg/apis/pluginregistration/v1"
+)
+
+const testDriver = "nfsplugin.csi.cliufreever.com"
+
+// kubeletClient calls the registration socket like kubelet does
+type kubeletClient struct {
+	client registerapi.RegistrationClient
+}
+
+func dialRegistration(t *testing.T, socket string) *kubeletClient {
+	t.Helper()
+	conn, err := grpc.Dial("unix://"+socket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to dial %s: %v", socket, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &kubeletClient{client: registerapi.NewRegistrationClient(conn)}
+}
+
+func (c *kubeletClient) getInfo() (*registerapi.PluginInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return c.client.GetInfo(ctx, &registerapi.InfoRequest{})
+}
+
+func (c *kubeletClient) notify(status *registerapi.RegistrationStatus) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := c.client.NotifyRegistrationStatus(ctx, status)
+	return err
+}
+
+func TestServer(t *testing.T) {
+	dir := t.TempDir()
+	socket := SocketPath(dir, testDriver)
+	// Left behind by a previous run
+	if err := os.WriteFile(socket, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServer(testDriver, "/var/lib/kubelet/plugins/csi-simpleplugin/csi.sock", dir)
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	client := dialRegistration(t, socket)
+
+	info, err := client.getInfo()
+	if err != nil {
+		t.Fatalf("GetInfo() error = %v", err)
+	}
+	want := &registerapi.PluginInfo{
+		Type:              "CSIPlugin",
+		Name:              testDriver,
+		Endpoint:          "/var/lib/kubelet/plugins/csi-simpleplugin/csi.sock",
+		SupportedVersions: []string{"1.0.0"},
+	}
+	if !reflect.DeepEqual(info, want) {
+		t.Errorf("GetInfo() = %+v, want %+v", info, want)
+	}
+
+	if err := client.notify(&registerapi.RegistrationStatus{PluginRegistered: true}); err != nil {
+		t.Fatalf("NotifyRegistrationStatus() error = %v", err)
+	}
+	if registered, err := s.Registered(); !registered || err != nil {
+		t.Errorf("Registered() = %v, %v, want registered", registered, err)
+	}
+
+	s.Stop()
+	if _, err := os.Stat(socket); !os.IsNotExist(err) {
+		t.Errorf("socket %s is left after Stop(): %v", socket, err)
+	}
+	if err := s.Start(); err == nil {
+		t.Errorf("Start() of a stopped server succeeded")
+	}
+}
+
+func TestServerRetry(t *testing.T) {
+	dir := t.TempDir()
+	s := NewServer(testDriver, "/csi/csi.sock", dir)
+	s.retryInterval = 10 * time.Millisecond
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer s.Stop()
+	s.lock.Lock()
+	first := s.server
+	s.lock.Unlock()
+
+	client := dialRegistration(t, SocketPath(dir, testDriver))
+	if err := client.notify(&registerapi.RegistrationStatus{Error: "plugin name is invalid"}); err != nil {
+		t.Fatalf("NotifyRegistrationStatus() error = %v", err)
+	}
+	if registered, err := s.Registered(); registered || err == nil || err.Error() != "plugin name is invalid" {
+		t.Errorf("Registered() = %v, %v, want the rejection", registered, err)
+	}
+
+	// The socket is offered again for kubelet to retry
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.lock.Lock()
+		served := s.server
+		s.lock.Unlock()
+		if served != nil && served != first {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("registration socket was not served again")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := dialRegistration(t, SocketPath(dir, testDriver)).getInfo(); err != nil {
+		t.Errorf("GetInfo() after the retry error = %v", err)
+	}
+}
+
+func TestKubeletEndpoint(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		want     string
+		wantErr  bool
+	}{
+		{endpoint: "unix:///csi/csi.sock", want: "/csi/csi.sock"},
+		{endpoint: "unix://tmp/csi.sock", want: "/tmp/csi.sock"},
+		{endpoint: "tcp://127.0.0.1:10000", wantErr: true},
+		{endpoint: "/csi/csi.sock", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := KubeletEndpoint(tt.endpoint)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("KubeletEndpoint(%s) = %s, %v, want %s, error %v", tt.endpoint, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// Options configure the interceptors of the grpc server, the zero value neither limits
+// nor times out calls
+type Options struct {
+	// Deadline of calls unless the CO set an earlier one, 0 for none
+	Timeout time.Duration
+	// Deadlines of single methods, keyed by method name such as NodePublishVolume
+	MethodTimeouts map[string]time.Duration
+	// Calls of a method served at once, 0 for no limit. Further calls wait for a slot
+	// until their deadline.
+	MaxConcurrent int
+	// Limits of single methods, keyed by method name
+	MethodMaxConcurrent map[string]int
+	// Registers the call metrics while the server runs, e.g. wrapped with the driver label.
+	// Nil leaves them unregistered.
+	Registerer prometheus.Registerer
+}
+
+// timeout returns the deadline of the calls of the method
+func (o Options) timeout(fullMethod string) time.Duration {
+	if timeout, ok := o.MethodTimeouts[methodName(fullMethod)]; ok {
+		return timeout
+	}
+	return o.Timeout
+}
+
+// maxConcurrent returns how many calls of the method are served at once
+func (o Options) maxConcurrent(fullMethod string) int {
+	if limit, ok := o.MethodMaxConcurrent[methodName(fullMethod)]; ok {
+		return limit
+	}
+	return o.MaxConcurrent
+}
+
+// methodName returns the method of a full method name such as /csi.v1.Node/NodePublishVolume
+func methodName(fullMethod string) string {
+	return fullMethod[strings.LastIndex(fullMethod, "/")+1:]
+}
+
+// interceptors returns the chain every call runs through: metrics and logging see the
+// outcome of all calls, a panic only fails its own call, and waiting for a free slot counts
+// against the deadline of the call
+func interceptors(opts Options, metrics *grpcMetrics) []grpc.UnaryServerInterceptor {
+	return []grpc.UnaryServerInterceptor{
+		metrics.metricsGRPC,
+		logGRPC,
+		recoverGRPC,
+		timeoutGRPC(opts),
+		newConcurrencyLimiter(opts).limitGRPC,
+	}
+}
+
+// recoverGRPC turns a panic of a handler into an Internal error and logs its stack
+func recoverGRPC(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			klog.FromContext(ctx).Error(fmt.Errorf("%v", r), "GRPC handler panicked", "stack", string(debug.Stack()))
+			resp, err = nil, status.Errorf(codes.Internal, "%s panicked: %v", methodName(info.FullMethod), r)
+		}
+	}()
+	return handler(ctx, req)
+}
+
+// timeoutGRPC gives calls the deadline of their method
+func timeoutGRPC(opts Options) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if timeout := opts.timeout(info.FullMethod); timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		return handler(ctx, req)
+	}
+}
+
+// concurrencyLimiter limits the calls served at once per method
+type concurrencyLimiter struct {
+	lock *sync.Mutex
+
+	opts Options
+	// Holds a token per running call of the method
+	slots map[string]chan struct{}
+}
+
+func newConcurrencyLimiter(opts Options) *concurrencyLimiter {
+	return &concurrencyLimiter{
+		lock:  &sync.Mutex{},
+		opts:  opts,
+		slots: make(map[string]chan struct{}),
+	}
+}
+
+// methodSlots returns the slots of the method, nil if its calls are not limited
+func (l *concurrencyLimiter) methodSlots(fullMethod string) chan struct{} {
+	limit := l.opts.maxConcurrent(fullMethod)
+	if limit <= 0 {
+		return nil
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if _, ok := l.slots[fullMethod]; !ok {
+		l.slots[fullMethod] = make(chan struct{}, limit)
+	}
+	return l.slots[fullMethod]
+}
+
+// limitGRPC waits for a free slot of the method, calls which run out of time waiting fail
+// with ResourceExhausted so the CO retries them later
+func (l *concurrencyLimiter) limitGRPC(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	slots := l.methodSlots(info.FullMethod)
+	if slots == nil {
+		return handler(ctx, req)
+	}
+	select {
+	case slots <- struct{}{}:
+	default:
+		klog.FromContext(ctx).V(4).Info("Waiting for a concurrent call to finish", "limit", cap(slots))
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, status.Errorf(codes.ResourceExhausted, "too many concurrent %s calls: %v", methodName(info.FullMethod), ctx.Err())
+		}
+	}
+	defer func() { <-slots }()
+	return handler(ctx, req)
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type panickingIdentityServer struct {
+	csi.UnimplementedIdentityServer
+}
+
+func (s *panickingIdentityServer) Probe(ctx context.Context, req *csi.ProbeRequest) (*csi.ProbeResponse, error) {
+	var resp *csi.ProbeResponse
+	// Dereferencing nil panics like a handler bug would
+	return &csi.ProbeResponse{Ready: resp.Ready}, nil
+}
+
+func TestRecoverGRPC(t *testing.T) {
+	endpoint := "unix:/" + filepath.Join(t.TempDir(), "csi.sock")
+	s := NewNonBlockingGRPCServer(Options{})
+	s.Start(endpoint, &panickingIdentityServer{}, nil, nil)
+	defer s.ForceStop()
+
+	// The server keeps serving after the first panic
+	panics := 0
+	for deadline := time.Now().Add(5 * time.Second); panics < 2 && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		_, err := Probe(ctx, endpoint)
+		cancel()
+		if status.Code(err) == codes.Internal {
+			panics++
+		}
+	}
+	if panics < 2 {
+		t.Errorf("got %d Internal errors, want the panicking Probe to fail twice", panics)
+	}
+}
+
+func TestTimeoutGRPC(t *testing.T) {
+	opts := Options{
+		Timeout:        time.Minute,
+		MethodTimeouts: map[string]time.Duration{"NodePublishVolume": time.Hour, "Probe": 0},
+	}
+	tests := []struct {
+		method       string
+		coTimeout    time.Duration
+		wantTimeout  time.Duration
+		wantDeadline bool
+	}{
+		{method: "/csi.v1.Controller/CreateVolume", wantTimeout: time.Minute, wantDeadline: true},
+		{method: "/csi.v1.Node/NodePublishVolume", wantTimeout: time.Hour, wantDeadline: true},
+		{method: "/csi.v1.Identity/Probe"},
+		{method: "/csi.v1.Controller/CreateVolume", coTimeout: time.Second, wantTimeout: time.Second, wantDeadline: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.coTimeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.coTimeout)
+				defer cancel()
+			}
+			start := time.Now()
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				deadline, ok := ctx.Deadline()
+				if ok != tt.wantDeadline {
+					t.Fatalf("deadline set = %v, want %v", ok, tt.wantDeadline)
+				}
+				if got := deadline.Sub(start); ok && (got > tt.wantTimeout+time.Second || got < tt.wantTimeout-time.Second) {
+					t.Errorf("timeout = %v, want %v", got, tt.wantTimeout)
+				}
+				return nil, nil
+			}
+			timeoutGRPC(opts)(ctx, nil, &grpc.UnaryServerInfo{FullMethod: tt.method}, handler)
+		})
+	}
+}
+
+func TestLimitGRPC(t *testing.T) {
+	l := newConcurrencyLimiter(Options{
+		MaxConcurrent:       1,
+		MethodMaxConcurrent: map[string]int{"Probe": 0},
+	})
+	create := &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Controller/CreateVolume"}
+	call := func(ctx context.Context, info *grpc.UnaryServerInfo, release chan struct{}) error {
+		_, err := l.limitGRPC(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			if release != nil {
+				<-release
+			}
+			return nil, nil
+		})
+		return err
+	}
+
+	release := make(chan struct{})
+	running := make(chan error)
+	go func() { running <- call(context.Background(), create, release) }()
+	// Wait for the first call to take the only slot
+	for deadline := time.Now().Add(5 * time.Second); len(l.methodSlots(create.FullMethod)) == 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("first call did not start")
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := call(ctx, create, nil); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("second CreateVolume error = %v, want ResourceExhausted", err)
+	}
+	for _, method := range []string{"/csi.v1.Controller/DeleteVolume", "/csi.v1.Identity/Probe"} {
+		if err := call(context.Background(), &grpc.UnaryServerInfo{FullMethod: method}, nil); err != nil {
+			t.Errorf("%s error = %v, want its own limit", method, err)
+		}
+	}
+
+	close(release)
+	if err := <-running; err != nil {
+		t.Errorf("first CreateVolume error = %v", err)
+	}
+	if err := call(context.Background(), create, nil); err != nil {
+		t.Errorf("CreateVolume after the slot was freed error = %v", err)
+	}
+}
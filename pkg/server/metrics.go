@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// grpcMetrics count and time the calls of a server
+type grpcMetrics struct {
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+}
+
+// Check if implements prometheus.Collector
+var _ prometheus.Collector = &grpcMetrics{}
+
+func newGRPCMetrics() *grpcMetrics {
+	return &grpcMetrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "simple_csi",
+			Subsystem: "grpc",
+			Name:      "requests_total",
+			Help:      "CSI calls by method and gRPC status code.",
+		}, []string{"method", "code"}),
+
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "simple_csi",
+			Subsystem: "grpc",
+			Name:      "request_duration_seconds",
+			Help:      "Latency of CSI calls by method and gRPC status code.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 4, 9),
+		}, []string{"method", "code"}),
+	}
+}
+
+func (m *grpcMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.requests.Describe(ch)
+	m.requestDuration.Describe(ch)
+}
+
+func (m *grpcMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.requests.Collect(ch)
+	m.requestDuration.Collect(ch)
+}
+
+// metricsGRPC counts the calls and observes their latency by method and status code
+func (m *grpcMetrics) metricsGRPC(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+
+	code := status.Code(err).String()
+	m.requests.WithLabelValues(info.FullMethod, code).Inc()
+	m.requestDuration.WithLabelValues(info.FullMethod, code).Observe(time.Since(start).Seconds())
+	return resp, err
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMetricsGRPC(t *testing.T) {
+	const method = "/csi.v1.Controller/TestMetricsGRPC"
+	metrics := newGRPCMetrics()
+	count := func(code codes.Code) float64 {
+		m := &dto.Metric{}
+		if err := metrics.requests.WithLabelValues(method, code.String()).Write(m); err != nil {
+			t.Fatalf("Failed to read the request count: %v", err)
+		}
+		return m.GetCounter().GetValue()
+	}
+
+	info := &grpc.UnaryServerInfo{FullMethod: method}
+	for _, err := range []error{nil, nil, status.Error(codes.NotFound, "volume not found")} {
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			return req, err
+		}
+		if _, got := metrics.metricsGRPC(context.Background(), "req", info, handler); got != err {
+			t.Errorf("metricsGRPC() error = %v, want %v", got, err)
+		}
+	}
+
+	if got := count(codes.OK); got != 2 {
+		t.Errorf("OK calls = %v, want 2", got)
+	}
+	if got := count(codes.NotFound); got != 1 {
+		t.Errorf("NotFound calls = %v, want 1", got)
+	}
+	m := &dto.Metric{}
+	if err := metrics.requestDuration.WithLabelValues(method, codes.OK.String()).(prometheus.Histogram).Write(m); err != nil {
+		t.Fatalf("Failed to read the request latency: %v", err)
+	}
+	if got := m.GetHistogram().GetSampleCount(); got != 2 {
+		t.Errorf("observed OK latencies = %v, want 2", got)
+	}
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"context"
+	"net"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Probe calls Probe of the identity service served at endpoint and returns whether the
+// driver is ready, a driver leaving Ready unset is
+func Probe(ctx context.Context, endpoint string) (bool, error) {
+	proto, addr, err := endpointAddress(endpoint)
+	if err != nil {
+		return false, err
+	}
+	conn, err := grpc.DialContext(ctx, addr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, proto, addr)
+		}),
+	)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	resp, err := csi.NewIdentityClient(conn).Probe(ctx, &csi.ProbeRequest{})
+	if err != nil {
+		return false, err
+	}
+	return resp.GetReady() == nil || resp.GetReady().GetValue(), nil
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type probedIdentityServer struct {
+	csi.UnimplementedIdentityServer
+	ready *wrapperspb.BoolValue
+}
+
+func (s *probedIdentityServer) Probe(ctx context.Context, req *csi.ProbeRequest) (*csi.ProbeResponse, error) {
+	return &csi.ProbeResponse{Ready: s.ready}, nil
+}
+
+func TestProbe(t *testing.T) {
+	tests := []struct {
+		name  string
+		ready *wrapperspb.BoolValue
+		want  bool
+	}{
+		{name: "ready", ready: wrapperspb.Bool(true), want: true},
+		{name: "not ready", ready: wrapperspb.Bool(false)},
+		{name: "ready unset", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			endpoint := "unix:/" + filepath.Join(t.TempDir(), "csi.sock")
+			s := NewNonBlockingGRPCServer(Options{})
+			s.Start(endpoint, &probedIdentityServer{ready: tt.ready}, nil, nil)
+			defer s.ForceStop()
+
+			// The server listens in the background
+			var got bool
+			var err error
+			for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+				got, err = Probe(ctx, endpoint)
+				cancel()
+				if err == nil {
+					break
+				}
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("Probe() = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+
+	if _, err := Probe(context.Background(), "unix:/"+filepath.Join(t.TempDir(), "missing.sock")); err == nil {
+		t.Errorf("Probe() of a missing endpoint succeeded")
+	}
+}
@@ -0,0 +1,193 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// This file is copied to avoid glog deuplicate import issue
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"k8s.io/klog/v2"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+// Defines Non blocking GRPC server interfaces
+type NonBlockingGRPCServer interface {
+	// Start services at the endpoint
+	Start(endpoint string, ids csi.IdentityServer, cs csi.ControllerServer, ns csi.NodeServer)
+	// Waits for the service to stop, returns why it failed if it did not stop on request
+	Wait() error
+	// Stops the service gracefully
+	Stop()
+	// Stops the service forcefully
+	ForceStop()
+}
+
+func NewNonBlockingGRPCServer(opts Options) NonBlockingGRPCServer {
+	return &nonBlockingGRPCServer{
+		lock: &sync.Mutex{},
+		opts: opts,
+	}
+}
+
+// NonBlocking server
+type nonBlockingGRPCServer struct {
+	wg   sync.WaitGroup
+	opts Options
+
+	lock    *sync.Mutex
+	server  *grpc.Server
+	stopped bool
+	err     error
+}
+
+func (s *nonBlockingGRPCServer) Start(endpoint string, ids csi.IdentityServer, cs csi.ControllerServer, ns csi.NodeServer) {
+
+	s.wg.Add(1)
+
+	go func() {
+		defer s.wg.Done()
+		if err := s.serve(endpoint, ids, cs, ns); err != nil {
+			s.lock.Lock()
+			s.err = err
+			s.lock.Unlock()
+		}
+	}()
+
+	return
+}
+
+func (s *nonBlockingGRPCServer) Wait() error {
+	s.wg.Wait()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.err
+}
+
+func (s *nonBlockingGRPCServer) Stop() {
+	if server := s.stop(); server != nil {
+		server.GracefulStop()
+	}
+}
+
+func (s *nonBlockingGRPCServer) ForceStop() {
+	if server := s.stop(); server != nil {
+		server.Stop()
+	}
+}
+
+// stop marks the server stopped, so a server still starting up does not serve anymore, and
+// returns the grpc server if it is serving already
+func (s *nonBlockingGRPCServer) stop() *grpc.Server {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.stopped = true
+	return s.server
+}
+
+func (s *nonBlockingGRPCServer) serve(endpoint string, ids csi.IdentityServer, cs csi.ControllerServer, ns csi.NodeServer) error {
+
+	proto, addr, err := endpointAddress(endpoint)
+	if err != nil {
+		return err
+	}
+
+	if proto == "unix" {
+		if err := removeStaleSocket(addr); err != nil {
+			return err
+		}
+	}
+
+	listener, err := net.Listen(proto, addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", endpoint, err)
+	}
+
+	metrics := newGRPCMetrics()
+	if s.opts.Registerer != nil {
+		if err := s.opts.Registerer.Register(metrics); err != nil {
+			klog.Warningf("Failed to register the grpc metrics: %v", err)
+		} else {
+			defer s.opts.Registerer.Unregister(metrics)
+		}
+	}
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(interceptors(s.opts, metrics)...),
+	}
+	server := grpc.NewServer(opts...)
+
+	if ids != nil {
+		csi.RegisterIdentityServer(server, ids)
+	}
+	if cs != nil {
+		csi.RegisterControllerServer(server, cs)
+	}
+	if ns != nil {
+		csi.RegisterNodeServer(server, ns)
+	}
+
+	s.lock.Lock()
+	if s.stopped {
+		s.lock.Unlock()
+		return listener.Close()
+	}
+	s.server = server
+	s.lock.Unlock()
+
+	klog.Infof("Listening for connections on address: %#v", listener.Addr())
+
+	// Stopped before serving, nothing failed
+	if err := server.Serve(listener); err != nil && err != grpc.ErrServerStopped {
+		return fmt.Errorf("failed to serve on %s: %v", endpoint, err)
+	}
+	return nil
+}
+
+// endpointAddress returns the network and the address the endpoint is served at
+func endpointAddress(endpoint string) (string, string, error) {
+	proto, addr, err := ParseEndpoint(endpoint)
+	if err != nil {
+		return "", "", err
+	}
+	if proto == "unix" {
+		addr = "/" + addr
+	}
+	return proto, addr, nil
+}
+
+// removeStaleSocket removes the socket a previous run left behind at addr, a socket some
+// server still answers on belongs to another driver and is kept
+func removeStaleSocket(addr string) error {
+	if _, err := os.Stat(addr); os.IsNotExist(err) {
+		return nil
+	}
+	if conn, err := net.DialTimeout("unix", addr, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use by another server", addr)
+	}
+	if err := os.Remove(addr); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove %s: %v", addr, err)
+	}
+	return nil
+}
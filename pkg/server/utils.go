@@ -0,0 +1,81 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/chenliu1993/simple-csi-driver/pkg/logging"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/kubernetes-csi/csi-lib-utils/protosanitizer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+func ParseEndpoint(ep string) (string, string, error) {
+	if strings.HasPrefix(strings.ToLower(ep), "unix://") || strings.HasPrefix(strings.ToLower(ep), "tcp://") {
+		s := strings.SplitN(ep, "://", 2)
+		if s[1] != "" {
+			return s[0], s[1], nil
+		}
+	}
+	return "", "", errors.New(fmt.Sprintf("Invalid endpoint: %v", ep))
+}
+
+// logGRPC gives each call a request ID and a logger carrying it and the fields of the
+// request, which the handlers log with through the context
+func logGRPC(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	level := int(getLogLevel(info.FullMethod))
+	ctx = logging.WithRequestID(ctx, logging.NewRequestID(), append([]interface{}{logging.MethodKey, info.FullMethod}, requestFields(req)...)...)
+	logger := klog.FromContext(ctx)
+	logger.V(level).Info("GRPC call", "request", protosanitizer.StripSecrets(req).String())
+
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	fields := []interface{}{logging.DurationKey, time.Since(start).String(), logging.CodeKey, status.Code(err).String()}
+	if err != nil {
+		logger.Error(err, "GRPC error", fields...)
+	} else {
+		if v, ok := resp.(interface{ GetVolume() *csi.Volume }); ok && v.GetVolume() != nil {
+			// The ID of a created volume is only known from the response
+			fields = append(fields, logging.VolumeIDKey, v.GetVolume().GetVolumeId())
+		}
+		logger.V(level).Info("GRPC response", append(fields, "response", protosanitizer.StripSecrets(resp).String())...)
+	}
+	return resp, err
+}
+
+// requestFields returns the volume, paths and nfs server a request is about as log fields
+func requestFields(req interface{}) []interface{} {
+	var fields []interface{}
+	if r, ok := req.(interface{ GetVolumeId() string }); ok && r.GetVolumeId() != "" {
+		fields = append(fields, logging.VolumeIDKey, r.GetVolumeId())
+	} else if r, ok := req.(interface{ GetSourceVolumeId() string }); ok && r.GetSourceVolumeId() != "" {
+		fields = append(fields, logging.VolumeIDKey, r.GetSourceVolumeId())
+	}
+	if r, ok := req.(interface{ GetTargetPath() string }); ok && r.GetTargetPath() != "" {
+		fields = append(fields, logging.TargetPathKey, r.GetTargetPath())
+	}
+	if r, ok := req.(interface{ GetStagingTargetPath() string }); ok && r.GetStagingTargetPath() != "" {
+		fields = append(fields, logging.StagingTargetPathKey, r.GetStagingTargetPath())
+	}
+	// Drivers serving nfs take the server from the StorageClass or the volume context
+	if r, ok := req.(interface{ GetVolumeContext() map[string]string }); ok && r.GetVolumeContext()[logging.ServerKey] != "" {
+		fields = append(fields, logging.ServerKey, r.GetVolumeContext()[logging.ServerKey])
+	} else if r, ok := req.(interface{ GetParameters() map[string]string }); ok && r.GetParameters()[logging.ServerKey] != "" {
+		fields = append(fields, logging.ServerKey, r.GetParameters()[logging.ServerKey])
+	}
+	return fields
+}
+
+func getLogLevel(method string) int32 {
+	if method == "/csi.v1.Identity/Probe" ||
+		method == "/csi.v1.Node/NodeGetCapabilities" ||
+		method == "/csi.v1.Node/NodeGetVolumeStats" {
+		return 8
+	}
+	return 2
+}
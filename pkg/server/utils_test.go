@@ -0,0 +1,114 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/chenliu1993/simple-csi-driver/pkg/logging"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+func TestRequestFields(t *testing.T) {
+	tests := []struct {
+		name string
+		req  interface{}
+		want []interface{}
+	}{
+		{
+			name: "publish",
+			req: &csi.NodePublishVolumeRequest{
+				VolumeId:      "server#export#vol",
+				TargetPath:    "/target",
+				VolumeContext: map[string]string{"server": "server"},
+			},
+			want: []interface{}{logging.VolumeIDKey, "server#export#vol", logging.TargetPathKey, "/target", logging.ServerKey, "server"},
+		},
+		{
+			name: "create",
+			req:  &csi.CreateVolumeRequest{Name: "vol", Parameters: map[string]string{"server": "server"}},
+			want: []interface{}{logging.ServerKey, "server"},
+		},
+		{
+			name: "snapshot",
+			req:  &csi.CreateSnapshotRequest{SourceVolumeId: "server#export#vol"},
+			want: []interface{}{logging.VolumeIDKey, "server#export#vol"},
+		},
+		{
+			name: "probe",
+			req:  &csi.ProbeRequest{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := requestFields(tt.req); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("requestFields() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogGRPC(t *testing.T) {
+	var out bytes.Buffer
+	if err := logging.SetFormat(logging.FormatJSON, &out); err != nil {
+		t.Fatal(err)
+	}
+	defer logging.SetFormat(logging.FormatText, nil)
+	flags := flag.NewFlagSet("klog", flag.ContinueOnError)
+	klog.InitFlags(flags)
+	flags.Set("v", "2")
+	defer flags.Set("v", "0")
+
+	req := &csi.NodePublishVolumeRequest{VolumeId: "server#export#vol", TargetPath: "/target"}
+	info := &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Node/NodePublishVolume"}
+	var requestID string
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		requestID = logging.RequestID(ctx)
+		klog.FromContext(ctx).Info("Mounting")
+		return nil, status.Error(codes.Internal, "mount failed")
+	}
+	if _, err := logGRPC(context.Background(), req, info, handler); status.Code(err) != codes.Internal {
+		t.Fatalf("logGRPC() error = %v, want the error of the handler", err)
+	}
+	if requestID == "" {
+		t.Fatalf("handler got no request ID")
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	wantMsgs := []string{"GRPC call", "Mounting", "GRPC error"}
+	if len(lines) != len(wantMsgs) {
+		t.Fatalf("got %d lines, want %d:\n%s", len(lines), len(wantMsgs), out.String())
+	}
+	for i, line := range lines {
+		var got map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &got); err != nil {
+			t.Fatalf("line %d %q is not json: %v", i, line, err)
+		}
+		want := map[string]interface{}{
+			"msg":                 wantMsgs[i],
+			logging.RequestIDKey:  requestID,
+			logging.MethodKey:     info.FullMethod,
+			logging.VolumeIDKey:   req.VolumeId,
+			logging.TargetPathKey: req.TargetPath,
+		}
+		if i == len(lines)-1 {
+			want[logging.CodeKey] = codes.Internal.String()
+		}
+		for k, v := range want {
+			if got[k] != v {
+				t.Errorf("line %d: %s = %v, want %v", i, k, got[k], v)
+			}
+		}
+		if _, ok := got[logging.DurationKey]; ok != (i == len(lines)-1) {
+			t.Errorf("line %d: duration = %v", i, got[logging.DurationKey])
+		}
+	}
+}
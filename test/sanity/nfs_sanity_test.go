@@ -1,12 +1,17 @@
 package sanity
 
 import (
+	"context"
+	"net"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 	"time"
 
 	"github.com/chenliu1993/simple-csi-driver/internal/nfs"
+	"github.com/chenliu1993/simple-csi-driver/internal/nfsv3"
+	"github.com/chenliu1993/simple-csi-driver/pkg/server"
 	"gopkg.in/yaml.v2"
 
 	. "github.com/onsi/ginkgo/v2"
@@ -21,9 +26,12 @@ var (
 
 	baseDir = "nfsshare"
 	// subDir   string
-	endpoint = "unix:///tmp/csi.sock"
+	// Socket of the driver, a fresh one per spec so a driver still stopping does not serve it
+	endpoint string
 
-	testNfsServer        = "127.0.0.1:2049"
+	// Address of the embedded nfs server, the driver maps its mounts to the exported directory
+	testNfsServer        string
+	testNfsRoot          string
 	testMountPermissions = "0644"
 
 	testVolumeParametersFile = "test_nfsvolume_parameters.yaml"
@@ -33,6 +41,9 @@ var (
 	mountPermissionKey = "mountPermission"
 	config             sanity.TestConfig
 	stopCh             chan os.Signal
+	// Closed once the driver stopped and unregistered its metrics
+	driverDone chan struct{}
+	nfsServer  *nfsv3.Server
 )
 
 func testNfsSanity() {
@@ -40,6 +51,19 @@ func testNfsSanity() {
 		var err error
 
 		stopCh = make(chan os.Signal, 1)
+		endpoint = "unix://" + filepath.Join(GinkgoT().TempDir(), "csi.sock")
+
+		// Serve a temporary directory instead of relying on a live nfs server
+		testNfsRoot, err = os.MkdirTemp("", "nfs-sanity")
+		Expect(err).NotTo(HaveOccurred())
+		err = os.Mkdir(filepath.Join(testNfsRoot, baseDir), 0755)
+		Expect(err).NotTo(HaveOccurred())
+		nfsServer, err = nfsv3.NewServer(testNfsRoot)
+		Expect(err).NotTo(HaveOccurred())
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		go nfsServer.Serve(l) //nolint:errcheck // stopped by Close
+		testNfsServer = l.Addr().String()
 
 		// For testing Nfs plugin
 		paramsFileContent := map[string]string{
@@ -58,12 +82,29 @@ func testNfsSanity() {
 		config.TestVolumeParametersFile = testVolumeParametersFile
 		config.Address = endpoint
 
+		// The driver reports topology, so the node needs at least one segment
+		os.Setenv("CSI_NODE_TOPOLOGY", "topology.kubernetes.io/zone=sanity")
+
 		signal.Notify(stopCh, syscall.SIGTERM)
+		driverDone = make(chan struct{})
 		go func() {
-			nfsDriver := nfs.NewNFSDriver(nfsdriver, endpoint, nodeName, stopCh)
+			defer close(driverDone)
+			nfsDriver := nfs.NewNFSDriver(&nfs.DriverOptions{
+				DriverName: nfsdriver,
+				Endpoint:   endpoint,
+				NodeID:     nodeName,
+				// Mounts go to the directory the embedded server exports
+				FakeMountRoot: testNfsRoot,
+			}, stopCh)
 			nfsDriver.Run()
 		}()
-		time.Sleep(500 * time.Millisecond)
+		// Wait for the driver to serve its socket
+		Eventually(func() error {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			_, err := server.Probe(ctx, endpoint)
+			return err
+		}, 10*time.Second, 20*time.Millisecond).Should(Succeed())
 	})
 	AfterEach(func() {
 		// Clean up
@@ -73,10 +114,7 @@ func testNfsSanity() {
 		// err = os.RemoveAll(subDir)
 		// Expect(err).NotTo(HaveOccurred())
 
-		err := os.RemoveAll(endpoint)
-		Expect(err).NotTo(HaveOccurred())
-
-		err = os.Remove(testVolumeParametersFile)
+		err := os.Remove(testVolumeParametersFile)
 		Expect(err).NotTo(HaveOccurred())
 
 		err = os.RemoveAll(config.TargetPath)
@@ -87,6 +125,12 @@ func testNfsSanity() {
 
 		// stop the drivers
 		stopCh <- syscall.SIGTERM
+		Eventually(driverDone, 10*time.Second).Should(BeClosed())
+
+		err = nfsServer.Close()
+		Expect(err).NotTo(HaveOccurred())
+		err = os.RemoveAll(testNfsRoot)
+		Expect(err).NotTo(HaveOccurred())
 	})
 
 	Context("NFS Sanity Test", func() {